@@ -18,6 +18,26 @@ type AuthToken struct {
 	CreatedAt time.Time    `json:"created_at"`
 }
 
+type ChannelHealth struct {
+	TeamID              string       `json:"team_id"`
+	Channel             string       `json:"channel"`
+	VerifiedAt          sql.NullTime `json:"verified_at"`
+	ConsecutiveFailures int64        `json:"consecutive_failures"`
+	LastSuccessAt       sql.NullTime `json:"last_success_at"`
+	LastFailureAt       sql.NullTime `json:"last_failure_at"`
+	LastError           string       `json:"last_error"`
+}
+
+type ChannelTest struct {
+	ID          string       `json:"id"`
+	TeamID      string       `json:"team_id"`
+	Channel     string       `json:"channel"`
+	Status      string       `json:"status"`
+	Response    string       `json:"response"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CompletedAt sql.NullTime `json:"completed_at"`
+}
+
 type Facility struct {
 	ID           string    `json:"id"`
 	Name         string    `json:"name"`
@@ -37,6 +57,18 @@ type Ground struct {
 	CreatedAt      time.Time      `json:"created_at"`
 }
 
+type LoginHistory struct {
+	ID         int64     `json:"id"`
+	TeamID     string    `json:"team_id"`
+	SessionID  string    `json:"session_id"`
+	Method     string    `json:"method"`
+	DeviceHash string    `json:"device_hash"`
+	UserAgent  string    `json:"user_agent"`
+	Ip         string    `json:"ip"`
+	NewDevice  int64     `json:"new_device"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
 type Migration struct {
 	MigrationNumber int64     `json:"migration_number"`
 	MigrationName   string    `json:"migration_name"`
@@ -53,22 +85,95 @@ type Municipality struct {
 }
 
 type Notification struct {
-	ID               string       `json:"id"`
-	TeamID           string       `json:"team_id"`
-	WatchConditionID string       `json:"watch_condition_id"`
-	SlotID           string       `json:"slot_id"`
-	Channel          string       `json:"channel"`
-	Status           string       `json:"status"`
-	SentAt           sql.NullTime `json:"sent_at"`
-	CreatedAt        time.Time    `json:"created_at"`
+	ID               string         `json:"id"`
+	TeamID           string         `json:"team_id"`
+	WatchConditionID string         `json:"watch_condition_id"`
+	SlotID           string         `json:"slot_id"`
+	Channel          string         `json:"channel"`
+	Status           string         `json:"status"`
+	SentAt           sql.NullTime   `json:"sent_at"`
+	CreatedAt        time.Time      `json:"created_at"`
+	BundleID         sql.NullString `json:"bundle_id"`
+	Attempts         int64          `json:"attempts"`
+	NextAttemptAt    sql.NullTime   `json:"next_attempt_at"`
+	LastError        sql.NullString `json:"last_error"`
+	Digest           sql.NullString `json:"digest"`
+	MessageID        sql.NullString `json:"message_id"`
+	MemberID         sql.NullString `json:"member_id"`
+}
+
+type NotificationAttempt struct {
+	ID             string         `json:"id"`
+	NotificationID string         `json:"notification_id"`
+	Attempt        int64          `json:"attempt"`
+	Channel        string         `json:"channel"`
+	Success        int64          `json:"success"`
+	Error          sql.NullString `json:"error"`
+	AttemptedAt    time.Time      `json:"attempted_at"`
+	MessageID      sql.NullString `json:"message_id"`
+}
+
+type NotificationClick struct {
+	ID             int64          `json:"id"`
+	NotificationID string         `json:"notification_id"`
+	MessageID      sql.NullString `json:"message_id"`
+	TeamID         string         `json:"team_id"`
+	MemberID       sql.NullString `json:"member_id"`
+	SlotID         string         `json:"slot_id"`
+	Channel        string         `json:"channel"`
+	ClickedAt      time.Time      `json:"clicked_at"`
+}
+
+type NotificationDestination struct {
+	TeamID                  string       `json:"team_id"`
+	Channel                 string       `json:"channel"`
+	Target                  string       `json:"target"`
+	VerifiedAt              sql.NullTime `json:"verified_at"`
+	CreatedAt               time.Time    `json:"created_at"`
+	UpdatedAt               time.Time    `json:"updated_at"`
+	Secret                  string       `json:"secret"`
+	PreviousSecret          string       `json:"previous_secret"`
+	PreviousSecretExpiresAt sql.NullTime `json:"previous_secret_expires_at"`
+}
+
+type NotificationMessage struct {
+	ID             string         `json:"id"`
+	TeamID         string         `json:"team_id"`
+	Channel        string         `json:"channel"`
+	Digest         sql.NullString `json:"digest"`
+	Status         string         `json:"status"`
+	LeaseExpiresAt sql.NullTime   `json:"lease_expires_at"`
+	LastError      sql.NullString `json:"last_error"`
+	CreatedAt      time.Time      `json:"created_at"`
+	SentAt         sql.NullTime   `json:"sent_at"`
+	MemberID       sql.NullString `json:"member_id"`
+	ClaimID        sql.NullInt64  `json:"claim_id"`
+	NotificationID sql.NullString `json:"notification_id"`
+	Attempts       int64          `json:"attempts"`
+	NextAttemptAt  sql.NullTime   `json:"next_attempt_at"`
+}
+
+type PhoneVerification struct {
+	ID         string         `json:"id"`
+	TeamID     string         `json:"team_id"`
+	MemberID   sql.NullString `json:"member_id"`
+	Phone      string         `json:"phone"`
+	CodeHash   string         `json:"code_hash"`
+	Attempts   int64          `json:"attempts"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+	VerifiedAt sql.NullTime   `json:"verified_at"`
+	CreatedAt  time.Time      `json:"created_at"`
 }
 
 type PlanLimit struct {
-	Plan                   string `json:"plan"`
-	MaxGrounds             int64  `json:"max_grounds"`
-	WeekendOnly            int64  `json:"weekend_only"`
-	MaxConditionsPerGround int64  `json:"max_conditions_per_ground"`
-	NotificationPriority   int64  `json:"notification_priority"`
+	Plan                     string `json:"plan"`
+	MaxGrounds               int64  `json:"max_grounds"`
+	WeekendOnly              int64  `json:"weekend_only"`
+	MaxConditionsPerGround   int64  `json:"max_conditions_per_ground"`
+	NotificationPriority     int64  `json:"notification_priority"`
+	NotificationDelayMinutes int64  `json:"notification_delay_minutes"`
+	DailyNotificationCap     int64  `json:"daily_notification_cap"`
+	MonthlySmsQuota          int64  `json:"monthly_sms_quota"`
 }
 
 type PromoCode struct {
@@ -92,6 +197,18 @@ type PromoCodeUsage struct {
 	AppliedAt   time.Time `json:"applied_at"`
 }
 
+type PushSubscription struct {
+	ID            string         `json:"id"`
+	TeamID        string         `json:"team_id"`
+	Endpoint      string         `json:"endpoint"`
+	P256dh        string         `json:"p256dh"`
+	Auth          string         `json:"auth"`
+	UserAgent     string         `json:"user_agent"`
+	CreatedAt     time.Time      `json:"created_at"`
+	LastSuccessAt sql.NullTime   `json:"last_success_at"`
+	MemberID      sql.NullString `json:"member_id"`
+}
+
 type ScrapeJob struct {
 	ID             string         `json:"id"`
 	MunicipalityID string         `json:"municipality_id"`
@@ -105,6 +222,18 @@ type ScrapeJob struct {
 	CreatedAt      time.Time      `json:"created_at"`
 }
 
+type Session struct {
+	ID         string    `json:"id"`
+	TeamID     string    `json:"team_id"`
+	TokenHash  string    `json:"token_hash"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	Ip         string    `json:"ip"`
+	Method     string    `json:"method"`
+}
+
 type Slot struct {
 	ID             string         `json:"id"`
 	FacilityID     sql.NullString `json:"facility_id"`
@@ -116,6 +245,48 @@ type Slot struct {
 	CourtName      sql.NullString `json:"court_name"`
 	RawText        sql.NullString `json:"raw_text"`
 	ScrapedAt      time.Time      `json:"scraped_at"`
+	LastSeenAt     sql.NullTime   `json:"last_seen_at"`
+	ReservationUrl string         `json:"reservation_url"`
+}
+
+type SlotBooking struct {
+	ID             int64          `json:"id"`
+	TeamID         string         `json:"team_id"`
+	SlotID         string         `json:"slot_id"`
+	NotificationID sql.NullString `json:"notification_id"`
+	MessageID      sql.NullString `json:"message_id"`
+	MemberID       sql.NullString `json:"member_id"`
+	Channel        string         `json:"channel"`
+	Source         string         `json:"source"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+type SlotClaim struct {
+	ID         int64          `json:"id"`
+	TeamID     string         `json:"team_id"`
+	SlotID     string         `json:"slot_id"`
+	MemberID   sql.NullString `json:"member_id"`
+	ClaimedAt  time.Time      `json:"claimed_at"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+	NotifiedAt sql.NullTime   `json:"notified_at"`
+}
+
+type SlotVote struct {
+	ID        int64          `json:"id"`
+	TeamID    string         `json:"team_id"`
+	SlotID    string         `json:"slot_id"`
+	MemberID  sql.NullString `json:"member_id"`
+	Vote      string         `json:"vote"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type SmsUsage struct {
+	ID        int64          `json:"id"`
+	TeamID    string         `json:"team_id"`
+	MemberID  sql.NullString `json:"member_id"`
+	Kind      string         `json:"kind"`
+	Segments  int64          `json:"segments"`
+	CreatedAt time.Time      `json:"created_at"`
 }
 
 type SupportMessage struct {
@@ -158,6 +329,28 @@ type Team struct {
 	CurrentPeriodEnd     sql.NullTime   `json:"current_period_end"`
 	CreatedAt            time.Time      `json:"created_at"`
 	UpdatedAt            time.Time      `json:"updated_at"`
+	NotificationChannels string         `json:"notification_channels"`
+	LineUserID           sql.NullString `json:"line_user_id"`
+	LineFollowed         int64          `json:"line_followed"`
+	LineLinkedAt         sql.NullTime   `json:"line_linked_at"`
+	DigestMode           string         `json:"digest_mode"`
+	DigestTime           string         `json:"digest_time"`
+	DigestWeekday        int64          `json:"digest_weekday"`
+	QuietStart           sql.NullString `json:"quiet_start"`
+	QuietEnd             sql.NullString `json:"quiet_end"`
+	QuietUrgentBypass    int64          `json:"quiet_urgent_bypass"`
+	MinIntervalMinutes   int64          `json:"min_interval_minutes"`
+}
+
+type TeamMember struct {
+	ID        string    `json:"id"`
+	TeamID    string    `json:"team_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Channels  string    `json:"channels"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Phone     string    `json:"phone"`
 }
 
 type Visitor struct {
@@ -168,15 +361,42 @@ type Visitor struct {
 }
 
 type WatchCondition struct {
-	ID         string       `json:"id"`
-	TeamID     string       `json:"team_id"`
-	FacilityID string       `json:"facility_id"`
-	DaysOfWeek string       `json:"days_of_week"`
-	TimeFrom   string       `json:"time_from"`
-	TimeTo     string       `json:"time_to"`
-	DateFrom   sql.NullTime `json:"date_from"`
-	DateTo     sql.NullTime `json:"date_to"`
-	Enabled    int64        `json:"enabled"`
-	CreatedAt  time.Time    `json:"created_at"`
-	UpdatedAt  time.Time    `json:"updated_at"`
+	ID            string         `json:"id"`
+	TeamID        string         `json:"team_id"`
+	FacilityID    string         `json:"facility_id"`
+	DaysOfWeek    string         `json:"days_of_week"`
+	TimeFrom      string         `json:"time_from"`
+	TimeTo        string         `json:"time_to"`
+	DateFrom      sql.NullTime   `json:"date_from"`
+	DateTo        sql.NullTime   `json:"date_to"`
+	Enabled       int64          `json:"enabled"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	MinLeadDays   int64          `json:"min_lead_days"`
+	MaxLeadDays   int64          `json:"max_lead_days"`
+	ExcludedDates string         `json:"excluded_dates"`
+	BundleType    string         `json:"bundle_type"`
+	BundleSize    int64          `json:"bundle_size"`
+	Channels      sql.NullString `json:"channels"`
+	DigestMode    sql.NullString `json:"digest_mode"`
+}
+
+type WatchConditionMember struct {
+	WatchConditionID string    `json:"watch_condition_id"`
+	MemberID         string    `json:"member_id"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID             string         `json:"id"`
+	TeamID         string         `json:"team_id"`
+	EventID        string         `json:"event_id"`
+	EventType      string         `json:"event_type"`
+	NotificationID sql.NullString `json:"notification_id"`
+	Url            string         `json:"url"`
+	StatusCode     int64          `json:"status_code"`
+	Success        int64          `json:"success"`
+	Error          sql.NullString `json:"error"`
+	DurationMs     int64          `json:"duration_ms"`
+	AttemptedAt    time.Time      `json:"attempted_at"`
 }
@@ -419,7 +419,7 @@ const createNotification = `-- name: CreateNotification :one
 
 INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, status, created_at)
 VALUES (?1, ?2, ?3, ?4, ?5, 'pending', CURRENT_TIMESTAMP)
-RETURNING id, team_id, watch_condition_id, slot_id, channel, status, sent_at, created_at
+RETURNING id, team_id, watch_condition_id, slot_id, channel, status, sent_at, created_at, bundle_id, attempts, next_attempt_at, last_error, digest, message_id, member_id
 `
 
 type CreateNotificationParams struct {
@@ -451,6 +451,13 @@ func (q *Queries) CreateNotification(ctx context.Context, arg CreateNotification
 		&i.Status,
 		&i.SentAt,
 		&i.CreatedAt,
+		&i.BundleID,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastError,
+		&i.Digest,
+		&i.MessageID,
+		&i.MemberID,
 	)
 	return i, err
 }
@@ -640,7 +647,7 @@ const createTeam = `-- name: CreateTeam :one
 
 INSERT INTO teams (id, name, email, plan, status, created_at, updated_at)
 VALUES (?1, ?2, ?3, ?4, 'active', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-RETURNING id, name, email, "plan", status, stripe_customer_id, stripe_subscription_id, billing_interval, current_period_end, created_at, updated_at
+RETURNING id, name, email, "plan", status, stripe_customer_id, stripe_subscription_id, billing_interval, current_period_end, created_at, updated_at, notification_channels, line_user_id, line_followed, line_linked_at, digest_mode, digest_time, digest_weekday, quiet_start, quiet_end, quiet_urgent_bypass, min_interval_minutes
 `
 
 type CreateTeamParams struct {
@@ -673,26 +680,44 @@ func (q *Queries) CreateTeam(ctx context.Context, arg CreateTeamParams) (Team, e
 		&i.CurrentPeriodEnd,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.NotificationChannels,
+		&i.LineUserID,
+		&i.LineFollowed,
+		&i.LineLinkedAt,
+		&i.DigestMode,
+		&i.DigestTime,
+		&i.DigestWeekday,
+		&i.QuietStart,
+		&i.QuietEnd,
+		&i.QuietUrgentBypass,
+		&i.MinIntervalMinutes,
 	)
 	return i, err
 }
 
 const createWatchCondition = `-- name: CreateWatchCondition :one
 
-INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to, date_from, date_to, enabled, created_at, updated_at)
-VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-RETURNING id, team_id, facility_id, days_of_week, time_from, time_to, date_from, date_to, enabled, created_at, updated_at
+INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to, date_from, date_to, min_lead_days, max_lead_days, excluded_dates, bundle_type, bundle_size, channels, digest_mode, enabled, created_at, updated_at)
+VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13, ?14, ?15, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+RETURNING id, team_id, facility_id, days_of_week, time_from, time_to, date_from, date_to, enabled, created_at, updated_at, min_lead_days, max_lead_days, excluded_dates, bundle_type, bundle_size, channels, digest_mode
 `
 
 type CreateWatchConditionParams struct {
-	ID         string       `json:"id"`
-	TeamID     string       `json:"team_id"`
-	FacilityID string       `json:"facility_id"`
-	DaysOfWeek string       `json:"days_of_week"`
-	TimeFrom   string       `json:"time_from"`
-	TimeTo     string       `json:"time_to"`
-	DateFrom   sql.NullTime `json:"date_from"`
-	DateTo     sql.NullTime `json:"date_to"`
+	ID            string         `json:"id"`
+	TeamID        string         `json:"team_id"`
+	FacilityID    string         `json:"facility_id"`
+	DaysOfWeek    string         `json:"days_of_week"`
+	TimeFrom      string         `json:"time_from"`
+	TimeTo        string         `json:"time_to"`
+	DateFrom      sql.NullTime   `json:"date_from"`
+	DateTo        sql.NullTime   `json:"date_to"`
+	MinLeadDays   int64          `json:"min_lead_days"`
+	MaxLeadDays   int64          `json:"max_lead_days"`
+	ExcludedDates string         `json:"excluded_dates"`
+	BundleType    string         `json:"bundle_type"`
+	BundleSize    int64          `json:"bundle_size"`
+	Channels      sql.NullString `json:"channels"`
+	DigestMode    sql.NullString `json:"digest_mode"`
 }
 
 // =============================================================================
@@ -708,6 +733,13 @@ func (q *Queries) CreateWatchCondition(ctx context.Context, arg CreateWatchCondi
 		arg.TimeTo,
 		arg.DateFrom,
 		arg.DateTo,
+		arg.MinLeadDays,
+		arg.MaxLeadDays,
+		arg.ExcludedDates,
+		arg.BundleType,
+		arg.BundleSize,
+		arg.Channels,
+		arg.DigestMode,
 	)
 	var i WatchCondition
 	err := row.Scan(
@@ -722,6 +754,13 @@ func (q *Queries) CreateWatchCondition(ctx context.Context, arg CreateWatchCondi
 		&i.Enabled,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.MinLeadDays,
+		&i.MaxLeadDays,
+		&i.ExcludedDates,
+		&i.BundleType,
+		&i.BundleSize,
+		&i.Channels,
+		&i.DigestMode,
 	)
 	return i, err
 }
@@ -949,7 +988,7 @@ func (q *Queries) GetMunicipalityByScraperType(ctx context.Context, scraperType
 }
 
 const getNotification = `-- name: GetNotification :one
-SELECT id, team_id, watch_condition_id, slot_id, channel, status, sent_at, created_at FROM notifications WHERE id = ?
+SELECT id, team_id, watch_condition_id, slot_id, channel, status, sent_at, created_at, bundle_id, attempts, next_attempt_at, last_error, digest, message_id, member_id FROM notifications WHERE id = ?
 `
 
 func (q *Queries) GetNotification(ctx context.Context, id string) (Notification, error) {
@@ -964,13 +1003,20 @@ func (q *Queries) GetNotification(ctx context.Context, id string) (Notification,
 		&i.Status,
 		&i.SentAt,
 		&i.CreatedAt,
+		&i.BundleID,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastError,
+		&i.Digest,
+		&i.MessageID,
+		&i.MemberID,
 	)
 	return i, err
 }
 
 const getPlanLimits = `-- name: GetPlanLimits :one
 
-SELECT "plan", max_grounds, weekend_only, max_conditions_per_ground, notification_priority FROM plan_limits WHERE plan = ?
+SELECT "plan", max_grounds, weekend_only, max_conditions_per_ground, notification_priority, notification_delay_minutes, daily_notification_cap, monthly_sms_quota FROM plan_limits WHERE plan = ?
 `
 
 // =============================================================================
@@ -985,6 +1031,9 @@ func (q *Queries) GetPlanLimits(ctx context.Context, plan string) (PlanLimit, er
 		&i.WeekendOnly,
 		&i.MaxConditionsPerGround,
 		&i.NotificationPriority,
+		&i.NotificationDelayMinutes,
+		&i.DailyNotificationCap,
+		&i.MonthlySmsQuota,
 	)
 	return i, err
 }
@@ -1084,7 +1133,7 @@ func (q *Queries) GetScrapeJob(ctx context.Context, id string) (ScrapeJob, error
 }
 
 const getSlot = `-- name: GetSlot :one
-SELECT id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at FROM slots WHERE id = ?
+SELECT id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at, last_seen_at, reservation_url FROM slots WHERE id = ?
 `
 
 func (q *Queries) GetSlot(ctx context.Context, id string) (Slot, error) {
@@ -1101,6 +1150,8 @@ func (q *Queries) GetSlot(ctx context.Context, id string) (Slot, error) {
 		&i.CourtName,
 		&i.RawText,
 		&i.ScrapedAt,
+		&i.LastSeenAt,
+		&i.ReservationUrl,
 	)
 	return i, err
 }
@@ -1128,7 +1179,7 @@ func (q *Queries) GetSupportTicket(ctx context.Context, id string) (SupportTicke
 }
 
 const getTeam = `-- name: GetTeam :one
-SELECT id, name, email, "plan", status, stripe_customer_id, stripe_subscription_id, billing_interval, current_period_end, created_at, updated_at FROM teams WHERE id = ?
+SELECT id, name, email, "plan", status, stripe_customer_id, stripe_subscription_id, billing_interval, current_period_end, created_at, updated_at, notification_channels, line_user_id, line_followed, line_linked_at, digest_mode, digest_time, digest_weekday, quiet_start, quiet_end, quiet_urgent_bypass, min_interval_minutes FROM teams WHERE id = ?
 `
 
 func (q *Queries) GetTeam(ctx context.Context, id string) (Team, error) {
@@ -1146,12 +1197,23 @@ func (q *Queries) GetTeam(ctx context.Context, id string) (Team, error) {
 		&i.CurrentPeriodEnd,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.NotificationChannels,
+		&i.LineUserID,
+		&i.LineFollowed,
+		&i.LineLinkedAt,
+		&i.DigestMode,
+		&i.DigestTime,
+		&i.DigestWeekday,
+		&i.QuietStart,
+		&i.QuietEnd,
+		&i.QuietUrgentBypass,
+		&i.MinIntervalMinutes,
 	)
 	return i, err
 }
 
 const getTeamByEmail = `-- name: GetTeamByEmail :one
-SELECT id, name, email, "plan", status, stripe_customer_id, stripe_subscription_id, billing_interval, current_period_end, created_at, updated_at FROM teams WHERE email = ?
+SELECT id, name, email, "plan", status, stripe_customer_id, stripe_subscription_id, billing_interval, current_period_end, created_at, updated_at, notification_channels, line_user_id, line_followed, line_linked_at, digest_mode, digest_time, digest_weekday, quiet_start, quiet_end, quiet_urgent_bypass, min_interval_minutes FROM teams WHERE email = ?
 `
 
 func (q *Queries) GetTeamByEmail(ctx context.Context, email string) (Team, error) {
@@ -1169,12 +1231,23 @@ func (q *Queries) GetTeamByEmail(ctx context.Context, email string) (Team, error
 		&i.CurrentPeriodEnd,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.NotificationChannels,
+		&i.LineUserID,
+		&i.LineFollowed,
+		&i.LineLinkedAt,
+		&i.DigestMode,
+		&i.DigestTime,
+		&i.DigestWeekday,
+		&i.QuietStart,
+		&i.QuietEnd,
+		&i.QuietUrgentBypass,
+		&i.MinIntervalMinutes,
 	)
 	return i, err
 }
 
 const getTeamByStripeCustomer = `-- name: GetTeamByStripeCustomer :one
-SELECT id, name, email, "plan", status, stripe_customer_id, stripe_subscription_id, billing_interval, current_period_end, created_at, updated_at FROM teams WHERE stripe_customer_id = ?
+SELECT id, name, email, "plan", status, stripe_customer_id, stripe_subscription_id, billing_interval, current_period_end, created_at, updated_at, notification_channels, line_user_id, line_followed, line_linked_at, digest_mode, digest_time, digest_weekday, quiet_start, quiet_end, quiet_urgent_bypass, min_interval_minutes FROM teams WHERE stripe_customer_id = ?
 `
 
 func (q *Queries) GetTeamByStripeCustomer(ctx context.Context, stripeCustomerID sql.NullString) (Team, error) {
@@ -1192,6 +1265,17 @@ func (q *Queries) GetTeamByStripeCustomer(ctx context.Context, stripeCustomerID
 		&i.CurrentPeriodEnd,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.NotificationChannels,
+		&i.LineUserID,
+		&i.LineFollowed,
+		&i.LineLinkedAt,
+		&i.DigestMode,
+		&i.DigestTime,
+		&i.DigestWeekday,
+		&i.QuietStart,
+		&i.QuietEnd,
+		&i.QuietUrgentBypass,
+		&i.MinIntervalMinutes,
 	)
 	return i, err
 }
@@ -1213,7 +1297,7 @@ func (q *Queries) GetVisitor(ctx context.Context, id string) (Visitor, error) {
 }
 
 const getWatchCondition = `-- name: GetWatchCondition :one
-SELECT id, team_id, facility_id, days_of_week, time_from, time_to, date_from, date_to, enabled, created_at, updated_at FROM watch_conditions WHERE id = ?
+SELECT id, team_id, facility_id, days_of_week, time_from, time_to, date_from, date_to, enabled, created_at, updated_at, min_lead_days, max_lead_days, excluded_dates, bundle_type, bundle_size, channels, digest_mode FROM watch_conditions WHERE id = ?
 `
 
 func (q *Queries) GetWatchCondition(ctx context.Context, id string) (WatchCondition, error) {
@@ -1231,6 +1315,13 @@ func (q *Queries) GetWatchCondition(ctx context.Context, id string) (WatchCondit
 		&i.Enabled,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.MinLeadDays,
+		&i.MaxLeadDays,
+		&i.ExcludedDates,
+		&i.BundleType,
+		&i.BundleSize,
+		&i.Channels,
+		&i.DigestMode,
 	)
 	return i, err
 }
@@ -1482,7 +1573,7 @@ func (q *Queries) ListMunicipalities(ctx context.Context) ([]Municipality, error
 }
 
 const listNotificationsByTeam = `-- name: ListNotificationsByTeam :many
-SELECT id, team_id, watch_condition_id, slot_id, channel, status, sent_at, created_at FROM notifications WHERE team_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?
+SELECT id, team_id, watch_condition_id, slot_id, channel, status, sent_at, created_at, bundle_id, attempts, next_attempt_at, last_error, digest, message_id, member_id FROM notifications WHERE team_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?
 `
 
 type ListNotificationsByTeamParams struct {
@@ -1509,6 +1600,13 @@ func (q *Queries) ListNotificationsByTeam(ctx context.Context, arg ListNotificat
 			&i.Status,
 			&i.SentAt,
 			&i.CreatedAt,
+			&i.BundleID,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastError,
+			&i.Digest,
+			&i.MessageID,
+			&i.MemberID,
 		); err != nil {
 			return nil, err
 		}
@@ -1714,7 +1812,7 @@ func (q *Queries) ListRecentScrapeJobs(ctx context.Context, limit int64) ([]List
 }
 
 const listSlotsByDateRange = `-- name: ListSlotsByDateRange :many
-SELECT id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at FROM slots WHERE municipality_id = ? AND slot_date BETWEEN ? AND ? ORDER BY slot_date, time_from
+SELECT id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at, last_seen_at, reservation_url FROM slots WHERE municipality_id = ? AND slot_date BETWEEN ? AND ? ORDER BY slot_date, time_from
 `
 
 func (q *Queries) ListSlotsByDateRange(ctx context.Context, municipalityID sql.NullString) ([]Slot, error) {
@@ -1737,6 +1835,8 @@ func (q *Queries) ListSlotsByDateRange(ctx context.Context, municipalityID sql.N
 			&i.CourtName,
 			&i.RawText,
 			&i.ScrapedAt,
+			&i.LastSeenAt,
+			&i.ReservationUrl,
 		); err != nil {
 			return nil, err
 		}
@@ -1752,7 +1852,7 @@ func (q *Queries) ListSlotsByDateRange(ctx context.Context, municipalityID sql.N
 }
 
 const listSlotsByFacility = `-- name: ListSlotsByFacility :many
-SELECT id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at FROM slots WHERE facility_id = ? AND slot_date >= date('now') ORDER BY slot_date, time_from
+SELECT id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at, last_seen_at, reservation_url FROM slots WHERE facility_id = ? AND slot_date >= date('now') ORDER BY slot_date, time_from
 `
 
 func (q *Queries) ListSlotsByFacility(ctx context.Context, facilityID sql.NullString) ([]Slot, error) {
@@ -1775,6 +1875,8 @@ func (q *Queries) ListSlotsByFacility(ctx context.Context, facilityID sql.NullSt
 			&i.CourtName,
 			&i.RawText,
 			&i.ScrapedAt,
+			&i.LastSeenAt,
+			&i.ReservationUrl,
 		); err != nil {
 			return nil, err
 		}
@@ -1790,7 +1892,7 @@ func (q *Queries) ListSlotsByFacility(ctx context.Context, facilityID sql.NullSt
 }
 
 const listSlotsByGround = `-- name: ListSlotsByGround :many
-SELECT id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at FROM slots WHERE ground_id = ? AND slot_date >= date('now') ORDER BY slot_date, time_from
+SELECT id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at, last_seen_at, reservation_url FROM slots WHERE ground_id = ? AND slot_date >= date('now') ORDER BY slot_date, time_from
 `
 
 func (q *Queries) ListSlotsByGround(ctx context.Context, groundID sql.NullString) ([]Slot, error) {
@@ -1813,6 +1915,8 @@ func (q *Queries) ListSlotsByGround(ctx context.Context, groundID sql.NullString
 			&i.CourtName,
 			&i.RawText,
 			&i.ScrapedAt,
+			&i.LastSeenAt,
+			&i.ReservationUrl,
 		); err != nil {
 			return nil, err
 		}
@@ -1828,7 +1932,7 @@ func (q *Queries) ListSlotsByGround(ctx context.Context, groundID sql.NullString
 }
 
 const listSlotsByMunicipality = `-- name: ListSlotsByMunicipality :many
-SELECT id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at FROM slots WHERE municipality_id = ? AND slot_date >= date('now') ORDER BY slot_date, time_from
+SELECT id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at, last_seen_at, reservation_url FROM slots WHERE municipality_id = ? AND slot_date >= date('now') ORDER BY slot_date, time_from
 `
 
 func (q *Queries) ListSlotsByMunicipality(ctx context.Context, municipalityID sql.NullString) ([]Slot, error) {
@@ -1851,6 +1955,8 @@ func (q *Queries) ListSlotsByMunicipality(ctx context.Context, municipalityID sq
 			&i.CourtName,
 			&i.RawText,
 			&i.ScrapedAt,
+			&i.LastSeenAt,
+			&i.ReservationUrl,
 		); err != nil {
 			return nil, err
 		}
@@ -1945,7 +2051,7 @@ func (q *Queries) ListSupportTickets(ctx context.Context, arg ListSupportTickets
 }
 
 const listTeams = `-- name: ListTeams :many
-SELECT id, name, email, "plan", status, stripe_customer_id, stripe_subscription_id, billing_interval, current_period_end, created_at, updated_at FROM teams ORDER BY created_at DESC LIMIT ? OFFSET ?
+SELECT id, name, email, "plan", status, stripe_customer_id, stripe_subscription_id, billing_interval, current_period_end, created_at, updated_at, notification_channels, line_user_id, line_followed, line_linked_at, digest_mode, digest_time, digest_weekday, quiet_start, quiet_end, quiet_urgent_bypass, min_interval_minutes FROM teams ORDER BY created_at DESC LIMIT ? OFFSET ?
 `
 
 type ListTeamsParams struct {
@@ -1974,6 +2080,17 @@ func (q *Queries) ListTeams(ctx context.Context, arg ListTeamsParams) ([]Team, e
 			&i.CurrentPeriodEnd,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.NotificationChannels,
+			&i.LineUserID,
+			&i.LineFollowed,
+			&i.LineLinkedAt,
+			&i.DigestMode,
+			&i.DigestTime,
+			&i.DigestWeekday,
+			&i.QuietStart,
+			&i.QuietEnd,
+			&i.QuietUrgentBypass,
+			&i.MinIntervalMinutes,
 		); err != nil {
 			return nil, err
 		}
@@ -1989,26 +2106,33 @@ func (q *Queries) ListTeams(ctx context.Context, arg ListTeamsParams) ([]Team, e
 }
 
 const listWatchConditionsByFacility = `-- name: ListWatchConditionsByFacility :many
-SELECT wc.id, wc.team_id, wc.facility_id, wc.days_of_week, wc.time_from, wc.time_to, wc.date_from, wc.date_to, wc.enabled, wc.created_at, wc.updated_at, t.email as team_email, t.name as team_name
+SELECT wc.id, wc.team_id, wc.facility_id, wc.days_of_week, wc.time_from, wc.time_to, wc.date_from, wc.date_to, wc.enabled, wc.created_at, wc.updated_at, wc.min_lead_days, wc.max_lead_days, wc.excluded_dates, wc.bundle_type, wc.bundle_size, wc.channels, wc.digest_mode, t.email as team_email, t.name as team_name
 FROM watch_conditions wc
 JOIN teams t ON wc.team_id = t.id
 WHERE wc.facility_id = ? AND wc.enabled = 1 AND t.status = 'active'
 `
 
 type ListWatchConditionsByFacilityRow struct {
-	ID         string       `json:"id"`
-	TeamID     string       `json:"team_id"`
-	FacilityID string       `json:"facility_id"`
-	DaysOfWeek string       `json:"days_of_week"`
-	TimeFrom   string       `json:"time_from"`
-	TimeTo     string       `json:"time_to"`
-	DateFrom   sql.NullTime `json:"date_from"`
-	DateTo     sql.NullTime `json:"date_to"`
-	Enabled    int64        `json:"enabled"`
-	CreatedAt  time.Time    `json:"created_at"`
-	UpdatedAt  time.Time    `json:"updated_at"`
-	TeamEmail  string       `json:"team_email"`
-	TeamName   string       `json:"team_name"`
+	ID            string         `json:"id"`
+	TeamID        string         `json:"team_id"`
+	FacilityID    string         `json:"facility_id"`
+	DaysOfWeek    string         `json:"days_of_week"`
+	TimeFrom      string         `json:"time_from"`
+	TimeTo        string         `json:"time_to"`
+	DateFrom      sql.NullTime   `json:"date_from"`
+	DateTo        sql.NullTime   `json:"date_to"`
+	Enabled       int64          `json:"enabled"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	MinLeadDays   int64          `json:"min_lead_days"`
+	MaxLeadDays   int64          `json:"max_lead_days"`
+	ExcludedDates string         `json:"excluded_dates"`
+	BundleType    string         `json:"bundle_type"`
+	BundleSize    int64          `json:"bundle_size"`
+	Channels      sql.NullString `json:"channels"`
+	DigestMode    sql.NullString `json:"digest_mode"`
+	TeamEmail     string         `json:"team_email"`
+	TeamName      string         `json:"team_name"`
 }
 
 func (q *Queries) ListWatchConditionsByFacility(ctx context.Context, facilityID string) ([]ListWatchConditionsByFacilityRow, error) {
@@ -2032,6 +2156,13 @@ func (q *Queries) ListWatchConditionsByFacility(ctx context.Context, facilityID
 			&i.Enabled,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.MinLeadDays,
+			&i.MaxLeadDays,
+			&i.ExcludedDates,
+			&i.BundleType,
+			&i.BundleSize,
+			&i.Channels,
+			&i.DigestMode,
 			&i.TeamEmail,
 			&i.TeamName,
 		); err != nil {
@@ -2049,7 +2180,7 @@ func (q *Queries) ListWatchConditionsByFacility(ctx context.Context, facilityID
 }
 
 const listWatchConditionsByTeam = `-- name: ListWatchConditionsByTeam :many
-SELECT id, team_id, facility_id, days_of_week, time_from, time_to, date_from, date_to, enabled, created_at, updated_at FROM watch_conditions WHERE team_id = ? ORDER BY created_at DESC
+SELECT id, team_id, facility_id, days_of_week, time_from, time_to, date_from, date_to, enabled, created_at, updated_at, min_lead_days, max_lead_days, excluded_dates, bundle_type, bundle_size, channels, digest_mode FROM watch_conditions WHERE team_id = ? ORDER BY created_at DESC
 `
 
 func (q *Queries) ListWatchConditionsByTeam(ctx context.Context, teamID string) ([]WatchCondition, error) {
@@ -2073,6 +2204,13 @@ func (q *Queries) ListWatchConditionsByTeam(ctx context.Context, teamID string)
 			&i.Enabled,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.MinLeadDays,
+			&i.MaxLeadDays,
+			&i.ExcludedDates,
+			&i.BundleType,
+			&i.BundleSize,
+			&i.Channels,
+			&i.DigestMode,
 		); err != nil {
 			return nil, err
 		}
@@ -2350,7 +2488,7 @@ ON CONFLICT(municipality_id, slot_date, time_from, time_to, court_name) DO UPDAT
     ground_id = COALESCE(excluded.ground_id, slots.ground_id),
     raw_text = excluded.raw_text,
     scraped_at = CURRENT_TIMESTAMP
-RETURNING id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at
+RETURNING id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at, last_seen_at, reservation_url
 `
 
 type UpsertSlotParams struct {
@@ -2392,6 +2530,8 @@ func (q *Queries) UpsertSlot(ctx context.Context, arg UpsertSlotParams) (Slot, e
 		&i.CourtName,
 		&i.RawText,
 		&i.ScrapedAt,
+		&i.LastSeenAt,
+		&i.ReservationUrl,
 	)
 	return i, err
 }
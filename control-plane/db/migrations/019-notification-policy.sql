@@ -0,0 +1,15 @@
+-- Plan-aware notification policy
+-- notification_delay_minutes: 通知を送るまでの待機時間（有料プランを先に通知するため）
+-- daily_notification_cap: 1日(JST)あたりに通知する空き枠の上限（0 = 無制限）
+
+ALTER TABLE plan_limits ADD COLUMN notification_delay_minutes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE plan_limits ADD COLUMN daily_notification_cap INTEGER NOT NULL DEFAULT 0;
+
+UPDATE plan_limits SET notification_delay_minutes = 30, daily_notification_cap = 10 WHERE plan = 'free';
+UPDATE plan_limits SET notification_delay_minutes = 10, daily_notification_cap = 30 WHERE plan = 'personal';
+UPDATE plan_limits SET notification_delay_minutes = 0, daily_notification_cap = 100 WHERE plan = 'pro';
+UPDATE plan_limits SET notification_delay_minutes = 0, daily_notification_cap = 0 WHERE plan = 'org';
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (019, '019-notification-policy');
@@ -0,0 +1,12 @@
+-- Relative booking window and exclusion dates for watch conditions
+-- min_lead_days: 今日(JST)から最低何日先の枠を対象にするか（0 = 制限なし）
+-- max_lead_days: 今日(JST)から何日先までの枠を対象にするか（0 = 制限なし）
+-- excluded_dates: 通知しない日付の JSON 配列（例: ["2025-05-03", "2025-05-04"]）
+
+ALTER TABLE watch_conditions ADD COLUMN min_lead_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE watch_conditions ADD COLUMN max_lead_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE watch_conditions ADD COLUMN excluded_dates TEXT NOT NULL DEFAULT '[]';
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (020, '020-condition-booking-window');
@@ -0,0 +1,14 @@
+-- Bundle conditions for tournaments
+-- bundle_type: '' = 単一枠, 'courts' = 同一施設・同時刻の複数面, 'days' = 同一施設の連続日
+-- bundle_size: セットに必要な面数または日数
+-- notifications.bundle_id: 同じセットとして通知する枠をまとめる ID
+
+ALTER TABLE watch_conditions ADD COLUMN bundle_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE watch_conditions ADD COLUMN bundle_size INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE notifications ADD COLUMN bundle_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_notifications_bundle ON notifications(bundle_id);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (021, '021-bundle-conditions');
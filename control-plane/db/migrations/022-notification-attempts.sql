@@ -0,0 +1,25 @@
+-- Notification delivery retries
+-- attempts: 送信を試みた回数
+-- next_attempt_at: 次に再送してよい時刻（NULL = すぐに送信可能）
+-- last_error: 直近の送信エラー
+
+ALTER TABLE notifications ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE notifications ADD COLUMN next_attempt_at TIMESTAMP;
+ALTER TABLE notifications ADD COLUMN last_error TEXT;
+
+-- 送信試行の履歴
+CREATE TABLE IF NOT EXISTS notification_attempts (
+    id TEXT PRIMARY KEY,
+    notification_id TEXT NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+    attempt INTEGER NOT NULL,
+    channel TEXT NOT NULL,
+    success INTEGER NOT NULL,
+    error TEXT,
+    attempted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_attempts_notification ON notification_attempts(notification_id);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (022, '022-notification-attempts');
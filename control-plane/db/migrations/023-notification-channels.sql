@@ -0,0 +1,10 @@
+-- Notification channel preferences
+-- teams.notification_channels: チームで有効な通知チャネルの JSON 配列
+-- watch_conditions.channels: ルールごとの通知チャネル（NULL = チームの設定を使う）
+
+ALTER TABLE teams ADD COLUMN notification_channels TEXT NOT NULL DEFAULT '["email"]';
+ALTER TABLE watch_conditions ADD COLUMN channels TEXT;
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (023, '023-notification-channels');
@@ -0,0 +1,14 @@
+-- LINE account linking
+-- teams.line_user_id: LINE Login で連携した LINE ユーザー ID（Messaging API の push 先）
+-- teams.line_followed: 公式アカウントを友だち追加しているか（webhook の follow/unfollow で更新）
+-- teams.line_linked_at: 連携日時
+
+ALTER TABLE teams ADD COLUMN line_user_id TEXT;
+ALTER TABLE teams ADD COLUMN line_followed INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE teams ADD COLUMN line_linked_at TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_teams_line_user ON teams(line_user_id) WHERE line_user_id IS NOT NULL;
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (024, '024-line-link');
@@ -0,0 +1,18 @@
+-- Per-team notification destinations
+-- notification_destinations: チームごと・チャネルごとの通知先（Slack / Discord の incoming webhook URL など）
+-- target: 送信先（webhook URL）
+-- verified_at: テストメッセージの送信に成功した日時
+
+CREATE TABLE IF NOT EXISTS notification_destinations (
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    target TEXT NOT NULL,
+    verified_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (team_id, channel)
+);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (025, '025-notification-destinations');
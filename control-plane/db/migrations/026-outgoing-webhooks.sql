@@ -0,0 +1,28 @@
+-- Signed outgoing webhooks
+-- notification_destinations.secret: 署名用シークレット（webhook チャネルのみ）
+-- notification_destinations.previous_secret: ローテーション前のシークレット（猶予期間中は両方で署名）
+-- notification_destinations.previous_secret_expires_at: 旧シークレットの有効期限
+-- webhook_deliveries: webhook の配信ログ（HTTP ステータス・所要時間・エラー）
+
+ALTER TABLE notification_destinations ADD COLUMN secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE notification_destinations ADD COLUMN previous_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE notification_destinations ADD COLUMN previous_secret_expires_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    notification_id TEXT,
+    url TEXT NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    success INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    attempted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_team ON webhook_deliveries(team_id, attempted_at);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (026, '026-outgoing-webhooks');
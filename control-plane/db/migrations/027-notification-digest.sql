@@ -0,0 +1,20 @@
+-- Digest notifications
+-- teams.digest_mode: 通知タイミング（instant = 即時, daily = 日次まとめ, weekly = 週次まとめ）
+-- teams.digest_time: まとめを送る時刻（JST, HH:MM）
+-- teams.digest_weekday: 週次まとめを送る曜日（0=日 ... 6=土）
+-- watch_conditions.digest_mode: ルールごとの通知タイミング（NULL = チームの設定を使う）
+-- notifications.digest: まとめ配信に回された通知のモード（NULL = 即時）。配信予定時刻は next_attempt_at
+-- slots.last_seen_at: スクレイプで最後に確認された日時（まとめ送信前に消えた枠の判定に使う）
+
+ALTER TABLE teams ADD COLUMN digest_mode TEXT NOT NULL DEFAULT 'instant';
+ALTER TABLE teams ADD COLUMN digest_time TEXT NOT NULL DEFAULT '08:00';
+ALTER TABLE teams ADD COLUMN digest_weekday INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE watch_conditions ADD COLUMN digest_mode TEXT;
+ALTER TABLE notifications ADD COLUMN digest TEXT;
+ALTER TABLE slots ADD COLUMN last_seen_at TIMESTAMP;
+
+UPDATE slots SET last_seen_at = scraped_at WHERE last_seen_at IS NULL;
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (027, '027-notification-digest');
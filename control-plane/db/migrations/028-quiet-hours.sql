@@ -0,0 +1,13 @@
+-- Quiet hours and throttling
+-- teams.quiet_start / quiet_end: 通知を控える時間帯（JST, HH:MM。NULL = なし。日付をまたいでもよい）
+-- teams.quiet_urgent_bypass: 当日の空き枠は静かな時間帯でも通知する
+-- teams.min_interval_minutes: 同じチャネルへの通知の最小間隔（分, 0 = 制限なし）
+
+ALTER TABLE teams ADD COLUMN quiet_start TEXT;
+ALTER TABLE teams ADD COLUMN quiet_end TEXT;
+ALTER TABLE teams ADD COLUMN quiet_urgent_bypass INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE teams ADD COLUMN min_interval_minutes INTEGER NOT NULL DEFAULT 0;
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (028, '028-quiet-hours');
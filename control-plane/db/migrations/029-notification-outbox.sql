@@ -0,0 +1,30 @@
+-- Notification outbox
+-- 送信1回分（チーム×チャネル×まとめ）を notification_messages に記録してから送信する。
+-- id は冪等キーとして対応するプロバイダにも渡す。
+-- status: sending（送信中。lease_expires_at を過ぎたら送信途中で停止したとみなす）, sent, failed,
+--         unknown（送信中に停止し、重複を避けるため再送しなかった）
+-- notifications.status に 'sending'（送信メッセージに確保済み）を追加し、message_id で紐づける。
+
+CREATE TABLE IF NOT EXISTS notification_messages (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    digest TEXT,
+    status TEXT NOT NULL DEFAULT 'sending',
+    lease_expires_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_messages_status ON notification_messages(status, lease_expires_at);
+
+ALTER TABLE notifications ADD COLUMN message_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_notifications_message ON notifications(message_id);
+
+-- どの送信メッセージでの試行か
+ALTER TABLE notification_attempts ADD COLUMN message_id TEXT;
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (029, '029-notification-outbox');
@@ -0,0 +1,22 @@
+-- Web Push subscriptions
+-- push_subscriptions: チームのブラウザ・端末ごとの Web Push 購読
+-- endpoint: プッシュサービスの送信先 URL（端末ごとに一意）
+-- p256dh / auth: ペイロード暗号化用の端末の公開鍵と認証シークレット（base64url）
+-- last_success_at: 最後に配信に成功した日時。404/410 が返った購読は worker が削除する
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    endpoint TEXT NOT NULL UNIQUE,
+    p256dh TEXT NOT NULL,
+    auth TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_success_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_team ON push_subscriptions(team_id);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (030, '030-push-subscriptions');
@@ -0,0 +1,35 @@
+-- Channel test notifications and channel health
+-- channel_tests: ダッシュボードから依頼されたテスト通知。worker がサンプル通知を送信し結果を記録する
+-- status: pending（送信待ち）, sending（送信中）, sent, failed
+-- response: 送信結果（失敗時はプロバイダのエラー）
+-- channel_health: チーム×チャネルごとの配信状況
+-- verified_at: テスト通知または実際の通知が初めて届いた日時（通知先の変更でリセット）
+-- consecutive_failures: 連続した配信失敗の回数。成功で 0 に戻る
+
+CREATE TABLE IF NOT EXISTS channel_tests (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    response TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_channel_tests_status ON channel_tests(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_channel_tests_team ON channel_tests(team_id, channel, created_at);
+
+CREATE TABLE IF NOT EXISTS channel_health (
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    verified_at TIMESTAMP,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    last_success_at TIMESTAMP,
+    last_failure_at TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (team_id, channel)
+);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (031, '031-channel-tests');
@@ -0,0 +1,39 @@
+-- Team members
+-- team_members: 代表者（teams.email）以外に通知を受け取るチームのメンバー。メンバーごとの連絡先と通知方法を持つ
+-- channels: メンバーの通知方法（JSON 配列）。email, push に対応
+-- watch_condition_members: 監視条件ごとに通知を受け取るメンバー（メンバー自身がオプトインする）
+-- notifications.member_id: メンバー宛ての通知（NULL = チームの通知先宛て）。メンバーごとに配信状況を持つ
+-- notification_messages.member_id: 送信メッセージの宛先メンバー
+-- push_subscriptions.member_id: メンバーの端末の購読（NULL = チームの端末）
+
+CREATE TABLE IF NOT EXISTS team_members (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    channels TEXT NOT NULL DEFAULT '["email"]',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_team_members_team ON team_members(team_id);
+
+CREATE TABLE IF NOT EXISTS watch_condition_members (
+    watch_condition_id TEXT NOT NULL REFERENCES watch_conditions(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES team_members(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (watch_condition_id, member_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_watch_condition_members_member ON watch_condition_members(member_id);
+
+ALTER TABLE notifications ADD COLUMN member_id TEXT REFERENCES team_members(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_notifications_member ON notifications(member_id);
+
+ALTER TABLE notification_messages ADD COLUMN member_id TEXT;
+
+ALTER TABLE push_subscriptions ADD COLUMN member_id TEXT REFERENCES team_members(id) ON DELETE CASCADE;
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (032, '032-team-members');
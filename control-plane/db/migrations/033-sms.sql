@@ -0,0 +1,45 @@
+-- SMS notifications
+-- plan_limits.monthly_sms_quota: 1か月(JST)あたりに送れる SMS の通数（0 = SMS 通知なし）。確認コードとテスト通知も含む
+-- team_members.phone: メンバーの確認済みの電話番号（E.164）。チームの電話番号は notification_destinations（channel = 'sms'）に保存する
+-- phone_verifications: 電話番号の確認コード。code_hash は SHA-256、attempts は入力の失敗回数
+-- member_id: 確認するメンバー（NULL = チームの通知先）
+-- sms_usage: 送信した SMS（kind: notification, test, verification）。プランの上限の集計に使う
+-- segments: 課金単位のセグメント数
+
+ALTER TABLE plan_limits ADD COLUMN monthly_sms_quota INTEGER NOT NULL DEFAULT 0;
+
+UPDATE plan_limits SET monthly_sms_quota = 0 WHERE plan = 'free';
+UPDATE plan_limits SET monthly_sms_quota = 30 WHERE plan = 'personal';
+UPDATE plan_limits SET monthly_sms_quota = 100 WHERE plan = 'pro';
+UPDATE plan_limits SET monthly_sms_quota = 500 WHERE plan = 'org';
+
+ALTER TABLE team_members ADD COLUMN phone TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS phone_verifications (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    member_id TEXT REFERENCES team_members(id) ON DELETE CASCADE,
+    phone TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    verified_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_phone_verifications_team ON phone_verifications(team_id, created_at);
+
+CREATE TABLE IF NOT EXISTS sms_usage (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    member_id TEXT,
+    kind TEXT NOT NULL,
+    segments INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sms_usage_team ON sms_usage(team_id, created_at);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (033, '033-sms');
@@ -0,0 +1,39 @@
+-- Click tracking and bookings
+-- notification_clicks: 通知内の予約リンク（/r/）のクリック。通知・メッセージ・枠・チャネルごとに記録する
+-- 枠の削除で通知が消えても集計できるよう、notification_id・message_id・slot_id には外部キーを張らない
+-- slot_bookings: チームが「予約した」と記録した枠（1チーム1枠につき1件）
+-- notification_id / message_id / channel: 予約につながった通知（ダッシュボードから記録し該当の通知がない場合は NULL / ''）
+-- source: link（通知のリンクから）, dashboard（マイページから）
+
+CREATE TABLE IF NOT EXISTS notification_clicks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    notification_id TEXT NOT NULL,
+    message_id TEXT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    member_id TEXT,
+    slot_id TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    clicked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_clicks_notification ON notification_clicks(notification_id);
+CREATE INDEX IF NOT EXISTS idx_notification_clicks_message ON notification_clicks(message_id);
+
+CREATE TABLE IF NOT EXISTS slot_bookings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    slot_id TEXT NOT NULL,
+    notification_id TEXT,
+    message_id TEXT,
+    member_id TEXT,
+    channel TEXT NOT NULL DEFAULT '',
+    source TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(team_id, slot_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_slot_bookings_message ON slot_bookings(message_id);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (034, '034-click-tracking');
@@ -0,0 +1,45 @@
+-- Slot coordination within a team
+-- slot_claims: 「この枠を予約します」の宣言（1チーム1枠につき1件）。expires_at を過ぎた宣言は無効で、誰でも宣言し直せる
+-- id: 宣言ごとのID。宣言し直すと新しいIDになり、通知済みの記録はこのIDに付ける
+-- member_id: 宣言したメンバー（NULL = チームの代表者）
+-- notified_at: ほかのメンバーへの宣言の通知を送信キューに積んだ日時（NULL = 未通知。ワーカーが送信する）
+-- notification_messages.claim_id: 宣言の通知のとき slot_claims.id（NULL = 枠の通知）
+-- notification_messages.notification_id: 宣言の通知の宛先へ送った枠の通知（リンクと宛先はこの通知のもの）
+-- notification_messages.attempts / next_attempt_at: 宣言の通知の送信回数と次の再送日時。再送を待つ通知は status = 'pending'
+-- slot_votes: 枠を取るかどうかのメンバーの投票（1人1枠につき1票）。vote は yes / no
+-- 予約済み（slot_bookings）の枠は、チームへ通知しない
+
+CREATE TABLE IF NOT EXISTS slot_claims (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    slot_id TEXT NOT NULL REFERENCES slots(id) ON DELETE CASCADE,
+    member_id TEXT REFERENCES team_members(id) ON DELETE CASCADE,
+    claimed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    notified_at TIMESTAMP,
+    UNIQUE (team_id, slot_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_slot_claims_notified ON slot_claims(notified_at);
+
+CREATE TABLE IF NOT EXISTS slot_votes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    slot_id TEXT NOT NULL REFERENCES slots(id) ON DELETE CASCADE,
+    member_id TEXT REFERENCES team_members(id) ON DELETE CASCADE,
+    vote TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_slot_votes_slot ON slot_votes(team_id, slot_id);
+
+ALTER TABLE notification_messages ADD COLUMN claim_id INTEGER;
+ALTER TABLE notification_messages ADD COLUMN notification_id TEXT;
+ALTER TABLE notification_messages ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE notification_messages ADD COLUMN next_attempt_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_notification_messages_claim ON notification_messages(claim_id, status);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (035, '035-slot-coordination');
@@ -0,0 +1,9 @@
+-- Per-slot reservation links
+-- slots.reservation_url: 枠の予約ページ（施設・日付まで指定したページ）。'' のときは municipalities.url（予約サイトのトップ）を使う
+-- スクレイパーが枠ごとに設定し、同じ枠を再取得したときに更新する。予約ページは GET で開く
+
+ALTER TABLE slots ADD COLUMN reservation_url TEXT NOT NULL DEFAULT '';
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (036, '036-slot-reservation-links');
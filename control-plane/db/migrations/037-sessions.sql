@@ -0,0 +1,18 @@
+-- Login sessions
+-- sessions: マジックリンク・Google ログインで発行するセッション。Cookie のトークンは保存せず、SHA-256 ハッシュ（token_hash）だけを保存する
+-- expires_at: 有効期限。利用されるたびに延長する（last_seen_at は最後に利用された日時）
+-- ログアウトやチームの削除で行を削除する
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_team ON sessions(team_id);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (037, '037-sessions');
@@ -0,0 +1,27 @@
+-- Login activity
+-- sessions.user_agent / ip: ログインした端末のブラウザと IP アドレス（ip は利用時に最新の値へ更新する）
+-- sessions.method: ログイン方法（magic_link, google）
+-- login_history: マジックリンク・Google ログインの履歴。セッションを削除しても残す
+-- device_hash: 端末 Cookie（akigura_device）の SHA-256 ハッシュ。チームの履歴にない端末からのログインは new_device = 1 とし、チームのメールアドレスへ通知する
+
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN method TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS login_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    session_id TEXT NOT NULL,
+    method TEXT NOT NULL,
+    device_hash TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    new_device INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_login_history_team ON login_history(team_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_history_device ON login_history(team_id, device_hash);
+
+-- Record execution of this migration
+INSERT OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES (038, '038-login-activity');
@@ -28,9 +28,21 @@ CREATE TABLE teams (
     billing_interval TEXT DEFAULT 'monthly', -- monthly, yearly
     current_period_end TIMESTAMP,
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    notification_channels TEXT NOT NULL DEFAULT '["email"]',
+    line_user_id TEXT,
+    line_followed INTEGER NOT NULL DEFAULT 0,
+    line_linked_at TIMESTAMP,
+    digest_mode TEXT NOT NULL DEFAULT 'instant', -- instant, daily, weekly
+    digest_time TEXT NOT NULL DEFAULT '08:00', -- JST HH:MM
+    digest_weekday INTEGER NOT NULL DEFAULT 1, -- 0=Sun ... 6=Sat (weekly)
+    quiet_start TEXT, -- JST HH:MM (NULL = no quiet hours)
+    quiet_end TEXT,
+    quiet_urgent_bypass INTEGER NOT NULL DEFAULT 0,
+    min_interval_minutes INTEGER NOT NULL DEFAULT 0
 );
 CREATE INDEX idx_teams_stripe_customer ON teams(stripe_customer_id);
+CREATE UNIQUE INDEX idx_teams_line_user ON teams(line_user_id) WHERE line_user_id IS NOT NULL;
 
 -- Municipalities (one per scraper)
 CREATE TABLE municipalities (
@@ -70,7 +82,10 @@ CREATE TABLE plan_limits (
     max_grounds INTEGER NOT NULL,
     weekend_only INTEGER NOT NULL DEFAULT 0,
     max_conditions_per_ground INTEGER NOT NULL,
-    notification_priority INTEGER NOT NULL DEFAULT 0
+    notification_priority INTEGER NOT NULL DEFAULT 0,
+    notification_delay_minutes INTEGER NOT NULL DEFAULT 0,
+    daily_notification_cap INTEGER NOT NULL DEFAULT 0, -- 0 = unlimited
+    monthly_sms_quota INTEGER NOT NULL DEFAULT 0 -- SMS per JST month, 0 = no SMS
 );
 
 -- Watch Conditions
@@ -85,11 +100,40 @@ CREATE TABLE watch_conditions (
     date_to DATE,
     enabled INTEGER NOT NULL DEFAULT 1,
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    min_lead_days INTEGER NOT NULL DEFAULT 0,
+    max_lead_days INTEGER NOT NULL DEFAULT 0,
+    excluded_dates TEXT NOT NULL DEFAULT '[]',
+    bundle_type TEXT NOT NULL DEFAULT '',
+    bundle_size INTEGER NOT NULL DEFAULT 1,
+    channels TEXT,
+    digest_mode TEXT
 );
 CREATE INDEX idx_watch_conditions_team ON watch_conditions(team_id);
 CREATE INDEX idx_watch_conditions_facility ON watch_conditions(facility_id);
 
+-- Team members notified in addition to the team's own destinations
+CREATE TABLE team_members (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    channels TEXT NOT NULL DEFAULT '["email"]', -- JSON array: email, push, sms
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    phone TEXT NOT NULL DEFAULT '' -- verified phone number (E.164)
+);
+CREATE INDEX idx_team_members_team ON team_members(team_id);
+
+-- Members opted in to a watch condition's notifications
+CREATE TABLE watch_condition_members (
+    watch_condition_id TEXT NOT NULL REFERENCES watch_conditions(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES team_members(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (watch_condition_id, member_id)
+);
+CREATE INDEX idx_watch_condition_members_member ON watch_condition_members(member_id);
+
 -- Slots (available time slots from scraping)
 CREATE TABLE slots (
     id TEXT PRIMARY KEY,
@@ -102,6 +146,9 @@ CREATE TABLE slots (
     court_name TEXT,
     raw_text TEXT,
     scraped_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP,
+    -- Most specific reservation page for the slot ('' = the municipality's url)
+    reservation_url TEXT NOT NULL DEFAULT '',
     UNIQUE(municipality_id, slot_date, time_from, time_to, court_name)
 );
 CREATE INDEX idx_slots_municipality ON slots(municipality_id);
@@ -118,9 +165,201 @@ CREATE TABLE notifications (
     channel TEXT NOT NULL,
     status TEXT NOT NULL DEFAULT 'pending',
     sent_at TIMESTAMP,
-    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    bundle_id TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP,
+    last_error TEXT,
+    digest TEXT, -- daily, weekly when held for a digest (NULL = instant)
+    message_id TEXT, -- notification_messages.id of the latest send
+    member_id TEXT REFERENCES team_members(id) ON DELETE CASCADE -- NULL = the team's own destination
 );
 CREATE INDEX idx_notifications_team ON notifications(team_id);
+CREATE INDEX idx_notifications_member ON notifications(member_id);
+CREATE INDEX idx_notifications_bundle ON notifications(bundle_id);
+CREATE INDEX idx_notifications_message ON notifications(message_id);
+
+-- Notification outbox: one row per message sent to a team on a channel.
+-- The id is the idempotency key passed to providers that support one.
+CREATE TABLE notification_messages (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    digest TEXT,
+    status TEXT NOT NULL DEFAULT 'sending', -- pending (claim notices waiting to be sent), sending, sent, failed, unknown
+    lease_expires_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP,
+    member_id TEXT,
+    claim_id INTEGER, -- slot_claims.id for a claim notice; NULL = a slot notification
+    notification_id TEXT, -- for a claim notice, the slot notification sent to the same recipient
+    attempts INTEGER NOT NULL DEFAULT 0, -- claim notices only
+    next_attempt_at TIMESTAMP -- claim notices only
+);
+CREATE INDEX idx_notification_messages_status ON notification_messages(status, lease_expires_at);
+CREATE INDEX idx_notification_messages_claim ON notification_messages(claim_id, status);
+
+-- Notification delivery attempts
+CREATE TABLE notification_attempts (
+    id TEXT PRIMARY KEY,
+    notification_id TEXT NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+    attempt INTEGER NOT NULL,
+    channel TEXT NOT NULL,
+    success INTEGER NOT NULL,
+    error TEXT,
+    attempted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    message_id TEXT
+);
+CREATE INDEX idx_notification_attempts_notification ON notification_attempts(notification_id);
+
+-- Per-team notification destinations (Slack / Discord webhook URLs)
+CREATE TABLE notification_destinations (
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    target TEXT NOT NULL,
+    verified_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    secret TEXT NOT NULL DEFAULT '',
+    previous_secret TEXT NOT NULL DEFAULT '',
+    previous_secret_expires_at TIMESTAMP,
+    PRIMARY KEY (team_id, channel)
+);
+
+-- Web Push subscriptions, one per browser/device
+CREATE TABLE push_subscriptions (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    endpoint TEXT NOT NULL UNIQUE,
+    p256dh TEXT NOT NULL,
+    auth TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_success_at TIMESTAMP,
+    member_id TEXT REFERENCES team_members(id) ON DELETE CASCADE -- NULL = a device of the team
+);
+CREATE INDEX idx_push_subscriptions_team ON push_subscriptions(team_id);
+
+-- Test notifications requested from the dashboard, sent by the worker
+CREATE TABLE channel_tests (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, sending, sent, failed
+    response TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+CREATE INDEX idx_channel_tests_status ON channel_tests(status, created_at);
+CREATE INDEX idx_channel_tests_team ON channel_tests(team_id, channel, created_at);
+
+-- One-time codes verifying a phone number for SMS
+CREATE TABLE phone_verifications (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    member_id TEXT REFERENCES team_members(id) ON DELETE CASCADE, -- NULL = the team's own destination
+    phone TEXT NOT NULL,
+    code_hash TEXT NOT NULL, -- SHA-256 of the code
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    verified_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_phone_verifications_team ON phone_verifications(team_id, created_at);
+
+-- SMS sent per team, counted against the plan's monthly quota
+CREATE TABLE sms_usage (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    member_id TEXT,
+    kind TEXT NOT NULL, -- notification, test, verification
+    segments INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_sms_usage_team ON sms_usage(team_id, created_at);
+
+-- Clicks on tracked reservation links in notifications. IDs are kept
+-- without foreign keys so the counts outlive purged slots.
+CREATE TABLE notification_clicks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    notification_id TEXT NOT NULL,
+    message_id TEXT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    member_id TEXT,
+    slot_id TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    clicked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_notification_clicks_notification ON notification_clicks(notification_id);
+CREATE INDEX idx_notification_clicks_message ON notification_clicks(message_id);
+
+-- Slots a team marked as booked, credited to the notification they came from
+CREATE TABLE slot_bookings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    slot_id TEXT NOT NULL,
+    notification_id TEXT,
+    message_id TEXT,
+    member_id TEXT,
+    channel TEXT NOT NULL DEFAULT '',
+    source TEXT NOT NULL, -- link, dashboard
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(team_id, slot_id)
+);
+CREATE INDEX idx_slot_bookings_message ON slot_bookings(message_id);
+
+-- "I'm booking this" claims on a slot, one per team and slot; void after expires_at
+CREATE TABLE slot_claims (
+    id INTEGER PRIMARY KEY AUTOINCREMENT, -- a new id for every claim, so a notice is recorded on the claim it announces
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    slot_id TEXT NOT NULL REFERENCES slots(id) ON DELETE CASCADE,
+    member_id TEXT REFERENCES team_members(id) ON DELETE CASCADE, -- NULL = the team's representative
+    claimed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    notified_at TIMESTAMP, -- when the other members' notices were queued; NULL = not yet
+    UNIQUE (team_id, slot_id)
+);
+CREATE INDEX idx_slot_claims_notified ON slot_claims(notified_at);
+
+-- Member votes on whether to take a slot, one per member and slot
+CREATE TABLE slot_votes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    slot_id TEXT NOT NULL REFERENCES slots(id) ON DELETE CASCADE,
+    member_id TEXT REFERENCES team_members(id) ON DELETE CASCADE, -- NULL = the team's representative
+    vote TEXT NOT NULL, -- yes, no
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_slot_votes_slot ON slot_votes(team_id, slot_id);
+
+-- Delivery health per team and channel
+CREATE TABLE channel_health (
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    verified_at TIMESTAMP,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    last_success_at TIMESTAMP,
+    last_failure_at TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (team_id, channel)
+);
+
+-- Outgoing webhook delivery log
+CREATE TABLE webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    notification_id TEXT,
+    url TEXT NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    success INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    attempted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_webhook_deliveries_team ON webhook_deliveries(team_id, attempted_at);
 
 -- Scrape Jobs
 CREATE TABLE scrape_jobs (
@@ -182,6 +421,35 @@ CREATE TABLE auth_tokens (
 CREATE INDEX idx_auth_tokens_token ON auth_tokens(token);
 CREATE INDEX idx_auth_tokens_team ON auth_tokens(team_id);
 
+-- Login sessions (the cookie's token is stored only as its SHA-256 hash)
+CREATE TABLE sessions (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL, -- extended on use
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '', -- latest IP the session was used from
+    method TEXT NOT NULL DEFAULT '' -- magic_link, google
+);
+CREATE INDEX idx_sessions_team ON sessions(team_id);
+
+-- Login history (kept when sessions end); device_hash is the hashed device cookie
+CREATE TABLE login_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    session_id TEXT NOT NULL,
+    method TEXT NOT NULL,
+    device_hash TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    new_device INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_login_history_team ON login_history(team_id, created_at);
+CREATE INDEX idx_login_history_device ON login_history(team_id, device_hash);
+
 -- Promo Codes
 CREATE TABLE promo_codes (
     id TEXT PRIMARY KEY,
@@ -3,6 +3,7 @@ module srv.exe.dev
 go 1.24.0
 
 require (
+	akigura.dev/shared v0.0.0-00010101000000-000000000000
 	github.com/google/uuid v1.6.0
 	github.com/tursodatabase/libsql-client-go v0.0.0-20251219100830-236aa1ff8acc
 	modernc.org/sqlite v1.39.0
@@ -75,3 +76,5 @@ require (
 )
 
 tool github.com/sqlc-dev/sqlc/cmd/sqlc
+
+replace akigura.dev/shared => ../shared
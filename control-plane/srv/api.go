@@ -11,6 +11,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"srv.exe.dev/db/dbgen"
@@ -556,7 +557,8 @@ func (s *Server) HandleListGrounds(w http.ResponseWriter, r *http.Request) {
 // Plan Limits API
 func (s *Server) HandleGetPlanLimits(w http.ResponseWriter, r *http.Request) {
 	rows, err := s.DB.QueryContext(r.Context(), `
-		SELECT plan, max_grounds, weekend_only, max_conditions_per_ground, notification_priority
+		SELECT plan, max_grounds, weekend_only, max_conditions_per_ground, notification_priority,
+		       notification_delay_minutes, daily_notification_cap, monthly_sms_quota
 		FROM plan_limits
 	`)
 	if err != nil {
@@ -566,17 +568,21 @@ func (s *Server) HandleGetPlanLimits(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	type PlanLimit struct {
-		Plan                   string `json:"plan"`
-		MaxGrounds             int    `json:"max_grounds"`
-		WeekendOnly            bool   `json:"weekend_only"`
-		MaxConditionsPerGround int    `json:"max_conditions_per_ground"`
-		NotificationPriority   int    `json:"notification_priority"`
+		Plan                     string `json:"plan"`
+		MaxGrounds               int    `json:"max_grounds"`
+		WeekendOnly              bool   `json:"weekend_only"`
+		MaxConditionsPerGround   int    `json:"max_conditions_per_ground"`
+		NotificationPriority     int    `json:"notification_priority"`
+		NotificationDelayMinutes int    `json:"notification_delay_minutes"`
+		DailyNotificationCap     int    `json:"daily_notification_cap"` // 0 = unlimited
+		MonthlySMSQuota          int    `json:"monthly_sms_quota"`      // 0 = no SMS
 	}
 	var limits []PlanLimit
 	for rows.Next() {
 		var l PlanLimit
 		var weekendOnly int
-		if err := rows.Scan(&l.Plan, &l.MaxGrounds, &weekendOnly, &l.MaxConditionsPerGround, &l.NotificationPriority); err != nil {
+		if err := rows.Scan(&l.Plan, &l.MaxGrounds, &weekendOnly, &l.MaxConditionsPerGround, &l.NotificationPriority,
+			&l.NotificationDelayMinutes, &l.DailyNotificationCap, &l.MonthlySMSQuota); err != nil {
 			continue
 		}
 		l.WeekendOnly = weekendOnly == 1
@@ -701,23 +707,63 @@ func (s *Server) HandleListConditions(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) HandleCreateCondition(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		TeamID     string `json:"team_id"`
-		FacilityID string `json:"facility_id"`
-		DaysOfWeek string `json:"days_of_week"`
-		TimeFrom   string `json:"time_from"`
-		TimeTo     string `json:"time_to"`
+		TeamID        string   `json:"team_id"`
+		FacilityID    string   `json:"facility_id"`
+		DaysOfWeek    string   `json:"days_of_week"`
+		TimeFrom      string   `json:"time_from"`
+		TimeTo        string   `json:"time_to"`
+		MinLeadDays   int      `json:"min_lead_days"`
+		MaxLeadDays   int      `json:"max_lead_days"`
+		ExcludedDates []string `json:"excluded_dates"`
+		BundleType    string   `json:"bundle_type"`
+		BundleSize    int      `json:"bundle_size"`
+		Channels      []string `json:"channels"`    // empty = use the team's channels
+		DigestMode    string   `json:"digest_mode"` // empty = use the team's mode
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		s.jsonError(w, "invalid request", http.StatusBadRequest)
 		return
 	}
+	excludedDates, err := validateBookingWindow(req.MinLeadDays, req.MaxLeadDays, req.ExcludedDates)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bundleSize, err := validateBundle(req.BundleType, req.BundleSize)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var channels sql.NullString
+	if len(req.Channels) > 0 {
+		if channels.String, err = normalizeChannels(req.Channels); err != nil {
+			s.jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		channels.Valid = true
+	}
+	var digestMode sql.NullString
+	if req.DigestMode != "" {
+		if err := validateDigest(req.DigestMode, "00:00", 0); err != nil {
+			s.jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		digestMode = sql.NullString{String: req.DigestMode, Valid: true}
+	}
 	condition, err := s.Queries.CreateWatchCondition(r.Context(), dbgen.CreateWatchConditionParams{
-		ID:         uuid.New().String(),
-		TeamID:     req.TeamID,
-		FacilityID: req.FacilityID,
-		DaysOfWeek: req.DaysOfWeek,
-		TimeFrom:   req.TimeFrom,
-		TimeTo:     req.TimeTo,
+		ID:            uuid.New().String(),
+		TeamID:        req.TeamID,
+		FacilityID:    req.FacilityID,
+		DaysOfWeek:    req.DaysOfWeek,
+		TimeFrom:      req.TimeFrom,
+		TimeTo:        req.TimeTo,
+		MinLeadDays:   int64(req.MinLeadDays),
+		MaxLeadDays:   int64(req.MaxLeadDays),
+		ExcludedDates: excludedDates,
+		BundleType:    req.BundleType,
+		BundleSize:    int64(bundleSize),
+		Channels:      channels,
+		DigestMode:    digestMode,
 	})
 	if err != nil {
 		s.jsonError(w, err.Error(), http.StatusInternalServerError)
@@ -726,6 +772,57 @@ func (s *Server) HandleCreateCondition(w http.ResponseWriter, r *http.Request) {
 	s.jsonResponse(w, condition)
 }
 
+// validateBookingWindow checks the relative booking window and exclusion dates
+// of a watch condition. It returns the exclusion dates as a sorted, de-duplicated
+// JSON array ready to be stored in excluded_dates.
+func validateBookingWindow(minLead, maxLead int, excluded []string) (string, error) {
+	if minLead < 0 || maxLead < 0 {
+		return "", fmt.Errorf("min_lead_days and max_lead_days must not be negative")
+	}
+	if minLead > MaxLeadDays || maxLead > MaxLeadDays {
+		return "", fmt.Errorf("lead days must be at most %d", MaxLeadDays)
+	}
+	if maxLead > 0 && minLead > maxLead {
+		return "", fmt.Errorf("min_lead_days must not exceed max_lead_days")
+	}
+	if len(excluded) > MaxExcludedDates {
+		return "", fmt.Errorf("at most %d excluded_dates are allowed", MaxExcludedDates)
+	}
+
+	dates := make([]string, 0, len(excluded))
+	for _, d := range excluded {
+		d = strings.TrimSpace(d)
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return "", fmt.Errorf("invalid excluded date %q (expected YYYY-MM-DD)", d)
+		}
+		dates = append(dates, d)
+	}
+	slices.Sort(dates)
+	dates = slices.Compact(dates)
+
+	b, err := json.Marshal(dates)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// validateBundle checks the bundle settings of a watch condition and returns
+// the size to store. Conditions without a bundle type always have size 1.
+func validateBundle(bundleType string, size int) (int, error) {
+	switch bundleType {
+	case "":
+		return 1, nil
+	case BundleTypeCourts, BundleTypeDays:
+		if size < 2 || size > MaxBundleSize {
+			return 0, fmt.Errorf("bundle_size must be between 2 and %d", MaxBundleSize)
+		}
+		return size, nil
+	default:
+		return 0, fmt.Errorf("invalid bundle_type: %s", bundleType)
+	}
+}
+
 func (s *Server) HandleDeleteCondition(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
@@ -752,14 +849,24 @@ func (s *Server) HandleListNotifications(w http.ResponseWriter, r *http.Request)
 	rows, err := s.DB.QueryContext(r.Context(), `
 		SELECT 
 			n.id, n.team_id, n.watch_condition_id, n.slot_id, n.channel, n.status, n.sent_at, n.created_at,
+			COALESCE(n.bundle_id, '') as bundle_id,
 			COALESCE(g.name, '') as facility_name,
 			COALESCE(s.slot_date, '') as slot_date, 
 			COALESCE(s.time_from, '') as slot_time_from, 
 			COALESCE(s.time_to, '') as slot_time_to, 
-			COALESCE(s.court_name, '') as court_name
+			COALESCE(s.court_name, '') as court_name,
+			EXISTS (SELECT 1 FROM slot_bookings b WHERE b.team_id = n.team_id AND b.slot_id = n.slot_id) as booked,
+			(SELECT COUNT(*) FROM notification_clicks c WHERE c.notification_id = n.id) as clicks,
+			COALESCE(cl.member_id, '') as claim_member_id,
+			CASE WHEN cl.team_id IS NULL THEN '' WHEN cl.member_id IS NULL THEN '代表者' ELSE COALESCE(tm.name, '') || ' さん' END as claimed_by,
+			COALESCE(cl.expires_at, '') as claim_expires_at,
+			(SELECT COUNT(*) FROM slot_votes v WHERE v.team_id = n.team_id AND v.slot_id = n.slot_id AND v.vote = 'yes') as votes_yes,
+			(SELECT COUNT(*) FROM slot_votes v WHERE v.team_id = n.team_id AND v.slot_id = n.slot_id AND v.vote = 'no') as votes_no
 		FROM notifications n
 		LEFT JOIN slots s ON n.slot_id = s.id
 		LEFT JOIN grounds g ON s.ground_id = g.id
+		LEFT JOIN slot_claims cl ON cl.team_id = n.team_id AND cl.slot_id = n.slot_id AND cl.expires_at > datetime('now')
+		LEFT JOIN team_members tm ON tm.id = cl.member_id
 		WHERE n.team_id = ?
 		ORDER BY n.created_at DESC
 		LIMIT 50
@@ -779,10 +886,19 @@ func (s *Server) HandleListNotifications(w http.ResponseWriter, r *http.Request)
 		Status           string  `json:"status"`
 		SentAt           *string `json:"sent_at"`
 		CreatedAt        string  `json:"created_at"`
+		BundleID         string  `json:"bundle_id"`
 		FacilityName     string  `json:"facility_name"`
 		SlotDate         string  `json:"slot_date"`
 		SlotTime         string  `json:"slot_time"`
 		CourtName        string  `json:"court_name"`
+		Booked           bool    `json:"booked"`
+		Clicks           int     `json:"clicks"`
+		// Coordination: the open claim, if any, and the votes on the slot
+		ClaimMemberID  string `json:"claim_member_id"`
+		ClaimedBy      string `json:"claimed_by"`
+		ClaimExpiresAt string `json:"claim_expires_at"`
+		VotesYes       int    `json:"votes_yes"`
+		VotesNo        int    `json:"votes_no"`
 	}
 
 	var notifications []NotificationWithSlot
@@ -790,7 +906,8 @@ func (s *Server) HandleListNotifications(w http.ResponseWriter, r *http.Request)
 		var n NotificationWithSlot
 		var timeFrom, timeTo string
 		if err := rows.Scan(&n.ID, &n.TeamID, &n.WatchConditionID, &n.SlotID, &n.Channel, &n.Status, &n.SentAt, &n.CreatedAt,
-			&n.FacilityName, &n.SlotDate, &timeFrom, &timeTo, &n.CourtName); err != nil {
+			&n.BundleID, &n.FacilityName, &n.SlotDate, &timeFrom, &timeTo, &n.CourtName, &n.Booked, &n.Clicks,
+			&n.ClaimMemberID, &n.ClaimedBy, &n.ClaimExpiresAt, &n.VotesYes, &n.VotesNo); err != nil {
 			continue
 		}
 		n.SlotTime = timeFrom + " - " + timeTo
@@ -110,3 +110,105 @@ func TestFacilityHandlers(t *testing.T) {
 		}
 	})
 }
+
+func TestCreateConditionOptions(t *testing.T) {
+	tempDB := filepath.Join(t.TempDir(), "test_conditions.sqlite3")
+	server, err := New(tempDB, "test-hostname")
+	if err != nil {
+		t.Fatalf("サーバー初期化に失敗すべきではない: %v", err)
+	}
+
+	post := func(payload map[string]any) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/api/conditions", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		server.HandleCreateCondition(w, req)
+		return w
+	}
+	base := func() map[string]any {
+		return map[string]any{
+			"team_id":      "team-1",
+			"facility_id":  "ground-1",
+			"days_of_week": "[0,6]",
+			"time_from":    "09:00",
+			"time_to":      "17:00",
+		}
+	}
+
+	t.Run("リード日数と除外日を保存すべき", func(t *testing.T) {
+		payload := base()
+		payload["min_lead_days"] = 3
+		payload["max_lead_days"] = 14
+		payload["excluded_dates"] = []string{"2025-06-08", "2025-06-07", "2025-06-08"}
+		w := post(payload)
+		if w.Code != http.StatusOK {
+			t.Fatalf("作成は 200 を返すべき: %d %s", w.Code, w.Body.String())
+		}
+		var cond dbgen.WatchCondition
+		if err := json.NewDecoder(w.Body).Decode(&cond); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗すべきではない: %v", err)
+		}
+		if cond.MinLeadDays != 3 || cond.MaxLeadDays != 14 {
+			t.Errorf("リード日数が保存されるべき: min=%d max=%d", cond.MinLeadDays, cond.MaxLeadDays)
+		}
+		if cond.ExcludedDates != `["2025-06-07","2025-06-08"]` {
+			t.Errorf("除外日は整列・重複除去されるべき: %s", cond.ExcludedDates)
+		}
+	})
+
+	invalid := []struct {
+		name  string
+		key   string
+		value any
+	}{
+		{"負のリード日数は拒否すべき", "min_lead_days", -1},
+		{"上限を超えるリード日数は拒否すべき", "max_lead_days", MaxLeadDays + 1},
+		{"不正な形式の除外日は拒否すべき", "excluded_dates", []string{"2025/06/07"}},
+		{"未知のセット種別は拒否すべき", "bundle_type", "weeks"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := base()
+			payload[tt.key] = tt.value
+			if w := post(payload); w.Code != http.StatusBadRequest {
+				t.Errorf("400 を返すべき: %d", w.Code)
+			}
+		})
+	}
+
+	t.Run("セット条件を保存すべき", func(t *testing.T) {
+		payload := base()
+		payload["facility_id"] = "ground-2"
+		payload["bundle_type"] = BundleTypeDays
+		payload["bundle_size"] = 2
+		w := post(payload)
+		if w.Code != http.StatusOK {
+			t.Fatalf("作成は 200 を返すべき: %d %s", w.Code, w.Body.String())
+		}
+		var cond dbgen.WatchCondition
+		if err := json.NewDecoder(w.Body).Decode(&cond); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗すべきではない: %v", err)
+		}
+		if cond.BundleType != BundleTypeDays || cond.BundleSize != 2 {
+			t.Errorf("セット条件が保存されるべき: type=%s size=%d", cond.BundleType, cond.BundleSize)
+		}
+	})
+
+	t.Run("セットの数が範囲外の場合は拒否すべき", func(t *testing.T) {
+		payload := base()
+		payload["bundle_type"] = BundleTypeCourts
+		payload["bundle_size"] = 1
+		if w := post(payload); w.Code != http.StatusBadRequest {
+			t.Errorf("400 を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("最低リード日数が最大を超える場合は拒否すべき", func(t *testing.T) {
+		payload := base()
+		payload["min_lead_days"] = 10
+		payload["max_lead_days"] = 5
+		if w := post(payload); w.Code != http.StatusBadRequest {
+			t.Errorf("400 を返すべき: %d", w.Code)
+		}
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"html"
 	"log/slog"
+	"mime"
 	"net/http"
 	"net/mail"
 	"net/smtp"
@@ -157,7 +158,7 @@ func (s *Server) HandleRequestMagicLink(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-// HandleVerifyMagicLink verifies the magic link token
+// HandleVerifyMagicLink verifies the magic link token and logs the team in
 func (s *Server) HandleVerifyMagicLink(w http.ResponseWriter, r *http.Request) {
 	token := r.URL.Query().Get("token")
 	if token == "" {
@@ -185,58 +186,7 @@ func (s *Server) HandleVerifyMagicLink(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get team
-	team, err := s.Queries.GetTeam(ctx, authToken.TeamID)
-	if err != nil {
-		slog.Error("get team", "error", err)
-		http.Error(w, "Team not found", http.StatusInternalServerError)
-		return
-	}
-
-	writeAuthRedirectHTML(w, team, "認証完了")
-}
-
-func teamToJSON(team dbgen.Team) string {
-	data, _ := json.Marshal(map[string]interface{}{
-		"id":         team.ID,
-		"name":       team.Name,
-		"email":      team.Email,
-		"plan":       team.Plan,
-		"status":     team.Status,
-		"created_at": team.CreatedAt,
-		"updated_at": team.UpdatedAt,
-	})
-	// HTML escape the JSON to prevent </script> breakouts
-	var buf bytes.Buffer
-	json.HTMLEscape(&buf, data)
-	return buf.String()
-}
-
-// writeAuthRedirectHTML writes an HTML page that stores team data in localStorage and redirects to /user
-func writeAuthRedirectHTML(w http.ResponseWriter, team dbgen.Team, title string) {
-	teamJSON := teamToJSON(team)
-	teamBase64 := base64.StdEncoding.EncodeToString([]byte(teamJSON))
-
-	htmlContent := fmt.Sprintf(`<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <title>%s - AkiGura</title>
-</head>
-<body>
-    <script>
-        var teamData = JSON.parse(atob('%s'));
-        localStorage.setItem('akigura_team', JSON.stringify(teamData));
-        window.location.href = '/user';
-    </script>
-    <noscript>
-        <p>%sが完了しました。<a href="/user">こちら</a>をクリックしてダッシュボードに移動してください。</p>
-    </noscript>
-</body>
-</html>`, title, teamBase64, title)
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(htmlContent))
+	s.finishLogin(w, r, authToken.TeamID, loginMagicLink)
 }
 
 // buildMagicLinkHTML generates the HTML content for magic link email
@@ -265,26 +215,39 @@ func buildMagicLinkHTML(teamName, magicLink string) string {
 
 // sendMagicLinkEmail sends the magic link via SMTP or SendGrid
 func sendMagicLinkEmail(config AuthConfig, email, teamName, magicLink string) error {
+	if !config.emailConfigured() {
+		slog.Info("Magic link (email not configured)", "email", email, "link", magicLink)
+		return nil
+	}
+	return sendAuthEmail(config, email, "【AkiGura】ログインリンク", buildMagicLinkHTML(teamName, magicLink))
+}
+
+// emailConfigured reports whether account emails can be sent.
+func (c AuthConfig) emailConfigured() bool {
+	return (c.SMTPUser != "" && c.SMTPPassword != "") || c.SendGridKey != ""
+}
+
+// sendAuthEmail sends an account email (login links, login alerts) via SMTP
+// or SendGrid
+func sendAuthEmail(config AuthConfig, email, subject, htmlContent string) error {
 	// Try SMTP first (Gmail)
 	if config.SMTPUser != "" && config.SMTPPassword != "" {
-		return sendMagicLinkEmailSMTP(config, email, teamName, magicLink)
+		return sendAuthEmailSMTP(config, email, subject, htmlContent)
 	}
 
 	// Fall back to SendGrid
 	if config.SendGridKey == "" {
-		slog.Info("Magic link (email not configured)", "email", email, "link", magicLink)
+		slog.Info("Account email (email not configured)", "email", email, "subject", subject)
 		return nil
 	}
 
-	htmlContent := buildMagicLinkHTML(teamName, magicLink)
-
 	payload := map[string]interface{}{
 		"personalizations": []map[string]interface{}{
 			{
 				"to": []map[string]string{
 					{"email": email},
 				},
-				"subject": "【AkiGura】ログインリンク",
+				"subject": subject,
 			},
 		},
 		"from": map[string]string{
@@ -324,8 +287,8 @@ func sendMagicLinkEmail(config AuthConfig, email, teamName, magicLink string) er
 	return nil
 }
 
-// sendMagicLinkEmailSMTP sends the magic link via SMTP (Gmail)
-func sendMagicLinkEmailSMTP(config AuthConfig, email, teamName, magicLink string) error {
+// sendAuthEmailSMTP sends an account email via SMTP (Gmail)
+func sendAuthEmailSMTP(config AuthConfig, email, subject, htmlContent string) error {
 	// Validate and parse email address to prevent injection
 	parsedAddr, err := mail.ParseAddress(email)
 	if err != nil {
@@ -333,20 +296,19 @@ func sendMagicLinkEmailSMTP(config AuthConfig, email, teamName, magicLink string
 	}
 	sanitizedEmail := parsedAddr.Address
 
-	htmlContent := buildMagicLinkHTML(teamName, magicLink)
-	subject := "【AkiGura】ログインリンク"
-
-	msg := fmt.Sprintf("From: %s <%s>\r\n"+
+	// Non-ASCII headers must be MIME-encoded (RFC 2047)
+	from := mail.Address{Name: config.EmailFromName, Address: config.SMTPUser}
+	msg := fmt.Sprintf("From: %s\r\n"+
 		"To: %s\r\n"+
 		"Subject: %s\r\n"+
 		"MIME-Version: 1.0\r\n"+
 		"Content-Type: text/html; charset=UTF-8\r\n"+
 		"\r\n%s",
-		config.EmailFromName, config.SMTPUser, sanitizedEmail, subject, htmlContent)
+		from.String(), sanitizedEmail, mime.BEncoding.Encode("UTF-8", subject), htmlContent)
 
 	auth := smtp.PlainAuth("", config.SMTPUser, config.SMTPPassword, config.SMTPHost)
 	addr := fmt.Sprintf("%s:%s", config.SMTPHost, config.SMTPPort)
 
-	slog.Info("Sending magic link via SMTP", "to", sanitizedEmail, "smtp", config.SMTPHost)
+	slog.Info("Sending account email via SMTP", "to", sanitizedEmail, "subject", subject, "smtp", config.SMTPHost)
 	return smtp.SendMail(addr, auth, config.SMTPUser, []string{sanitizedEmail}, []byte(msg))
 }
@@ -0,0 +1,335 @@
+package srv
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"akigura.dev/shared/match"
+)
+
+// Condition backtest
+//
+// A proposed watch condition is replayed against slots stored over the last
+// N weeks with the worker's own matching rules (akigura.dev/shared/match).
+// Each slot is evaluated as of the JST date it was first scraped, which is
+// when the worker would have notified it. Bundle conditions count complete
+// sets found by match.FindBundles among the slots open on each day, as
+// Matcher.ProcessMatches does.
+
+// BacktestDay is the number of matching slots on one slot date.
+type BacktestDay struct {
+	Date    string `json:"date"`
+	Weekday int    `json:"weekday"`
+	Count   int    `json:"count"`
+}
+
+// BacktestGround is the number of matching slots at one ground.
+type BacktestGround struct {
+	GroundID   string `json:"ground_id"`
+	GroundName string `json:"ground_name"`
+	Count      int    `json:"count"`
+}
+
+// BacktestSuggestion describes a relaxed condition and how often it would have matched.
+type BacktestSuggestion struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+	Matches     int    `json:"matches"`
+}
+
+// BacktestResult summarizes how a condition would have performed.
+type BacktestResult struct {
+	Weeks          int                  `json:"weeks"`
+	SlotsEvaluated int                  `json:"slots_evaluated"`
+	Matches        int                  `json:"matches"`
+	MatchesPerWeek float64              `json:"matches_per_week"`
+	Days           []BacktestDay        `json:"days"`
+	Grounds        []BacktestGround     `json:"grounds"`
+	Suggestions    []BacktestSuggestion `json:"suggestions"`
+}
+
+type backtestSlot struct {
+	explainSlot
+	GroundName string
+	// Noon JST on the dates the slot was first and last scraped. Noon JST
+	// falls on the same date in UTC, so both of the matcher's date bases agree.
+	SeenAt     time.Time
+	LastSeenAt time.Time
+}
+
+// HandleBacktestCondition replays a proposed condition against recent slots.
+// The body is the same condition object as HandleCreateCondition, plus the
+// number of weeks to replay.
+func (s *Server) HandleBacktestCondition(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FacilityID    string   `json:"facility_id"`
+		DaysOfWeek    string   `json:"days_of_week"`
+		TimeFrom      string   `json:"time_from"`
+		TimeTo        string   `json:"time_to"`
+		DateFrom      string   `json:"date_from"`
+		DateTo        string   `json:"date_to"`
+		MinLeadDays   int      `json:"min_lead_days"`
+		MaxLeadDays   int      `json:"max_lead_days"`
+		ExcludedDates []string `json:"excluded_dates"`
+		BundleType    string   `json:"bundle_type"`
+		BundleSize    int      `json:"bundle_size"`
+		Weeks         int      `json:"weeks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.FacilityID == "" {
+		s.jsonError(w, "facility_id required", http.StatusBadRequest)
+		return
+	}
+	if req.Weeks == 0 {
+		req.Weeks = DefaultBacktestWeeks
+	}
+	if req.Weeks < 1 || req.Weeks > MaxBacktestWeeks {
+		s.jsonError(w, fmt.Sprintf("weeks must be between 1 and %d", MaxBacktestWeeks), http.StatusBadRequest)
+		return
+	}
+	excludedJSON, err := validateBookingWindow(req.MinLeadDays, req.MaxLeadDays, req.ExcludedDates)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bundleSize, err := validateBundle(req.BundleType, req.BundleSize)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, d := range []string{req.DateFrom, req.DateTo} {
+		if _, err := time.Parse("2006-01-02", d); d != "" && err != nil {
+			s.jsonError(w, "date_from and date_to must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// An empty time bound leaves that end of the day open
+	cond := explainCondition{
+		ID:          "backtest",
+		TeamStatus:  "active",
+		Enabled:     true,
+		FacilityID:  req.FacilityID,
+		TimeFrom:    cmp.Or(req.TimeFrom, "00:00"),
+		TimeTo:      cmp.Or(req.TimeTo, "24:00"),
+		DateFrom:    req.DateFrom,
+		DateTo:      req.DateTo,
+		MinLeadDays: req.MinLeadDays,
+		MaxLeadDays: req.MaxLeadDays,
+		BundleType:  req.BundleType,
+		BundleSize:  bundleSize,
+	}
+	if req.DaysOfWeek != "" {
+		if err := json.Unmarshal([]byte(req.DaysOfWeek), &cond.DaysOfWeek); err != nil {
+			s.jsonError(w, "days_of_week must be a JSON array", http.StatusBadRequest)
+			return
+		}
+	}
+	_ = json.Unmarshal([]byte(excludedJSON), &cond.ExcludedDates)
+
+	slots, err := s.loadBacktestSlots(r.Context(), req.FacilityID, req.Weeks)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, backtest(slots, cond, req.Weeks))
+}
+
+// loadBacktestSlots returns slots scraped in the last weeks weeks at every
+// ground in the same municipality as groundID, so that relaxing the ground
+// can be evaluated too.
+func (s *Server) loadBacktestSlots(ctx context.Context, groundID string, weeks int) ([]backtestSlot, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT s.id, COALESCE(s.municipality_id, ''), s.ground_id, s.slot_date, s.time_from, s.time_to,
+		       COALESCE(s.court_name, ''), g.name, date(s.scraped_at, '+9 hours'),
+		       date(COALESCE(s.last_seen_at, s.scraped_at), '+9 hours')
+		FROM slots s
+		JOIN grounds g ON g.id = s.ground_id
+		WHERE g.municipality_id = (SELECT municipality_id FROM grounds WHERE id = ?)
+		  AND s.scraped_at >= datetime('now', ?)
+	`, groundID, fmt.Sprintf("-%d days", weeks*7))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slots []backtestSlot
+	for rows.Next() {
+		var sl backtestSlot
+		var seen, lastSeen string
+		if err := rows.Scan(&sl.ID, &sl.MunicipalityID, &sl.GroundID, &sl.Date, &sl.TimeFrom, &sl.TimeTo,
+			&sl.CourtName, &sl.GroundName, &seen, &lastSeen); err != nil {
+			return nil, err
+		}
+		sl.Date = dateOnly(sl.Date)
+		if sl.SeenAt, err = noonJST(seen); err != nil {
+			continue
+		}
+		if sl.LastSeenAt, err = noonJST(lastSeen); err != nil {
+			continue
+		}
+		slots = append(slots, sl)
+	}
+	return slots, rows.Err()
+}
+
+// backtest counts the matches the condition would have produced and, when
+// it never matched, evaluates relaxed variants of the condition. A bundle
+// counts once, on the date of its first slot.
+func backtest(slots []backtestSlot, cond explainCondition, weeks int) BacktestResult {
+	res := BacktestResult{Weeks: weeks, Days: []BacktestDay{}, Grounds: []BacktestGround{}, Suggestions: []BacktestSuggestion{}}
+
+	for _, sl := range slots {
+		if sl.GroundID == cond.FacilityID {
+			res.SlotsEvaluated++
+		}
+	}
+	days := make(map[string]int)
+	grounds := make(map[string]*BacktestGround)
+	for _, sl := range backtestMatches(slots, cond) {
+		res.Matches++
+		days[sl.Date]++
+		if g, ok := grounds[sl.GroundID]; ok {
+			g.Count++
+		} else {
+			grounds[sl.GroundID] = &BacktestGround{GroundID: sl.GroundID, GroundName: sl.GroundName, Count: 1}
+		}
+	}
+	res.MatchesPerWeek = float64(res.Matches) / float64(weeks)
+
+	for date, n := range days {
+		d, _ := time.Parse("2006-01-02", date)
+		res.Days = append(res.Days, BacktestDay{Date: date, Weekday: int(d.Weekday()), Count: n})
+	}
+	slices.SortFunc(res.Days, func(a, b BacktestDay) int { return cmp.Compare(a.Date, b.Date) })
+	for _, g := range grounds {
+		res.Grounds = append(res.Grounds, *g)
+	}
+
+	if res.Matches == 0 {
+		res.Suggestions = suggestRelaxations(slots, cond)
+	}
+	return res
+}
+
+// backtestMatches returns one slot per match: every matching slot, or the
+// first slot of every complete bundle. Bundles are replayed one seen date at
+// a time from the slots open that day, and count on the day a newly scraped
+// slot completed them, so a set already announced is not counted again.
+func backtestMatches(slots []backtestSlot, cond explainCondition) []backtestSlot {
+	mc := cond.matchCondition()
+	var ground []backtestSlot
+	for _, sl := range slots {
+		if sl.GroundID == cond.FacilityID {
+			ground = append(ground, sl)
+		}
+	}
+	if cond.BundleType == "" {
+		var matched []backtestSlot
+		for _, sl := range ground {
+			if match.Matches(sl.matchSlot(), mc, sl.SeenAt) {
+				matched = append(matched, sl)
+			}
+		}
+		return matched
+	}
+
+	var days []time.Time
+	for _, sl := range ground {
+		if !slices.ContainsFunc(days, sl.SeenAt.Equal) {
+			days = append(days, sl.SeenAt)
+		}
+	}
+	slices.SortFunc(days, time.Time.Compare)
+
+	var firsts []backtestSlot
+	for _, day := range days {
+		var open []backtestSlot
+		for _, sl := range ground {
+			if !sl.SeenAt.After(day) && !sl.LastSeenAt.Before(day) {
+				open = append(open, sl)
+			}
+		}
+		for _, bundle := range match.FindBundles(open, backtestSlot.matchSlot, mc, day) {
+			if slices.ContainsFunc(bundle, func(sl backtestSlot) bool { return sl.SeenAt.Equal(day) }) {
+				firsts = append(firsts, bundle[0])
+			}
+		}
+	}
+	return firsts
+}
+
+// suggestRelaxations tries relaxing one constraint at a time and returns the
+// variants that would have matched, best first.
+func suggestRelaxations(slots []backtestSlot, cond explainCondition) []BacktestSuggestion {
+	count := func(c explainCondition) int {
+		return len(backtestMatches(slots, c))
+	}
+
+	var suggestions []BacktestSuggestion
+	try := func(field, description string, relaxed explainCondition) {
+		if n := count(relaxed); n > 0 {
+			suggestions = append(suggestions, BacktestSuggestion{Field: field, Description: description, Matches: n})
+		}
+	}
+
+	if len(cond.DaysOfWeek) > 0 {
+		c := cond
+		c.DaysOfWeek = nil
+		try("days_of_week", "曜日の指定を外す", c)
+	}
+	if cond.TimeFrom != "00:00" || cond.TimeTo != "24:00" {
+		c := cond
+		c.TimeFrom, c.TimeTo = "00:00", "24:00"
+		try("time", "時間帯の指定を外す", c)
+	}
+	if cond.MinLeadDays > 0 || cond.MaxLeadDays > 0 {
+		c := cond
+		c.MinLeadDays, c.MaxLeadDays = 0, 0
+		try("lead_days", "何日後から/までの指定を外す", c)
+	}
+	if len(cond.ExcludedDates) > 0 {
+		c := cond
+		c.ExcludedDates = nil
+		try("excluded_dates", "除外日を外す", c)
+	}
+	if cond.DateFrom != "" || cond.DateTo != "" {
+		c := cond
+		c.DateFrom, c.DateTo = "", ""
+		try("date_range", "期間の指定を外す", c)
+	}
+
+	// Same condition at another ground in the municipality
+	best := BacktestSuggestion{}
+	seen := make(map[string]bool)
+	for _, sl := range slots {
+		if sl.GroundID == cond.FacilityID || seen[sl.GroundID] {
+			continue
+		}
+		seen[sl.GroundID] = true
+		c := cond
+		c.FacilityID = sl.GroundID
+		if n := count(c); n > best.Matches {
+			best = BacktestSuggestion{Field: "facility_id", Description: "同じ地域の " + sl.GroundName + " を監視する", Matches: n}
+		}
+	}
+	if best.Matches > 0 {
+		suggestions = append(suggestions, best)
+	}
+
+	slices.SortStableFunc(suggestions, func(a, b BacktestSuggestion) int { return cmp.Compare(b.Matches, a.Matches) })
+	return suggestions
+}
+
+// noonJST returns noon JST on a YYYY-MM-DD date.
+func noonJST(date string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04", date+" 12:00", jst)
+}
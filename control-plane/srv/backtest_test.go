@@ -0,0 +1,235 @@
+package srv
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBacktest(t *testing.T) {
+	seen := time.Date(2025, 6, 1, 3, 0, 0, 0, time.UTC) // noon JST
+	slot := func(id, ground, date, from string) backtestSlot {
+		return backtestSlot{
+			explainSlot: explainSlot{ID: id, GroundID: ground, Date: date, TimeFrom: from, TimeTo: "12:00"},
+			GroundName:  ground + "球場",
+			SeenAt:      seen,
+			LastSeenAt:  seen,
+		}
+	}
+	slots := []backtestSlot{
+		slot("s1", "g1", "2025-06-07", "09:00"), // Sat
+		slot("s2", "g1", "2025-06-07", "10:00"), // Sat
+		slot("s3", "g1", "2025-06-10", "09:00"), // Tue
+		slot("s4", "g2", "2025-06-08", "09:00"), // Sun
+		slot("s5", "g2", "2025-06-15", "09:00"), // Sun
+		slot("s6", "g2", "2025-06-22", "09:00"), // Sun
+	}
+	base := explainCondition{TeamStatus: "active", Enabled: true, TimeFrom: "08:00", TimeTo: "12:00"}
+
+	t.Run("一致件数と日付・施設を集計すべき", func(t *testing.T) {
+		cond := base
+		cond.FacilityID = "g1"
+		cond.DaysOfWeek = []int{6}
+		res := backtest(slots, cond, 2)
+		if res.SlotsEvaluated != 3 || res.Matches != 2 {
+			t.Fatalf("g1 の 3 枠中 2 枠が一致すべき: %+v", res)
+		}
+		if res.MatchesPerWeek != 1 {
+			t.Errorf("週あたり 1 件になるべき: %v", res.MatchesPerWeek)
+		}
+		if len(res.Days) != 1 || res.Days[0].Date != "2025-06-07" || res.Days[0].Count != 2 {
+			t.Errorf("日付別の集計が正しくあるべき: %+v", res.Days)
+		}
+		if len(res.Grounds) != 1 || res.Grounds[0].GroundName != "g1球場" {
+			t.Errorf("施設別の集計が正しくあるべき: %+v", res.Grounds)
+		}
+		if len(res.Suggestions) != 0 {
+			t.Errorf("一致する場合は提案を返さないべき: %+v", res.Suggestions)
+		}
+	})
+
+	t.Run("一致しない場合は緩和案を多い順に提案すべき", func(t *testing.T) {
+		cond := base
+		cond.FacilityID = "g1"
+		cond.DaysOfWeek = []int{0}
+		res := backtest(slots, cond, 4)
+		if res.Matches != 0 {
+			t.Fatalf("日曜の g1 には一致しないべき: %+v", res)
+		}
+		if len(res.Suggestions) != 2 {
+			t.Fatalf("曜日と施設の緩和案を返すべき: %+v", res.Suggestions)
+		}
+		if res.Suggestions[0].Field != "days_of_week" || res.Suggestions[0].Matches != 3 {
+			t.Errorf("曜日を外す案が最も多く一致すべき: %+v", res.Suggestions[0])
+		}
+		if res.Suggestions[1].Field != "facility_id" || res.Suggestions[1].Matches != 3 {
+			t.Errorf("g2 を監視する案を返すべき: %+v", res.Suggestions[1])
+		}
+	})
+
+	t.Run("期間の指定を外す案を返すべき", func(t *testing.T) {
+		cond := base
+		cond.FacilityID = "g1"
+		cond.DateFrom = "2025-07-01"
+		res := backtest(slots, cond, 4)
+		if res.Matches != 0 || len(res.Suggestions) == 0 || res.Suggestions[0].Field != "date_range" {
+			t.Errorf("期間外の枠には一致せず期間を外す案を返すべき: %+v", res)
+		}
+	})
+
+	t.Run("セット条件は揃ったセットを1件と数えるべき", func(t *testing.T) {
+		court := func(id, date, name string) backtestSlot {
+			sl := slot(id, "g3", date, "09:00")
+			sl.CourtName = name
+			return sl
+		}
+		bundleSlots := []backtestSlot{
+			court("c1", "2025-06-07", "A面"),
+			court("c2", "2025-06-07", "B面"),
+			court("c3", "2025-06-08", "A面"),
+			court("c4", "2025-06-09", "A面"),
+		}
+		cond := base
+		cond.FacilityID = "g3"
+		cond.BundleType, cond.BundleSize = BundleTypeCourts, 2
+		if res := backtest(bundleSlots, cond, 1); res.Matches != 1 || res.Days[0].Date != "2025-06-07" {
+			t.Errorf("2面揃った 6/7 だけを 1 件と数えるべき: %+v", res)
+		}
+		cond.BundleType, cond.BundleSize = BundleTypeDays, 3
+		if res := backtest(bundleSlots, cond, 1); res.Matches != 1 || res.Days[0].Date != "2025-06-07" {
+			t.Errorf("3日連続を 1 件と数えるべき: %+v", res)
+		}
+		cond.BundleSize = 4
+		if res := backtest(bundleSlots, cond, 1); res.Matches != 0 {
+			t.Errorf("揃わないセットは数えないべき: %+v", res)
+		}
+	})
+
+	t.Run("後のスクレイプで揃ったセットはその日に数えるべき", func(t *testing.T) {
+		court := func(id, name string, seenDays, lastSeenDays int) backtestSlot {
+			sl := slot(id, "g3", "2025-06-14", "09:00")
+			sl.CourtName = name
+			sl.SeenAt = seen.AddDate(0, 0, seenDays)
+			sl.LastSeenAt = seen.AddDate(0, 0, lastSeenDays)
+			return sl
+		}
+		cond := base
+		cond.FacilityID = "g3"
+		cond.BundleType, cond.BundleSize = BundleTypeCourts, 2
+
+		// A stays open until B opens two days later
+		res := backtest([]backtestSlot{court("c1", "A面", 0, 2), court("c2", "B面", 2, 2)}, cond, 1)
+		if res.Matches != 1 {
+			t.Errorf("以前の枠と新しい枠のセットを 1 件と数えるべき: %+v", res)
+		}
+		// A was gone before B opened
+		res = backtest([]backtestSlot{court("c1", "A面", 0, 1), court("c2", "B面", 2, 2)}, cond, 1)
+		if res.Matches != 0 {
+			t.Errorf("同時に空いていない枠はセットにならないべき: %+v", res)
+		}
+	})
+
+	t.Run("取得日時点のリード日数で評価すべき", func(t *testing.T) {
+		cond := base
+		cond.FacilityID = "g2"
+		cond.MaxLeadDays = 14
+		res := backtest(slots, cond, 4)
+		if res.Matches != 2 {
+			t.Errorf("取得日から 14 日以内の 2 枠が一致すべき: %+v", res)
+		}
+	})
+}
+
+func TestHandleBacktestCondition(t *testing.T) {
+	server := newTestServer(t)
+	// Seeded by 014-grounds-seed-data.sql
+	const (
+		municipalityID = "d9e5f0b2-3456-5789-0bcd-ef0123456789"
+		groundID       = "d0000001-0001-4000-8000-000000000001"
+	)
+	slotDate := time.Now().AddDate(0, 0, 7).Format("2006-01-02")
+	mustExec(t, server.DB, `INSERT INTO slots (id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, scraped_at)
+		VALUES ('slot-1', ?, ?, ?, '09:00', '11:00', 'サーティーフォー保土ケ谷球場', datetime('now', '-1 day'))`,
+		municipalityID, groundID, slotDate)
+
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'team-1', 'team-1@example.com', 'pro')`)
+	login := httptest.NewRecorder()
+	if _, err := server.startSession(t.Context(), login, httptest.NewRequest(http.MethodGet, "/", nil), "team-1", loginMagicLink); err != nil {
+		t.Fatalf("セッションの開始に失敗すべきではない: %v", err)
+	}
+	cookie := login.Result().Cookies()[0]
+
+	post := func(payload map[string]any) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/api/teams/team-1/conditions/backtest", bytes.NewReader(body))
+		req.SetPathValue("id", "team-1")
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		server.teamRoute(server.HandleBacktestCondition)(w, req)
+		return w
+	}
+	matches := func(t *testing.T, w *httptest.ResponseRecorder) int {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("200 を返すべき: %d %s", w.Code, w.Body.String())
+		}
+		var res BacktestResult
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗すべきではない: %v", err)
+		}
+		return res.Matches
+	}
+
+	t.Run("ログインしていなければ拒否すべき", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/teams/team-1/conditions/backtest", strings.NewReader(`{}`))
+		req.SetPathValue("id", "team-1")
+		w := httptest.NewRecorder()
+		server.teamRoute(server.HandleBacktestCondition)(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("401 を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("時間帯が空なら終日として扱うべき", func(t *testing.T) {
+		if n := matches(t, post(map[string]any{"facility_id": groundID})); n != 1 {
+			t.Errorf("時間帯の指定がなければ一致すべき: %d", n)
+		}
+	})
+
+	t.Run("期間とセットの指定で絞り込むべき", func(t *testing.T) {
+		if n := matches(t, post(map[string]any{"facility_id": groundID, "date_to": time.Now().Format("2006-01-02")})); n != 0 {
+			t.Errorf("期間外の枠は一致しないべき: %d", n)
+		}
+		if n := matches(t, post(map[string]any{"facility_id": groundID, "bundle_type": "courts", "bundle_size": 2})); n != 0 {
+			t.Errorf("1面だけではセットにならないべき: %d", n)
+		}
+		if w := post(map[string]any{"facility_id": groundID, "bundle_type": "courts", "bundle_size": 1}); w.Code != http.StatusBadRequest {
+			t.Errorf("不正なセットの大きさは拒否すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("保存済みの枠に対して一致件数を返すべき", func(t *testing.T) {
+		w := post(map[string]any{"facility_id": groundID, "days_of_week": "[]", "time_from": "08:00", "time_to": "12:00"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("200 を返すべき: %d %s", w.Code, w.Body.String())
+		}
+		var res BacktestResult
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗すべきではない: %v", err)
+		}
+		if res.Weeks != DefaultBacktestWeeks || res.Matches != 1 {
+			t.Errorf("既定の期間で 1 件一致すべき: %+v", res)
+		}
+	})
+
+	t.Run("範囲外の週数は拒否すべき", func(t *testing.T) {
+		w := post(map[string]any{"facility_id": groundID, "weeks": MaxBacktestWeeks + 1})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("400 を返すべき: %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,257 @@
+package srv
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// Channel tests and health
+//
+// A team can send a test notification on any channel it has a destination
+// for. The request is queued in channel_tests; the worker sends a sample
+// notification through the same notifier as real ones and records the
+// provider's response (see worker/notifier/channeltest.go), which the
+// dashboard polls for.
+//
+// The worker records every delivery in channel_health. A channel is verified
+// once a test or real notification reached it, and failing after
+// ChannelFailingThreshold consecutive failed deliveries.
+
+// ChannelFailingThreshold is the number of consecutive failed deliveries
+// after which a channel is flagged as failing.
+const ChannelFailingThreshold = 3
+
+// channelTestCooldown is the minimum time between tests on one channel.
+const channelTestCooldown = "-30 seconds"
+
+// Channel states shown in the dashboard
+const (
+	ChannelStateUnconfigured = "unconfigured" // no destination to send to
+	ChannelStateUnverified   = "unverified"   // nothing delivered yet
+	ChannelStateFailing      = "failing"
+	ChannelStateOK           = "ok"
+)
+
+// ChannelTest is a test notification and its outcome.
+type ChannelTest struct {
+	ID          string  `json:"id"`
+	Channel     string  `json:"channel"`
+	Status      string  `json:"status"` // pending, sending, sent, failed
+	Response    string  `json:"response"`
+	CreatedAt   string  `json:"created_at"`
+	CompletedAt *string `json:"completed_at"`
+}
+
+// ChannelStatus is the delivery health of one of the team's channels.
+type ChannelStatus struct {
+	Channel             string  `json:"channel"`
+	Enabled             bool    `json:"enabled"`
+	Configured          bool    `json:"configured"`
+	State               string  `json:"state"`
+	VerifiedAt          *string `json:"verified_at"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	LastSuccessAt       *string `json:"last_success_at"`
+	LastFailureAt       *string `json:"last_failure_at"`
+	LastError           string  `json:"last_error"`
+}
+
+// channelConfigured reports whether the team has somewhere to send on the
+// channel: an email address, a followed LINE account, a webhook destination,
+// a verified phone number or a push subscription.
+func (s *Server) channelConfigured(ctx context.Context, teamID, channel string) (bool, error) {
+	query, args := `SELECT COUNT(*) > 0 FROM notification_destinations WHERE team_id = ? AND channel = ?`, []any{teamID, channel}
+	switch channel {
+	case ChannelEmail:
+		query, args = `SELECT email != '' FROM teams WHERE id = ?`, []any{teamID}
+	case ChannelLINE:
+		query, args = `SELECT line_user_id IS NOT NULL AND line_followed = 1 FROM teams WHERE id = ?`, []any{teamID}
+	case ChannelPush:
+		query, args = `SELECT COUNT(*) > 0 FROM push_subscriptions WHERE team_id = ? AND member_id IS NULL`, []any{teamID}
+	}
+	var ok bool
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&ok)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return ok, err
+}
+
+// HandleSendChannelTest queues a test notification on one of the team's
+// channels. The response carries the test ID to poll for the outcome with
+// HandleGetChannelTest.
+func (s *Server) HandleSendChannelTest(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	channel := r.PathValue("channel")
+	if id == "" || channel == "" {
+		s.jsonError(w, "team id and channel required", http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(SupportedChannels, channel) {
+		s.jsonError(w, "unsupported channel: "+channel, http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+
+	var exists bool
+	err := s.DB.QueryRowContext(ctx, `SELECT 1 FROM teams WHERE id = ?`, id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	configured, err := s.channelConfigured(ctx, id, channel)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !configured {
+		s.jsonError(w, "no destination registered for "+channel, http.StatusBadRequest)
+		return
+	}
+	if channel == ChannelSMS {
+		// A test SMS counts against the monthly quota like any other
+		quota, used, err := smsQuota(ctx, s.DB, id)
+		if err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if used >= quota {
+			s.jsonError(w, "monthly sms quota reached", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	var recent int
+	if err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM channel_tests
+		WHERE team_id = ? AND channel = ? AND (status IN ('pending', 'sending') OR created_at > datetime('now', ?))
+	`, id, channel, channelTestCooldown).Scan(&recent); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if recent > 0 {
+		s.jsonError(w, "a test was sent recently; try again shortly", http.StatusTooManyRequests)
+		return
+	}
+
+	testID := uuid.New().String()
+	if _, err := s.DB.ExecContext(ctx, `
+		INSERT INTO channel_tests (id, team_id, channel, status, created_at) VALUES (?, ?, ?, 'pending', CURRENT_TIMESTAMP)
+	`, testID, id, channel); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("channel test requested", "team_id", id, "channel", channel, "test", testID)
+	s.jsonResponse(w, map[string]any{"id": testID, "status": "pending"})
+}
+
+// HandleGetChannelTest returns the outcome of one of the team's tests.
+func (s *Server) HandleGetChannelTest(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	testID := r.PathValue("test")
+	if id == "" || testID == "" {
+		s.jsonError(w, "team id and test required", http.StatusBadRequest)
+		return
+	}
+	var t ChannelTest
+	err := s.DB.QueryRowContext(r.Context(), `
+		SELECT id, channel, status, response, created_at, completed_at
+		FROM channel_tests WHERE id = ? AND team_id = ?
+	`, testID, id).Scan(&t.ID, &t.Channel, &t.Status, &t.Response, &t.CreatedAt, &t.CompletedAt)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "test not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, t)
+}
+
+// HandleListChannelStatus returns the delivery health of every channel, so
+// the dashboard can flag channels that are unverified or keep failing.
+func (s *Server) HandleListChannelStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	var channelsJSON string
+	err := s.DB.QueryRowContext(ctx, `SELECT notification_channels FROM teams WHERE id = ?`, id).Scan(&channelsJSON)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var enabled []string
+	_ = json.Unmarshal([]byte(channelsJSON), &enabled)
+
+	statuses := make(map[string]*ChannelStatus)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT channel, verified_at, consecutive_failures, last_success_at, last_failure_at, last_error
+		FROM channel_health WHERE team_id = ?
+	`, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var c ChannelStatus
+		if err := rows.Scan(&c.Channel, &c.VerifiedAt, &c.ConsecutiveFailures, &c.LastSuccessAt, &c.LastFailureAt, &c.LastError); err != nil {
+			continue
+		}
+		statuses[c.Channel] = &c
+	}
+	rows.Close()
+
+	result := []ChannelStatus{}
+	for _, ch := range SupportedChannels {
+		c := ChannelStatus{Channel: ch}
+		if st, ok := statuses[ch]; ok {
+			c = *st
+		}
+		c.Enabled = slices.Contains(enabled, ch)
+		if c.Configured, err = s.channelConfigured(ctx, id, ch); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		switch {
+		case !c.Configured:
+			c.State = ChannelStateUnconfigured
+		case c.ConsecutiveFailures >= ChannelFailingThreshold:
+			c.State = ChannelStateFailing
+		case c.VerifiedAt == nil:
+			c.State = ChannelStateUnverified
+		default:
+			c.State = ChannelStateOK
+		}
+		result = append(result, c)
+	}
+	s.jsonResponse(w, result)
+}
+
+// markChannelVerifiedTx records that a message reached the team on the
+// channel, e.g. the test message sent when a destination is saved.
+func markChannelVerifiedTx(ctx context.Context, tx *sql.Tx, teamID, channel string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO channel_health (team_id, channel, verified_at, consecutive_failures, last_success_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP, 0, CURRENT_TIMESTAMP)
+		ON CONFLICT (team_id, channel) DO UPDATE SET
+			verified_at = CURRENT_TIMESTAMP, consecutive_failures = 0, last_success_at = CURRENT_TIMESTAMP, last_error = ''
+	`, teamID, channel)
+	return err
+}
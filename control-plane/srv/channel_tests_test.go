@@ -0,0 +1,106 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChannelTestHandlers(t *testing.T) {
+	server := newTestServer(t)
+	for _, team := range []string{"team-1", "team-2"} {
+		mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES (?, ?, ?, 'free')`, team, team, team+"@example.com")
+	}
+	sendTest := func(team, channel string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.SetPathValue("id", team)
+		req.SetPathValue("channel", channel)
+		w := httptest.NewRecorder()
+		server.HandleSendChannelTest(w, req)
+		return w
+	}
+	getTest := func(team, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetPathValue("id", team)
+		req.SetPathValue("test", id)
+		w := httptest.NewRecorder()
+		server.HandleGetChannelTest(w, req)
+		return w
+	}
+	statuses := func() map[string]ChannelStatus {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetPathValue("id", "team-1")
+		w := httptest.NewRecorder()
+		server.HandleListChannelStatus(w, req)
+		var list []ChannelStatus
+		json.NewDecoder(w.Body).Decode(&list)
+		byChannel := make(map[string]ChannelStatus)
+		for _, c := range list {
+			byChannel[c.Channel] = c
+		}
+		return byChannel
+	}
+
+	t.Run("通知先のないチャネルはテストできないべき", func(t *testing.T) {
+		if w := sendTest("team-1", ChannelSlack); w.Code != http.StatusBadRequest {
+			t.Errorf("400を返すべき: %d", w.Code)
+		}
+		if w := sendTest("team-1", "fax"); w.Code != http.StatusBadRequest {
+			t.Errorf("未対応のチャネルは400を返すべき: %d", w.Code)
+		}
+	})
+
+	var testID string
+	t.Run("テスト通知を依頼し結果を取得できるべき", func(t *testing.T) {
+		w := sendTest("team-1", ChannelEmail)
+		if w.Code != http.StatusOK {
+			t.Fatalf("依頼は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		testID, _ = resp["id"].(string)
+		if resp["status"] != "pending" || testID == "" {
+			t.Fatalf("送信待ちになるべき: %v", resp)
+		}
+
+		// The worker sends it and records the provider's response
+		server.DB.Exec(`UPDATE channel_tests SET status = 'failed', response = 'smtp: 550 mailbox unavailable', completed_at = CURRENT_TIMESTAMP WHERE id = ?`, testID)
+		w = getTest("team-1", testID)
+		var got ChannelTest
+		json.NewDecoder(w.Body).Decode(&got)
+		if w.Code != http.StatusOK || got.Status != "failed" || got.Response != "smtp: 550 mailbox unavailable" || got.CompletedAt == nil {
+			t.Errorf("結果と応答を返すべき: %d %+v", w.Code, got)
+		}
+	})
+
+	t.Run("他チームのテスト結果は取得できないべき", func(t *testing.T) {
+		if w := getTest("team-2", testID); w.Code != http.StatusNotFound {
+			t.Errorf("404を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("直後の再テストは429を返すべき", func(t *testing.T) {
+		if w := sendTest("team-1", ChannelEmail); w.Code != http.StatusTooManyRequests {
+			t.Errorf("429を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("未確認と失敗が続くチャネルを区別すべき", func(t *testing.T) {
+		if c := statuses()[ChannelEmail]; c.State != ChannelStateUnverified || !c.Enabled || !c.Configured {
+			t.Errorf("メールは未確認になるべき: %+v", c)
+		}
+		if c := statuses()[ChannelSlack]; c.State != ChannelStateUnconfigured {
+			t.Errorf("Slack は未設定になるべき: %+v", c)
+		}
+
+		server.DB.Exec(`INSERT INTO channel_health (team_id, channel, verified_at, consecutive_failures, last_error) VALUES ('team-1', 'email', CURRENT_TIMESTAMP, 3, 'timeout')`)
+		if c := statuses()[ChannelEmail]; c.State != ChannelStateFailing || c.LastError != "timeout" {
+			t.Errorf("連続して失敗したチャネルは failing になるべき: %+v", c)
+		}
+		server.DB.Exec(`UPDATE channel_health SET consecutive_failures = 0 WHERE team_id = 'team-1'`)
+		if c := statuses()[ChannelEmail]; c.State != ChannelStateOK {
+			t.Errorf("確認済みで失敗がなければ ok になるべき: %+v", c)
+		}
+	})
+}
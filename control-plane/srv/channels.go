@@ -0,0 +1,219 @@
+package srv
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Notification channel preferences.
+//
+// A team enables one or more channels (teams.notification_channels). A watch
+// condition can override them (watch_conditions.channels); NULL means the
+// condition uses the team's channels. The worker creates one notification per
+// resolved channel.
+
+// normalizeChannels validates a channel list and returns it as a de-duplicated
+// JSON array in SupportedChannels order.
+func normalizeChannels(channels []string) (string, error) {
+	if len(channels) == 0 {
+		return "", fmt.Errorf("at least one channel is required")
+	}
+	for _, ch := range channels {
+		if !slices.Contains(SupportedChannels, ch) {
+			return "", fmt.Errorf("unsupported channel: %s", ch)
+		}
+	}
+	var normalized []string
+	for _, ch := range SupportedChannels {
+		if slices.Contains(channels, ch) {
+			normalized = append(normalized, ch)
+		}
+	}
+	b, err := json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// HandleUpdateTeamChannels sets the channels a team is notified on.
+func (s *Server) HandleUpdateTeamChannels(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Channels []string `json:"channels"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	channels, err := normalizeChannels(req.Channels)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := s.DB.ExecContext(r.Context(), `
+		UPDATE teams SET notification_channels = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, channels, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	s.jsonResponse(w, map[string]any{"notification_channels": json.RawMessage(channels)})
+}
+
+// HandleUpdateConditionChannels overrides the channels of one of the team's
+// watch conditions. A null or empty channel list reverts to the team's channels.
+func (s *Server) HandleUpdateConditionChannels(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "id required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		TeamID   string   `json:"team_id"`
+		Channels []string `json:"channels"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.TeamID == "" {
+		s.jsonError(w, "team_id required", http.StatusBadRequest)
+		return
+	}
+
+	var channels *string
+	if len(req.Channels) > 0 {
+		c, err := normalizeChannels(req.Channels)
+		if err != nil {
+			s.jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		channels = &c
+	}
+
+	res, err := s.DB.ExecContext(r.Context(), `
+		UPDATE watch_conditions SET channels = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND team_id = ?
+	`, channels, id, req.TeamID)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "condition not found", http.StatusNotFound)
+		return
+	}
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// enableChannelTx adds channel to the team's notification channels.
+func enableChannelTx(ctx context.Context, tx *sql.Tx, teamID, channel string) error {
+	var channelsJSON string
+	if err := tx.QueryRowContext(ctx, `SELECT notification_channels FROM teams WHERE id = ?`, teamID).Scan(&channelsJSON); err != nil {
+		return err
+	}
+	var channels []string
+	_ = json.Unmarshal([]byte(channelsJSON), &channels)
+	channelsJSON, err := normalizeChannels(append(channels, channel))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE teams SET notification_channels = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, channelsJSON, teamID)
+	return err
+}
+
+// disableChannelTx removes channel from the team and its conditions when its
+// destination goes away, so nothing keeps being queued for a destination that
+// can no longer be reached. Pending notifications on the channel are failed
+// with reason and the channel's health is cleared. A team left without
+// channels falls back to email.
+func disableChannelTx(ctx context.Context, tx *sql.Tx, teamID, channel, reason string) error {
+	var channelsJSON string
+	if err := tx.QueryRowContext(ctx, `SELECT notification_channels FROM teams WHERE id = ?`, teamID).Scan(&channelsJSON); err != nil {
+		return err
+	}
+	teamChannels, _ := withoutChannel(channelsJSON, channel)
+	if teamChannels == "" {
+		teamChannels = `["` + ChannelEmail + `"]`
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE teams SET notification_channels = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, teamChannels, teamID); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, channels FROM watch_conditions WHERE team_id = ? AND channels IS NOT NULL`, teamID)
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]string)
+	for rows.Next() {
+		var id, channels string
+		if err := rows.Scan(&id, &channels); err != nil {
+			rows.Close()
+			return err
+		}
+		overrides[id] = channels
+	}
+	rows.Close()
+	for id, channels := range overrides {
+		updated, changed := withoutChannel(channels, channel)
+		if !changed {
+			continue
+		}
+		// An override left empty falls back to the team's channels
+		var value any
+		if updated != "" {
+			value = updated
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE watch_conditions SET channels = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, value, id); err != nil {
+			return err
+		}
+	}
+
+	// A destination added later starts unverified
+	if _, err := tx.ExecContext(ctx, `DELETE FROM channel_health WHERE team_id = ? AND channel = ?`, teamID, channel); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE notifications SET status = 'failed', last_error = ?
+		WHERE team_id = ? AND channel = ? AND status = 'pending' AND member_id IS NULL
+	`, reason, teamID, channel)
+	return err
+}
+
+// withoutChannel removes channel from a JSON channel list. It returns "" when
+// no channels remain and reports whether the list changed.
+func withoutChannel(channelsJSON, channel string) (string, bool) {
+	var channels []string
+	if err := json.Unmarshal([]byte(channelsJSON), &channels); err != nil {
+		return channelsJSON, false
+	}
+	if !slices.Contains(channels, channel) {
+		return channelsJSON, false
+	}
+	channels = slices.DeleteFunc(channels, func(c string) bool { return c == channel })
+	if len(channels) == 0 {
+		return "", true
+	}
+	normalized, err := normalizeChannels(channels)
+	if err != nil {
+		return channelsJSON, false
+	}
+	return normalized, true
+}
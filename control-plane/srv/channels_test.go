@@ -0,0 +1,71 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChannelHandlers(t *testing.T) {
+	server := newTestServer(t)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-2', 'チーム2', 'team2@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+		VALUES ('wc-1', 'team-1', 'ground-1', '[]', '00:00', '24:00')`)
+
+	call := func(handler http.HandlerFunc, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	t.Run("チームの通知先を正規化して保存すべき", func(t *testing.T) {
+		w := call(server.HandleUpdateTeamChannels, "team-1", `{"channels":["slack","email","slack"]}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("更新は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var channels string
+		if err := server.DB.QueryRow(`SELECT notification_channels FROM teams WHERE id = 'team-1'`).Scan(&channels); err != nil {
+			t.Fatal(err)
+		}
+		if channels != `["email","slack"]` {
+			t.Errorf("unexpected channels: %s", channels)
+		}
+	})
+
+	t.Run("未対応または空の通知先は拒否すべき", func(t *testing.T) {
+		for _, body := range []string{`{"channels":["fax"]}`, `{"channels":[]}`} {
+			if w := call(server.HandleUpdateTeamChannels, "team-1", body); w.Code != http.StatusBadRequest {
+				t.Errorf("%s should be rejected: %d", body, w.Code)
+			}
+		}
+	})
+
+	t.Run("条件の通知先を上書きし空で解除すべき", func(t *testing.T) {
+		w := call(server.HandleUpdateConditionChannels, "wc-1", `{"team_id":"team-1","channels":["line"]}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("更新は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var channels *string
+		server.DB.QueryRow(`SELECT channels FROM watch_conditions WHERE id = 'wc-1'`).Scan(&channels)
+		if channels == nil || *channels != `["line"]` {
+			t.Fatalf("override should be stored: %v", channels)
+		}
+
+		call(server.HandleUpdateConditionChannels, "wc-1", `{"team_id":"team-1","channels":null}`)
+		server.DB.QueryRow(`SELECT channels FROM watch_conditions WHERE id = 'wc-1'`).Scan(&channels)
+		if channels != nil {
+			t.Errorf("override should be cleared: %v", *channels)
+		}
+	})
+
+	t.Run("他チームの条件は更新できないべき", func(t *testing.T) {
+		w := call(server.HandleUpdateConditionChannels, "wc-1", `{"team_id":"team-2","channels":["slack"]}`)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", w.Code)
+		}
+	})
+}
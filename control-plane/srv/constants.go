@@ -3,6 +3,8 @@ package srv
 import (
 	"net/http"
 	"time"
+
+	"akigura.dev/shared/match"
 )
 
 const (
@@ -30,6 +32,45 @@ const (
 	DefaultRequestTimeout = 30 * time.Second
 	LongRequestTimeout    = 60 * time.Second
 
+	// Watch condition booking window
+	MaxLeadDays      = 365
+	MaxExcludedDates = 100
+
+	// Watch condition bundles
+	BundleTypeCourts = match.BundleTypeCourts
+	BundleTypeDays   = match.BundleTypeDays
+	MaxBundleSize    = 7
+
+	// Notification channels
+	ChannelEmail   = "email"
+	ChannelLINE    = "line"
+	ChannelSlack   = "slack"
+	ChannelDiscord = "discord"
+	ChannelWebhook = "webhook" // signed outgoing webhook for developer integrations
+	ChannelPush    = "push"    // Web Push to the team's subscribed browsers
+	ChannelSMS     = "sms"     // SMS to a verified phone number
+
+	// Team members notified in addition to the team's own destinations
+	MaxTeamMembers    = 30
+	MaxMemberNameSize = 50
+
+	// Outgoing webhook secret rotation: the previous secret keeps signing
+	// deliveries for this long so receivers can switch over.
+	WebhookSecretGracePeriod = 24 * time.Hour
+
+	// Notification timing: instant alerts or a daily/weekly digest sent at
+	// the team's digest time (JST)
+	DigestInstant = "instant"
+	DigestDaily   = "daily"
+	DigestWeekly  = "weekly"
+
+	// Throttling: the longest minimum interval between messages on a channel
+	MaxMinIntervalMinutes = 24 * 60
+
+	// Condition backtest
+	DefaultBacktestWeeks = 4
+	MaxBacktestWeeks     = 12
+
 	// Subscription plans
 	PlanFree     = "free"
 	PlanPersonal = "personal"
@@ -65,6 +106,25 @@ var SupportedScraperTypes = []string{
 	ScraperTypeFujisawa,
 }
 
+// Supported notification channels
+var SupportedChannels = []string{
+	ChannelEmail,
+	ChannelLINE,
+	ChannelSlack,
+	ChannelDiscord,
+	ChannelWebhook,
+	ChannelPush,
+	ChannelSMS,
+}
+
+// Channels a team member can be notified on: their own email address,
+// their own subscribed devices and their own verified phone number
+var MemberChannels = []string{
+	ChannelEmail,
+	ChannelPush,
+	ChannelSMS,
+}
+
 // ValidatePlan checks if a plan is valid
 func ValidatePlan(plan string) bool {
 	switch plan {
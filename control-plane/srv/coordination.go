@@ -0,0 +1,235 @@
+package srv
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Slot coordination
+//
+// When a match reaches several members, they agree on a slot before anyone
+// books it. A member claims a slot ("I'm booking this") for claimTimeout,
+// so that the others do not book it too; the worker tells the other members
+// who received the slot about the claim (see worker/notifier/claim.go).
+// Members can also vote on whether to take a slot. Booking a slot (see
+// tracking.go) ends its claim, and the matcher stops notifying the team
+// about it.
+//
+// The actions are on the /booked page linked from each notification, where
+// the actor is the message's recipient, and in the user API, where the
+// dashboard names the member (or no member, for the team's representative).
+
+// claimTimeout is how long a claim holds a slot. Reservation sites log
+// sessions out well within this, so a claim still open after it was most
+// likely abandoned.
+const claimTimeout = 30 * time.Minute
+
+// jst is the zone claim expiries are shown in.
+var jst = time.FixedZone("JST", 9*60*60)
+
+// Votes on a slot
+const (
+	voteYes = "yes"
+	voteNo  = "no"
+)
+
+var (
+	errSlotClaimed  = errors.New("slot is claimed by another member")
+	errNoClaim      = errors.New("no claim to release")
+	errInvalidVote  = errors.New("vote must be yes, no or empty")
+	errMemberAbsent = errors.New("member not found")
+)
+
+// nullMember is the slot_claims/slot_votes member_id for a member ID, NULL
+// for the team's representative.
+func nullMember(memberID string) sql.NullString {
+	return sql.NullString{String: memberID, Valid: memberID != ""}
+}
+
+// claimSlot claims a slot for a member until claimTimeout from now. The
+// claimer renews their own claim; an unexpired claim by anyone else is
+// errSlotClaimed. A new claim is announced to the other members again.
+func (s *Server) claimSlot(ctx context.Context, teamID, slotID, memberID string) (time.Time, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	expires := time.Now().Add(claimTimeout).UTC()
+	var holder sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT member_id FROM slot_claims WHERE team_id = ? AND slot_id = ? AND expires_at > datetime('now')
+	`, teamID, slotID).Scan(&holder)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if _, err := tx.ExecContext(ctx, `DELETE FROM slot_claims WHERE team_id = ? AND slot_id = ?`, teamID, slotID); err != nil {
+			return time.Time{}, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO slot_claims (team_id, slot_id, member_id, claimed_at, expires_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?)
+		`, teamID, slotID, nullMember(memberID), expires.Format(time.DateTime)); err != nil {
+			return time.Time{}, err
+		}
+	case err != nil:
+		return time.Time{}, err
+	case holder.String != memberID:
+		return time.Time{}, errSlotClaimed
+	default:
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE slot_claims SET expires_at = ? WHERE team_id = ? AND slot_id = ?
+		`, expires.Format(time.DateTime), teamID, slotID); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return expires, tx.Commit()
+}
+
+// releaseClaim removes a slot's claim. With mine set only the member's own
+// claim is removed; the dashboard can release anyone's.
+func (s *Server) releaseClaim(ctx context.Context, teamID, slotID, memberID string, mine bool) error {
+	query, args := `DELETE FROM slot_claims WHERE team_id = ? AND slot_id = ?`, []any{teamID, slotID}
+	if mine {
+		query += ` AND member_id IS ?`
+		args = append(args, nullMember(memberID))
+	}
+	res, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errNoClaim
+	}
+	return nil
+}
+
+// voteSlot records a member's vote on a slot, replacing their earlier one.
+// An empty vote withdraws it.
+func (s *Server) voteSlot(ctx context.Context, teamID, slotID, memberID, vote string) error {
+	if vote != "" && vote != voteYes && vote != voteNo {
+		return errInvalidVote
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM slot_votes WHERE team_id = ? AND slot_id = ? AND member_id IS ?
+	`, teamID, slotID, nullMember(memberID)); err != nil {
+		return err
+	}
+	if vote != "" {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO slot_votes (team_id, slot_id, member_id, vote) VALUES (?, ?, ?, ?)
+		`, teamID, slotID, nullMember(memberID), vote); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// endClaim removes a booked slot's claim: there is nothing left to
+// coordinate.
+func endClaim(ctx context.Context, db *sql.DB, teamID, slotID string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM slot_claims WHERE team_id = ? AND slot_id = ?`, teamID, slotID)
+	return err
+}
+
+// coordinationRequest is the body of the user API's claim and vote requests.
+type coordinationRequest struct {
+	MemberID string `json:"member_id"` // empty for the team's representative
+	Vote     string `json:"vote"`
+}
+
+// decodeCoordination reads the request for a team's slot and checks that
+// the slot exists and the member belongs to the team.
+func (s *Server) decodeCoordination(w http.ResponseWriter, r *http.Request) (teamID, slotID string, req coordinationRequest, ok bool) {
+	teamID, slotID = r.PathValue("id"), r.PathValue("slot")
+	if teamID == "" || slotID == "" {
+		s.jsonError(w, "team id and slot required", http.StatusBadRequest)
+		return "", "", req, false
+	}
+	// DELETE may come without a body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && r.Method != http.MethodDelete {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return "", "", req, false
+	}
+
+	var slotExists, memberOK bool
+	if err := s.DB.QueryRowContext(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM slots WHERE id = ?),
+		       ? = '' OR EXISTS (SELECT 1 FROM team_members WHERE id = ? AND team_id = ?)
+	`, slotID, req.MemberID, req.MemberID, teamID).Scan(&slotExists, &memberOK); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return "", "", req, false
+	}
+	if !slotExists {
+		s.jsonError(w, "slot not found", http.StatusNotFound)
+		return "", "", req, false
+	}
+	if !memberOK {
+		s.jsonError(w, errMemberAbsent.Error(), http.StatusNotFound)
+		return "", "", req, false
+	}
+	return teamID, slotID, req, true
+}
+
+// HandleClaimSlot claims a slot for a member of the team: they are booking
+// it. It returns 409 while another member's claim is open.
+func (s *Server) HandleClaimSlot(w http.ResponseWriter, r *http.Request) {
+	teamID, slotID, req, ok := s.decodeCoordination(w, r)
+	if !ok {
+		return
+	}
+	expires, err := s.claimSlot(r.Context(), teamID, slotID, req.MemberID)
+	if errors.Is(err, errSlotClaimed) {
+		s.jsonError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, map[string]any{"success": true, "expires_at": expires.Format(time.RFC3339)})
+}
+
+// HandleReleaseClaim removes a slot's claim, e.g. when the member gave up.
+func (s *Server) HandleReleaseClaim(w http.ResponseWriter, r *http.Request) {
+	teamID, slotID, _, ok := s.decodeCoordination(w, r)
+	if !ok {
+		return
+	}
+	err := s.releaseClaim(r.Context(), teamID, slotID, "", false)
+	if errors.Is(err, errNoClaim) {
+		s.jsonError(w, "claim not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// HandleVoteSlot records a member's vote on whether to take a slot.
+func (s *Server) HandleVoteSlot(w http.ResponseWriter, r *http.Request) {
+	teamID, slotID, req, ok := s.decodeCoordination(w, r)
+	if !ok {
+		return
+	}
+	err := s.voteSlot(r.Context(), teamID, slotID, req.MemberID, req.Vote)
+	if errors.Is(err, errInvalidVote) {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
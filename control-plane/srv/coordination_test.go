@@ -0,0 +1,126 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSlotCoordination(t *testing.T) {
+	server := newTestServer(t)
+	t.Setenv("TRACKING_SECRET", "test-secret")
+	const municipality, ground = "d9e5f0b2-3456-5789-0bcd-ef0123456789", "d0000001-0001-4000-8000-000000000001"
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO team_members (id, team_id, name, email) VALUES ('member-1', 'team-1', '佐藤', 'sato@example.com'), ('member-2', 'team-1', '鈴木', 'suzuki@example.com')`)
+	mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+		VALUES ('wc-1', 'team-1', ?, '[]', '09:00', '12:00')`, ground)
+	mustExec(t, server.DB, `INSERT INTO slots (id, municipality_id, ground_id, slot_date, time_from, time_to)
+		VALUES ('slot-1', ?, ?, '2099-01-03', '09:00', '11:00')`, municipality, ground)
+	// The same slot sent to both members
+	for _, m := range []string{"member-1", "member-2"} {
+		mustExec(t, server.DB, `INSERT INTO notification_messages (id, team_id, member_id, channel, status, sent_at) VALUES ('msg-' || ?, 'team-1', ?, 'email', 'sent', CURRENT_TIMESTAMP)`, m, m)
+		mustExec(t, server.DB, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, member_id, status, message_id, sent_at)
+			VALUES ('n-' || ?, 'team-1', 'wc-1', 'slot-1', 'email', ?, 'sent', 'msg-' || ?, CURRENT_TIMESTAMP)`, m, m, m)
+	}
+
+	post := func(member, action string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {signTracking("test-secret", trackBooked, "msg-"+member)}, "slot_id": {"slot-1"}, "action": {action}}
+		req := httptest.NewRequest(http.MethodPost, "/booked", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.HandleBooked(w, req)
+		return w
+	}
+	api := func(handler http.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.SetPathValue("id", "team-1")
+		req.SetPathValue("slot", "slot-1")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	claimant := func() string {
+		var member string
+		server.DB.QueryRow(`SELECT COALESCE(member_id, '') FROM slot_claims WHERE team_id = 'team-1' AND slot_id = 'slot-1'`).Scan(&member)
+		return member
+	}
+
+	t.Run("通知のリンクから予約を宣言できるべき", func(t *testing.T) {
+		w := post("member-1", "claim")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "あなたが予約中") {
+			t.Fatalf("宣言は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if claimant() != "member-1" {
+			t.Errorf("メッセージの受信者の宣言として記録すべき: %q", claimant())
+		}
+
+		w = post("member-2", "claim")
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "佐藤 さんが予約中") {
+			t.Errorf("ほかのメンバーの宣言中は409を返すべき: %d %s", w.Code, w.Body.String())
+		}
+		if w := post("member-2", "release"); !strings.Contains(w.Body.String(), "取り消す宣言はありません") || claimant() != "member-1" {
+			t.Errorf("ほかのメンバーの宣言は取り消せないべき: %s", w.Body.String())
+		}
+		if w := api(server.HandleClaimSlot, http.MethodPost, `{"member_id":"member-2"}`); w.Code != http.StatusConflict {
+			t.Errorf("API でも409を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("期限切れの宣言はほかのメンバーが引き継げるべき", func(t *testing.T) {
+		mustExec(t, server.DB, `UPDATE slot_claims SET expires_at = datetime('now', '-1 minutes'), notified_at = CURRENT_TIMESTAMP`)
+		w := api(server.HandleClaimSlot, http.MethodPost, `{"member_id":"member-2"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("宣言は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var notified bool
+		server.DB.QueryRow(`SELECT notified_at IS NOT NULL FROM slot_claims`).Scan(&notified)
+		if claimant() != "member-2" || notified {
+			t.Errorf("新しい宣言として改めて知らせるべき: %q notified=%v", claimant(), notified)
+		}
+		if w := api(server.HandleClaimSlot, http.MethodPost, `{"member_id":"member-9"}`); w.Code != http.StatusNotFound {
+			t.Errorf("チームにいないメンバーは404を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("枠を取るかどうか投票できるべき", func(t *testing.T) {
+		post("member-1", "yes")
+		if w := api(server.HandleVoteSlot, http.MethodPut, `{"member_id":"member-2","vote":"no"}`); w.Code != http.StatusOK {
+			t.Fatalf("投票は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if w := api(server.HandleVoteSlot, http.MethodPut, `{"vote":"maybe"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("不正な投票は400を返すべき: %d", w.Code)
+		}
+		w := post("member-1", "yes")
+		if !strings.Contains(w.Body.String(), "投票を取り消しました") || !strings.Contains(w.Body.String(), "賛成 0") || !strings.Contains(w.Body.String(), "反対 1") {
+			t.Errorf("同じ票をもう一度押すと取り消すべき: %s", w.Body.String())
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/api/notifications?team_id=team-1", nil)
+		w = httptest.NewRecorder()
+		server.HandleListNotifications(w, req)
+		var list []struct {
+			ClaimMemberID string `json:"claim_member_id"`
+			ClaimedBy     string `json:"claimed_by"`
+			VotesNo       int    `json:"votes_no"`
+		}
+		json.NewDecoder(w.Body).Decode(&list)
+		if len(list) != 2 || list[0].ClaimMemberID != "member-2" || list[0].ClaimedBy != "鈴木 さん" || list[0].VotesNo != 1 {
+			t.Errorf("通知履歴に宣言と投票を表示すべき: %+v", list)
+		}
+	})
+
+	t.Run("予約を記録すると宣言を終えるべき", func(t *testing.T) {
+		if w := post("member-2", "book"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "予約を記録しました") {
+			t.Fatalf("記録は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if claimant() != "" {
+			t.Error("予約済みの枠の宣言は削除すべき")
+		}
+		if w := api(server.HandleReleaseClaim, http.MethodDelete, ""); w.Code != http.StatusNotFound {
+			t.Errorf("宣言がなければ404を返すべき: %d", w.Code)
+		}
+	})
+}
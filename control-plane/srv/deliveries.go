@@ -0,0 +1,154 @@
+package srv
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+// Delivery administration: failed notifications, their attempt history and
+// manual resend. The worker's notification sender records attempts and retries
+// automatically; these handlers let admins inspect and override that.
+
+// Delivery is a notification with its delivery state.
+type Delivery struct {
+	ID            string  `json:"id"`
+	TeamID        string  `json:"team_id"`
+	TeamName      string  `json:"team_name"`
+	MemberName    string  `json:"member_name"` // set for notifications to a team member
+	Channel       string  `json:"channel"`
+	Status        string  `json:"status"`
+	Attempts      int     `json:"attempts"`
+	LastError     *string `json:"last_error"`
+	NextAttemptAt *string `json:"next_attempt_at"`
+	CreatedAt     string  `json:"created_at"`
+	FacilityName  string  `json:"facility_name"`
+	SlotDate      string  `json:"slot_date"`
+	SlotTime      string  `json:"slot_time"`
+	CourtName     string  `json:"court_name"`
+}
+
+// DeliveryAttempt is one try to deliver a notification.
+type DeliveryAttempt struct {
+	ID          string  `json:"id"`
+	Attempt     int     `json:"attempt"`
+	Channel     string  `json:"channel"`
+	Success     bool    `json:"success"`
+	Error       *string `json:"error"`
+	AttemptedAt string  `json:"attempted_at"`
+}
+
+// HandleListDeliveries lists notifications by delivery status (default: failed).
+// Pending notifications that have failed at least once can be listed with status=retrying,
+// and notifications claimed by a sender but not yet recorded with status=sending.
+func (s *Server) HandleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	where := "n.status = 'failed'"
+	switch status {
+	case "", "failed":
+	case "retrying":
+		where = "n.status = 'pending' AND n.attempts > 0"
+	case "sending":
+		where = "n.status = 'sending'"
+	default:
+		s.jsonError(w, "status must be failed, retrying or sending", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := s.DB.QueryContext(r.Context(), `
+		SELECT n.id, n.team_id, COALESCE(t.name, ''), COALESCE(tm.name, ''), n.channel, n.status, n.attempts,
+		       n.last_error, n.next_attempt_at, n.created_at,
+		       COALESCE(g.name, ''), COALESCE(sl.slot_date, ''),
+		       COALESCE(sl.time_from, ''), COALESCE(sl.time_to, ''), COALESCE(sl.court_name, '')
+		FROM notifications n
+		LEFT JOIN teams t ON t.id = n.team_id
+		LEFT JOIN team_members tm ON tm.id = n.member_id
+		LEFT JOIN slots sl ON sl.id = n.slot_id
+		LEFT JOIN grounds g ON g.id = sl.ground_id
+		WHERE `+where+`
+		ORDER BY n.created_at DESC
+		LIMIT ?
+	`, DefaultAPILimit)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var timeFrom, timeTo string
+		if err := rows.Scan(&d.ID, &d.TeamID, &d.TeamName, &d.MemberName, &d.Channel, &d.Status, &d.Attempts,
+			&d.LastError, &d.NextAttemptAt, &d.CreatedAt,
+			&d.FacilityName, &d.SlotDate, &timeFrom, &timeTo, &d.CourtName); err != nil {
+			continue
+		}
+		d.SlotDate = dateOnly(d.SlotDate)
+		d.SlotTime = timeFrom + " - " + timeTo
+		deliveries = append(deliveries, d)
+	}
+	s.jsonResponse(w, deliveries)
+}
+
+// HandleListDeliveryAttempts returns the attempt history of a notification.
+func (s *Server) HandleListDeliveryAttempts(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "id required", http.StatusBadRequest)
+		return
+	}
+	rows, err := s.DB.QueryContext(r.Context(), `
+		SELECT id, attempt, channel, success, error, attempted_at
+		FROM notification_attempts
+		WHERE notification_id = ?
+		ORDER BY attempt
+	`, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	attempts := []DeliveryAttempt{}
+	for rows.Next() {
+		var a DeliveryAttempt
+		var success int
+		if err := rows.Scan(&a.ID, &a.Attempt, &a.Channel, &success, &a.Error, &a.AttemptedAt); err != nil {
+			continue
+		}
+		a.Success = success == 1
+		attempts = append(attempts, a)
+	}
+	s.jsonResponse(w, attempts)
+}
+
+// HandleResendNotification queues a failed notification for immediate delivery
+// with a fresh retry budget. The worker still drops it if the slot has started.
+func (s *Server) HandleResendNotification(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "id required", http.StatusBadRequest)
+		return
+	}
+	var status string
+	err := s.DB.QueryRowContext(r.Context(), `SELECT status FROM notifications WHERE id = ?`, id).Scan(&status)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status != "failed" {
+		s.jsonError(w, "only failed notifications can be resent", http.StatusConflict)
+		return
+	}
+	if _, err := s.DB.ExecContext(r.Context(), `
+		UPDATE notifications SET status = 'pending', attempts = 0, next_attempt_at = NULL WHERE id = ?
+	`, id); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
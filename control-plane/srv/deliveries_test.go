@@ -0,0 +1,78 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeliveryHandlers(t *testing.T) {
+	server := newTestServer(t)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+		VALUES ('wc-1', 'team-1', 'ground-1', '[]', '00:00', '24:00')`)
+	mustExec(t, server.DB, `INSERT INTO slots (id, slot_date, time_from, time_to, court_name) VALUES ('slot-1', '2099-01-03', '09:00', '11:00', 'A面')`)
+	mustExec(t, server.DB, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, status, attempts, last_error)
+		VALUES ('n-failed', 'team-1', 'wc-1', 'slot-1', 'email', 'failed', 8, 'smtp: timeout')`)
+	mustExec(t, server.DB, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, status)
+		VALUES ('n-sent', 'team-1', 'wc-1', 'slot-1', 'slack', 'sent')`)
+	mustExec(t, server.DB, `INSERT INTO notification_attempts (id, notification_id, attempt, channel, success, error)
+		VALUES ('a-1', 'n-failed', 1, 'email', 0, 'smtp: timeout')`)
+
+	call := func(handler http.HandlerFunc, method, url, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		if id != "" {
+			req.SetPathValue("id", id)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	t.Run("失敗した配信を一覧できるべき", func(t *testing.T) {
+		w := call(server.HandleListDeliveries, http.MethodGet, "/admin/api/deliveries", "")
+		var deliveries []Delivery
+		if err := json.NewDecoder(w.Body).Decode(&deliveries); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗すべきではない: %v", err)
+		}
+		if len(deliveries) != 1 || deliveries[0].ID != "n-failed" || deliveries[0].LastError == nil {
+			t.Fatalf("失敗した通知のみを返すべき: %+v", deliveries)
+		}
+	})
+
+	t.Run("送信試行の履歴を返すべき", func(t *testing.T) {
+		w := call(server.HandleListDeliveryAttempts, http.MethodGet, "/admin/api/notifications/n-failed/attempts", "n-failed")
+		var attempts []DeliveryAttempt
+		if err := json.NewDecoder(w.Body).Decode(&attempts); err != nil {
+			t.Fatalf("レスポンスのデコードに失敗すべきではない: %v", err)
+		}
+		if len(attempts) != 1 || attempts[0].Success {
+			t.Fatalf("失敗した試行を返すべき: %+v", attempts)
+		}
+	})
+
+	t.Run("失敗した通知を再送キューに戻すべき", func(t *testing.T) {
+		w := call(server.HandleResendNotification, http.MethodPost, "/admin/api/notifications/n-failed/resend", "n-failed")
+		if w.Code != http.StatusOK {
+			t.Fatalf("200 を返すべき: %d %s", w.Code, w.Body.String())
+		}
+		var status string
+		var attempts int
+		if err := server.DB.QueryRow(`SELECT status, attempts FROM notifications WHERE id = 'n-failed'`).Scan(&status, &attempts); err != nil {
+			t.Fatalf("通知の取得に失敗すべきではない: %v", err)
+		}
+		if status != "pending" || attempts != 0 {
+			t.Errorf("pending に戻り試行回数がリセットされるべき: status=%s attempts=%d", status, attempts)
+		}
+	})
+
+	t.Run("失敗していない通知の再送は拒否すべき", func(t *testing.T) {
+		if w := call(server.HandleResendNotification, http.MethodPost, "/admin/api/notifications/n-sent/resend", "n-sent"); w.Code != http.StatusConflict {
+			t.Errorf("409 を返すべき: %d", w.Code)
+		}
+		if w := call(server.HandleResendNotification, http.MethodPost, "/admin/api/notifications/missing/resend", "missing"); w.Code != http.StatusNotFound {
+			t.Errorf("404 を返すべき: %d", w.Code)
+		}
+	})
+}
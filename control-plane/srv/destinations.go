@@ -0,0 +1,307 @@
+package srv
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"akigura.dev/shared/netguard"
+)
+
+// Notification destinations
+//
+// Slack and Discord notifications are posted to incoming-webhook URLs that
+// each team registers for itself (notification_destinations), as are signed
+// outgoing webhooks (see webhooks.go). A URL is only saved after a test
+// message has been delivered to it. The worker reads the destination for each
+// notification's channel.
+
+// webhookHosts lists the hosts and path prefixes accepted for each webhook
+// channel. Restricting them keeps the test message from being used to make
+// requests to arbitrary URLs.
+var webhookHosts = map[string]struct {
+	Hosts      []string
+	PathPrefix string
+}{
+	ChannelSlack:   {Hosts: []string{"hooks.slack.com"}, PathPrefix: "/services/"},
+	ChannelDiscord: {Hosts: []string{"discord.com", "discordapp.com"}, PathPrefix: "/api/webhooks/"},
+}
+
+// Destination is a team's registered notification destination.
+type Destination struct {
+	Channel    string  `json:"channel"`
+	Target     string  `json:"target"` // masked webhook URL
+	VerifiedAt *string `json:"verified_at"`
+	UpdatedAt  string  `json:"updated_at"`
+	// PreviousSecretExpiresAt is set while a rotated webhook secret is still valid.
+	PreviousSecretExpiresAt *string `json:"previous_secret_expires_at,omitempty"`
+}
+
+// validateWebhookURL checks that raw is an HTTPS webhook URL of the channel's service.
+func validateWebhookURL(channel, raw string) error {
+	allowed, ok := webhookHosts[channel]
+	if !ok {
+		return fmt.Errorf("channel %s has no webhook destination", channel)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.User != nil || u.Port() != "" {
+		return fmt.Errorf("url must be an https webhook URL")
+	}
+	if !slices.Contains(allowed.Hosts, u.Hostname()) || !strings.HasPrefix(u.Path, allowed.PathPrefix) {
+		return fmt.Errorf("url is not a %s incoming webhook URL", channel)
+	}
+	return nil
+}
+
+// maskWebhookURL hides the secret part of a webhook URL for display.
+func maskWebhookURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	tail := u.Path
+	if len(tail) > 4 {
+		tail = tail[len(tail)-4:]
+	}
+	return u.Scheme + "://" + u.Host + "/…" + tail
+}
+
+// sendTestMessage posts a confirmation message to a webhook destination.
+func (s *Server) sendTestMessage(ctx context.Context, channel, webhookURL, teamName string) error {
+	text := fmt.Sprintf("✅ AkiGura の通知先として登録されました（%s）。空き枠が見つかるとここに通知されます。", teamName)
+	var payload any
+	switch channel {
+	case ChannelSlack:
+		payload = map[string]any{"text": text}
+	case ChannelDiscord:
+		payload = map[string]any{
+			"username": "AkiGura",
+			"embeds": []map[string]any{
+				{"title": "⚾ AkiGura", "description": text, "color": 0x4F46E5},
+			},
+		}
+	default:
+		return fmt.Errorf("channel %s has no webhook destination", channel)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = postJSON(ctx, s.httpClient(), webhookURL, body, nil)
+	return err
+}
+
+// postJSON posts body to endpoint and returns the response status. A non-2xx
+// status is an error.
+func postJSON(ctx context.Context, client *http.Client, endpoint string, body []byte, headers map[string]string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("test message rejected: %d %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp.StatusCode, nil
+}
+
+// httpClient returns the client for requests to team-supplied URLs. Unless
+// HTTPClient is set, it only connects to public addresses.
+func (s *Server) httpClient() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return netguard.NewClient(DefaultRequestTimeout)
+}
+
+// HandleListDestinations returns the team's registered destinations with masked URLs.
+func (s *Server) HandleListDestinations(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	rows, err := s.DB.QueryContext(r.Context(), `
+		SELECT channel, target, verified_at, updated_at,
+		       CASE WHEN previous_secret_expires_at > datetime('now') THEN previous_secret_expires_at END
+		FROM notification_destinations
+		WHERE team_id = ?
+		ORDER BY channel
+	`, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	destinations := []Destination{}
+	for rows.Next() {
+		var d Destination
+		if err := rows.Scan(&d.Channel, &d.Target, &d.VerifiedAt, &d.UpdatedAt, &d.PreviousSecretExpiresAt); err != nil {
+			continue
+		}
+		if d.Channel == ChannelSMS {
+			d.Target = maskPhone(d.Target)
+		} else {
+			d.Target = maskWebhookURL(d.Target)
+		}
+		destinations = append(destinations, d)
+	}
+	s.jsonResponse(w, destinations)
+}
+
+// HandleSaveDestination validates a webhook URL with a test message, stores it
+// as the team's destination for the channel and enables the channel.
+// For the outgoing webhook channel a signing secret is issued on first save
+// and returned once in the response.
+func (s *Server) HandleSaveDestination(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	channel := r.PathValue("channel")
+	if id == "" || channel == "" {
+		s.jsonError(w, "team id and channel required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	req.URL = strings.TrimSpace(req.URL)
+	ctx := r.Context()
+	var err error
+	if channel == ChannelWebhook {
+		err = validateEndpointURL(ctx, req.URL)
+	} else {
+		err = validateWebhookURL(channel, req.URL)
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var teamName string
+	err = s.DB.QueryRowContext(ctx, `SELECT name FROM teams WHERE id = ?`, id).Scan(&teamName)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var secret, newSecret string
+	if channel == ChannelWebhook {
+		if secret, err = s.currentWebhookSecret(ctx, id); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if secret == "" {
+			if secret, err = generateWebhookSecret(); err != nil {
+				s.jsonError(w, "failed to generate secret", http.StatusInternalServerError)
+				return
+			}
+			newSecret = secret
+		}
+		err = s.sendWebhookPing(ctx, id, req.URL, secret)
+	} else {
+		err = s.sendTestMessage(ctx, channel, req.URL, teamName)
+	}
+	if err != nil {
+		slog.Warn("destination test message failed", "team_id", id, "channel", channel, "error", err)
+		s.jsonError(w, "failed to send test message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO notification_destinations (team_id, channel, target, secret, verified_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (team_id, channel) DO UPDATE SET
+			target = excluded.target, secret = excluded.secret, verified_at = excluded.verified_at, updated_at = CURRENT_TIMESTAMP
+	`, id, channel, req.URL, secret); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := enableChannelTx(ctx, tx, id, channel); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := markChannelVerifiedTx(ctx, tx, id, channel); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("notification destination saved", "team_id", id, "channel", channel)
+	resp := map[string]any{"success": true}
+	if newSecret != "" {
+		resp["secret"] = newSecret
+	}
+	s.jsonResponse(w, resp)
+}
+
+// HandleDeleteDestination removes the team's destination for the channel and
+// disables the channel.
+func (s *Server) HandleDeleteDestination(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	channel := r.PathValue("channel")
+	if id == "" || channel == "" {
+		s.jsonError(w, "team id and channel required", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM notification_destinations WHERE team_id = ? AND channel = ?`, id, channel)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "destination not found", http.StatusNotFound)
+		return
+	}
+	if err := disableChannelTx(ctx, tx, id, channel, "destination removed"); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
@@ -0,0 +1,123 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// rewriteTransport sends every request to a test server, keeping the path.
+type rewriteTransport struct{ target *url.URL }
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		channel, url string
+		ok           bool
+	}{
+		{ChannelSlack, "https://hooks.slack.com/services/T000/B000/XXXX", true},
+		{ChannelDiscord, "https://discord.com/api/webhooks/123/abc", true},
+		{ChannelSlack, "http://hooks.slack.com/services/T000/B000/XXXX", false},
+		{ChannelSlack, "https://example.com/services/T000", false},
+		{ChannelDiscord, "https://discord.com/channels/123", false},
+		{ChannelEmail, "https://hooks.slack.com/services/T000", false},
+	}
+	for _, tt := range tests {
+		if err := validateWebhookURL(tt.channel, tt.url); (err == nil) != tt.ok {
+			t.Errorf("validateWebhookURL(%s, %s) = %v, want ok=%v", tt.channel, tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestDestinationHandlers(t *testing.T) {
+	server := newTestServer(t)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+
+	status := http.StatusOK
+	var received []map[string]any
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		received = append(received, body)
+		w.WriteHeader(status)
+	}))
+	defer webhook.Close()
+	target, _ := url.Parse(webhook.URL)
+	server.HTTPClient = &http.Client{Transport: rewriteTransport{target: target}}
+
+	call := func(handler http.HandlerFunc, method, channel, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.SetPathValue("id", "team-1")
+		req.SetPathValue("channel", channel)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	teamChannels := func() string {
+		var channels string
+		server.DB.QueryRow(`SELECT notification_channels FROM teams WHERE id = 'team-1'`).Scan(&channels)
+		return channels
+	}
+
+	t.Run("テスト送信に成功したwebhookを保存し通知先を有効にすべき", func(t *testing.T) {
+		w := call(server.HandleSaveDestination, http.MethodPut, ChannelDiscord, `{"url":"https://discord.com/api/webhooks/123/secret-token"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("保存は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if len(received) != 1 || received[0]["embeds"] == nil {
+			t.Fatalf("discord test message should use embeds: %+v", received)
+		}
+		if got := teamChannels(); got != `["email","discord"]` {
+			t.Errorf("discord should be enabled: %s", got)
+		}
+
+		w = call(server.HandleListDestinations, http.MethodGet, "", "")
+		var destinations []Destination
+		json.NewDecoder(w.Body).Decode(&destinations)
+		if len(destinations) != 1 || destinations[0].VerifiedAt == nil || strings.Contains(destinations[0].Target, "secret") {
+			t.Errorf("destination should be listed verified and masked: %+v", destinations)
+		}
+	})
+
+	t.Run("テスト送信に失敗したwebhookは保存しないべき", func(t *testing.T) {
+		status = http.StatusNotFound
+		defer func() { status = http.StatusOK }()
+		w := call(server.HandleSaveDestination, http.MethodPut, ChannelSlack, `{"url":"https://hooks.slack.com/services/T000/B000/XXXX"}`)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", w.Code)
+		}
+		var n int
+		server.DB.QueryRow(`SELECT COUNT(*) FROM notification_destinations WHERE channel = 'slack'`).Scan(&n)
+		if n != 0 {
+			t.Errorf("failed destination should not be stored")
+		}
+	})
+
+	t.Run("許可されていないURLはテスト送信せずに拒否すべき", func(t *testing.T) {
+		before := len(received)
+		w := call(server.HandleSaveDestination, http.MethodPut, ChannelSlack, `{"url":"https://169.254.169.254/services/x"}`)
+		if w.Code != http.StatusBadRequest || len(received) != before {
+			t.Errorf("expected rejection without request: %d", w.Code)
+		}
+	})
+
+	t.Run("削除すると通知先を無効にすべき", func(t *testing.T) {
+		if w := call(server.HandleDeleteDestination, http.MethodDelete, ChannelDiscord, ""); w.Code != http.StatusOK {
+			t.Fatalf("削除は成功すべき: %d", w.Code)
+		}
+		if got := teamChannels(); got != `["email"]` {
+			t.Errorf("discord should be disabled: %s", got)
+		}
+		if w := call(server.HandleDeleteDestination, http.MethodDelete, ChannelDiscord, ""); w.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", w.Code)
+		}
+	})
+}
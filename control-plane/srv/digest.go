@@ -0,0 +1,118 @@
+package srv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Digest notifications.
+//
+// A team chooses when matches are delivered (teams.digest_mode): instantly, or
+// as a daily or weekly digest sent at digest_time (JST) and, for weekly, on
+// digest_weekday. A watch condition can override the mode
+// (watch_conditions.digest_mode); NULL means the team's mode. The worker holds
+// digest notifications until the next digest time and drops slots that are no
+// longer available when it goes out.
+
+// validateDigest checks a digest mode, and for digest modes the time of day
+// and weekday.
+func validateDigest(mode, at string, weekday int) error {
+	switch mode {
+	case DigestInstant:
+		return nil
+	case DigestDaily, DigestWeekly:
+	default:
+		return fmt.Errorf("digest_mode must be %s, %s or %s", DigestInstant, DigestDaily, DigestWeekly)
+	}
+	if _, err := time.Parse("15:04", at); err != nil || len(at) != 5 {
+		return fmt.Errorf("digest_time must be HH:MM")
+	}
+	if weekday < 0 || weekday > 6 {
+		return fmt.Errorf("digest_weekday must be between 0 and 6")
+	}
+	return nil
+}
+
+// HandleUpdateTeamDigest sets when a team's notifications are delivered.
+func (s *Server) HandleUpdateTeamDigest(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	req := struct {
+		Mode    string `json:"digest_mode"`
+		Time    string `json:"digest_time"`
+		Weekday int    `json:"digest_weekday"`
+	}{Time: "08:00", Weekday: 1}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := validateDigest(req.Mode, req.Time, req.Weekday); err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := s.DB.ExecContext(r.Context(), `
+		UPDATE teams SET digest_mode = ?, digest_time = ?, digest_weekday = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, req.Mode, req.Time, req.Weekday, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	s.jsonResponse(w, map[string]any{
+		"digest_mode":    req.Mode,
+		"digest_time":    req.Time,
+		"digest_weekday": req.Weekday,
+	})
+}
+
+// HandleUpdateConditionDigest overrides the digest mode of one of the team's
+// watch conditions. A null or empty mode reverts to the team's mode.
+func (s *Server) HandleUpdateConditionDigest(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "id required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		TeamID string `json:"team_id"`
+		Mode   string `json:"digest_mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.TeamID == "" {
+		s.jsonError(w, "team_id required", http.StatusBadRequest)
+		return
+	}
+
+	var mode *string
+	if req.Mode != "" {
+		if err := validateDigest(req.Mode, "00:00", 0); err != nil {
+			s.jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mode = &req.Mode
+	}
+
+	res, err := s.DB.ExecContext(r.Context(), `
+		UPDATE watch_conditions SET digest_mode = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND team_id = ?
+	`, mode, id, req.TeamID)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "condition not found", http.StatusNotFound)
+		return
+	}
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
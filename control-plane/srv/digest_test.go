@@ -0,0 +1,75 @@
+package srv
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDigestHandlers(t *testing.T) {
+	server := newTestServer(t)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-2', 'チーム2', 'team2@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+		VALUES ('wc-1', 'team-1', 'ground-1', '[]', '00:00', '24:00')`)
+
+	call := func(handler http.HandlerFunc, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	t.Run("チームのまとめ配信設定を保存すべき", func(t *testing.T) {
+		w := call(server.HandleUpdateTeamDigest, "team-1", `{"digest_mode":"weekly","digest_time":"19:30","digest_weekday":5}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("更新は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var mode, at string
+		var weekday int
+		server.DB.QueryRow(`SELECT digest_mode, digest_time, digest_weekday FROM teams WHERE id = 'team-1'`).Scan(&mode, &at, &weekday)
+		if mode != DigestWeekly || at != "19:30" || weekday != 5 {
+			t.Errorf("unexpected digest settings: %s %s %d", mode, at, weekday)
+		}
+	})
+
+	t.Run("不正なまとめ配信設定は拒否すべき", func(t *testing.T) {
+		for _, body := range []string{
+			`{"digest_mode":"hourly"}`,
+			`{"digest_mode":"daily","digest_time":"25:00"}`,
+			`{"digest_mode":"daily","digest_time":"8:00"}`,
+			`{"digest_mode":"weekly","digest_time":"08:00","digest_weekday":7}`,
+		} {
+			if w := call(server.HandleUpdateTeamDigest, "team-1", body); w.Code != http.StatusBadRequest {
+				t.Errorf("%s should be rejected: %d", body, w.Code)
+			}
+		}
+	})
+
+	t.Run("条件のまとめ設定を上書きし空で解除すべき", func(t *testing.T) {
+		if w := call(server.HandleUpdateConditionDigest, "wc-1", `{"team_id":"team-1","digest_mode":"daily"}`); w.Code != http.StatusOK {
+			t.Fatalf("更新は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var mode sql.NullString
+		server.DB.QueryRow(`SELECT digest_mode FROM watch_conditions WHERE id = 'wc-1'`).Scan(&mode)
+		if mode.String != DigestDaily {
+			t.Errorf("unexpected condition digest mode: %v", mode)
+		}
+		if w := call(server.HandleUpdateConditionDigest, "wc-1", `{"team_id":"team-1","digest_mode":""}`); w.Code != http.StatusOK {
+			t.Fatalf("解除は成功すべき: %d", w.Code)
+		}
+		server.DB.QueryRow(`SELECT digest_mode FROM watch_conditions WHERE id = 'wc-1'`).Scan(&mode)
+		if mode.Valid {
+			t.Errorf("解除後はチームの設定に従うべき: %v", mode)
+		}
+	})
+
+	t.Run("他チームの条件は更新できないべき", func(t *testing.T) {
+		if w := call(server.HandleUpdateConditionDigest, "wc-1", `{"team_id":"team-2","digest_mode":"daily"}`); w.Code != http.StatusNotFound {
+			t.Errorf("404を返すべき: %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package srv
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Email preview
+//
+// Notification emails are rendered by the worker from the templates in
+// worker/notifier/templates, which operators can override per file with
+// EMAIL_TEMPLATE_DIR. The preview renders a sample notification with the same
+// files and the same data shape (mirroring notifier.EmailData) so template
+// changes can be checked without sending mail.
+
+const (
+	emailSubjectTemplate = "email_subject.txt.tmpl"
+	emailTextTemplate    = "email.txt.tmpl"
+	emailHTMLTemplate    = "email.html.tmpl"
+)
+
+type emailPreviewData struct {
+	TeamName    string
+	MemberName  string
+	Count       int
+	Digest      bool
+	DigestLabel string
+	Test        bool
+	Sections    []emailPreviewSection
+
+	UnsubscribeURL    string
+	UnsubscribeAllURL string
+	BookedURL         string
+	ClaimedBy         string
+	ClaimUntil        string
+}
+
+type emailPreviewSection struct {
+	Title          string
+	Label          string
+	ReservationURL string
+	Slots          []emailPreviewSlot
+}
+
+type emailPreviewSlot struct {
+	SlotDate       string
+	SlotTime       string
+	CourtName      string
+	FacilityName   string
+	ReservationURL string // set when the section has no shared link
+}
+
+// EmailPreview is a rendered sample email.
+type EmailPreview struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+var emailTemplateFuncs = map[string]any{
+	"inc": func(i int) int { return i + 1 },
+}
+
+// sampleEmailData returns a sample notification for the preview: two grounds,
+// one with a two-court bundle, or a digest when digest is daily or weekly.
+func sampleEmailData(digest string) emailPreviewData {
+	a1 := emailPreviewSlot{SlotDate: "2026-10-24", SlotTime: "09:00-11:00", CourtName: "A面", FacilityName: "中央公園野球場"}
+	a2 := emailPreviewSlot{SlotDate: "2026-10-24", SlotTime: "09:00-11:00", CourtName: "B面", FacilityName: "中央公園野球場"}
+	b := emailPreviewSlot{SlotDate: "2026-10-25", SlotTime: "13:00-15:00", CourtName: "", FacilityName: "河川敷グラウンド",
+		ReservationURL: "https://example.com/reserve?slot=sample"}
+	data := emailPreviewData{
+		TeamName:          "サンプルチーム",
+		Count:             3,
+		UnsubscribeURL:    "https://example.com/unsubscribe?token=sample-conditions",
+		UnsubscribeAllURL: "https://example.com/unsubscribe?token=sample-all",
+		BookedURL:         "https://example.com/booked?token=sample",
+	}
+	switch digest {
+	case DigestDaily, DigestWeekly:
+		data.Digest = true
+		data.DigestLabel = "本日のまとめ"
+		if digest == DigestWeekly {
+			data.DigestLabel = "今週のまとめ"
+		}
+		data.Sections = []emailPreviewSection{
+			{Title: "中央公園野球場 2026-10-24", ReservationURL: "https://example.com/reserve", Slots: []emailPreviewSlot{a1, a2}},
+			{Title: "河川敷グラウンド 2026-10-25", Slots: []emailPreviewSlot{b}},
+		}
+	default:
+		data.Sections = []emailPreviewSection{
+			{Title: a1.FacilityName, Label: "2面同時", ReservationURL: "https://example.com/reserve", Slots: []emailPreviewSlot{a1, a2}},
+			{Title: b.FacilityName, Slots: []emailPreviewSlot{b}},
+		}
+	}
+	return data
+}
+
+// readEmailTemplate reads a template from EMAIL_TEMPLATE_DIR if present there,
+// and from the default templates otherwise.
+func (s *Server) readEmailTemplate(name string) (string, error) {
+	if dir := os.Getenv("EMAIL_TEMPLATE_DIR"); dir != "" {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return string(b), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(s.EmailTemplatesDir, name))
+	return string(b), err
+}
+
+// renderEmailPreview renders the email templates with data.
+func (s *Server) renderEmailPreview(data emailPreviewData) (EmailPreview, error) {
+	var p EmailPreview
+	for _, name := range []string{emailSubjectTemplate, emailTextTemplate, emailHTMLTemplate} {
+		src, err := s.readEmailTemplate(name)
+		if err != nil {
+			return p, err
+		}
+		var t interface {
+			Execute(w io.Writer, data any) error
+		}
+		if name == emailHTMLTemplate {
+			t, err = htmltemplate.New(name).Funcs(emailTemplateFuncs).Parse(src)
+		} else {
+			t, err = texttemplate.New(name).Funcs(emailTemplateFuncs).Parse(src)
+		}
+		if err != nil {
+			return p, err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return p, err
+		}
+		switch name {
+		case emailSubjectTemplate:
+			p.Subject = strings.Join(strings.Fields(buf.String()), " ")
+		case emailTextTemplate:
+			p.Text = buf.String()
+		default:
+			p.HTML = buf.String()
+		}
+	}
+	return p, nil
+}
+
+// HandleEmailPreview renders a sample notification email. ?digest=daily|weekly
+// previews a digest; ?format=html or ?format=text returns that body alone for
+// viewing in a browser, otherwise the subject and both bodies are returned as JSON.
+func (s *Server) HandleEmailPreview(w http.ResponseWriter, r *http.Request) {
+	p, err := s.renderEmailPreview(sampleEmailData(r.URL.Query().Get("digest")))
+	if err != nil {
+		s.jsonError(w, "render email templates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(p.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Subject: " + p.Subject + "\n\n" + p.Text))
+	default:
+		s.jsonResponse(w, p)
+	}
+}
@@ -0,0 +1,60 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandleEmailPreview(t *testing.T) {
+	server := newTestServer(t)
+	if _, err := os.Stat(server.EmailTemplatesDir); err != nil {
+		t.Skipf("worker templates not found: %v", err)
+	}
+
+	preview := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.HandleEmailPreview(w, httptest.NewRequest(http.MethodGet, "/api/email-preview?"+query, nil))
+		return w
+	}
+
+	t.Run("件名とテキスト・HTML本文を返すべき", func(t *testing.T) {
+		w := preview("")
+		if w.Code != http.StatusOK {
+			t.Fatalf("200を返すべき: %d %s", w.Code, w.Body.String())
+		}
+		var p EmailPreview
+		json.NewDecoder(w.Body).Decode(&p)
+		if p.Subject != "【AkiGura】空き枠が見つかりました（3件）" {
+			t.Errorf("unexpected subject: %q", p.Subject)
+		}
+		if !strings.Contains(p.Text, "【1】中央公園野球場（2面同時セット）") || !strings.Contains(p.HTML, "サンプルチーム") {
+			t.Errorf("サンプル通知が描画されるべき: %+v", p)
+		}
+		if !strings.Contains(p.Text, "予約: https://example.com/reserve?slot=sample") {
+			t.Errorf("枠ごとの予約リンクを描画すべき: %s", p.Text)
+		}
+	})
+
+	t.Run("まとめ通知をHTMLで返すべき", func(t *testing.T) {
+		w := preview("digest=weekly&format=html")
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || !strings.Contains(w.Body.String(), "今週のまとめ") {
+			t.Errorf("週次まとめのHTMLを返すべき: %s", w.Body.String())
+		}
+	})
+
+	t.Run("EMAIL_TEMPLATE_DIRのファイルで上書きすべき", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, emailSubjectTemplate), []byte("{{.TeamName}} 様へ"), 0o644)
+		t.Setenv("EMAIL_TEMPLATE_DIR", dir)
+		var p EmailPreview
+		json.NewDecoder(preview("").Body).Decode(&p)
+		if p.Subject != "サンプルチーム 様へ" || p.Text == "" {
+			t.Errorf("件名のみ上書きされるべき: %+v", p)
+		}
+	})
+}
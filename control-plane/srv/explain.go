@@ -0,0 +1,442 @@
+package srv
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"akigura.dev/shared/match"
+)
+
+// Match explanation
+//
+// These handlers re-evaluate the worker's matching rules (ProcessMatches and
+// the akigura.dev/shared/match checks behind Matcher.MatchSlot) and the
+// sender's delivery rules (booked slots, the plan's delay and daily cap)
+// against the current database rows and return a trace of every check, so
+// support can answer "why wasn't I notified about this slot?". The slot
+// checks come from the shared matcher itself; keep the remaining checks in
+// sync with worker/matcher.go and worker/notifier/sender.go.
+
+// ExplainCheck is the result of a single matching check.
+type ExplainCheck = match.Check
+
+// MatchExplanation traces how one slot was evaluated against one condition.
+type MatchExplanation struct {
+	SlotID             string         `json:"slot_id"`
+	SlotDate           string         `json:"slot_date"`
+	SlotTime           string         `json:"slot_time"`
+	CourtName          string         `json:"court_name"`
+	ConditionID        string         `json:"condition_id"`
+	TeamID             string         `json:"team_id"`
+	TeamName           string         `json:"team_name"`
+	Matched            bool           `json:"matched"`
+	NotificationStatus string         `json:"notification_status"` // empty when no notification exists
+	Checks             []ExplainCheck `json:"checks"`
+}
+
+type explainSlot struct {
+	ID             string
+	MunicipalityID string
+	GroundID       string
+	Date           string
+	TimeFrom       string
+	TimeTo         string
+	CourtName      string
+}
+
+type explainCondition struct {
+	ID            string
+	TeamID        string
+	TeamName      string
+	TeamStatus    string
+	FacilityID    string
+	DaysOfWeek    []int
+	TimeFrom      string
+	TimeTo        string
+	DateFrom      string
+	DateTo        string
+	Enabled       bool
+	MinLeadDays   int
+	MaxLeadDays   int
+	ExcludedDates []string
+	BundleType    string
+	BundleSize    int
+}
+
+func (s explainSlot) matchSlot() match.Slot {
+	return match.Slot{Date: s.Date, TimeFrom: s.TimeFrom, TimeTo: s.TimeTo, CourtName: s.CourtName}
+}
+
+func (c explainCondition) matchCondition() match.Condition {
+	return match.Condition{
+		DaysOfWeek:    c.DaysOfWeek,
+		TimeFrom:      c.TimeFrom,
+		TimeTo:        c.TimeTo,
+		DateFrom:      c.DateFrom,
+		DateTo:        c.DateTo,
+		MinLeadDays:   c.MinLeadDays,
+		MaxLeadDays:   c.MaxLeadDays,
+		ExcludedDates: c.ExcludedDates,
+		BundleType:    c.BundleType,
+		BundleSize:    c.BundleSize,
+	}
+}
+
+// explainQuery selects the slots and conditions to explain.
+type explainQuery struct {
+	SlotID      string
+	GroundID    string
+	Date        string
+	ConditionID string
+	TeamID      string
+}
+
+func parseExplainQuery(r *http.Request) (explainQuery, error) {
+	q := explainQuery{
+		SlotID:      r.URL.Query().Get("slot_id"),
+		GroundID:    r.URL.Query().Get("ground_id"),
+		Date:        r.URL.Query().Get("date"),
+		ConditionID: r.URL.Query().Get("condition_id"),
+		TeamID:      r.URL.Query().Get("team_id"),
+	}
+	if q.SlotID == "" && (q.GroundID == "" || q.Date == "") {
+		return q, errors.New("slot_id or ground_id and date required")
+	}
+	if q.Date != "" {
+		if _, err := time.Parse("2006-01-02", q.Date); err != nil {
+			return q, errors.New("date must be YYYY-MM-DD")
+		}
+	}
+	if q.ConditionID == "" && q.TeamID == "" {
+		return q, errors.New("condition_id or team_id required")
+	}
+	return q, nil
+}
+
+// HandleAdminExplainMatch explains matching for any team or condition.
+func (s *Server) HandleAdminExplainMatch(w http.ResponseWriter, r *http.Request) {
+	q, err := parseExplainQuery(r)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.writeExplanations(w, r, q)
+}
+
+// HandleExplainMatch explains matching for the requesting team's own conditions.
+func (s *Server) HandleExplainMatch(w http.ResponseWriter, r *http.Request) {
+	q, err := parseExplainQuery(r)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.TeamID == "" {
+		s.jsonError(w, "team_id required", http.StatusBadRequest)
+		return
+	}
+	s.writeExplanations(w, r, q)
+}
+
+func (s *Server) writeExplanations(w http.ResponseWriter, r *http.Request, q explainQuery) {
+	explanations, err := s.explainMatch(r.Context(), q)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if explanations == nil {
+		explanations = []MatchExplanation{}
+	}
+	s.jsonResponse(w, explanations)
+}
+
+// explainMatch evaluates every selected slot against every selected condition.
+// When both condition_id and team_id are given, the condition must belong to the team.
+func (s *Server) explainMatch(ctx context.Context, q explainQuery) ([]MatchExplanation, error) {
+	slots, err := s.loadExplainSlots(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("load slots: %w", err)
+	}
+	conds, err := s.loadExplainConditions(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("load conditions: %w", err)
+	}
+
+	now := time.Now()
+	var explanations []MatchExplanation
+	for _, slot := range slots {
+		mapped, pattern := s.mapGroundByPattern(ctx, slot)
+		for _, cond := range conds {
+			e := evaluateMatch(slot, cond, now)
+			e.Checks = slices.Insert(e.Checks, 0, groundCheck(slot, cond, mapped, pattern))
+			e.Checks = append(e.Checks, s.bookedCheck(ctx, slot, cond))
+			e.Matched = allPassed(e.Checks)
+			e.Checks = append(e.Checks, s.deliveryChecks(ctx, slot, cond)...)
+			e.Checks = append(e.Checks, s.notificationCheck(ctx, slot, cond, &e))
+			explanations = append(explanations, e)
+		}
+	}
+	return explanations, nil
+}
+
+func (s *Server) loadExplainSlots(ctx context.Context, q explainQuery) ([]explainSlot, error) {
+	query := `
+		SELECT s.id, COALESCE(s.municipality_id, ''), COALESCE(s.ground_id, ''), s.slot_date,
+		       s.time_from, s.time_to, COALESCE(s.court_name, '')
+		FROM slots s`
+	var args []any
+	if q.SlotID != "" {
+		query += ` WHERE s.id = ?`
+		args = append(args, q.SlotID)
+	} else {
+		// Include slots that were never mapped to the ground but whose court
+		// name matches its court_pattern, since those are a common cause of
+		// missed notifications.
+		query += `
+		LEFT JOIN grounds g ON g.id = ?
+		WHERE date(s.slot_date) = ?
+		  AND (s.ground_id = g.id OR (s.municipality_id = g.municipality_id AND instr(s.court_name, g.court_pattern) > 0))
+		ORDER BY s.time_from, s.court_name
+		LIMIT ?`
+		args = append(args, q.GroundID, q.Date, DefaultPageSize)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slots []explainSlot
+	for rows.Next() {
+		var sl explainSlot
+		if err := rows.Scan(&sl.ID, &sl.MunicipalityID, &sl.GroundID, &sl.Date,
+			&sl.TimeFrom, &sl.TimeTo, &sl.CourtName); err != nil {
+			return nil, err
+		}
+		sl.Date = dateOnly(sl.Date)
+		slots = append(slots, sl)
+	}
+	return slots, rows.Err()
+}
+
+func (s *Server) loadExplainConditions(ctx context.Context, q explainQuery) ([]explainCondition, error) {
+	query := `
+		SELECT wc.id, wc.team_id, COALESCE(t.name, ''), COALESCE(t.status, ''), wc.facility_id,
+		       wc.days_of_week, wc.time_from, wc.time_to,
+		       COALESCE(wc.date_from, ''), COALESCE(wc.date_to, ''), wc.enabled,
+		       wc.min_lead_days, wc.max_lead_days, wc.excluded_dates, wc.bundle_type, wc.bundle_size
+		FROM watch_conditions wc
+		LEFT JOIN teams t ON t.id = wc.team_id
+		WHERE 1 = 1`
+	var args []any
+	if q.ConditionID != "" {
+		query += ` AND wc.id = ?`
+		args = append(args, q.ConditionID)
+	}
+	if q.TeamID != "" {
+		query += ` AND wc.team_id = ?`
+		args = append(args, q.TeamID)
+	}
+	query += ` ORDER BY wc.created_at`
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conds []explainCondition
+	for rows.Next() {
+		var c explainCondition
+		var daysJSON, excludedJSON string
+		var enabled int
+		if err := rows.Scan(&c.ID, &c.TeamID, &c.TeamName, &c.TeamStatus, &c.FacilityID,
+			&daysJSON, &c.TimeFrom, &c.TimeTo, &c.DateFrom, &c.DateTo, &enabled,
+			&c.MinLeadDays, &c.MaxLeadDays, &excludedJSON, &c.BundleType, &c.BundleSize); err != nil {
+			return nil, err
+		}
+		c.Enabled = enabled == 1
+		c.DateFrom = dateOnly(c.DateFrom)
+		c.DateTo = dateOnly(c.DateTo)
+		// Malformed JSON is treated as empty, as the worker does
+		_ = json.Unmarshal([]byte(daysJSON), &c.DaysOfWeek)
+		_ = json.Unmarshal([]byte(excludedJSON), &c.ExcludedDates)
+		conds = append(conds, c)
+	}
+	return conds, rows.Err()
+}
+
+// mapGroundByPattern resolves the ground for a slot's court name the same way
+// the worker does when saving slots: the longest matching court_pattern wins.
+func (s *Server) mapGroundByPattern(ctx context.Context, slot explainSlot) (groundID, pattern string) {
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT id, court_pattern FROM grounds
+		WHERE municipality_id = ? AND instr(?, court_pattern) > 0
+		ORDER BY length(court_pattern) DESC
+		LIMIT 1
+	`, slot.MunicipalityID, slot.CourtName).Scan(&groundID, &pattern)
+	if err != nil {
+		return "", ""
+	}
+	return groundID, pattern
+}
+
+func groundCheck(slot explainSlot, cond explainCondition, mapped, pattern string) ExplainCheck {
+	c := ExplainCheck{Name: "ground", Passed: slot.GroundID != "" && slot.GroundID == cond.FacilityID}
+	switch {
+	case slot.GroundID == "":
+		c.Detail = fmt.Sprintf("slot %q is not mapped to any ground", slot.CourtName)
+	case c.Passed:
+		c.Detail = fmt.Sprintf("slot is mapped to the watched ground %s", cond.FacilityID)
+	default:
+		c.Detail = fmt.Sprintf("slot is mapped to ground %s, condition watches %s", slot.GroundID, cond.FacilityID)
+	}
+	if mapped == "" {
+		c.Detail += "; no court_pattern matches the court name"
+	} else {
+		c.Detail += fmt.Sprintf("; court_pattern %q maps to %s", pattern, mapped)
+	}
+	return c
+}
+
+// evaluateMatch runs the worker's condition checks as of now: the team and
+// condition filters of Matcher.GetActiveConditions, then the shared slot checks.
+func evaluateMatch(slot explainSlot, cond explainCondition, now time.Time) MatchExplanation {
+	e := MatchExplanation{
+		SlotID:      slot.ID,
+		SlotDate:    slot.Date,
+		SlotTime:    slot.TimeFrom + "-" + slot.TimeTo,
+		CourtName:   slot.CourtName,
+		ConditionID: cond.ID,
+		TeamID:      cond.TeamID,
+		TeamName:    cond.TeamName,
+		Checks: []ExplainCheck{
+			{Name: "team_status", Passed: cond.TeamStatus == "active", Detail: fmt.Sprintf("team status is %q", cond.TeamStatus)},
+			{Name: "condition_enabled", Passed: cond.Enabled, Detail: fmt.Sprintf("condition enabled: %t", cond.Enabled)},
+		},
+	}
+	e.Checks = append(e.Checks, match.Explain(slot.matchSlot(), cond.matchCondition(), now)...)
+	if cond.BundleType != "" {
+		e.Checks = append(e.Checks, ExplainCheck{Name: "bundle", Passed: true,
+			Detail: fmt.Sprintf("bundle condition (%s x%d): notified only when the full bundle is available",
+				cond.BundleType, cond.BundleSize)})
+	}
+	return e
+}
+
+// bookedCheck reports whether the team has booked the slot. Booked slots are
+// not notified again (Matcher.CreateNotification) and pending notifications
+// for them are dropped by the sender.
+func (s *Server) bookedCheck(ctx context.Context, slot explainSlot, cond explainCondition) ExplainCheck {
+	var booked bool
+	_ = s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM slot_bookings WHERE team_id = ? AND slot_id = ?)
+	`, cond.TeamID, slot.ID).Scan(&booked)
+	if booked {
+		return ExplainCheck{Name: "not_booked", Passed: false, Detail: "the team has booked this slot, so it is not notified again"}
+	}
+	return ExplainCheck{Name: "not_booked", Passed: true, Detail: "the team has not booked this slot"}
+}
+
+// deliveryChecks report the sender's per-plan rules for the team
+// (worker/notifier/policy.go): the delay before a match is delivered and the
+// daily cap on distinct slots notified to the team. Teams on a plan without
+// plan_limits get the free plan's rules, as in the sender.
+func (s *Server) deliveryChecks(ctx context.Context, slot explainSlot, cond explainCondition) []ExplainCheck {
+	var plan string
+	var delay, dailyCap int
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT t.plan,
+		       COALESCE(pl.notification_delay_minutes, free.notification_delay_minutes, 0),
+		       COALESCE(pl.daily_notification_cap, free.daily_notification_cap, 0)
+		FROM teams t
+		LEFT JOIN plan_limits pl ON pl.plan = t.plan
+		LEFT JOIN plan_limits free ON free.plan = 'free'
+		WHERE t.id = ?
+	`, cond.TeamID).Scan(&plan, &delay, &dailyCap)
+	if err != nil {
+		return nil
+	}
+
+	delayCheck := ExplainCheck{Name: "plan_delay", Passed: true,
+		Detail: fmt.Sprintf("plan %s delivers matches %d minutes after they are found", plan, delay)}
+	var waited int
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT CAST((julianday('now') - julianday(created_at)) * 1440 AS INTEGER) FROM notifications
+		WHERE team_id = ? AND slot_id = ? AND watch_condition_id = ? AND status = 'pending'
+		ORDER BY created_at DESC LIMIT 1
+	`, cond.TeamID, slot.ID, cond.ID).Scan(&waited)
+	if err == nil && waited < delay {
+		delayCheck.Passed = false
+		delayCheck.Detail += fmt.Sprintf("; the pending notification has waited %d minutes", waited)
+	}
+
+	var sentToday int
+	var alreadySent bool
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT slot_id), COALESCE(MAX(slot_id = ?), 0) FROM notifications
+		WHERE team_id = ? AND status = 'sent' AND date(sent_at, '+9 hours') = date('now', '+9 hours')
+	`, slot.ID, cond.TeamID).Scan(&sentToday, &alreadySent)
+	if err != nil {
+		return []ExplainCheck{delayCheck}
+	}
+	capCheck := ExplainCheck{Name: "daily_cap", Passed: true}
+	switch {
+	case dailyCap == 0:
+		capCheck.Detail = fmt.Sprintf("plan %s has no daily cap", plan)
+	case alreadySent:
+		capCheck.Detail = "the slot was already notified today and does not count again"
+	default:
+		capCheck.Passed = sentToday < dailyCap
+		capCheck.Detail = fmt.Sprintf("team was notified of %d of %d slots today (plan %s); further slots are skipped until tomorrow",
+			sentToday, dailyCap, plan)
+	}
+	return []ExplainCheck{delayCheck, capCheck}
+}
+
+// notificationCheck reports the dedupe and delivery state for the slot and condition.
+func (s *Server) notificationCheck(ctx context.Context, slot explainSlot, cond explainCondition, e *MatchExplanation) ExplainCheck {
+	var id, channel, status string
+	var sentAt sql.NullString
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT id, channel, status, sent_at FROM notifications
+		WHERE team_id = ? AND slot_id = ? AND watch_condition_id = ?
+		ORDER BY created_at DESC LIMIT 1
+	`, cond.TeamID, slot.ID, cond.ID).Scan(&id, &channel, &status, &sentAt)
+	if err != nil {
+		detail := "no notification has been created for this slot"
+		if e.Matched {
+			detail += "; it will be created on the next match run"
+		}
+		return ExplainCheck{Name: "notification", Passed: false, Detail: detail}
+	}
+	e.NotificationStatus = status
+	detail := fmt.Sprintf("notification %s via %s is %s (duplicates are not re-created)", id, channel, status)
+	if sentAt.Valid {
+		detail += ", sent at " + sentAt.String
+	}
+	return ExplainCheck{Name: "notification", Passed: status == "sent", Detail: detail}
+}
+
+func allPassed(checks []ExplainCheck) bool {
+	for _, c := range checks {
+		if !c.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// dateOnly trims a date or timestamp string to YYYY-MM-DD.
+func dateOnly(s string) string {
+	if len(s) > 10 {
+		return s[:10]
+	}
+	return s
+}
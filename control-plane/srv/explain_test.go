@@ -0,0 +1,145 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExplainMatch(t *testing.T) {
+	server := newTestServer(t)
+
+	// Seeded by 014-grounds-seed-data.sql
+	const (
+		municipalityID = "d9e5f0b2-3456-5789-0bcd-ef0123456789"
+		groundID       = "d0000001-0001-4000-8000-000000000001"
+	)
+	// A Saturday far in the future keeps the weekday check deterministic
+	const saturday = "2099-01-03"
+
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-2', 'チーム2', 'team2@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+		VALUES ('wc-1', 'team-1', ?, '[0]', '09:00', '12:00')`, groundID)
+	mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+		VALUES ('wc-2', 'team-2', ?, '[6]', '09:00', '12:00')`, groundID)
+	mustExec(t, server.DB, `INSERT INTO slots (id, municipality_id, ground_id, slot_date, time_from, time_to, court_name)
+		VALUES ('slot-1', ?, ?, ?, '09:00', '11:00', 'サーティーフォー保土ケ谷球場')`, municipalityID, groundID, saturday)
+	// Not mapped to a ground, but its court name matches the ground's court_pattern
+	mustExec(t, server.DB, `INSERT INTO slots (id, municipality_id, slot_date, time_from, time_to, court_name)
+		VALUES ('slot-2', ?, ?, '13:00', '15:00', 'サーティーフォー保土ケ谷球場B面')`, municipalityID, saturday)
+
+	get := func(handler http.HandlerFunc, url string) (*httptest.ResponseRecorder, []MatchExplanation) {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		var explanations []MatchExplanation
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&explanations); err != nil {
+				t.Fatalf("レスポンスのデコードに失敗すべきではない: %v", err)
+			}
+		}
+		return w, explanations
+	}
+	check := func(e MatchExplanation, name string) ExplainCheck {
+		for _, c := range e.Checks {
+			if c.Name == name {
+				return c
+			}
+		}
+		t.Fatalf("check %s が含まれるべき: %+v", name, e.Checks)
+		return ExplainCheck{}
+	}
+
+	t.Run("曜日が一致しない理由を返すべき", func(t *testing.T) {
+		w, explanations := get(server.HandleExplainMatch, "/api/explain?team_id=team-1&slot_id=slot-1")
+		if w.Code != http.StatusOK || len(explanations) != 1 {
+			t.Fatalf("1 件の診断結果を返すべき: %d %v", w.Code, explanations)
+		}
+		e := explanations[0]
+		if e.Matched {
+			t.Error("日曜のみの条件は土曜の枠に一致しないべき")
+		}
+		if check(e, "weekday").Passed {
+			t.Error("weekday チェックは失敗すべき")
+		}
+		if !check(e, "ground").Passed || !check(e, "time_overlap").Passed {
+			t.Errorf("施設と時間帯のチェックは成功すべき: %+v", e.Checks)
+		}
+	})
+
+	t.Run("一致した枠の通知状況を返すべき", func(t *testing.T) {
+		mustExec(t, server.DB, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, status, sent_at)
+			VALUES ('n-1', 'team-2', 'wc-2', 'slot-1', 'email', 'sent', ?)`, time.Now().UTC().Format(time.DateTime))
+		_, explanations := get(server.HandleExplainMatch, "/api/explain?team_id=team-2&slot_id=slot-1")
+		if len(explanations) != 1 || !explanations[0].Matched {
+			t.Fatalf("条件に一致すべき: %+v", explanations)
+		}
+		if explanations[0].NotificationStatus != "sent" || !check(explanations[0], "notification").Passed {
+			t.Errorf("送信済みの通知を返すべき: %+v", explanations[0])
+		}
+	})
+
+	t.Run("施設に紐付いていない枠も court_pattern で検出すべき", func(t *testing.T) {
+		_, explanations := get(server.HandleAdminExplainMatch, "/admin/api/explain?condition_id=wc-2&ground_id="+groundID+"&date="+saturday)
+		if len(explanations) != 2 {
+			t.Fatalf("2 件の枠を診断すべき: %+v", explanations)
+		}
+		unmapped := explanations[1]
+		if unmapped.SlotID != "slot-2" {
+			t.Fatalf("未紐付けの枠が含まれるべき: %+v", unmapped)
+		}
+		if c := check(unmapped, "ground"); c.Passed {
+			t.Errorf("ground チェックは失敗すべき: %+v", c)
+		}
+	})
+
+	t.Run("プランの配信待ちと1日の上限を返すべき", func(t *testing.T) {
+		mustExec(t, server.DB, `UPDATE plan_limits SET notification_delay_minutes = 30, daily_notification_cap = 1 WHERE plan = 'pro'`)
+		defer mustExec(t, server.DB, `UPDATE plan_limits SET notification_delay_minutes = 0, daily_notification_cap = 0 WHERE plan = 'pro'`)
+		mustExec(t, server.DB, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, status)
+			VALUES ('n-2', 'team-2', 'wc-2', 'slot-2', 'email', 'pending')`)
+		defer mustExec(t, server.DB, `DELETE FROM notifications WHERE id = 'n-2'`)
+
+		_, explanations := get(server.HandleAdminExplainMatch, "/admin/api/explain?condition_id=wc-2&ground_id="+groundID+"&date="+saturday)
+		if len(explanations) != 2 {
+			t.Fatalf("2 件の枠を診断すべき: %+v", explanations)
+		}
+		sent, pending := explanations[0], explanations[1]
+		if c := check(sent, "daily_cap"); !c.Passed {
+			t.Errorf("今日通知済みの枠は上限に数えないべき: %+v", c)
+		}
+		if c := check(pending, "daily_cap"); c.Passed {
+			t.Errorf("上限に達したら失敗すべき: %+v", c)
+		}
+		if c := check(pending, "plan_delay"); c.Passed {
+			t.Errorf("配信待ちの通知は失敗すべき: %+v", c)
+		}
+		if c := check(sent, "plan_delay"); !c.Passed {
+			t.Errorf("配信待ちの通知がなければ成功すべき: %+v", c)
+		}
+	})
+
+	t.Run("予約済みの枠は一致しないべき", func(t *testing.T) {
+		mustExec(t, server.DB, `INSERT INTO slot_bookings (team_id, slot_id, source) VALUES ('team-2', 'slot-1', 'dashboard')`)
+		_, explanations := get(server.HandleExplainMatch, "/api/explain?team_id=team-2&slot_id=slot-1")
+		if len(explanations) != 1 || explanations[0].Matched {
+			t.Fatalf("予約済みの枠は一致しないべき: %+v", explanations)
+		}
+		if check(explanations[0], "not_booked").Passed {
+			t.Error("not_booked チェックは失敗すべき")
+		}
+	})
+
+	t.Run("ユーザー向けは自チームの条件のみ返すべき", func(t *testing.T) {
+		_, explanations := get(server.HandleExplainMatch, "/api/explain?team_id=team-1&condition_id=wc-2&slot_id=slot-1")
+		if len(explanations) != 0 {
+			t.Errorf("他チームの条件は返さないべき: %+v", explanations)
+		}
+		if w, _ := get(server.HandleExplainMatch, "/api/explain?condition_id=wc-2&slot_id=slot-1"); w.Code != http.StatusBadRequest {
+			t.Errorf("team_id なしは 400 を返すべき: %d", w.Code)
+		}
+	})
+}
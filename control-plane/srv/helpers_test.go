@@ -0,0 +1,36 @@
+package srv
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// newTestServer returns a server on a new database in the test's temporary
+// directory, with every migration applied.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	server, err := New(filepath.Join(t.TempDir(), "test.sqlite3"), "test-hostname")
+	if err != nil {
+		t.Fatalf("サーバー初期化に失敗すべきではない: %v", err)
+	}
+	return server
+}
+
+// mustExec runs a statement, failing the test on error.
+func mustExec(t *testing.T, db *sql.DB, query string, args ...any) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("exec %q: %v", query, err)
+	}
+}
+
+// queryInt returns the single integer a query selects, e.g. a COUNT(*).
+func queryInt(t *testing.T, db *sql.DB, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,417 @@
+package srv
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// LINE account linking
+//
+// A team links its LINE account through LINE Login (HandleLINELogin /
+// HandleLINECallback), which stores the team's LINE user ID. The worker pushes
+// Messaging API notifications to that ID. Pushes only reach users who have
+// added the official account as a friend, so the follow state is checked at
+// link time and kept up to date by follow/unfollow webhook events.
+//
+// The LINE Login channel must be linked to the Messaging API channel under the
+// same provider so that both see the same user ID.
+
+// HandleLINELogin starts LINE Login for the logged-in team.
+func (s *Server) HandleLINELogin(w http.ResponseWriter, r *http.Request) {
+	config := getOAuthConfig()
+
+	if config.LINEChannelID == "" {
+		s.jsonError(w, "LINE Login not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	state, err := generateOAuthState()
+	if err != nil {
+		slog.Error("generate oauth state", "error", err)
+		s.jsonError(w, "failed to generate state", http.StatusInternalServerError)
+		return
+	}
+
+	// Store state in a cookie for verification; the team being linked is the
+	// session's, checked again in the callback
+	http.SetCookie(w, &http.Cookie{
+		Name:     "line_oauth_state",
+		Value:    state,
+		Path:     "/",
+		MaxAge:   600, // 10 minutes
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.BaseURL, "https"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	redirectURI := config.BaseURL + "/auth/line/callback"
+
+	// bot_prompt=aggressive asks the user to add the official account as a
+	// friend in the same consent screen.
+	authURL := fmt.Sprintf(
+		"https://access.line.me/oauth2/v2.1/authorize?response_type=code&client_id=%s&redirect_uri=%s&state=%s&scope=%s&bot_prompt=aggressive",
+		url.QueryEscape(config.LINEChannelID),
+		url.QueryEscape(redirectURI),
+		url.QueryEscape(state),
+		url.QueryEscape("profile openid"),
+	)
+
+	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+}
+
+// HandleLINECallback handles the LINE Login callback and links the LINE user to the team.
+func (s *Server) HandleLINECallback(w http.ResponseWriter, r *http.Request) {
+	config := getOAuthConfig()
+
+	// Verify state
+	stateCookie, err := r.Cookie("line_oauth_state")
+	if err != nil {
+		slog.Warn("line oauth state cookie missing")
+		http.Error(w, "Invalid state", http.StatusBadRequest)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	if state != stateCookie.Value {
+		slog.Warn("line oauth state mismatch")
+		http.Error(w, "Invalid state", http.StatusBadRequest)
+		return
+	}
+
+	// Clear state cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:   "line_oauth_state",
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+
+	// Check for error from LINE (e.g. the user cancelled)
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		slog.Warn("oauth error from line", "error", errParam)
+		http.Redirect(w, r, "/user?error=line_link_failed", http.StatusTemporaryRedirect)
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	tokenResp, err := exchangeLINECode(config, code)
+	if err != nil {
+		slog.Error("exchange line code", "error", err)
+		http.Error(w, "Failed to exchange code", http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := getLINEProfile(tokenResp.AccessToken)
+	if err != nil {
+		slog.Error("get line profile", "error", err)
+		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
+		return
+	}
+
+	followed, err := getLINEFriendship(tokenResp.AccessToken)
+	if err != nil {
+		// Not fatal: the follow webhook will update the state later
+		slog.Warn("get line friendship status", "error", err)
+	}
+
+	ctx := r.Context()
+	sess, err := s.currentSession(r)
+	if err != nil {
+		slog.Warn("line link without session", "error", err)
+		http.Redirect(w, r, "/user?error=line_link_failed", http.StatusTemporaryRedirect)
+		return
+	}
+	teamID := sess.TeamID
+	if err := s.linkLINE(ctx, teamID, profile.UserID, followed); err != nil {
+		slog.Error("link line account", "team_id", teamID, "error", err)
+		http.Error(w, "Failed to link LINE account", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("line account linked", "team_id", teamID, "followed", followed)
+
+	http.Redirect(w, r, "/user", http.StatusSeeOther)
+}
+
+// linkLINE stores the LINE user ID on the team and enables the LINE channel.
+// A LINE account can only be linked to one team; linking it again moves it.
+func (s *Server) linkLINE(ctx context.Context, teamID, lineUserID string, followed bool) error {
+	if lineUserID == "" {
+		return fmt.Errorf("empty LINE user ID")
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM teams WHERE line_user_id = ? AND id <> ?`, lineUserID, teamID)
+	if err != nil {
+		return err
+	}
+	var previous []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		previous = append(previous, id)
+	}
+	rows.Close()
+	for _, id := range previous {
+		if err := unlinkLINETx(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE teams SET line_user_id = ?, line_followed = ?, line_linked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, lineUserID, followed, teamID); err != nil {
+		return err
+	}
+	if err := enableChannelTx(ctx, tx, teamID, ChannelLINE); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HandleUnlinkLINE removes the team's LINE account link.
+func (s *Server) HandleUnlinkLINE(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var lineUserID sql.NullString
+	err = tx.QueryRowContext(ctx, `SELECT line_user_id FROM teams WHERE id = ?`, id).Scan(&lineUserID)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !lineUserID.Valid {
+		s.jsonError(w, "LINE account not linked", http.StatusConflict)
+		return
+	}
+	if err := unlinkLINETx(ctx, tx, id); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("line account unlinked", "team_id", id)
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// unlinkLINETx clears a team's LINE link and disables the LINE channel.
+func unlinkLINETx(ctx context.Context, tx *sql.Tx, teamID string) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE teams SET line_user_id = NULL, line_followed = 0, line_linked_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, teamID); err != nil {
+		return err
+	}
+	return disableChannelTx(ctx, tx, teamID, ChannelLINE, "LINE account unlinked")
+}
+
+// LINEWebhookEvent is one event of a Messaging API webhook request.
+type LINEWebhookEvent struct {
+	Type   string `json:"type"`
+	Source struct {
+		Type   string `json:"type"`
+		UserID string `json:"userId"`
+	} `json:"source"`
+}
+
+// HandleLINEWebhook receives Messaging API webhook events and tracks whether
+// linked users follow the official account. Events from users that have not
+// linked a team are ignored; the follow state is checked again when they link.
+func (s *Server) HandleLINEWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.jsonError(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if !verifyLINESignature(os.Getenv("LINE_CHANNEL_SECRET"), body, r.Header.Get("X-Line-Signature")) {
+		s.jsonError(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Events []LINEWebhookEvent `json:"events"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.jsonError(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	for _, ev := range req.Events {
+		if ev.Source.UserID == "" {
+			continue
+		}
+		var followed int
+		switch ev.Type {
+		case "follow":
+			followed = 1
+		case "unfollow":
+			followed = 0
+		default:
+			continue
+		}
+		res, err := s.DB.ExecContext(ctx, `
+			UPDATE teams SET line_followed = ?, updated_at = CURRENT_TIMESTAMP WHERE line_user_id = ?
+		`, followed, ev.Source.UserID)
+		if err != nil {
+			slog.Error("update line follow state", "error", err)
+			s.jsonError(w, "webhook handling failed", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			slog.Info("line follow state changed", "event", ev.Type)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifyLINESignature checks the X-Line-Signature header: the base64-encoded
+// HMAC-SHA256 of the request body keyed with the channel secret.
+func verifyLINESignature(channelSecret string, body []byte, signature string) bool {
+	if channelSecret == "" || signature == "" {
+		return false
+	}
+	got, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(channelSecret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// LINETokenResponse represents the token response from LINE Login
+type LINETokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+}
+
+// LINEProfile represents a LINE user profile
+type LINEProfile struct {
+	UserID      string `json:"userId"`
+	DisplayName string `json:"displayName"`
+}
+
+func exchangeLINECode(config OAuthConfig, code string) (*LINETokenResponse, error) {
+	redirectURI := config.BaseURL + "/auth/line/callback"
+
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", redirectURI)
+	data.Set("client_id", config.LINEChannelID)
+	data.Set("client_secret", config.LINEChannelSecret)
+
+	resp, err := http.Post(
+		"https://api.line.me/oauth2/v2.1/token",
+		"application/x-www-form-urlencoded",
+		strings.NewReader(data.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("token exchange failed: %s", string(body))
+	}
+
+	var tokenResp LINETokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, err
+	}
+
+	return &tokenResp, nil
+}
+
+func getLINEProfile(accessToken string) (*LINEProfile, error) {
+	var profile LINEProfile
+	if err := getLINEJSON(accessToken, "https://api.line.me/v2/profile", &profile); err != nil {
+		return nil, err
+	}
+	if profile.UserID == "" {
+		return nil, fmt.Errorf("profile has no user ID")
+	}
+	return &profile, nil
+}
+
+// getLINEFriendship reports whether the user has added the official account
+// linked to the LINE Login channel as a friend.
+func getLINEFriendship(accessToken string) (bool, error) {
+	var status struct {
+		FriendFlag bool `json:"friendFlag"`
+	}
+	if err := getLINEJSON(accessToken, "https://api.line.me/friendship/v1/status", &status); err != nil {
+		return false, err
+	}
+	return status.FriendFlag, nil
+}
+
+func getLINEJSON(accessToken, endpoint string, v any) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s request failed: %s", endpoint, string(body))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,124 @@
+package srv
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLINELinking(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	teamLINE := func(id string) (userID sql.NullString, followed int, channels string) {
+		t.Helper()
+		if err := server.DB.QueryRow(`SELECT line_user_id, line_followed, notification_channels FROM teams WHERE id = ?`, id).
+			Scan(&userID, &followed, &channels); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-2', 'チーム2', 'team2@example.com', 'pro')`)
+
+	t.Run("連携するとLINEユーザーIDを保存しLINE通知を有効にすべき", func(t *testing.T) {
+		if err := server.linkLINE(ctx, "team-1", "U111", false); err != nil {
+			t.Fatalf("linkLINE failed: %v", err)
+		}
+		userID, followed, channels := teamLINE("team-1")
+		if userID.String != "U111" || followed != 0 || channels != `["email","line"]` {
+			t.Errorf("unexpected link state: %v %d %s", userID, followed, channels)
+		}
+	})
+
+	t.Run("webhookの署名を検証し友だち状態を更新すべき", func(t *testing.T) {
+		t.Setenv("LINE_CHANNEL_SECRET", "secret")
+		post := func(body, signature string) int {
+			req := httptest.NewRequest(http.MethodPost, "/api/line/webhook", strings.NewReader(body))
+			req.Header.Set("X-Line-Signature", signature)
+			w := httptest.NewRecorder()
+			server.HandleLINEWebhook(w, req)
+			return w.Code
+		}
+		sign := func(body string) string {
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(body))
+			return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+
+		follow := `{"events":[{"type":"follow","source":{"type":"user","userId":"U111"}}]}`
+		if code := post(follow, sign("tampered")); code != http.StatusUnauthorized {
+			t.Fatalf("invalid signature should be rejected: %d", code)
+		}
+		if code := post(follow, sign(follow)); code != http.StatusOK {
+			t.Fatalf("follow should be accepted: %d", code)
+		}
+		if _, followed, _ := teamLINE("team-1"); followed != 1 {
+			t.Errorf("follow should be recorded")
+		}
+
+		unfollow := `{"events":[{"type":"unfollow","source":{"type":"user","userId":"U111"}}]}`
+		post(unfollow, sign(unfollow))
+		if userID, followed, _ := teamLINE("team-1"); followed != 0 || userID.String != "U111" {
+			t.Errorf("unfollow should keep the link but clear follow state: %v %d", userID, followed)
+		}
+	})
+
+	t.Run("別チームで連携すると元のチームの連携を解除すべき", func(t *testing.T) {
+		if err := server.linkLINE(ctx, "team-2", "U111", true); err != nil {
+			t.Fatalf("linkLINE failed: %v", err)
+		}
+		if userID, _, channels := teamLINE("team-1"); userID.Valid || channels != `["email"]` {
+			t.Errorf("previous team should be unlinked: %v %s", userID, channels)
+		}
+		if userID, followed, _ := teamLINE("team-2"); userID.String != "U111" || followed != 1 {
+			t.Errorf("new team should be linked: %v %d", userID, followed)
+		}
+	})
+
+	t.Run("連携解除でLINEを通知先から外し保留中の通知を失敗にすべき", func(t *testing.T) {
+		mustExec(t, server.DB, `UPDATE teams SET notification_channels = '["line"]' WHERE id = 'team-2'`)
+		mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to, channels)
+			VALUES ('wc-line', 'team-2', 'ground-1', '[]', '00:00', '24:00', '["line"]')`)
+		mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to, channels)
+			VALUES ('wc-both', 'team-2', 'ground-2', '[]', '00:00', '24:00', '["email","line"]')`)
+		mustExec(t, server.DB, `INSERT INTO slots (id, slot_date, time_from, time_to) VALUES ('slot-1', '2099-01-03', '09:00', '11:00')`)
+		mustExec(t, server.DB, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, status)
+			VALUES ('n-line', 'team-2', 'wc-line', 'slot-1', 'line', 'pending')`)
+
+		req := httptest.NewRequest(http.MethodDelete, "/api/teams/team-2/line", nil)
+		req.SetPathValue("id", "team-2")
+		w := httptest.NewRecorder()
+		server.HandleUnlinkLINE(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unlink should succeed: %d %s", w.Code, w.Body.String())
+		}
+
+		if userID, followed, channels := teamLINE("team-2"); userID.Valid || followed != 0 || channels != `["email"]` {
+			t.Errorf("team should fall back to email: %v %d %s", userID, followed, channels)
+		}
+		var lineOnly sql.NullString
+		var both string
+		server.DB.QueryRow(`SELECT channels FROM watch_conditions WHERE id = 'wc-line'`).Scan(&lineOnly)
+		server.DB.QueryRow(`SELECT channels FROM watch_conditions WHERE id = 'wc-both'`).Scan(&both)
+		if lineOnly.Valid || both != `["email"]` {
+			t.Errorf("condition overrides should drop line: %v %s", lineOnly, both)
+		}
+		var status string
+		server.DB.QueryRow(`SELECT status FROM notifications WHERE id = 'n-line'`).Scan(&status)
+		if status != "failed" {
+			t.Errorf("pending LINE notification should fail, got %s", status)
+		}
+
+		w = httptest.NewRecorder()
+		server.HandleUnlinkLINE(w, req)
+		if w.Code != http.StatusConflict {
+			t.Errorf("unlinking twice should conflict: %d", w.Code)
+		}
+	})
+}
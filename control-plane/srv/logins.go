@@ -0,0 +1,381 @@
+package srv
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Login activity
+//
+// Every magic-link and Google login is recorded in login_history along
+// with the browser it came from. A browser is recognised by the
+// akigura_device cookie, set on its first login and kept for
+// deviceCookieTTL; only its hash is stored. A login from a device the team
+// has not logged in from before is marked new_device and announced to the
+// team's email address, unless it is the team's first login.
+//
+// The dashboard lists the team's active sessions with their device, IP
+// address and last use, and can end any of them or all at once.
+
+// Login methods, stored in sessions.method and login_history.method
+const (
+	loginMagicLink = "magic_link"
+	loginGoogle    = "google"
+)
+
+const (
+	deviceCookie    = "akigura_device"
+	deviceCookieTTL = 2 * 365 * 24 * time.Hour
+
+	// loginHistoryLimit is how many logins the dashboard shows.
+	loginHistoryLimit = 50
+
+	// newDeviceAlertTimeout bounds sending a new-device alert.
+	newDeviceAlertTimeout = 30 * time.Second
+)
+
+// requestUserAgent is the request's User-Agent, cut to fit the columns
+// storing it.
+func requestUserAgent(r *http.Request) string {
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	return userAgent
+}
+
+// clientIP is the address the request came from. X-Forwarded-For is only
+// believed when the request comes from one of TRUSTED_PROXIES: the client
+// can put anything in the header, so the address used is the last one
+// added by a proxy that is not itself trusted.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	trusted := trustedProxies()
+	if !isTrustedProxy(trusted, host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(trusted, hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// trustedProxies parses TRUSTED_PROXIES, a comma-separated list of IPs or
+// CIDRs of the reverse proxies in front of the server.
+func trustedProxies() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p.Masked())
+		} else if a, err := netip.ParseAddr(s); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+		} else {
+			slog.Warn("ignoring invalid TRUSTED_PROXIES entry", "entry", s)
+		}
+	}
+	return prefixes
+}
+
+func isTrustedProxy(trusted []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceHash identifies the requesting browser, giving it a device cookie
+// if it has none.
+func deviceHash(w http.ResponseWriter, r *http.Request) (string, error) {
+	if c, err := r.Cookie(deviceCookie); err == nil && c.Value != "" {
+		return hashSessionToken(c.Value), nil
+	}
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(deviceCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   sessionCookieSecure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return hashSessionToken(token), nil
+}
+
+// recordLogin adds a login to the team's history and alerts the team when
+// it came from a new device.
+func (s *Server) recordLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, teamID, sessionID, method string) error {
+	device, err := deviceHash(w, r)
+	if err != nil {
+		return err
+	}
+	var seenTeam, seenDevice bool
+	if err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM login_history WHERE team_id = ?),
+		       EXISTS (SELECT 1 FROM login_history WHERE team_id = ? AND device_hash = ?)
+	`, teamID, teamID, device).Scan(&seenTeam, &seenDevice); err != nil {
+		return err
+	}
+	newDevice := seenTeam && !seenDevice
+
+	userAgent, ip := requestUserAgent(r), clientIP(r)
+	if _, err := s.DB.ExecContext(ctx, `
+		INSERT INTO login_history (team_id, session_id, method, device_hash, user_agent, ip, new_device)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, teamID, sessionID, method, device, userAgent, ip, newDevice); err != nil {
+		return err
+	}
+	if newDevice {
+		go s.sendNewDeviceAlert(teamID, userAgent, ip, method, time.Now())
+	}
+	return nil
+}
+
+// sendNewDeviceAlert emails the team about a login from a new device.
+func (s *Server) sendNewDeviceAlert(teamID, userAgent, ip, method string, at time.Time) {
+	if s.AccountEmail == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), newDeviceAlertTimeout)
+	defer cancel()
+
+	var email, name string
+	if err := s.DB.QueryRowContext(ctx, `SELECT email, name FROM teams WHERE id = ?`, teamID).Scan(&email, &name); err != nil {
+		slog.Error("load team for new device alert", "team_id", teamID, "error", err)
+		return
+	}
+	body := buildNewDeviceHTML(name, deviceLabel(userAgent), ip, loginMethodLabel(method), at.In(jst))
+	if err := s.AccountEmail(email, "【AkiGura】新しい端末からログインがありました", body); err != nil {
+		slog.Error("send new device alert", "team_id", teamID, "error", err)
+		return
+	}
+	slog.Info("new device alert sent", "team_id", teamID)
+}
+
+var (
+	uaBrowsers = []struct {
+		re   *regexp.Regexp
+		name string
+	}{
+		{regexp.MustCompile(`Edg/`), "Edge"},
+		{regexp.MustCompile(`Chrome/`), "Chrome"},
+		{regexp.MustCompile(`Firefox/`), "Firefox"},
+		{regexp.MustCompile(`Safari/`), "Safari"},
+	}
+	uaSystems = []struct {
+		re   *regexp.Regexp
+		name string
+	}{
+		{regexp.MustCompile(`Android`), "Android"},
+		{regexp.MustCompile(`iPhone|iPad`), "iOS"},
+		{regexp.MustCompile(`Mac OS X`), "Mac"},
+		{regexp.MustCompile(`Windows`), "Windows"},
+	}
+)
+
+// deviceLabel names a User-Agent's browser and OS like the dashboard's
+// deviceLabel does, e.g. "Chrome / Android".
+func deviceLabel(userAgent string) string {
+	label := "ブラウザ"
+	for _, b := range uaBrowsers {
+		if b.re.MatchString(userAgent) {
+			label = b.name
+			break
+		}
+	}
+	for _, o := range uaSystems {
+		if o.re.MatchString(userAgent) {
+			return label + " / " + o.name
+		}
+	}
+	return label
+}
+
+// loginMethodLabel is how a login method is shown to users.
+func loginMethodLabel(method string) string {
+	switch method {
+	case loginGoogle:
+		return "Google ログイン"
+	case loginMagicLink:
+		return "ログインリンク（メール）"
+	}
+	return method
+}
+
+func buildNewDeviceHTML(teamName, device, ip, method string, at time.Time) string {
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"></head>
+<body style="font-family: sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+  <div style="background: #4F46E5; color: white; padding: 20px; border-radius: 8px 8px 0 0;">
+    <h1 style="margin: 0;">⚾ AkiGura</h1>
+  </div>
+  <div style="border: 1px solid #e5e7eb; border-top: none; padding: 20px; border-radius: 0 0 8px 8px;">
+    <p>%s 様</p>
+    <p>これまでログインしたことのない端末から、AkiGura へのログインがありました。</p>
+    <table style="margin: 20px 0; font-size: 14px;">
+      <tr><td style="color: #6b7280; padding-right: 16px;">日時</td><td>%s</td></tr>
+      <tr><td style="color: #6b7280; padding-right: 16px;">端末</td><td>%s</td></tr>
+      <tr><td style="color: #6b7280; padding-right: 16px;">IP アドレス</td><td>%s</td></tr>
+      <tr><td style="color: #6b7280; padding-right: 16px;">ログイン方法</td><td>%s</td></tr>
+    </table>
+    <p>ご自身のログインであれば、対応は不要です。</p>
+    <p style="color: #b91c1c;">心当たりがない場合は、マイページの「ログイン中の端末」からその端末をログアウトさせてください。</p>
+  </div>
+</body>
+</html>`, html.EscapeString(teamName), at.Format("2006-01-02 15:04"), html.EscapeString(device),
+		html.EscapeString(ip), html.EscapeString(method))
+}
+
+// activeSession is a session listed on the dashboard.
+type activeSession struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	Method     string `json:"method"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	Current    bool   `json:"current"` // the session making the request
+}
+
+// HandleListSessions lists the team's active sessions, most recently used
+// first.
+func (s *Server) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	teamID := r.PathValue("id")
+	current, _ := sessionFrom(r.Context())
+	rows, err := s.DB.QueryContext(r.Context(), `
+		SELECT id, user_agent, ip, method,
+		       strftime('%Y-%m-%dT%H:%M:%SZ', created_at), strftime('%Y-%m-%dT%H:%M:%SZ', last_seen_at)
+		FROM sessions
+		WHERE team_id = ? AND expires_at > ?
+		ORDER BY last_seen_at DESC, created_at DESC
+	`, teamID, time.Now().UTC().Format(time.DateTime))
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sessions := []activeSession{}
+	for rows.Next() {
+		var a activeSession
+		if err := rows.Scan(&a.ID, &a.UserAgent, &a.IP, &a.Method, &a.CreatedAt, &a.LastSeenAt); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.Current = a.ID == current.ID
+		sessions = append(sessions, a)
+	}
+	if err := rows.Err(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, sessions)
+}
+
+// HandleRevokeSession logs one of the team's sessions out. Ending the
+// requesting session also clears its cookie.
+func (s *Server) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	teamID, sessionID := r.PathValue("id"), r.PathValue("session")
+	res, err := s.DB.ExecContext(r.Context(), `DELETE FROM sessions WHERE id = ? AND team_id = ?`, sessionID, teamID)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "session not found", http.StatusNotFound)
+		return
+	}
+	if current, _ := sessionFrom(r.Context()); current.ID == sessionID {
+		clearSessionCookie(w)
+	}
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// HandleRevokeAllSessions logs the team out everywhere, including the
+// requesting browser.
+func (s *Server) HandleRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	res, err := s.DB.ExecContext(r.Context(), `DELETE FROM sessions WHERE team_id = ?`, r.PathValue("id"))
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n, _ := res.RowsAffected()
+	clearSessionCookie(w)
+	s.jsonResponse(w, map[string]any{"success": true, "revoked": n})
+}
+
+// loginRecord is an entry of the team's login history.
+type loginRecord struct {
+	ID        int64  `json:"id"`
+	Method    string `json:"method"`
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+	NewDevice bool   `json:"new_device"`
+	CreatedAt string `json:"created_at"`
+}
+
+// HandleListLogins returns the team's latest logins.
+func (s *Server) HandleListLogins(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.QueryContext(r.Context(), `
+		SELECT id, method, user_agent, ip, new_device, strftime('%Y-%m-%dT%H:%M:%SZ', created_at)
+		FROM login_history
+		WHERE team_id = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, r.PathValue("id"), loginHistoryLimit)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	logins := []loginRecord{}
+	for rows.Next() {
+		var l loginRecord
+		if err := rows.Scan(&l.ID, &l.Method, &l.UserAgent, &l.IP, &l.NewDevice, &l.CreatedAt); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logins = append(logins, l)
+	}
+	if err := rows.Err(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, logins)
+}
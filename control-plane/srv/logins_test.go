@@ -0,0 +1,254 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginActivity(t *testing.T) {
+	server := newTestServer(t)
+	t.Setenv("BASE_URL", "https://akigura.example")
+	// httptest requests come from 192.0.2.1, standing in for the proxy
+	t.Setenv("TRUSTED_PROXIES", "192.0.2.1, 10.0.0.0/8")
+	type sentEmail struct{ to, subject, body string }
+	emails := make(chan sentEmail, 10)
+	server.AccountEmail = func(to, subject, htmlContent string) error {
+		emails <- sentEmail{to, subject, htmlContent}
+		return nil
+	}
+	for _, team := range []string{"team-1", "team-2"} {
+		mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES (?, ?, ?, 'pro')`, team, team, team+"@example.com")
+	}
+	cookieNamed := func(w *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+
+	// login logs the team in from a browser with the User-Agent and the
+	// device cookie (nil for a browser never used before) and returns the
+	// session and device cookies.
+	login := func(t *testing.T, team, method, userAgent string, device *http.Cookie) (*http.Cookie, *http.Cookie) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/auth/verify", nil)
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+		if device != nil {
+			req.AddCookie(device)
+		}
+		w := httptest.NewRecorder()
+		server.finishLogin(w, req, team, method)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("ログインに成功すべき: %d", w.Code)
+		}
+		sess := cookieNamed(w, sessionCookie)
+		if sess == nil {
+			t.Fatal("セッションの Cookie を発行すべき")
+		}
+		if c := cookieNamed(w, deviceCookie); c != nil {
+			device = c
+		}
+		return sess, device
+	}
+	call := func(handler http.HandlerFunc, method, team, sessionID string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/teams/"+team+"/sessions", nil)
+		req.SetPathValue("id", team)
+		req.SetPathValue("session", sessionID)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		server.teamRoute(handler)(w, req)
+		return w
+	}
+	expectNoEmail := func(t *testing.T) {
+		t.Helper()
+		select {
+		case e := <-emails:
+			t.Errorf("通知を送るべきではない: %+v", e)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	const iphone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Version/17.0 Mobile/15E148 Safari/604.1"
+	const windows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36"
+
+	phoneSession, phoneDevice := login(t, "team-1", loginMagicLink, iphone, nil)
+
+	t.Run("ログインを端末とともに履歴へ記録すべき", func(t *testing.T) {
+		if phoneDevice == nil || !phoneDevice.HttpOnly || !phoneDevice.Secure {
+			t.Fatalf("HttpOnly・Secure の端末 Cookie を発行すべき: %+v", phoneDevice)
+		}
+		var method, userAgent, ip, device string
+		var newDevice bool
+		if err := server.DB.QueryRow(`SELECT method, user_agent, ip, device_hash, new_device FROM login_history WHERE team_id = 'team-1'`).
+			Scan(&method, &userAgent, &ip, &device, &newDevice); err != nil {
+			t.Fatal(err)
+		}
+		if method != loginMagicLink || userAgent != iphone || ip != "203.0.113.7" {
+			t.Errorf("ログイン方法・ブラウザ・IP を記録すべき: %s %s %s", method, userAgent, ip)
+		}
+		if device != hashSessionToken(phoneDevice.Value) {
+			t.Error("端末 Cookie はハッシュで記録すべき")
+		}
+		if newDevice {
+			t.Error("チーム初めてのログインは新しい端末として扱うべきではない")
+		}
+		expectNoEmail(t)
+	})
+
+	t.Run("同じ端末からのログインは通知すべきではない", func(t *testing.T) {
+		login(t, "team-1", loginGoogle, iphone, phoneDevice)
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM login_history WHERE team_id = 'team-1' AND method = 'google' AND new_device = 0`); n != 1 {
+			t.Errorf("Google ログインも記録すべき: %d", n)
+		}
+		expectNoEmail(t)
+	})
+
+	pcSession, _ := login(t, "team-1", loginGoogle, windows, nil)
+
+	t.Run("新しい端末からのログインをメールで通知すべき", func(t *testing.T) {
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM login_history WHERE team_id = 'team-1' AND new_device = 1`); n != 1 {
+			t.Errorf("新しい端末として記録すべき: %d", n)
+		}
+		select {
+		case e := <-emails:
+			if e.to != "team-1@example.com" || !strings.Contains(e.subject, "新しい端末") {
+				t.Errorf("チームのアドレスへ通知すべき: %s %s", e.to, e.subject)
+			}
+			if !strings.Contains(e.body, "Chrome / Windows") || !strings.Contains(e.body, "203.0.113.7") {
+				t.Errorf("端末と IP を知らせるべき: %s", e.body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("通知メールを送るべき")
+		}
+	})
+
+	t.Run("有効なセッションを一覧し、この端末を示すべき", func(t *testing.T) {
+		w := call(server.HandleListSessions, http.MethodGet, "team-1", "", pcSession)
+		if w.Code != http.StatusOK {
+			t.Fatalf("一覧を返すべき: %d %s", w.Code, w.Body.String())
+		}
+		var sessions []activeSession
+		if err := json.Unmarshal(w.Body.Bytes(), &sessions); err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 3 {
+			t.Fatalf("3つのセッションを返すべき: %d", len(sessions))
+		}
+		current := 0
+		for _, s := range sessions {
+			if s.Current {
+				current++
+				if s.UserAgent != windows || s.Method != loginGoogle || s.IP != "203.0.113.7" {
+					t.Errorf("リクエストした端末のセッションを示すべき: %+v", s)
+				}
+			}
+		}
+		if current != 1 {
+			t.Errorf("この端末はひとつだけであるべき: %d", current)
+		}
+
+		w = call(server.HandleListLogins, http.MethodGet, "team-1", "", pcSession)
+		var logins []loginRecord
+		if err := json.Unmarshal(w.Body.Bytes(), &logins); err != nil {
+			t.Fatal(err)
+		}
+		if len(logins) != 3 || !logins[0].NewDevice {
+			t.Errorf("新しい順にログイン履歴を返すべき: %+v", logins)
+		}
+	})
+
+	t.Run("ほかの端末のセッションを終了できるべき", func(t *testing.T) {
+		phone, err := server.currentSession(cookieRequest(phoneSession))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := call(server.HandleRevokeSession, http.MethodDelete, "team-1", phone.ID, pcSession)
+		if w.Code != http.StatusOK {
+			t.Fatalf("終了に成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if c := cookieNamed(w, sessionCookie); c != nil {
+			t.Error("ほかの端末の終了でこの端末の Cookie を消すべきではない")
+		}
+		if _, err := server.currentSession(cookieRequest(phoneSession)); err == nil {
+			t.Error("終了したセッションは使えないべき")
+		}
+	})
+
+	t.Run("ほかのチームのセッションは終了できないべき", func(t *testing.T) {
+		otherSession, _ := login(t, "team-2", loginMagicLink, iphone, nil)
+		other, err := server.currentSession(cookieRequest(otherSession))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := call(server.HandleRevokeSession, http.MethodDelete, "team-1", other.ID, pcSession); w.Code != http.StatusNotFound {
+			t.Errorf("404を返すべき: %d", w.Code)
+		}
+		if _, err := server.currentSession(cookieRequest(otherSession)); err != nil {
+			t.Errorf("ほかのチームのセッションは残すべき: %v", err)
+		}
+	})
+
+	t.Run("すべての端末からログアウトできるべき", func(t *testing.T) {
+		w := call(server.HandleRevokeAllSessions, http.MethodDelete, "team-1", "", pcSession)
+		if w.Code != http.StatusOK {
+			t.Fatalf("ログアウトに成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if c := cookieNamed(w, sessionCookie); c == nil || c.MaxAge >= 0 {
+			t.Error("この端末の Cookie も消すべき")
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM sessions WHERE team_id = 'team-1'`); n != 0 {
+			t.Errorf("チームのセッションをすべて削除すべき: %d", n)
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM sessions WHERE team_id = 'team-2'`); n != 1 {
+			t.Errorf("ほかのチームのセッションは残すべき: %d", n)
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM login_history WHERE team_id = 'team-1'`); n != 3 {
+			t.Errorf("ログイン履歴は残すべき: %d", n)
+		}
+	})
+}
+
+// cookieRequest is a request carrying the cookie.
+func cookieRequest(c *http.Cookie) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(c)
+	return req
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		trusted  string
+		remote   string
+		forwards []string
+		want     string
+	}{
+		{"プロキシを設定していなければ接続元を使うべき", "", "198.51.100.9:4000", []string{"203.0.113.7"}, "198.51.100.9"},
+		{"信頼しない接続元の X-Forwarded-For は無視すべき", "10.0.0.1", "198.51.100.9:4000", []string{"203.0.113.7"}, "198.51.100.9"},
+		{"プロキシが追加したアドレスを使うべき", "10.0.0.1", "10.0.0.1:4000", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"クライアントが偽装した先頭のアドレスは使うべきではない", "10.0.0.1", "10.0.0.1:4000", []string{"1.2.3.4, 203.0.113.7"}, "203.0.113.7"},
+		{"信頼するプロキシの連なりを遡るべき", "10.0.0.0/8", "10.0.0.1:4000", []string{"1.2.3.4, 203.0.113.7", "10.0.0.2"}, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.trusted)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			for _, f := range tt.forwards {
+				req.Header.Add("X-Forwarded-For", f)
+			}
+			if got := clientIP(req); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,465 @@
+package srv
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Team members
+//
+// A team's own destinations (its email, LINE account, webhooks and devices)
+// reach whoever manages the team. Members let everyone else get alerts
+// directly: each member has their own email address, phone number and channels
+// (MemberChannels) and opts in to the watch conditions they care about
+// (watch_condition_members). The worker sends each opted-in member one
+// grouped message per channel and records its delivery on the member's
+// notifications (see worker/notifier/sender.go); the member list shows the
+// latest outcome per channel.
+
+// TeamMember is a member of a team as shown in the dashboard.
+type TeamMember struct {
+	ID           string           `json:"id"`
+	Name         string           `json:"name"`
+	Email        string           `json:"email"`
+	Phone        string           `json:"phone"` // masked verified number, empty if none
+	Channels     []string         `json:"channels"`
+	ConditionIDs []string         `json:"condition_ids"`
+	Devices      int              `json:"devices"` // subscribed push devices
+	Deliveries   []MemberDelivery `json:"deliveries"`
+	CreatedAt    string           `json:"created_at"`
+}
+
+// MemberDelivery is the latest message to a member on one channel.
+type MemberDelivery struct {
+	Channel   string  `json:"channel"`
+	Status    string  `json:"status"` // sending, sent, failed, unknown
+	LastError string  `json:"last_error"`
+	CreatedAt string  `json:"created_at"`
+	SentAt    *string `json:"sent_at"`
+}
+
+// memberRequest is the body of member create and update requests.
+type memberRequest struct {
+	Name     string   `json:"name"`
+	Email    string   `json:"email"`
+	Channels []string `json:"channels"`
+}
+
+// validate trims and checks the request, normalizing the email address and
+// the order of the channels.
+func (m *memberRequest) validate() error {
+	m.Name = strings.TrimSpace(m.Name)
+	if m.Name == "" {
+		return fmt.Errorf("name required")
+	}
+	if utf8.RuneCountInString(m.Name) > MaxMemberNameSize {
+		return fmt.Errorf("name must be at most %d characters", MaxMemberNameSize)
+	}
+	if m.Email = strings.TrimSpace(m.Email); m.Email != "" {
+		addr, err := mail.ParseAddress(m.Email)
+		if err != nil {
+			return fmt.Errorf("invalid email address")
+		}
+		m.Email = addr.Address
+	}
+	if len(m.Channels) == 0 {
+		return fmt.Errorf("at least one channel is required")
+	}
+	var channels []string
+	for _, ch := range m.Channels {
+		if !slices.Contains(MemberChannels, ch) {
+			return fmt.Errorf("unsupported member channel: %s", ch)
+		}
+	}
+	for _, ch := range MemberChannels {
+		if slices.Contains(m.Channels, ch) {
+			channels = append(channels, ch)
+		}
+	}
+	m.Channels = channels
+	if slices.Contains(m.Channels, ChannelEmail) && m.Email == "" {
+		return fmt.Errorf("email required for email notifications")
+	}
+	return nil
+}
+
+// memberDevices returns the number of push devices the member subscribed.
+func memberDevices(ctx context.Context, tx *sql.Tx, memberID string) (int, error) {
+	var n int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM push_subscriptions WHERE member_id = ?`, memberID).Scan(&n)
+	return n, err
+}
+
+// setMemberChannelTx turns one of a member's channels on or off, e.g. push
+// when the member's first device subscribes or the last one is removed.
+// Pending notifications on a channel turned off are failed with reason.
+func setMemberChannelTx(ctx context.Context, tx *sql.Tx, memberID, channel string, on bool, reason string) error {
+	var channelsJSON string
+	if err := tx.QueryRowContext(ctx, `SELECT channels FROM team_members WHERE id = ?`, memberID).Scan(&channelsJSON); err != nil {
+		return err
+	}
+	var channels []string
+	_ = json.Unmarshal([]byte(channelsJSON), &channels)
+	channels = slices.DeleteFunc(channels, func(c string) bool { return c == channel })
+	if on {
+		channels = append(channels, channel)
+	}
+	var normalized []string
+	for _, ch := range MemberChannels {
+		if slices.Contains(channels, ch) {
+			normalized = append(normalized, ch)
+		}
+	}
+	b, err := json.Marshal(normalized)
+	if err != nil {
+		return err
+	}
+	if normalized == nil {
+		b = []byte("[]")
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE team_members SET channels = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, string(b), memberID); err != nil {
+		return err
+	}
+	if on {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE notifications SET status = 'failed', last_error = ?
+		WHERE member_id = ? AND channel = ? AND status = 'pending'
+	`, reason, memberID, channel)
+	return err
+}
+
+// HandleListMembers lists the team's members with their opted-in conditions
+// and the latest delivery on each of their channels.
+func (s *Server) HandleListMembers(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT m.id, m.name, m.email, m.phone, m.channels, m.created_at,
+		       (SELECT COUNT(*) FROM push_subscriptions p WHERE p.member_id = m.id)
+		FROM team_members m
+		WHERE m.team_id = ?
+		ORDER BY m.created_at, m.id
+	`, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	members := []TeamMember{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var m TeamMember
+		var channelsJSON string
+		if err := rows.Scan(&m.ID, &m.Name, &m.Email, &m.Phone, &channelsJSON, &m.CreatedAt, &m.Devices); err != nil {
+			continue
+		}
+		if m.Phone != "" {
+			m.Phone = maskPhone(m.Phone)
+		}
+		_ = json.Unmarshal([]byte(channelsJSON), &m.Channels)
+		m.ConditionIDs = []string{}
+		m.Deliveries = []MemberDelivery{}
+		index[m.ID] = len(members)
+		members = append(members, m)
+	}
+	rows.Close()
+
+	rows, err = s.DB.QueryContext(ctx, `
+		SELECT wcm.member_id, wcm.watch_condition_id
+		FROM watch_condition_members wcm
+		JOIN team_members m ON m.id = wcm.member_id
+		WHERE m.team_id = ?
+		ORDER BY wcm.created_at
+	`, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var memberID, conditionID string
+		if err := rows.Scan(&memberID, &conditionID); err != nil {
+			continue
+		}
+		if i, ok := index[memberID]; ok {
+			members[i].ConditionIDs = append(members[i].ConditionIDs, conditionID)
+		}
+	}
+	rows.Close()
+
+	rows, err = s.DB.QueryContext(ctx, `
+		SELECT member_id, channel, status, COALESCE(last_error, ''), created_at, sent_at FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY member_id, channel ORDER BY created_at DESC, rowid DESC) AS rn
+			FROM notification_messages
+			WHERE team_id = ? AND member_id IS NOT NULL
+		) WHERE rn = 1
+		ORDER BY channel
+	`, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var memberID string
+		var d MemberDelivery
+		if err := rows.Scan(&memberID, &d.Channel, &d.Status, &d.LastError, &d.CreatedAt, &d.SentAt); err != nil {
+			continue
+		}
+		if i, ok := index[memberID]; ok {
+			members[i].Deliveries = append(members[i].Deliveries, d)
+		}
+	}
+	s.jsonResponse(w, members)
+}
+
+// HandleCreateMember adds a member to the team. Push is enabled later, by
+// subscribing one of the member's devices, and SMS by verifying their phone
+// number.
+func (s *Server) HandleCreateMember(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if slices.Contains(req.Channels, ChannelPush) {
+		s.jsonError(w, "subscribe a device to enable push", http.StatusBadRequest)
+		return
+	}
+	if slices.Contains(req.Channels, ChannelSMS) {
+		s.jsonError(w, "verify a phone number to enable sms", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM team_members WHERE team_id = t.id) FROM teams t WHERE t.id = ?
+	`, id).Scan(&count)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count >= MaxTeamMembers {
+		s.jsonError(w, fmt.Sprintf("a team can have at most %d members", MaxTeamMembers), http.StatusBadRequest)
+		return
+	}
+
+	channelsJSON, _ := json.Marshal(req.Channels)
+	memberID := uuid.New().String()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO team_members (id, team_id, name, email, channels, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, memberID, id, req.Name, req.Email, string(channelsJSON)); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("team member added", "team_id", id, "member", memberID)
+	s.jsonResponse(w, map[string]any{"success": true, "id": memberID})
+}
+
+// HandleUpdateMember changes a member's name, email address and channels.
+// Pending notifications on channels the member no longer uses are failed.
+func (s *Server) HandleUpdateMember(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	memberID := r.PathValue("member")
+	if id == "" || memberID == "" {
+		s.jsonError(w, "team id and member required", http.StatusBadRequest)
+		return
+	}
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var phone string
+	err = tx.QueryRowContext(ctx, `SELECT phone FROM team_members WHERE id = ? AND team_id = ?`, memberID, id).Scan(&phone)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "member not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if slices.Contains(req.Channels, ChannelSMS) && phone == "" {
+		s.jsonError(w, "verify a phone number to enable sms", http.StatusBadRequest)
+		return
+	}
+	if slices.Contains(req.Channels, ChannelPush) {
+		devices, err := memberDevices(ctx, tx, memberID)
+		if err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if devices == 0 {
+			s.jsonError(w, "subscribe a device to enable push", http.StatusBadRequest)
+			return
+		}
+	}
+
+	channelsJSON, _ := json.Marshal(req.Channels)
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE team_members SET name = ?, email = ?, channels = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, req.Name, req.Email, string(channelsJSON), memberID); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	args := []any{memberID}
+	for _, ch := range req.Channels {
+		args = append(args, ch)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE notifications SET status = 'failed', last_error = 'member channel disabled'
+		WHERE member_id = ? AND status = 'pending' AND channel NOT IN (?`+strings.Repeat(", ?", len(req.Channels)-1)+`)
+	`, args...); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// HandleDeleteMember removes a member with their opt-ins, devices and
+// notifications.
+func (s *Server) HandleDeleteMember(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	memberID := r.PathValue("member")
+	if id == "" || memberID == "" {
+		s.jsonError(w, "team id and member required", http.StatusBadRequest)
+		return
+	}
+	res, err := s.DB.ExecContext(r.Context(), `DELETE FROM team_members WHERE id = ? AND team_id = ?`, memberID, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "member not found", http.StatusNotFound)
+		return
+	}
+	slog.Info("team member removed", "team_id", id, "member", memberID)
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// HandleUpdateMemberConditions sets the watch conditions a member is opted
+// in to. An empty list opts the member out of everything.
+func (s *Server) HandleUpdateMemberConditions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	memberID := r.PathValue("member")
+	if id == "" || memberID == "" {
+		s.jsonError(w, "team id and member required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		ConditionIDs []string `json:"condition_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	slices.Sort(req.ConditionIDs)
+	req.ConditionIDs = slices.Compact(req.ConditionIDs)
+	if req.ConditionIDs == nil {
+		req.ConditionIDs = []string{}
+	}
+
+	ctx := r.Context()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM team_members WHERE id = ? AND team_id = ?`, memberID, id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "member not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM watch_condition_members WHERE member_id = ?`, memberID); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, conditionID := range req.ConditionIDs {
+		// Only the team's own conditions can be opted in to
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO watch_condition_members (watch_condition_id, member_id, created_at)
+			SELECT id, ?, CURRENT_TIMESTAMP FROM watch_conditions WHERE id = ? AND team_id = ?
+		`, memberID, conditionID, id)
+		if err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			s.jsonError(w, "condition not found: "+conditionID, http.StatusBadRequest)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, map[string]any{"success": true, "condition_ids": req.ConditionIDs})
+}
@@ -0,0 +1,140 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMemberHandlers(t *testing.T) {
+	server := newTestServer(t)
+	for _, team := range []string{"team-1", "team-2"} {
+		mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES (?, ?, ?, 'pro')`, team, team, team+"@example.com")
+		mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+			VALUES (?, ?, 'ground-1', '[]', '09:00', '12:00')`, "wc-"+team, team)
+	}
+	call := func(handler http.HandlerFunc, method, team, member, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.SetPathValue("id", team)
+		req.SetPathValue("member", member)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	list := func(team string) []TeamMember {
+		var members []TeamMember
+		json.NewDecoder(call(server.HandleListMembers, http.MethodGet, team, "", "").Body).Decode(&members)
+		return members
+	}
+
+	t.Run("不正なメンバーは追加できないべき", func(t *testing.T) {
+		for _, body := range []string{
+			`{"name":"","email":"a@example.com","channels":["email"]}`,
+			`{"name":"佐藤","email":"not-an-address","channels":["email"]}`,
+			`{"name":"佐藤","email":"","channels":["email"]}`,
+			`{"name":"佐藤","email":"a@example.com","channels":["slack"]}`,
+			`{"name":"佐藤","email":"a@example.com","channels":["push"]}`,
+		} {
+			if w := call(server.HandleCreateMember, http.MethodPost, "team-1", "", body); w.Code != http.StatusBadRequest {
+				t.Errorf("400を返すべき: %s -> %d", body, w.Code)
+			}
+		}
+	})
+
+	var memberID string
+	t.Run("メンバーを追加し条件にオプトインできるべき", func(t *testing.T) {
+		w := call(server.HandleCreateMember, http.MethodPost, "team-1", "", `{"name":" 佐藤 ","email":"Sato <sato@example.com>","channels":["email"]}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("追加は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		memberID, _ = resp["id"].(string)
+
+		if w := call(server.HandleUpdateMemberConditions, http.MethodPut, "team-1", memberID, `{"condition_ids":["wc-team-2"]}`); w.Code != http.StatusBadRequest {
+			t.Errorf("他チームの条件にはオプトインできないべき: %d", w.Code)
+		}
+		if w := call(server.HandleUpdateMemberConditions, http.MethodPut, "team-1", memberID, `{"condition_ids":["wc-team-1"]}`); w.Code != http.StatusOK {
+			t.Fatalf("オプトインは成功すべき: %d %s", w.Code, w.Body.String())
+		}
+
+		members := list("team-1")
+		if len(members) != 1 {
+			t.Fatalf("1人のメンバーを返すべき: %+v", members)
+		}
+		m := members[0]
+		if m.Name != "佐藤" || m.Email != "sato@example.com" || len(m.Channels) != 1 || len(m.ConditionIDs) != 1 || m.ConditionIDs[0] != "wc-team-1" {
+			t.Errorf("正規化した連絡先とオプトインを返すべき: %+v", m)
+		}
+		if len(list("team-2")) != 0 {
+			t.Error("他チームのメンバーは返すべきではない")
+		}
+	})
+
+	t.Run("他チームのメンバーは変更できないべき", func(t *testing.T) {
+		body := `{"name":"x","email":"x@example.com","channels":["email"]}`
+		if w := call(server.HandleUpdateMember, http.MethodPut, "team-2", memberID, body); w.Code != http.StatusNotFound {
+			t.Errorf("404を返すべき: %d", w.Code)
+		}
+		if w := call(server.HandleDeleteMember, http.MethodDelete, "team-2", memberID, ""); w.Code != http.StatusNotFound {
+			t.Errorf("404を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("メンバーの端末を登録するとメンバーのプッシュ通知が有効になるべき", func(t *testing.T) {
+		body := strings.Replace(newPushSubscriptionJSON(t, "https://fcm.googleapis.com/fcm/send/member"), "{", `{"member_id":"`+memberID+`",`, 1)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.SetPathValue("id", "team-1")
+		w := httptest.NewRecorder()
+		server.HandleSavePushSubscription(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("登録は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		subID, _ := resp["id"].(string)
+
+		m := list("team-1")[0]
+		if m.Devices != 1 || strings.Join(m.Channels, ",") != "email,push" {
+			t.Errorf("メンバーのプッシュ通知が有効になるべき: %+v", m)
+		}
+		var teamChannels string
+		server.DB.QueryRow(`SELECT notification_channels FROM teams WHERE id = 'team-1'`).Scan(&teamChannels)
+		if strings.Contains(teamChannels, "push") {
+			t.Errorf("チームのプッシュ通知は有効にしないべき: %s", teamChannels)
+		}
+
+		req = httptest.NewRequest(http.MethodDelete, "/", nil)
+		req.SetPathValue("id", "team-1")
+		req.SetPathValue("subscription", subID)
+		w = httptest.NewRecorder()
+		server.HandleDeletePushSubscription(w, req)
+		if m := list("team-1")[0]; w.Code != http.StatusOK || m.Devices != 0 || strings.Join(m.Channels, ",") != "email" {
+			t.Errorf("最後の端末を削除するとメンバーのプッシュ通知は無効になるべき: %d %+v", w.Code, m)
+		}
+	})
+
+	t.Run("メンバーごとの最新の配信状況を返すべき", func(t *testing.T) {
+		mustExec(t, server.DB, `INSERT INTO notification_messages (id, team_id, member_id, channel, status, created_at) VALUES
+			('msg-1', 'team-1', ?, 'email', 'sent', datetime('now', '-1 hour')),
+			('msg-2', 'team-1', ?, 'email', 'failed', datetime('now'))`, memberID, memberID)
+		mustExec(t, server.DB, `UPDATE notification_messages SET last_error = 'smtp: 550 mailbox unavailable' WHERE id = 'msg-2'`)
+		m := list("team-1")[0]
+		if len(m.Deliveries) != 1 || m.Deliveries[0].Status != "failed" || m.Deliveries[0].LastError != "smtp: 550 mailbox unavailable" {
+			t.Errorf("最新の配信結果を返すべき: %+v", m.Deliveries)
+		}
+	})
+
+	t.Run("メンバーを削除するとオプトインも削除されるべき", func(t *testing.T) {
+		if w := call(server.HandleDeleteMember, http.MethodDelete, "team-1", memberID, ""); w.Code != http.StatusOK {
+			t.Fatalf("削除は成功すべき: %d", w.Code)
+		}
+		var n int
+		server.DB.QueryRow(`SELECT COUNT(*) FROM watch_condition_members WHERE member_id = ?`, memberID).Scan(&n)
+		if n != 0 || len(list("team-1")) != 0 {
+			t.Errorf("メンバーとオプトインは削除されるべき: %d", n)
+		}
+	})
+}
@@ -22,6 +22,8 @@ import (
 type OAuthConfig struct {
 	GoogleClientID     string
 	GoogleClientSecret string
+	LINEChannelID      string // LINE Login channel
+	LINEChannelSecret  string
 	BaseURL            string
 }
 
@@ -29,6 +31,8 @@ func getOAuthConfig() OAuthConfig {
 	return OAuthConfig{
 		GoogleClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
 		GoogleClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		LINEChannelID:      os.Getenv("LINE_LOGIN_CHANNEL_ID"),
+		LINEChannelSecret:  os.Getenv("LINE_LOGIN_CHANNEL_SECRET"),
 		BaseURL:            getEnvOrDefault("BASE_URL", "http://localhost:8000"),
 	}
 }
@@ -173,7 +177,7 @@ func (s *Server) HandleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("google oauth login", "email", userInfo.Email, "team_id", team.ID)
 
-	writeAuthRedirectHTML(w, team, "ログイン完了")
+	s.finishLogin(w, r, team.ID, loginGoogle)
 }
 
 // GoogleTokenResponse represents the token response from Google
@@ -261,5 +265,6 @@ func (s *Server) HandleOAuthConfig(w http.ResponseWriter, r *http.Request) {
 	config := getOAuthConfig()
 	s.jsonResponse(w, map[string]interface{}{
 		"google_enabled": config.GoogleClientID != "",
+		"line_enabled":   config.LINEChannelID != "",
 	})
 }
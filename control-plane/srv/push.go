@@ -0,0 +1,298 @@
+package srv
+
+import (
+	"crypto/ecdh"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Web Push subscriptions
+//
+// The /user dashboard registers a service worker (/push-sw.js) and subscribes
+// the browser with the VAPID public key from /api/push/config. Each device's
+// subscription is stored in push_subscriptions; the worker encrypts and sends
+// notifications to all of them (see worker/notifier/webpush.go) and deletes
+// the ones the push service reports as gone.
+//
+// A device can also be subscribed for one of the team's members (member_id),
+// in which case it only receives that member's notifications and turns on the
+// member's push channel rather than the team's.
+
+// pushSubscriptionRequest is the browser's PushSubscription.toJSON(), with
+// the member the device belongs to, if any.
+type pushSubscriptionRequest struct {
+	MemberID string `json:"member_id"`
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// PushSubscription is a subscribed device as shown in the dashboard.
+type PushSubscription struct {
+	ID            string  `json:"id"`
+	Service       string  `json:"service"` // push service host, e.g. fcm.googleapis.com
+	UserAgent     string  `json:"user_agent"`
+	MemberID      *string `json:"member_id"`
+	MemberName    string  `json:"member_name"`
+	CreatedAt     string  `json:"created_at"`
+	LastSuccessAt *string `json:"last_success_at"`
+}
+
+// pushServiceHosts are the push services browsers subscribe with. The worker
+// POSTs to subscription endpoints, so other hosts are refused; a leading dot
+// matches any subdomain.
+var pushServiceHosts = []string{
+	"fcm.googleapis.com",                // Chrome, Edge on Android
+	"updates.push.services.mozilla.com", // Firefox
+	"web.push.apple.com",                // Safari
+	".notify.windows.com",               // Edge on Windows
+}
+
+// isPushServiceHost reports whether host is a known push service.
+func isPushServiceHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range pushServiceHosts {
+		if host == h || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h)) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBase64URL accepts base64url with or without padding, as browsers differ.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// validate checks the endpoint and that the keys can be used for encryption.
+func (p *pushSubscriptionRequest) validate() error {
+	u, err := url.Parse(p.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return fmt.Errorf("endpoint must be an https URL")
+	}
+	if u.Port() != "" || !isPushServiceHost(u.Hostname()) {
+		return fmt.Errorf("endpoint must be a known push service")
+	}
+	key, err := decodeBase64URL(p.Keys.P256dh)
+	if err != nil {
+		return fmt.Errorf("invalid p256dh key")
+	}
+	if _, err := ecdh.P256().NewPublicKey(key); err != nil {
+		return fmt.Errorf("invalid p256dh key")
+	}
+	if auth, err := decodeBase64URL(p.Keys.Auth); err != nil || len(auth) != 16 {
+		return fmt.Errorf("invalid auth secret")
+	}
+	return nil
+}
+
+// HandlePushConfig returns the VAPID public key browsers subscribe with.
+// Web Push is disabled when no key is configured.
+func (s *Server) HandlePushConfig(w http.ResponseWriter, r *http.Request) {
+	key := os.Getenv("VAPID_PUBLIC_KEY")
+	s.jsonResponse(w, map[string]any{
+		"enabled":    key != "",
+		"public_key": key,
+	})
+}
+
+// HandlePushServiceWorker serves the service worker that shows pushed
+// notifications. It is served from the root so that its scope covers /user.
+func (s *Server) HandlePushServiceWorker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, filepath.Join(s.StaticDir, "push-sw.js"))
+}
+
+// HandleListPushSubscriptions lists the team's subscribed devices, including
+// its members'.
+func (s *Server) HandleListPushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	rows, err := s.DB.QueryContext(r.Context(), `
+		SELECT p.id, p.endpoint, p.user_agent, p.member_id, COALESCE(m.name, ''), p.created_at, p.last_success_at
+		FROM push_subscriptions p
+		LEFT JOIN team_members m ON m.id = p.member_id
+		WHERE p.team_id = ?
+		ORDER BY p.created_at
+	`, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	subs := []PushSubscription{}
+	for rows.Next() {
+		var p PushSubscription
+		var endpoint string
+		if err := rows.Scan(&p.ID, &endpoint, &p.UserAgent, &p.MemberID, &p.MemberName, &p.CreatedAt, &p.LastSuccessAt); err != nil {
+			continue
+		}
+		// The endpoint is a capability URL; only its host is shown
+		if u, err := url.Parse(endpoint); err == nil {
+			p.Service = u.Hostname()
+		}
+		subs = append(subs, p)
+	}
+	s.jsonResponse(w, subs)
+}
+
+// HandleSavePushSubscription stores a browser's push subscription for the
+// team, or one of its members, and enables the push channel. Subscribing the
+// same browser again updates its keys and owner.
+func (s *Server) HandleSavePushSubscription(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	var req pushSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM teams WHERE id = ?`, id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var memberID *string
+	if req.MemberID != "" {
+		err := tx.QueryRowContext(ctx, `SELECT 1 FROM team_members WHERE id = ? AND team_id = ?`, req.MemberID, id).Scan(&exists)
+		if err == sql.ErrNoRows {
+			s.jsonError(w, "member not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		memberID = &req.MemberID
+	}
+
+	// Re-subscribing a device updates its keys and member. A device
+	// subscribed by another team stays theirs until they remove it.
+	userAgent := requestUserAgent(r)
+	var subID string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO push_subscriptions (id, team_id, member_id, endpoint, p256dh, auth, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (endpoint) DO UPDATE SET
+			member_id = excluded.member_id,
+			p256dh = excluded.p256dh, auth = excluded.auth, user_agent = excluded.user_agent
+		WHERE push_subscriptions.team_id = excluded.team_id
+		RETURNING id
+	`, uuid.New().String(), id, memberID, req.Endpoint, req.Keys.P256dh, req.Keys.Auth, userAgent).Scan(&subID)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "device is subscribed by another team", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if memberID != nil {
+		err = setMemberChannelTx(ctx, tx, *memberID, ChannelPush, true, "")
+	} else {
+		err = enableChannelTx(ctx, tx, id, ChannelPush)
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("push subscription saved", "team_id", id, "member_id", req.MemberID, "subscription", subID)
+	s.jsonResponse(w, map[string]any{"success": true, "id": subID})
+}
+
+// HandleDeletePushSubscription removes one of the team's devices. The push
+// channel of the team, or of the member owning the device, is disabled when
+// none of their devices remain.
+func (s *Server) HandleDeletePushSubscription(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	subID := r.PathValue("subscription")
+	if id == "" || subID == "" {
+		s.jsonError(w, "team id and subscription required", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var memberID string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM push_subscriptions WHERE id = ? AND team_id = ? RETURNING COALESCE(member_id, '')
+	`, subID, id).Scan(&memberID)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "subscription not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var remaining int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM push_subscriptions WHERE team_id = ? AND COALESCE(member_id, '') = ?
+	`, id, memberID).Scan(&remaining); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if remaining == 0 {
+		if memberID != "" {
+			err = setMemberChannelTx(ctx, tx, memberID, ChannelPush, false, "push subscriptions removed")
+		} else {
+			err = disableChannelTx(ctx, tx, id, ChannelPush, "push subscriptions removed")
+		}
+		if err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, map[string]any{"success": true, "remaining": remaining})
+}
@@ -0,0 +1,160 @@
+package srv
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newPushSubscriptionJSON returns a browser-style subscription with fresh keys.
+func newPushSubscriptionJSON(t *testing.T, endpoint string) string {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return fmt.Sprintf(`{"endpoint":%q,"keys":{"p256dh":%q,"auth":%q}}`, endpoint,
+		base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), base64.RawURLEncoding.EncodeToString(auth))
+}
+
+func TestPushSubscriptionHandlers(t *testing.T) {
+	server := newTestServer(t)
+	for _, team := range []string{"team-1", "team-2"} {
+		mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES (?, ?, ?, 'pro')`, team, team, team+"@example.com")
+	}
+	channels := func() string {
+		var c string
+		server.DB.QueryRow(`SELECT notification_channels FROM teams WHERE id = 'team-1'`).Scan(&c)
+		return c
+	}
+	subscribeAs := func(team, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.SetPathValue("id", team)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Linux; Android 14) Chrome/126.0")
+		w := httptest.NewRecorder()
+		server.HandleSavePushSubscription(w, req)
+		return w
+	}
+	subscribe := func(body string) *httptest.ResponseRecorder { return subscribeAs("team-1", body) }
+	list := func() []PushSubscription {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetPathValue("id", "team-1")
+		w := httptest.NewRecorder()
+		server.HandleListPushSubscriptions(w, req)
+		var subs []PushSubscription
+		json.NewDecoder(w.Body).Decode(&subs)
+		return subs
+	}
+
+	t.Run("公開鍵が設定されていればプッシュ通知を有効にすべき", func(t *testing.T) {
+		t.Setenv("VAPID_PUBLIC_KEY", "BPublicKey")
+		w := httptest.NewRecorder()
+		server.HandlePushConfig(w, httptest.NewRequest(http.MethodGet, "/api/push/config", nil))
+		var cfg map[string]any
+		json.NewDecoder(w.Body).Decode(&cfg)
+		if cfg["enabled"] != true || cfg["public_key"] != "BPublicKey" {
+			t.Errorf("unexpected config: %v", cfg)
+		}
+	})
+
+	t.Run("不正な購読は400を返すべき", func(t *testing.T) {
+		for _, body := range []string{
+			newPushSubscriptionJSON(t, "http://fcm.googleapis.com/fcm/send/1"),
+			newPushSubscriptionJSON(t, "https://push.example.com/send/1"),
+			newPushSubscriptionJSON(t, "https://internal.corp:8443/admin"),
+			newPushSubscriptionJSON(t, "https://fcm.googleapis.com.evil.example/send/1"),
+			`{"endpoint":"https://push.example.com/send/1","keys":{"p256dh":"AAAA","auth":"AAAAAAAAAAAAAAAAAAAAAA"}}`,
+			`{"endpoint":"https://push.example.com/send/1","keys":{"p256dh":"` + strings.Repeat("A", 87) + `","auth":"short"}}`,
+		} {
+			if w := subscribe(body); w.Code != http.StatusBadRequest {
+				t.Errorf("400を返すべき: %d %s", w.Code, body)
+			}
+		}
+	})
+
+	var firstID string
+	t.Run("購読を保存しプッシュ通知チャネルを有効にすべき", func(t *testing.T) {
+		w := subscribe(newPushSubscriptionJSON(t, "https://fcm.googleapis.com/fcm/send/device-1"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("保存は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		firstID, _ = resp["id"].(string)
+		if !strings.Contains(channels(), `"push"`) {
+			t.Errorf("push チャネルが有効になるべき: %s", channels())
+		}
+		subs := list()
+		if len(subs) != 1 || subs[0].Service != "fcm.googleapis.com" || !strings.Contains(subs[0].UserAgent, "Android") {
+			t.Errorf("エンドポイントはホストだけを返すべき: %+v", subs)
+		}
+	})
+
+	t.Run("同じ端末の再登録は鍵を更新すべき", func(t *testing.T) {
+		w := subscribe(newPushSubscriptionJSON(t, "https://fcm.googleapis.com/fcm/send/device-1"))
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusOK || resp["id"] != firstID || len(list()) != 1 {
+			t.Errorf("同じ購読として更新されるべき: %d %v", w.Code, resp)
+		}
+		subscribe(newPushSubscriptionJSON(t, "https://updates.push.services.mozilla.com/wpush/v2/device-2"))
+		if len(list()) != 2 {
+			t.Error("別の端末は追加されるべき")
+		}
+	})
+
+	t.Run("他チームの端末の購読は奪えないべき", func(t *testing.T) {
+		w := subscribeAs("team-2", newPushSubscriptionJSON(t, "https://fcm.googleapis.com/fcm/send/device-1"))
+		if w.Code != http.StatusConflict {
+			t.Errorf("409を返すべき: %d %s", w.Code, w.Body.String())
+		}
+		var team string
+		server.DB.QueryRow(`SELECT team_id FROM push_subscriptions WHERE id = ?`, firstID).Scan(&team)
+		if team != "team-1" {
+			t.Errorf("購読は元のチームのままであるべき: %s", team)
+		}
+	})
+
+	del := func(team, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/", nil)
+		req.SetPathValue("id", team)
+		req.SetPathValue("subscription", id)
+		w := httptest.NewRecorder()
+		server.HandleDeletePushSubscription(w, req)
+		return w
+	}
+
+	t.Run("他チームの購読は削除できないべき", func(t *testing.T) {
+		if w := del("team-2", firstID); w.Code != http.StatusNotFound {
+			t.Errorf("404を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("最後の端末を削除したらチャネルを無効にすべき", func(t *testing.T) {
+		if w := del("team-1", firstID); w.Code != http.StatusOK || !strings.Contains(channels(), `"push"`) {
+			t.Fatalf("端末が残る間はチャネルを維持すべき: %d %s", w.Code, channels())
+		}
+		if w := del("team-1", list()[0].ID); w.Code != http.StatusOK {
+			t.Fatalf("削除は成功すべき: %d", w.Code)
+		}
+		if strings.Contains(channels(), `"push"`) {
+			t.Errorf("push チャネルは無効になるべき: %s", channels())
+		}
+	})
+
+	t.Run("サービスワーカーをルートから配信すべき", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.HandlePushServiceWorker(w, httptest.NewRequest(http.MethodGet, "/push-sw.js", nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Type"), "javascript") || !strings.Contains(w.Body.String(), "showNotification") {
+			t.Errorf("サービスワーカーを返すべき: %d %s", w.Code, w.Header())
+		}
+	})
+}
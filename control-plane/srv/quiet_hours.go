@@ -0,0 +1,88 @@
+package srv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Quiet hours and throttling.
+//
+// A team can set quiet hours in JST (teams.quiet_start/quiet_end, which may
+// cross midnight) and a minimum interval between messages on each channel
+// (teams.min_interval_minutes). The worker holds instant notifications that
+// arrive during quiet hours or within the interval and sends them as one
+// message when the hold ends. With quiet_urgent_bypass, slots for the same
+// day are sent during quiet hours anyway.
+
+// validateQuietHours checks a quiet-hours window. Both ends empty disables it.
+func validateQuietHours(start, end string) error {
+	if start == "" && end == "" {
+		return nil
+	}
+	for _, v := range []string{start, end} {
+		if _, err := time.Parse("15:04", v); err != nil || len(v) != 5 {
+			return fmt.Errorf("quiet_start and quiet_end must both be HH:MM")
+		}
+	}
+	if start == end {
+		return fmt.Errorf("quiet_start and quiet_end must differ")
+	}
+	return nil
+}
+
+// HandleUpdateQuietHours sets a team's quiet hours and minimum interval.
+func (s *Server) HandleUpdateQuietHours(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		QuietStart   string `json:"quiet_start"`
+		QuietEnd     string `json:"quiet_end"`
+		UrgentBypass bool   `json:"quiet_urgent_bypass"`
+		MinInterval  int    `json:"min_interval_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := validateQuietHours(req.QuietStart, req.QuietEnd); err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.MinInterval < 0 || req.MinInterval > MaxMinIntervalMinutes {
+		s.jsonError(w, fmt.Sprintf("min_interval_minutes must be between 0 and %d", MaxMinIntervalMinutes), http.StatusBadRequest)
+		return
+	}
+
+	var start, end *string
+	if req.QuietStart != "" {
+		start, end = &req.QuietStart, &req.QuietEnd
+	}
+	bypass := 0
+	if req.UrgentBypass {
+		bypass = 1
+	}
+	res, err := s.DB.ExecContext(r.Context(), `
+		UPDATE teams SET quiet_start = ?, quiet_end = ?, quiet_urgent_bypass = ?, min_interval_minutes = ?,
+		       updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, start, end, bypass, req.MinInterval, id)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	s.jsonResponse(w, map[string]any{
+		"quiet_start":          req.QuietStart,
+		"quiet_end":            req.QuietEnd,
+		"quiet_urgent_bypass":  req.UrgentBypass,
+		"min_interval_minutes": req.MinInterval,
+	})
+}
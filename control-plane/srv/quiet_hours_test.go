@@ -0,0 +1,60 @@
+package srv
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQuietHoursHandler(t *testing.T) {
+	server := newTestServer(t)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	call := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		req.SetPathValue("id", "team-1")
+		w := httptest.NewRecorder()
+		server.HandleUpdateQuietHours(w, req)
+		return w
+	}
+	settings := func() (start, end sql.NullString, bypass, interval int) {
+		server.DB.QueryRow(`SELECT quiet_start, quiet_end, quiet_urgent_bypass, min_interval_minutes FROM teams WHERE id = 'team-1'`).
+			Scan(&start, &end, &bypass, &interval)
+		return
+	}
+
+	t.Run("日付をまたぐ静かな時間帯と最小間隔を保存すべき", func(t *testing.T) {
+		w := call(`{"quiet_start":"22:00","quiet_end":"07:00","quiet_urgent_bypass":true,"min_interval_minutes":30}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("更新は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		start, end, bypass, interval := settings()
+		if start.String != "22:00" || end.String != "07:00" || bypass != 1 || interval != 30 {
+			t.Errorf("unexpected settings: %v %v %d %d", start, end, bypass, interval)
+		}
+	})
+
+	t.Run("空の時間帯で静かな時間帯を解除すべき", func(t *testing.T) {
+		if w := call(`{"quiet_start":"","quiet_end":"","min_interval_minutes":0}`); w.Code != http.StatusOK {
+			t.Fatalf("解除は成功すべき: %d", w.Code)
+		}
+		if start, end, _, _ := settings(); start.Valid || end.Valid {
+			t.Errorf("静かな時間帯はNULLになるべき: %v %v", start, end)
+		}
+	})
+
+	t.Run("不正な設定は拒否すべき", func(t *testing.T) {
+		for _, body := range []string{
+			`{"quiet_start":"22:00"}`,
+			`{"quiet_start":"22:00","quiet_end":"22:00"}`,
+			`{"quiet_start":"24:00","quiet_end":"07:00"}`,
+			`{"min_interval_minutes":-1}`,
+			`{"min_interval_minutes":1441}`,
+		} {
+			if w := call(body); w.Code != http.StatusBadRequest {
+				t.Errorf("%s should be rejected: %d", body, w.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package srv
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Reservation links
+//
+// Scrapers store the most specific reservation page they can for each slot
+// (slots.reservation_url), e.g. a facility's time selection for the date,
+// so that a member does not have to find the slot again from the site's
+// home page. Slots without a link of their own open the municipality's site.
+
+// slotReservation returns a slot's reservation page, falling back to the
+// municipality's site. An unknown slot is sql.ErrNoRows.
+func slotReservation(ctx context.Context, db *sql.DB, slotID string) (string, error) {
+	var link string
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(NULLIF(sl.reservation_url, ''), m.url, '')
+		FROM slots sl
+		LEFT JOIN municipalities m ON m.id = sl.municipality_id
+		WHERE sl.id = ?
+	`, slotID).Scan(&link)
+	return link, err
+}
+
+// openReservation sends the browser to a reservation page, or to fallback
+// when there is none.
+func (s *Server) openReservation(w http.ResponseWriter, r *http.Request, link, fallback string) {
+	w.Header().Set("Cache-Control", "no-store")
+	// Keep tracking tokens out of the reservation site's logs
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if link == "" {
+		link = fallback
+	}
+	http.Redirect(w, r, link, http.StatusFound)
+}
+
+// HandleReserveSlot opens a slot's reservation page from the dashboard's
+// calendar. Unknown slots open the dashboard.
+func (s *Server) HandleReserveSlot(w http.ResponseWriter, r *http.Request) {
+	link, err := slotReservation(r.Context(), s.DB, r.PathValue("id"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("load slot reservation", "slot_id", r.PathValue("id"), "error", err)
+	}
+	s.openReservation(w, r, link, "/user")
+}
@@ -0,0 +1,57 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReservationLinks(t *testing.T) {
+	server := newTestServer(t)
+	t.Setenv("TRACKING_SECRET", "test-secret")
+	const municipality, ground = "d9e5f0b2-3456-5789-0bcd-ef0123456789", "d0000001-0001-4000-8000-000000000001"
+	const deepLink = "https://yoyaku.e-kanagawa.lg.jp/Kanagawa/SmartPhone/Wsp_JikanSentaku.aspx?SJCode=01&UseDate=20990103"
+	for i, s := range [][2]string{
+		{"slot-link", deepLink},
+		{"slot-none", ""},
+	} {
+		mustExec(t, server.DB, `INSERT INTO slots (id, municipality_id, ground_id, slot_date, time_from, time_to, reservation_url)
+			VALUES (?, ?, ?, '2099-01-03', ?, '11:00', ?)`, s[0], municipality, ground, []string{"06:00", "08:00"}[i], s[1])
+	}
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+		VALUES ('wc-1', 'team-1', ?, '[]', '06:00', '12:00')`, ground)
+	mustExec(t, server.DB, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, status, sent_at)
+		VALUES ('n-1', 'team-1', 'wc-1', 'slot-link', 'email', 'sent', CURRENT_TIMESTAMP)`)
+
+	reserve := func(slotID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/slots/"+slotID+"/reserve", nil)
+		req.SetPathValue("id", slotID)
+		w := httptest.NewRecorder()
+		server.HandleReserveSlot(w, req)
+		return w
+	}
+
+	t.Run("枠の予約ページへ転送すべき", func(t *testing.T) {
+		if w := reserve("slot-link"); w.Code != http.StatusFound || w.Header().Get("Location") != deepLink {
+			t.Errorf("枠の予約ページへ転送すべき: %d %s", w.Code, w.Header().Get("Location"))
+		}
+		if w := reserve("slot-none"); w.Header().Get("Location") != "https://yoyaku.e-kanagawa.lg.jp" {
+			t.Errorf("リンクのない枠は自治体の予約サイトへ転送すべき: %s", w.Header().Get("Location"))
+		}
+		if w := reserve("slot-deleted"); w.Header().Get("Location") != "/user" {
+			t.Errorf("存在しない枠はマイページへ転送すべき: %s", w.Header().Get("Location"))
+		}
+	})
+
+	t.Run("通知のリンクも枠の予約ページへ転送すべき", func(t *testing.T) {
+		token := signTracking("test-secret", trackClick, "n-1")
+		req := httptest.NewRequest(http.MethodGet, "/r/"+token, nil)
+		req.SetPathValue("token", token)
+		w := httptest.NewRecorder()
+		server.HandleTrackedRedirect(w, req)
+		if w.Code != http.StatusFound || w.Header().Get("Location") != deepLink {
+			t.Errorf("枠の予約ページへ転送すべき: %d %s", w.Code, w.Header().Get("Location"))
+		}
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"runtime"
 	"strings"
 
+	"akigura.dev/shared/sms"
 	"srv.exe.dev/db"
 	"srv.exe.dev/db/dbgen"
 	"srv.exe.dev/ent"
@@ -25,6 +26,19 @@ type Server struct {
 	Hostname     string
 	TemplatesDir string
 	StaticDir    string
+	// EmailTemplatesDir holds the worker's default notification email
+	// templates, used by the email preview. Files in EMAIL_TEMPLATE_DIR
+	// override them as they do in the worker.
+	EmailTemplatesDir string
+	// HTTPClient is used for outgoing requests such as destination test
+	// messages. It defaults to a client with DefaultRequestTimeout that only
+	// connects to public addresses (netguard.NewClient).
+	HTTPClient *http.Client
+	// SMS sends phone verification codes. It is nil when SMS is not
+	// configured.
+	SMS sms.Provider
+	// AccountEmail sends account emails such as new-device login alerts.
+	AccountEmail func(to, subject, htmlContent string) error
 }
 
 type DashboardData struct {
@@ -57,6 +71,12 @@ func New(dbPath, hostname string) (*Server, error) {
 		Hostname:     hostname,
 		TemplatesDir: filepath.Join(baseDir, "templates"),
 		StaticDir:    filepath.Join(baseDir, "static"),
+		// The worker embeds the same files
+		EmailTemplatesDir: filepath.Join(baseDir, "..", "..", "worker", "notifier", "templates"),
+		SMS:               sms.NewProviderFromEnv(),
+		AccountEmail: func(to, subject, htmlContent string) error {
+			return sendAuthEmail(getAuthConfig(), to, subject, htmlContent)
+		},
 	}
 	if err := srv.setUpDatabase(dbPath); err != nil {
 		return nil, err
@@ -147,7 +167,14 @@ func (s *Server) Serve(addr string) error {
 	adminMux.HandleFunc("GET /api/conditions", s.HandleListConditions)
 	adminMux.HandleFunc("DELETE /api/conditions/{id}", s.HandleDeleteCondition)
 	adminMux.HandleFunc("GET /api/notifications", s.HandleListNotifications)
+	adminMux.HandleFunc("GET /api/deliveries", s.HandleListDeliveries)
+	adminMux.HandleFunc("GET /api/notification-stats", s.HandleNotificationStats)
+	adminMux.HandleFunc("GET /api/notifications/{id}/attempts", s.HandleListDeliveryAttempts)
+	adminMux.HandleFunc("POST /api/notifications/{id}/resend", s.HandleResendNotification)
 	adminMux.HandleFunc("GET /api/slots", s.HandleListSlots)
+	adminMux.HandleFunc("GET /api/email-preview", s.HandleEmailPreview)
+	adminMux.HandleFunc("GET /api/explain", s.HandleAdminExplainMatch)
+	adminMux.HandleFunc("POST /api/conditions/backtest", s.HandleBacktestCondition)
 	adminMux.HandleFunc("GET /api/jobs", s.HandleListJobs)
 	adminMux.HandleFunc("GET /api/jobs/{id}", s.HandleGetJobDetail)
 	adminMux.HandleFunc("POST /api/scrape", s.HandleTriggerScrape)
@@ -163,28 +190,69 @@ func (s *Server) Serve(addr string) error {
 
 	// Public pages (no auth)
 	mux.HandleFunc("GET /user", s.HandleUserPage)
+	mux.HandleFunc("GET /push-sw.js", s.HandlePushServiceWorker)
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(s.StaticDir))))
 
-	// User API endpoints (authenticated via session/JWT, not Basic Auth)
-	mux.HandleFunc("GET /api/teams/by-email", s.HandleGetTeamByEmail)
-	mux.HandleFunc("DELETE /api/teams/{id}", s.HandleDeleteTeam)
-	mux.HandleFunc("POST /api/conditions", s.HandleCreateCondition)
-	mux.HandleFunc("DELETE /api/conditions/{id}", s.HandleDeleteCondition)
+	// User API endpoints (authenticated by the login session, see session.go)
+	mux.HandleFunc("GET /api/session", s.sessionRoute(s.HandleGetSession))
+	mux.HandleFunc("POST /api/logout", s.HandleLogout)
+	mux.HandleFunc("DELETE /api/teams/{id}", s.teamRoute(s.HandleDeleteTeam))
+	mux.HandleFunc("PUT /api/teams/{id}/channels", s.teamRoute(s.HandleUpdateTeamChannels))
+	mux.HandleFunc("PUT /api/teams/{id}/digest", s.teamRoute(s.HandleUpdateTeamDigest))
+	mux.HandleFunc("PUT /api/teams/{id}/quiet-hours", s.teamRoute(s.HandleUpdateQuietHours))
+	mux.HandleFunc("DELETE /api/teams/{id}/line", s.teamRoute(s.HandleUnlinkLINE))
+	mux.HandleFunc("GET /api/teams/{id}/destinations", s.teamRoute(s.HandleListDestinations))
+	mux.HandleFunc("PUT /api/teams/{id}/destinations/{channel}", s.teamRoute(s.HandleSaveDestination))
+	mux.HandleFunc("DELETE /api/teams/{id}/destinations/{channel}", s.teamRoute(s.HandleDeleteDestination))
+	mux.HandleFunc("POST /api/teams/{id}/destinations/webhook/rotate-secret", s.teamRoute(s.HandleRotateWebhookSecret))
+	mux.HandleFunc("GET /api/teams/{id}/webhook-deliveries", s.teamRoute(s.HandleListWebhookDeliveries))
+	mux.HandleFunc("GET /api/teams/{id}/push-subscriptions", s.teamRoute(s.HandleListPushSubscriptions))
+	mux.HandleFunc("POST /api/teams/{id}/push-subscriptions", s.teamRoute(s.HandleSavePushSubscription))
+	mux.HandleFunc("DELETE /api/teams/{id}/push-subscriptions/{subscription}", s.teamRoute(s.HandleDeletePushSubscription))
+	mux.HandleFunc("GET /api/push/config", s.HandlePushConfig)
+	mux.HandleFunc("POST /api/teams/{id}/channels/{channel}/test", s.teamRoute(s.HandleSendChannelTest))
+	mux.HandleFunc("GET /api/teams/{id}/channel-tests/{test}", s.teamRoute(s.HandleGetChannelTest))
+	mux.HandleFunc("GET /api/teams/{id}/channel-status", s.teamRoute(s.HandleListChannelStatus))
+	mux.HandleFunc("GET /api/teams/{id}/sms-usage", s.teamRoute(s.HandleGetSMSUsage))
+	mux.HandleFunc("POST /api/teams/{id}/phone-verifications", s.teamRoute(s.HandleStartPhoneVerification))
+	mux.HandleFunc("POST /api/teams/{id}/phone-verifications/{verification}/confirm", s.teamRoute(s.HandleConfirmPhoneVerification))
+	mux.HandleFunc("GET /api/teams/{id}/members", s.teamRoute(s.HandleListMembers))
+	mux.HandleFunc("POST /api/teams/{id}/members", s.teamRoute(s.HandleCreateMember))
+	mux.HandleFunc("PUT /api/teams/{id}/members/{member}", s.teamRoute(s.HandleUpdateMember))
+	mux.HandleFunc("DELETE /api/teams/{id}/members/{member}", s.teamRoute(s.HandleDeleteMember))
+	mux.HandleFunc("PUT /api/teams/{id}/members/{member}/conditions", s.teamRoute(s.HandleUpdateMemberConditions))
+	mux.HandleFunc("POST /api/teams/{id}/bookings", s.teamRoute(s.HandleCreateBooking))
+	mux.HandleFunc("DELETE /api/teams/{id}/bookings/{slot}", s.teamRoute(s.HandleDeleteBooking))
+	mux.HandleFunc("POST /api/teams/{id}/slots/{slot}/claim", s.teamRoute(s.HandleClaimSlot))
+	mux.HandleFunc("DELETE /api/teams/{id}/slots/{slot}/claim", s.teamRoute(s.HandleReleaseClaim))
+	mux.HandleFunc("PUT /api/teams/{id}/slots/{slot}/vote", s.teamRoute(s.HandleVoteSlot))
+	mux.HandleFunc("GET /api/teams/{id}/sessions", s.teamRoute(s.HandleListSessions))
+	mux.HandleFunc("DELETE /api/teams/{id}/sessions", s.teamRoute(s.HandleRevokeAllSessions))
+	mux.HandleFunc("DELETE /api/teams/{id}/sessions/{session}", s.teamRoute(s.HandleRevokeSession))
+	mux.HandleFunc("GET /api/teams/{id}/logins", s.teamRoute(s.HandleListLogins))
+	mux.HandleFunc("POST /api/teams/{id}/conditions/backtest", s.teamRoute(s.HandleBacktestCondition))
+	mux.HandleFunc("GET /api/conditions", s.sessionRoute(s.HandleListConditions))
+	mux.HandleFunc("POST /api/conditions", s.sessionRoute(s.HandleCreateCondition))
+	mux.HandleFunc("PUT /api/conditions/{id}/channels", s.conditionRoute(s.HandleUpdateConditionChannels))
+	mux.HandleFunc("PUT /api/conditions/{id}/digest", s.conditionRoute(s.HandleUpdateConditionDigest))
+	mux.HandleFunc("PUT /api/conditions/{id}/enabled", s.conditionRoute(s.HandleUpdateConditionEnabled))
+	mux.HandleFunc("DELETE /api/conditions/{id}", s.conditionRoute(s.HandleDeleteCondition))
 	mux.HandleFunc("GET /api/plan-limits", s.HandleGetPlanLimits)
+	mux.HandleFunc("GET /api/explain", s.sessionRoute(s.HandleExplainMatch))
+	mux.HandleFunc("GET /api/notifications", s.sessionRoute(s.HandleListNotifications))
 
 	// Public data endpoints (for user dashboard)
 	mux.HandleFunc("GET /api/municipalities", s.HandleListMunicipalities)
 	mux.HandleFunc("GET /api/grounds", s.HandleListGrounds)
 	mux.HandleFunc("GET /api/slots", s.HandleListSlots)
-	mux.HandleFunc("GET /api/conditions", s.HandleListConditions)
-	mux.HandleFunc("GET /api/notifications", s.HandleListNotifications)
+	mux.HandleFunc("GET /slots/{id}/reserve", s.HandleReserveSlot)
 
 	// Billing API (user-facing)
 	mux.HandleFunc("GET /api/plans", s.HandlePlans)
-	mux.HandleFunc("POST /api/billing/checkout", s.HandleCreateCheckout)
-	mux.HandleFunc("POST /api/billing/portal", s.HandleBillingPortal)
+	mux.HandleFunc("POST /api/billing/checkout", s.sessionRoute(s.HandleCreateCheckout))
+	mux.HandleFunc("POST /api/billing/portal", s.sessionRoute(s.HandleBillingPortal))
 	mux.HandleFunc("POST /api/billing/webhook", s.HandleStripeWebhook)
-	mux.HandleFunc("POST /api/billing/validate-promo", s.HandleValidatePromoCode)
+	mux.HandleFunc("POST /api/billing/validate-promo", s.sessionRoute(s.HandleValidatePromoCode))
 
 	// Auth endpoints (public)
 	mux.HandleFunc("POST /api/auth/magic-link", s.HandleRequestMagicLink)
@@ -192,6 +260,18 @@ func (s *Server) Serve(addr string) error {
 	mux.HandleFunc("GET /api/auth/config", s.HandleOAuthConfig)
 	mux.HandleFunc("GET /auth/google", s.HandleGoogleLogin)
 	mux.HandleFunc("GET /auth/google/callback", s.HandleGoogleCallback)
+	mux.HandleFunc("GET /auth/line", s.sessionPage(s.HandleLINELogin))
+	mux.HandleFunc("GET /auth/line/callback", s.HandleLINECallback)
+	mux.HandleFunc("POST /api/line/webhook", s.HandleLINEWebhook)
+
+	// Unsubscribe links in notification emails (signed, no login)
+	mux.HandleFunc("GET /unsubscribe", s.HandleUnsubscribePage)
+	mux.HandleFunc("POST /unsubscribe", s.HandleUnsubscribe)
+
+	// Tracked reservation links, slot coordination and booking records in notifications (signed, no login)
+	mux.HandleFunc("GET /r/{token}", s.HandleTrackedRedirect)
+	mux.HandleFunc("GET /booked", s.HandleBookedPage)
+	mux.HandleFunc("POST /booked", s.HandleBooked)
 
 	// Redirect root to admin for convenience
 	mux.HandleFunc("GET /{$}", s.HandleLandingPage)
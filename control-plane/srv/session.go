@@ -0,0 +1,345 @@
+package srv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Login sessions
+//
+// Magic-link and Google login start a session: a random token in an
+// HttpOnly cookie, stored server-side only as its SHA-256 hash. User routes
+// are wrapped so that the team they act on is the session's team:
+//
+//   - teamRoute: /api/teams/{id}/..., where {id} must be the session's team
+//   - conditionRoute: /api/conditions/{id}/..., where the condition must
+//     belong to it
+//   - sessionRoute: routes naming the team with team_id in the query or JSON
+//     body, or not at all
+//
+// All three bind team_id: a request naming another team is rejected with
+// 403, and one naming no team acts on the session's. The handlers are shared
+// with the admin panel, which reaches them without a session.
+//
+// sessionPage wraps pages opened by browser navigation rather than fetch,
+// such as the start of LINE linking: without a session they redirect to the
+// login page instead of answering with JSON.
+
+const (
+	sessionCookie = "akigura_session"
+	// sessionTTL is how long a session lasts without being used.
+	sessionTTL = 30 * 24 * time.Hour
+	// sessionTouchInterval limits how often a session's use is written back.
+	sessionTouchInterval = time.Hour
+	// maxBoundBody bounds the JSON bodies read to check their team_id.
+	maxBoundBody = 1 << 20
+)
+
+var errNoSession = errors.New("login required")
+
+// session is the login behind a request.
+type session struct {
+	ID     string
+	TeamID string
+}
+
+type sessionContextKey struct{}
+
+// sessionFrom returns the session of a request passed through a user route
+// wrapper.
+func sessionFrom(ctx context.Context) (session, bool) {
+	sess, ok := ctx.Value(sessionContextKey{}).(session)
+	return sess, ok
+}
+
+// hashSessionToken is the stored form of a session token.
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionCookieSecure reports whether session cookies need HTTPS, as they
+// do unless the site is served over plain HTTP in development.
+func sessionCookieSecure() bool {
+	return strings.HasPrefix(getAuthConfig().BaseURL, "https")
+}
+
+// startSession logs the team in on the browser making the request and
+// returns the session's ID. method is how the team logged in.
+func (s *Server) startSession(ctx context.Context, w http.ResponseWriter, r *http.Request, teamID, method string) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	id := uuid.New().String()
+	expires := time.Now().Add(sessionTTL).UTC()
+	if _, err := s.DB.ExecContext(ctx, `
+		INSERT INTO sessions (id, team_id, token_hash, expires_at, user_agent, ip, method) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, teamID, hashSessionToken(token), expires.Format(time.DateTime), requestUserAgent(r), clientIP(r), method); err != nil {
+		return "", err
+	}
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= datetime('now')`); err != nil {
+		slog.Warn("delete expired sessions", "error", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   sessionCookieSecure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id, nil
+}
+
+// clearSessionCookie removes the session cookie from the browser.
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   sessionCookieSecure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// currentSession loads the session of the request's cookie, extending it
+// and noting the IP it is used from when it was last extended more than
+// sessionTouchInterval ago. A missing,
+// unknown or expired session is errNoSession.
+func (s *Server) currentSession(r *http.Request) (session, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return session{}, errNoSession
+	}
+	var sess session
+	var stale bool
+	err = s.DB.QueryRowContext(r.Context(), `
+		SELECT id, team_id, last_seen_at <= ?
+		FROM sessions WHERE token_hash = ? AND expires_at > datetime('now')
+	`, time.Now().Add(-sessionTouchInterval).UTC().Format(time.DateTime), hashSessionToken(cookie.Value)).Scan(&sess.ID, &sess.TeamID, &stale)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session{}, errNoSession
+	}
+	if err != nil {
+		return session{}, err
+	}
+	if stale {
+		if _, err := s.DB.ExecContext(r.Context(), `
+			UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP, expires_at = ?, ip = ? WHERE id = ?
+		`, time.Now().Add(sessionTTL).UTC().Format(time.DateTime), clientIP(r), sess.ID); err != nil {
+			slog.Warn("touch session", "session_id", sess.ID, "error", err)
+		}
+	}
+	return sess, nil
+}
+
+// requireSession serves a user route for the request's session. owns, when
+// set, checks that the resource in the path belongs to the session's team.
+func (s *Server) requireSession(owns func(r *http.Request, teamID string) (bool, error), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess, err := s.currentSession(r)
+		if errors.Is(err, errNoSession) {
+			s.jsonError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			slog.Error("load session", "error", err)
+			s.jsonError(w, "failed to load session", http.StatusInternalServerError)
+			return
+		}
+		if owns != nil {
+			ok, err := owns(r, sess.TeamID)
+			if err != nil {
+				s.jsonError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				s.jsonError(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		r, err = bindTeam(r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, sess)), sess.TeamID)
+		if errors.Is(err, errOtherTeam) {
+			s.jsonError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			s.jsonError(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// sessionRoute serves a route that names the team, if at all, with team_id.
+func (s *Server) sessionRoute(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireSession(nil, next)
+}
+
+// sessionPage serves a page of the session's team. A request without a
+// session is redirected to the login page.
+func (s *Server) sessionPage(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess, err := s.currentSession(r)
+		if errors.Is(err, errNoSession) {
+			http.Redirect(w, r, "/user", http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			slog.Error("load session", "error", err)
+			http.Error(w, "failed to load session", http.StatusInternalServerError)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, sess)))
+	}
+}
+
+// teamRoute serves a /api/teams/{id}/... route of the session's team.
+func (s *Server) teamRoute(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireSession(func(r *http.Request, teamID string) (bool, error) {
+		return r.PathValue("id") == teamID, nil
+	}, next)
+}
+
+// conditionRoute serves a /api/conditions/{id}/... route of one of the
+// session team's watch conditions.
+func (s *Server) conditionRoute(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireSession(func(r *http.Request, teamID string) (bool, error) {
+		var owned bool
+		err := s.DB.QueryRowContext(r.Context(), `
+			SELECT EXISTS (SELECT 1 FROM watch_conditions WHERE id = ? AND team_id = ?)
+		`, r.PathValue("id"), teamID).Scan(&owned)
+		return owned, err
+	}, next)
+}
+
+var errOtherTeam = errors.New("team_id does not match the session")
+
+// bindTeam sets the team_id of the request's query and JSON object body to
+// the session's team. A request naming another team is errOtherTeam. Bodies
+// that are not JSON objects are left for the handler to reject.
+func bindTeam(r *http.Request, teamID string) (*http.Request, error) {
+	r = r.Clone(r.Context())
+
+	query := r.URL.Query()
+	if v := query.Get("team_id"); v != "" && v != teamID {
+		return nil, errOtherTeam
+	}
+	query.Set("team_id", teamID)
+	r.URL.RawQuery = query.Encode()
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBoundBody))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil || fields == nil {
+		return r, nil
+	}
+	if raw, ok := fields["team_id"]; ok {
+		var v string
+		if json.Unmarshal(raw, &v) != nil || (v != "" && v != teamID) {
+			return nil, errOtherTeam
+		}
+	}
+	fields["team_id"], _ = json.Marshal(teamID)
+	if body, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return r, nil
+}
+
+// HandleGetSession returns the logged-in team as the dashboard sees it.
+func (s *Server) HandleGetSession(w http.ResponseWriter, r *http.Request) {
+	sess, _ := sessionFrom(r.Context())
+	var team struct {
+		ID                   string `json:"id"`
+		Name                 string `json:"name"`
+		Email                string `json:"email"`
+		Plan                 string `json:"plan"`
+		Status               string `json:"status"`
+		CreatedAt            string `json:"created_at"`
+		UpdatedAt            string `json:"updated_at"`
+		NotificationChannels string `json:"notification_channels"`
+		LineLinked           bool   `json:"line_linked"`
+		LineFollowed         bool   `json:"line_followed"`
+		DigestMode           string `json:"digest_mode"`
+		DigestTime           string `json:"digest_time"`
+		DigestWeekday        int    `json:"digest_weekday"`
+		QuietStart           string `json:"quiet_start"`
+		QuietEnd             string `json:"quiet_end"`
+		QuietUrgentBypass    bool   `json:"quiet_urgent_bypass"`
+		MinIntervalMinutes   int    `json:"min_interval_minutes"`
+	}
+	err := s.DB.QueryRowContext(r.Context(), `
+		SELECT id, name, email, plan, status, created_at, updated_at,
+		       notification_channels, line_user_id IS NOT NULL, line_followed = 1,
+		       digest_mode, digest_time, digest_weekday,
+		       COALESCE(quiet_start, ''), COALESCE(quiet_end, ''), quiet_urgent_bypass = 1, min_interval_minutes
+		FROM teams WHERE id = ?
+	`, sess.TeamID).Scan(&team.ID, &team.Name, &team.Email, &team.Plan, &team.Status, &team.CreatedAt, &team.UpdatedAt,
+		&team.NotificationChannels, &team.LineLinked, &team.LineFollowed,
+		&team.DigestMode, &team.DigestTime, &team.DigestWeekday,
+		&team.QuietStart, &team.QuietEnd, &team.QuietUrgentBypass, &team.MinIntervalMinutes)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, team)
+}
+
+// HandleLogout ends the request's session.
+func (s *Server) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		if _, err := s.DB.ExecContext(r.Context(), `DELETE FROM sessions WHERE token_hash = ?`, hashSessionToken(cookie.Value)); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	clearSessionCookie(w)
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// finishLogin starts a session for a team that just logged in, records the
+// login (see logins.go) and opens the dashboard.
+func (s *Server) finishLogin(w http.ResponseWriter, r *http.Request, teamID, method string) {
+	sessionID, err := s.startSession(r.Context(), w, r, teamID, method)
+	if err != nil {
+		slog.Error("start session", "team_id", teamID, "error", err)
+		http.Error(w, "ログインに失敗しました。再度ログインしてください。", http.StatusInternalServerError)
+		return
+	}
+	if err := s.recordLogin(r.Context(), w, r, teamID, sessionID, method); err != nil {
+		// The login itself succeeded
+		slog.Error("record login", "team_id", teamID, "error", err)
+	}
+	http.Redirect(w, r, "/user", http.StatusSeeOther)
+}
@@ -0,0 +1,143 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessions(t *testing.T) {
+	server := newTestServer(t)
+	t.Setenv("BASE_URL", "https://akigura.example")
+	for _, team := range []string{"team-1", "team-2"} {
+		mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES (?, ?, ?, 'pro')`, team, team, team+"@example.com")
+		mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+			VALUES (?, ?, 'ground-1', '[]', '09:00', '12:00')`, "wc-"+team, team)
+	}
+
+	// login verifies a magic link for the team and returns the session cookie
+	login := func(t *testing.T, team string) *http.Cookie {
+		t.Helper()
+		token := "magic-" + team
+		mustExec(t, server.DB, `DELETE FROM auth_tokens WHERE token = ?`, token)
+		mustExec(t, server.DB, `INSERT INTO auth_tokens (id, team_id, token, expires_at) VALUES (?, ?, ?, datetime('now', '+15 minutes'))`, token, team, token)
+		w := httptest.NewRecorder()
+		server.HandleVerifyMagicLink(w, httptest.NewRequest(http.MethodGet, "/auth/verify?token="+token, nil))
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/user" {
+			t.Fatalf("ログイン後はマイページへ転送すべき: %d %s", w.Code, w.Header().Get("Location"))
+		}
+		for _, c := range w.Result().Cookies() {
+			if c.Name == sessionCookie {
+				return c
+			}
+		}
+		t.Fatal("セッションの Cookie を発行すべき")
+		return nil
+	}
+	call := func(handler http.HandlerFunc, method, target, team, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.SetPathValue("id", team)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	cookie := login(t, "team-1")
+
+	t.Run("ハッシュ化したトークンで安全な Cookie のセッションを発行すべき", func(t *testing.T) {
+		if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+			t.Errorf("Cookie は HttpOnly・Secure・SameSite=Lax であるべき: %+v", cookie)
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM sessions WHERE team_id = 'team-1' AND token_hash = ?`, hashSessionToken(cookie.Value)); n != 1 {
+			t.Errorf("トークンのハッシュを保存すべき: %d", n)
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM sessions WHERE token_hash = ?`, cookie.Value); n != 0 {
+			t.Error("トークンをそのまま保存すべきではない")
+		}
+
+		w := call(server.sessionRoute(server.HandleGetSession), http.MethodGet, "/api/session", "", "", cookie)
+		var team map[string]any
+		json.NewDecoder(w.Body).Decode(&team)
+		if w.Code != http.StatusOK || team["id"] != "team-1" {
+			t.Errorf("セッションのチームを返すべき: %d %v", w.Code, team)
+		}
+	})
+
+	t.Run("ログインしていなければ401を返すべき", func(t *testing.T) {
+		if w := call(server.teamRoute(server.HandleListMembers), http.MethodGet, "/", "team-1", "", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("Cookie なしは401を返すべき: %d", w.Code)
+		}
+		forged := &http.Cookie{Name: sessionCookie, Value: hashSessionToken(cookie.Value)}
+		if w := call(server.teamRoute(server.HandleListMembers), http.MethodGet, "/", "team-1", "", forged); w.Code != http.StatusUnauthorized {
+			t.Errorf("保存されたハッシュでは認証すべきではない: %d", w.Code)
+		}
+	})
+
+	t.Run("ほかのチームへの操作は403を返すべき", func(t *testing.T) {
+		if w := call(server.teamRoute(server.HandleListMembers), http.MethodGet, "/", "team-1", "", cookie); w.Code != http.StatusOK {
+			t.Errorf("自分のチームは操作できるべき: %d %s", w.Code, w.Body.String())
+		}
+		if w := call(server.teamRoute(server.HandleDeleteTeam), http.MethodDelete, "/", "team-2", "", cookie); w.Code != http.StatusForbidden {
+			t.Errorf("パスのチームが違えば403を返すべき: %d", w.Code)
+		}
+		if w := call(server.sessionRoute(server.HandleListConditions), http.MethodGet, "/api/conditions?team_id=team-2", "", "", cookie); w.Code != http.StatusForbidden {
+			t.Errorf("クエリのチームが違えば403を返すべき: %d", w.Code)
+		}
+		if w := call(server.sessionRoute(server.HandleCreateCondition), http.MethodPost, "/api/conditions", "",
+			`{"team_id":"team-2","facility_id":"ground-1","days_of_week":"[]","time_from":"09:00","time_to":"12:00"}`, cookie); w.Code != http.StatusForbidden {
+			t.Errorf("本文のチームが違えば403を返すべき: %d", w.Code)
+		}
+		if w := call(server.conditionRoute(server.HandleDeleteCondition), http.MethodDelete, "/", "wc-team-2", "", cookie); w.Code != http.StatusForbidden {
+			t.Errorf("ほかのチームの監視条件は403を返すべき: %d", w.Code)
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM teams WHERE id = 'team-2'`) + queryInt(t, server.DB, `SELECT COUNT(*) FROM watch_conditions WHERE id = 'wc-team-2'`); n != 2 {
+			t.Error("ほかのチームのデータは変更すべきではない")
+		}
+	})
+
+	t.Run("チームを指定しない操作はセッションのチームを対象にすべき", func(t *testing.T) {
+		w := call(server.sessionRoute(server.HandleListConditions), http.MethodGet, "/api/conditions", "", "", cookie)
+		var conditions []struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&conditions)
+		if w.Code != http.StatusOK || len(conditions) != 1 || conditions[0].ID != "wc-team-1" {
+			t.Errorf("セッションのチームの監視条件を返すべき: %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("ブラウザで開くページはログインしていなければログイン画面へ転送すべき", func(t *testing.T) {
+		opened := false
+		page := server.sessionPage(func(w http.ResponseWriter, r *http.Request) { opened = true })
+		w := call(page, http.MethodGet, "/auth/line", "", "", nil)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/user" || opened {
+			t.Errorf("ログイン画面へ転送すべき: %d %s", w.Code, w.Header().Get("Location"))
+		}
+		if w := call(page, http.MethodGet, "/auth/line", "", "", cookie); w.Code != http.StatusOK || !opened {
+			t.Errorf("ログイン中はページを開くべき: %d", w.Code)
+		}
+	})
+
+	t.Run("ログアウトでセッションを削除すべき", func(t *testing.T) {
+		w := call(server.HandleLogout, http.MethodPost, "/api/logout", "", "", cookie)
+		if w.Code != http.StatusOK || queryInt(t, server.DB, `SELECT COUNT(*) FROM sessions WHERE token_hash = ?`, hashSessionToken(cookie.Value)) != 0 {
+			t.Fatalf("セッションを削除すべき: %d", w.Code)
+		}
+		if w := call(server.sessionRoute(server.HandleGetSession), http.MethodGet, "/api/session", "", "", cookie); w.Code != http.StatusUnauthorized {
+			t.Errorf("ログアウト後は401を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("期限切れのセッションは使えないべき", func(t *testing.T) {
+		expired := login(t, "team-1")
+		mustExec(t, server.DB, `UPDATE sessions SET expires_at = datetime('now', '-1 minutes') WHERE token_hash = ?`, hashSessionToken(expired.Value))
+		if w := call(server.sessionRoute(server.HandleGetSession), http.MethodGet, "/api/session", "", "", expired); w.Code != http.StatusUnauthorized {
+			t.Errorf("期限切れは401を返すべき: %d", w.Code)
+		}
+	})
+}
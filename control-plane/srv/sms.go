@@ -0,0 +1,364 @@
+package srv
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SMS and phone verification
+//
+// SMS notifications go to a phone number verified with a one-time code: the
+// team's number is stored as its sms destination (notification_destinations),
+// a member's on the member (team_members.phone). The control plane sends the
+// code itself through the same sms.Provider as the worker (see
+// worker/notifier/sms.go), which sends the notifications.
+//
+// SMS costs money, so every message, codes included, is recorded in sms_usage
+// and counted against the plan's monthly quota (plan_limits.monthly_sms_quota).
+// Plans without a quota cannot use SMS.
+
+// Phone verification limits
+const (
+	phoneCodeExpiry         = 10 * time.Minute
+	maxPhoneCodeAttempts    = 5
+	maxPhoneCodesPerDay     = 5 // per team
+	phoneCodeResendCooldown = "-60 seconds"
+)
+
+// normalizePhone converts a phone number to E.164. Japanese numbers may be
+// written domestically ("090-1234-5678") and must be mobile numbers
+// (070/080/090), the only ones that receive SMS.
+func normalizePhone(raw string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return r
+		case r >= '０' && r <= '９':
+			return '0' + (r - '０')
+		case r == '+' || r == '＋':
+			return '+'
+		case r == '-' || r == '‐' || r == '－' || r == ' ' || r == '　' || r == '(' || r == ')':
+			return -1
+		}
+		return 'x'
+	}, strings.TrimSpace(raw))
+
+	var e164 string
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		e164 = digits
+	case strings.HasPrefix(digits, "0") && len(digits) == 11:
+		e164 = "+81" + digits[1:]
+	default:
+		return "", fmt.Errorf("invalid phone number")
+	}
+	if len(e164) < 9 || len(e164) > 16 || strings.ContainsAny(e164[1:], "+x") {
+		return "", fmt.Errorf("invalid phone number")
+	}
+	if strings.HasPrefix(e164, "+81") {
+		if len(e164) != 13 || !strings.ContainsAny(e164[3:4], "789") || e164[4] != '0' {
+			return "", fmt.Errorf("phone number must be a mobile number")
+		}
+	}
+	return e164, nil
+}
+
+// maskPhone hides the middle of a phone number for display, e.g.
+// "090-****-5678".
+func maskPhone(e164 string) string {
+	if strings.HasPrefix(e164, "+81") && len(e164) == 13 {
+		return "0" + e164[3:5] + "-****-" + e164[9:]
+	}
+	if len(e164) <= 4 {
+		return e164
+	}
+	return strings.Repeat("*", len(e164)-4) + e164[len(e164)-4:]
+}
+
+// hashPhoneCode returns the stored form of a verification code.
+func hashPhoneCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newPhoneCode returns a random 6-digit code.
+func newPhoneCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// smsQuota returns the team's monthly SMS quota and how many it has used
+// this JST month.
+func smsQuota(ctx context.Context, db *sql.DB, teamID string) (quota, used int, err error) {
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(pl.monthly_sms_quota, 0),
+		       (SELECT COUNT(*) FROM sms_usage u
+		        WHERE u.team_id = t.id AND strftime('%Y-%m', u.created_at, '+9 hours') = strftime('%Y-%m', 'now', '+9 hours'))
+		FROM teams t
+		LEFT JOIN plan_limits pl ON pl.plan = t.plan
+		WHERE t.id = ?
+	`, teamID).Scan(&quota, &used)
+	return quota, used, err
+}
+
+// HandleGetSMSUsage returns the team's SMS quota and usage this month.
+func (s *Server) HandleGetSMSUsage(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	quota, used, err := smsQuota(ctx, s.DB, id)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var segments int
+	if err := s.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(segments), 0) FROM sms_usage
+		WHERE team_id = ? AND strftime('%Y-%m', created_at, '+9 hours') = strftime('%Y-%m', 'now', '+9 hours')
+	`, id).Scan(&segments); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, map[string]any{
+		"available": s.SMS != nil && quota > 0,
+		"quota":     quota,
+		"used":      used,
+		"segments":  segments,
+	})
+}
+
+// HandleStartPhoneVerification sends a verification code to a phone number
+// for the team, or for one of its members when member_id is given. The
+// response carries the verification ID to confirm the code with.
+func (s *Server) HandleStartPhoneVerification(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Phone    string `json:"phone"`
+		MemberID string `json:"member_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	phone, err := normalizePhone(req.Phone)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.SMS == nil {
+		s.jsonError(w, "sms is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := r.Context()
+
+	quota, used, err := smsQuota(ctx, s.DB, id)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if quota == 0 {
+		s.jsonError(w, "sms is not available on your plan", http.StatusForbidden)
+		return
+	}
+	if used >= quota {
+		s.jsonError(w, "monthly sms quota reached", http.StatusTooManyRequests)
+		return
+	}
+	var memberID *string
+	if req.MemberID != "" {
+		var exists bool
+		err := s.DB.QueryRowContext(ctx, `SELECT 1 FROM team_members WHERE id = ? AND team_id = ?`, req.MemberID, id).Scan(&exists)
+		if err == sql.ErrNoRows {
+			s.jsonError(w, "member not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		memberID = &req.MemberID
+	}
+
+	var today, recent int
+	if err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(created_at > datetime('now', ?)), 0)
+		FROM phone_verifications WHERE team_id = ? AND created_at > datetime('now', '-1 day')
+	`, phoneCodeResendCooldown, id).Scan(&today, &recent); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if recent > 0 {
+		s.jsonError(w, "a code was sent recently; try again shortly", http.StatusTooManyRequests)
+		return
+	}
+	if today >= maxPhoneCodesPerDay {
+		s.jsonError(w, "too many verification codes today", http.StatusTooManyRequests)
+		return
+	}
+
+	code, err := newPhoneCode()
+	if err != nil {
+		s.jsonError(w, "failed to generate code", http.StatusInternalServerError)
+		return
+	}
+	verificationID := uuid.New().String()
+	expiresAt := time.Now().Add(phoneCodeExpiry).UTC().Format(time.DateTime)
+	if _, err := s.DB.ExecContext(ctx, `
+		INSERT INTO phone_verifications (id, team_id, member_id, phone, code_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, verificationID, id, memberID, phone, hashPhoneCode(code), expiresAt); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body := fmt.Sprintf("AkiGura 確認コード: %s\n%d分以内に入力してください。", code, int(phoneCodeExpiry.Minutes()))
+	if err := s.SMS.Send(ctx, phone, body); err != nil {
+		slog.Warn("phone verification code not sent", "team_id", id, "error", err)
+		s.jsonError(w, "failed to send verification code: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if _, err := s.DB.ExecContext(ctx, `
+		INSERT INTO sms_usage (team_id, member_id, kind, segments, created_at) VALUES (?, ?, 'verification', 1, CURRENT_TIMESTAMP)
+	`, id, memberID); err != nil {
+		slog.Warn("record sms usage", "team_id", id, "error", err)
+	}
+	s.jsonResponse(w, map[string]any{
+		"id":         verificationID,
+		"phone":      maskPhone(phone),
+		"expires_at": expiresAt,
+	})
+}
+
+// HandleConfirmPhoneVerification checks a verification code. On success the
+// number becomes the team's sms destination, or the member's phone, and
+// the sms channel is enabled for them.
+func (s *Server) HandleConfirmPhoneVerification(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	verificationID := r.PathValue("verification")
+	if id == "" || verificationID == "" {
+		s.jsonError(w, "team id and verification required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var phone, codeHash string
+	var memberID sql.NullString
+	var attempts int
+	var expired, verified bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT phone, code_hash, member_id, attempts, expires_at <= ?, verified_at IS NOT NULL
+		FROM phone_verifications WHERE id = ? AND team_id = ?
+	`, time.Now().UTC().Format(time.DateTime), verificationID, id).Scan(&phone, &codeHash, &memberID, &attempts, &expired, &verified)
+	if err == sql.ErrNoRows {
+		s.jsonError(w, "verification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case verified:
+		s.jsonError(w, "code already used", http.StatusBadRequest)
+		return
+	case expired:
+		s.jsonError(w, "code expired", http.StatusBadRequest)
+		return
+	case attempts >= maxPhoneCodeAttempts:
+		s.jsonError(w, "too many attempts; request a new code", http.StatusTooManyRequests)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hashPhoneCode(strings.TrimSpace(req.Code))), []byte(codeHash)) != 1 {
+		if _, err := tx.ExecContext(ctx, `UPDATE phone_verifications SET attempts = attempts + 1 WHERE id = ?`, verificationID); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.jsonError(w, "incorrect code", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE phone_verifications SET verified_at = CURRENT_TIMESTAMP WHERE id = ?`, verificationID); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if memberID.Valid {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE team_members SET phone = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+		`, phone, memberID.String); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = setMemberChannelTx(ctx, tx, memberID.String, ChannelSMS, true, "")
+	} else {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO notification_destinations (team_id, channel, target, verified_at, created_at, updated_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			ON CONFLICT (team_id, channel) DO UPDATE SET
+				target = excluded.target, verified_at = excluded.verified_at, updated_at = CURRENT_TIMESTAMP
+		`, id, ChannelSMS, phone); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err = enableChannelTx(ctx, tx, id, ChannelSMS); err == nil {
+			err = markChannelVerifiedTx(ctx, tx, id, ChannelSMS)
+		}
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("phone number verified", "team_id", id, "member", memberID.String)
+	s.jsonResponse(w, map[string]any{"success": true, "phone": maskPhone(phone)})
+}
@@ -0,0 +1,194 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"akigura.dev/shared/sms"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"090-1234-5678", "+819012345678"},
+		{"０８０ １２３４ ５６７８", "+818012345678"},
+		{"+81 70-1234-5678", "+817012345678"},
+		{"+1 415 555 2671", "+14155552671"},
+		{"03-1234-5678", ""},    // landline
+		{"+81312345678", ""},    // landline
+		{"090-1234-567", ""},    // too short
+		{"090-1234-5678x", ""},  // not a number
+		{"tel:09012345678", ""}, // not a number
+	}
+	for _, tt := range tests {
+		got, err := normalizePhone(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("normalizePhone(%q) = %q, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizePhone(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestPhoneVerificationHandlers(t *testing.T) {
+	server := newTestServer(t)
+	provider := &sms.Fake{}
+	server.SMS = provider
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'team-1', 'team-1@example.com', 'pro'), ('free-team', 'free-team', 'free@example.com', 'free')`)
+	mustExec(t, server.DB, `INSERT INTO team_members (id, team_id, name, email) VALUES ('member-1', 'team-1', '佐藤', 'sato@example.com')`)
+
+	start := func(team, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.SetPathValue("id", team)
+		w := httptest.NewRecorder()
+		server.HandleStartPhoneVerification(w, req)
+		return w
+	}
+	confirm := func(team, verification, code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"code":"`+code+`"}`))
+		req.SetPathValue("id", team)
+		req.SetPathValue("verification", verification)
+		w := httptest.NewRecorder()
+		server.HandleConfirmPhoneVerification(w, req)
+		return w
+	}
+	codePattern := regexp.MustCompile(`\d{6}`)
+	lastCode := func() string {
+		msgs := provider.Messages()
+		if len(msgs) == 0 {
+			t.Fatal("確認コードを送信すべき")
+		}
+		return codePattern.FindString(msgs[len(msgs)-1].Body)
+	}
+	// allowResend moves earlier codes out of the resend cooldown
+	allowResend := func() {
+		mustExec(t, server.DB, `UPDATE phone_verifications SET created_at = datetime('now', '-2 minutes')`)
+	}
+
+	t.Run("SMS のないプランでは確認できないべき", func(t *testing.T) {
+		if w := start("free-team", `{"phone":"090-1234-5678"}`); w.Code != http.StatusForbidden {
+			t.Errorf("403を返すべき: %d", w.Code)
+		}
+		if w := start("team-1", `{"phone":"03-1234-5678"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("携帯電話以外の番号は400を返すべき: %d", w.Code)
+		}
+		if len(provider.Messages()) != 0 {
+			t.Error("SMS を送信すべきではない")
+		}
+	})
+
+	t.Run("確認コードを入力するとチームの SMS 通知先になるべき", func(t *testing.T) {
+		w := start("team-1", `{"phone":"090-1234-5678"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("送信は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		id, _ := resp["id"].(string)
+		if resp["phone"] != "090-****-5678" || provider.Messages()[0].To != "+819012345678" {
+			t.Errorf("E.164 の番号に送信し伏せ字で返すべき: %v %+v", resp, provider.Messages())
+		}
+
+		if w := start("team-1", `{"phone":"090-1234-5678"}`); w.Code != http.StatusTooManyRequests {
+			t.Errorf("直後の再送は429を返すべき: %d", w.Code)
+		}
+		if w := confirm("free-team", id, lastCode()); w.Code != http.StatusNotFound {
+			t.Errorf("他チームの確認は404を返すべき: %d", w.Code)
+		}
+		if w := confirm("team-1", id, "000000x"); w.Code != http.StatusBadRequest {
+			t.Errorf("誤ったコードは400を返すべき: %d", w.Code)
+		}
+		if w := confirm("team-1", id, lastCode()); w.Code != http.StatusOK {
+			t.Fatalf("正しいコードで確認できるべき: %d %s", w.Code, w.Body.String())
+		}
+		if w := confirm("team-1", id, lastCode()); w.Code != http.StatusBadRequest {
+			t.Errorf("使用済みのコードは400を返すべき: %d", w.Code)
+		}
+
+		var target, channels string
+		server.DB.QueryRow(`SELECT target FROM notification_destinations WHERE team_id = 'team-1' AND channel = 'sms'`).Scan(&target)
+		server.DB.QueryRow(`SELECT notification_channels FROM teams WHERE id = 'team-1'`).Scan(&channels)
+		if target != "+819012345678" || !strings.Contains(channels, `"sms"`) {
+			t.Errorf("SMS 通知先を保存し有効にすべき: %q %s", target, channels)
+		}
+	})
+
+	t.Run("失敗が続いたコードは使えないべき", func(t *testing.T) {
+		allowResend()
+		w := start("team-1", `{"phone":"080-1111-2222"}`)
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		id, _ := resp["id"].(string)
+		for i := 0; i < maxPhoneCodeAttempts; i++ {
+			confirm("team-1", id, "wrong")
+		}
+		if w := confirm("team-1", id, lastCode()); w.Code != http.StatusTooManyRequests {
+			t.Errorf("429を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("メンバーの電話番号を確認するとメンバーの SMS が有効になるべき", func(t *testing.T) {
+		body := `{"name":"佐藤","email":"sato@example.com","channels":["email","sms"]}`
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		req.SetPathValue("id", "team-1")
+		req.SetPathValue("member", "member-1")
+		w := httptest.NewRecorder()
+		server.HandleUpdateMember(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("確認前に SMS は有効にできないべき: %d", w.Code)
+		}
+
+		allowResend()
+		if w := start("team-1", `{"phone":"070-3333-4444","member_id":"member-2"}`); w.Code != http.StatusNotFound {
+			t.Errorf("存在しないメンバーは404を返すべき: %d", w.Code)
+		}
+		w = start("team-1", `{"phone":"070-3333-4444","member_id":"member-1"}`)
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		id, _ := resp["id"].(string)
+		if w := confirm("team-1", id, lastCode()); w.Code != http.StatusOK {
+			t.Fatalf("確認できるべき: %d %s", w.Code, w.Body.String())
+		}
+		var phone, channels, target string
+		server.DB.QueryRow(`SELECT phone, channels FROM team_members WHERE id = 'member-1'`).Scan(&phone, &channels)
+		server.DB.QueryRow(`SELECT target FROM notification_destinations WHERE team_id = 'team-1' AND channel = 'sms'`).Scan(&target)
+		if phone != "+817033334444" || channels != `["email","sms"]` || target != "+819012345678" {
+			t.Errorf("メンバーの番号だけを更新すべき: %q %s %q", phone, channels, target)
+		}
+	})
+
+	t.Run("今月の SMS の利用数を返すべき", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetPathValue("id", "team-1")
+		w := httptest.NewRecorder()
+		server.HandleGetSMSUsage(w, req)
+		var usage struct {
+			Available bool `json:"available"`
+			Quota     int  `json:"quota"`
+			Used      int  `json:"used"`
+		}
+		json.NewDecoder(w.Body).Decode(&usage)
+		if !usage.Available || usage.Quota != 100 || usage.Used != 3 {
+			t.Errorf("確認コードも利用数に含めるべき: %+v", usage)
+		}
+	})
+
+	t.Run("月間の上限に達したらコードを送らないべき", func(t *testing.T) {
+		allowResend()
+		for i := 0; i < 97; i++ {
+			mustExec(t, server.DB, `INSERT INTO sms_usage (team_id, kind) VALUES ('team-1', 'notification')`)
+		}
+		if w := start("team-1", `{"phone":"090-1234-5678"}`); w.Code != http.StatusTooManyRequests {
+			t.Errorf("429を返すべき: %d", w.Code)
+		}
+	})
+}
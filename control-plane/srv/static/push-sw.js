@@ -0,0 +1,29 @@
+// AkiGura Web Push service worker.
+// Shows notifications pushed by the worker (see worker/notifier/webpush.go)
+// and opens the slot's reservation page or the dashboard when clicked.
+
+self.addEventListener('push', (event) => {
+    let data = {};
+    try {
+        data = event.data ? event.data.json() : {};
+    } catch (e) {
+        data = { body: event.data ? event.data.text() : '' };
+    }
+    event.waitUntil(self.registration.showNotification(data.title || 'AkiGura', {
+        body: data.body || '',
+        tag: data.tag,
+        data: { url: data.url || '/user' },
+        lang: 'ja'
+    }));
+});
+
+self.addEventListener('notificationclick', (event) => {
+    event.notification.close();
+    const url = event.notification.data?.url || '/user';
+    event.waitUntil((async () => {
+        const windows = await clients.matchAll({ type: 'window', includeUncontrolled: true });
+        const existing = windows.find(w => w.url === url);
+        if (existing) return existing.focus();
+        return clients.openWindow(url);
+    })());
+});
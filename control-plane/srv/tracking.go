@@ -0,0 +1,443 @@
+package srv
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Click tracking and bookings
+//
+// The worker routes reservation links through /r/{token} (see
+// worker/notifier/tracking.go). The token names a notification and is signed
+// with TRACKING_SECRET; the redirect records a click for the notification's
+// slot and channel and sends the browser to the municipality's reservation
+// page, looked up here so that the link cannot point anywhere else.
+//
+// Each message also links to /booked, signed for the message, where the
+// recipient claims or votes on its slots (see coordination.go) and marks the
+// ones they booked. Bookings can be recorded from the
+// dashboard too; they are credited to the notification the team most likely
+// booked from. Clicks and bookings per channel feed the admin stats.
+
+// Tracking token purposes
+const (
+	trackClick  = "click"
+	trackBooked = "booked"
+)
+
+// trackingSigSize is the number of HMAC bytes kept in a token, as the worker
+// signs them.
+const trackingSigSize = 16
+
+// Booking sources
+const (
+	bookingSourceLink      = "link"
+	bookingSourceDashboard = "dashboard"
+)
+
+var errInvalidTrackingToken = errors.New("invalid tracking link")
+
+// signTracking returns the token for an ID, as the worker builds it.
+func signTracking(secret, purpose, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose + ":" + id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:trackingSigSize])
+}
+
+// parseTrackingToken verifies a token signed for purpose and returns its ID.
+func parseTrackingToken(secret, purpose, token string) (string, error) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok || id == "" || secret == "" {
+		return "", errInvalidTrackingToken
+	}
+	if !hmac.Equal([]byte(token), []byte(signTracking(secret, purpose, id))) {
+		return "", errInvalidTrackingToken
+	}
+	return id, nil
+}
+
+// HandleTrackedRedirect records a click on a notification's reservation link
+// and opens the slot's reservation page (see reservation.go). Links to
+// notifications that no longer exist, e.g. for purged slots, open the
+// dashboard instead.
+func (s *Server) HandleTrackedRedirect(w http.ResponseWriter, r *http.Request) {
+	id, err := parseTrackingToken(os.Getenv("TRACKING_SECRET"), trackClick, r.PathValue("token"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var teamID, slotID, channel, link string
+	var memberID, messageID sql.NullString
+	err = s.DB.QueryRowContext(r.Context(), `
+		SELECT n.team_id, n.member_id, n.message_id, n.slot_id, n.channel,
+		       COALESCE(NULLIF(sl.reservation_url, ''), m.url, '')
+		FROM notifications n
+		LEFT JOIN slots sl ON sl.id = n.slot_id
+		LEFT JOIN municipalities m ON m.id = sl.municipality_id
+		WHERE n.id = ?
+	`, id).Scan(&teamID, &memberID, &messageID, &slotID, &channel, &link)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		slog.Error("load tracked notification", "notification_id", id, "error", err)
+	default:
+		// HEAD requests come from link checkers, not people
+		if r.Method == http.MethodGet {
+			if _, err := s.DB.ExecContext(r.Context(), `
+				INSERT INTO notification_clicks (notification_id, message_id, team_id, member_id, slot_id, channel)
+				VALUES (?, ?, ?, ?, ?, ?)
+			`, id, messageID, teamID, memberID, slotID, channel); err != nil {
+				slog.Error("record click", "notification_id", id, "error", err)
+			}
+		}
+	}
+
+	s.openReservation(w, r, link, "/user")
+}
+
+// bookingSlot is a slot of a message shown on the booking page.
+type bookingSlot struct {
+	NotificationID string
+	SlotID         string
+	MemberID       string
+	Channel        string
+	GroundName     string
+	SlotDate       string
+	TimeFrom       string
+	TimeTo         string
+	CourtName      string
+	Booked         bool
+	// Coordination: who claimed the slot and until when (JST), whether the
+	// claim is the recipient's own, and the votes
+	ClaimedBy    string
+	ClaimExpires string
+	ClaimMine    bool
+	Yes          int
+	No           int
+	MyVote       string
+}
+
+type bookedPage struct {
+	Token    string
+	TeamName string
+	MemberID string // the message's recipient; empty for the team's representative
+	Slots    []bookingSlot
+	Done     bool
+	Notice   string // outcome of a claim or vote
+	Error    string
+}
+
+// loadBookedPage verifies a booking token and loads the slots of its message.
+func (s *Server) loadBookedPage(ctx context.Context, token string) (teamID, messageID string, page bookedPage, status int) {
+	page = bookedPage{Token: token}
+	messageID, err := parseTrackingToken(os.Getenv("TRACKING_SECRET"), trackBooked, token)
+	if err != nil {
+		page.Error = "リンクが無効です。通知に記載されたリンクをそのまま開いてください。"
+		return "", "", page, http.StatusBadRequest
+	}
+	if err := s.DB.QueryRowContext(ctx, `
+		SELECT t.id, t.name, COALESCE(m.member_id, '') FROM notification_messages m JOIN teams t ON t.id = m.team_id WHERE m.id = ?
+	`, messageID).Scan(&teamID, &page.TeamName, &page.MemberID); err != nil {
+		page.Error = "通知が見つかりません。"
+		return "", "", page, http.StatusNotFound
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT n.id, n.slot_id, COALESCE(n.member_id, ''), n.channel,
+		       COALESCE(g.name, ''), COALESCE(sl.slot_date, ''), COALESCE(sl.time_from, ''),
+		       COALESCE(sl.time_to, ''), COALESCE(sl.court_name, ''), b.id IS NOT NULL,
+		       CASE WHEN c.team_id IS NULL THEN '' WHEN c.member_id IS NULL THEN '代表者' ELSE COALESCE(tm.name, '') || ' さん' END,
+		       COALESCE(c.expires_at, ''), c.team_id IS NOT NULL AND COALESCE(c.member_id, '') = ?,
+		       (SELECT COUNT(*) FROM slot_votes v WHERE v.team_id = n.team_id AND v.slot_id = n.slot_id AND v.vote = 'yes'),
+		       (SELECT COUNT(*) FROM slot_votes v WHERE v.team_id = n.team_id AND v.slot_id = n.slot_id AND v.vote = 'no'),
+		       COALESCE((SELECT v.vote FROM slot_votes v WHERE v.team_id = n.team_id AND v.slot_id = n.slot_id AND COALESCE(v.member_id, '') = ?), '')
+		FROM notifications n
+		LEFT JOIN slots sl ON sl.id = n.slot_id
+		LEFT JOIN grounds g ON g.id = sl.ground_id
+		LEFT JOIN slot_bookings b ON b.team_id = n.team_id AND b.slot_id = n.slot_id
+		LEFT JOIN slot_claims c ON c.team_id = n.team_id AND c.slot_id = n.slot_id AND c.expires_at > datetime('now')
+		LEFT JOIN team_members tm ON tm.id = c.member_id
+		WHERE n.message_id = ?
+		ORDER BY sl.slot_date, sl.time_from, g.name, sl.court_name
+	`, page.MemberID, page.MemberID, messageID)
+	if err != nil {
+		slog.Error("load booking slots", "message_id", messageID, "error", err)
+		page.Error = "読み込みに失敗しました。時間をおいて再度お試しください。"
+		return "", "", page, http.StatusInternalServerError
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b bookingSlot
+		if err := rows.Scan(&b.NotificationID, &b.SlotID, &b.MemberID, &b.Channel,
+			&b.GroundName, &b.SlotDate, &b.TimeFrom, &b.TimeTo, &b.CourtName, &b.Booked,
+			&b.ClaimedBy, &b.ClaimExpires, &b.ClaimMine, &b.Yes, &b.No, &b.MyVote); err != nil {
+			continue
+		}
+		b.SlotDate = dateOnly(b.SlotDate)
+		if t, err := time.Parse(time.DateTime, b.ClaimExpires); err == nil {
+			b.ClaimExpires = t.In(jst).Format("15:04")
+		}
+		page.Slots = append(page.Slots, b)
+	}
+	if len(page.Slots) == 0 {
+		page.Error = "この通知の空き枠は終了しました。"
+		return "", "", page, http.StatusNotFound
+	}
+	return teamID, messageID, page, http.StatusOK
+}
+
+func (s *Server) renderBookedPage(w http.ResponseWriter, r *http.Request, status int, page bookedPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := s.renderTemplate(w, "booked.html", page); err != nil {
+		slog.Warn("render template", "url", r.URL.Path, "error", err)
+	}
+}
+
+// HandleBookedPage lists the slots of a notification so the team can mark
+// the one they booked. Like the unsubscribe page it changes nothing.
+func (s *Server) HandleBookedPage(w http.ResponseWriter, r *http.Request) {
+	_, _, page, status := s.loadBookedPage(r.Context(), r.URL.Query().Get("token"))
+	s.renderBookedPage(w, r, status, page)
+}
+
+// HandleBooked performs the recipient's action on one of the notification's
+// slots: claiming it, releasing their claim, voting on it, or recording a
+// booking credited to the notification (the default, as in older emails).
+func (s *Server) HandleBooked(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := r.PostFormValue("token")
+	teamID, messageID, page, status := s.loadBookedPage(ctx, token)
+	if status != http.StatusOK {
+		s.renderBookedPage(w, r, status, page)
+		return
+	}
+	slotID := r.PostFormValue("slot_id")
+	i := -1
+	for j, b := range page.Slots {
+		if b.SlotID == slotID {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		page.Error = "この通知にない空き枠です。"
+		s.renderBookedPage(w, r, http.StatusBadRequest, page)
+		return
+	}
+
+	b := page.Slots[i]
+	var notice string
+	var err error
+	code := http.StatusOK
+	switch action := r.PostFormValue("action"); action {
+	case "", "book":
+		if err := s.recordLinkBooking(ctx, teamID, messageID, b); err != nil {
+			slog.Error("record booking", "team_id", teamID, "slot_id", slotID, "error", err)
+			page.Error = "記録に失敗しました。時間をおいて再度お試しください。"
+			s.renderBookedPage(w, r, http.StatusInternalServerError, page)
+			return
+		}
+		page.Slots[i].Booked = true
+		page.Done = true
+		s.renderBookedPage(w, r, http.StatusOK, page)
+		return
+	case "claim":
+		notice = fmt.Sprintf("この枠を予約することをチームに知らせました。%d分以内に予約してください。", int(claimTimeout.Minutes()))
+		if _, err = s.claimSlot(ctx, teamID, b.SlotID, page.MemberID); errors.Is(err, errSlotClaimed) {
+			notice, err, code = "ほかのメンバーが予約中です。", nil, http.StatusConflict
+		}
+	case "release":
+		notice = "予約の宣言を取り消しました。"
+		if err = s.releaseClaim(ctx, teamID, b.SlotID, page.MemberID, true); errors.Is(err, errNoClaim) {
+			notice, err = "取り消す宣言はありません。", nil
+		}
+	case voteYes, voteNo:
+		// Pressing the same vote again withdraws it
+		vote := action
+		notice = "投票しました。"
+		if b.MyVote == action {
+			vote, notice = "", "投票を取り消しました。"
+		}
+		err = s.voteSlot(ctx, teamID, b.SlotID, page.MemberID, vote)
+	default:
+		page.Error = "不明な操作です。"
+		s.renderBookedPage(w, r, http.StatusBadRequest, page)
+		return
+	}
+	if err != nil {
+		slog.Error("coordinate slot", "team_id", teamID, "slot_id", slotID, "error", err)
+		page.Error = "記録に失敗しました。時間をおいて再度お試しください。"
+		s.renderBookedPage(w, r, http.StatusInternalServerError, page)
+		return
+	}
+
+	// Show the slots as they are now
+	_, _, page, _ = s.loadBookedPage(ctx, token)
+	page.Notice = notice
+	s.renderBookedPage(w, r, code, page)
+}
+
+// recordLinkBooking records a booking of a slot from the booking page and
+// ends its claim.
+func (s *Server) recordLinkBooking(ctx context.Context, teamID, messageID string, b bookingSlot) error {
+	if _, err := s.DB.ExecContext(ctx, `
+		INSERT INTO slot_bookings (team_id, slot_id, notification_id, message_id, member_id, channel, source)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, ?)
+		ON CONFLICT(team_id, slot_id) DO NOTHING
+	`, teamID, b.SlotID, b.NotificationID, messageID, b.MemberID, b.Channel, bookingSourceLink); err != nil {
+		return err
+	}
+	slog.Info("slot booked", "team_id", teamID, "slot_id", b.SlotID, "channel", b.Channel, "source", bookingSourceLink)
+	return endClaim(ctx, s.DB, teamID, b.SlotID)
+}
+
+// HandleCreateBooking marks a slot as booked from the dashboard and ends its
+// claim. The booking is credited to the team's notification of the slot,
+// preferring one whose link was clicked, then the latest.
+func (s *Server) HandleCreateBooking(w http.ResponseWriter, r *http.Request) {
+	teamID := r.PathValue("id")
+	if teamID == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		SlotID string `json:"slot_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SlotID == "" {
+		s.jsonError(w, "slot_id required", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+
+	var notificationID, messageID, memberID sql.NullString
+	var channel string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT n.id, n.message_id, n.member_id, n.channel
+		FROM notifications n
+		WHERE n.team_id = ? AND n.slot_id = ? AND n.status = 'sent'
+		ORDER BY EXISTS (SELECT 1 FROM notification_clicks c WHERE c.notification_id = n.id) DESC, n.sent_at DESC
+		LIMIT 1
+	`, teamID, req.SlotID).Scan(&notificationID, &messageID, &memberID, &channel)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Booked without a notification, e.g. found on the calendar
+		var exists bool
+		if err := s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM slots WHERE id = ?)`, req.SlotID).Scan(&exists); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			s.jsonError(w, "slot not found", http.StatusNotFound)
+			return
+		}
+	} else if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := s.DB.ExecContext(ctx, `
+		INSERT INTO slot_bookings (team_id, slot_id, notification_id, message_id, member_id, channel, source)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(team_id, slot_id) DO NOTHING
+	`, teamID, req.SlotID, notificationID, messageID, memberID, channel, bookingSourceDashboard); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := endClaim(ctx, s.DB, teamID, req.SlotID); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, map[string]any{"success": true, "channel": channel})
+}
+
+// HandleDeleteBooking removes a slot's booking, e.g. one marked by mistake.
+func (s *Server) HandleDeleteBooking(w http.ResponseWriter, r *http.Request) {
+	teamID, slotID := r.PathValue("id"), r.PathValue("slot")
+	if teamID == "" || slotID == "" {
+		s.jsonError(w, "team id and slot required", http.StatusBadRequest)
+		return
+	}
+	res, err := s.DB.ExecContext(r.Context(), `DELETE FROM slot_bookings WHERE team_id = ? AND slot_id = ?`, teamID, slotID)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "booking not found", http.StatusNotFound)
+		return
+	}
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// ChannelStats is the engagement with the messages sent on a channel.
+type ChannelStats struct {
+	Channel     string  `json:"channel"`
+	Sent        int     `json:"sent"`         // messages sent
+	Clicked     int     `json:"clicked"`      // messages with a clicked reservation link
+	Booked      int     `json:"booked"`       // messages a booking was credited to
+	Clicks      int     `json:"clicks"`       // clicks in total
+	ClickRate   float64 `json:"click_rate"`   // Clicked / Sent
+	BookingRate float64 `json:"booking_rate"` // Booked / Sent
+}
+
+// HandleNotificationStats reports click-through and booking rates per
+// channel for the messages sent in the last ?days= days (default 30). Claim
+// notices point at the same links as the slot notification they follow, so
+// only slot notifications are counted.
+func (s *Server) HandleNotificationStats(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			s.jsonError(w, "days must be between 1 and 365", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	rows, err := s.DB.QueryContext(r.Context(), `
+		SELECT m.channel, COUNT(*),
+		       SUM(EXISTS (SELECT 1 FROM notification_clicks c WHERE c.message_id = m.id)),
+		       SUM(EXISTS (SELECT 1 FROM slot_bookings b WHERE b.message_id = m.id)),
+		       SUM((SELECT COUNT(*) FROM notification_clicks c WHERE c.message_id = m.id))
+		FROM notification_messages m
+		WHERE m.status = 'sent' AND m.claim_id IS NULL AND m.sent_at >= datetime('now', ?)
+		GROUP BY m.channel
+		ORDER BY COUNT(*) DESC, m.channel
+	`, "-"+strconv.Itoa(days)+" days")
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	stats := []ChannelStats{}
+	for rows.Next() {
+		var c ChannelStats
+		if err := rows.Scan(&c.Channel, &c.Sent, &c.Clicked, &c.Booked, &c.Clicks); err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c.ClickRate = float64(c.Clicked) / float64(c.Sent)
+		c.BookingRate = float64(c.Booked) / float64(c.Sent)
+		stats = append(stats, c)
+	}
+	if err := rows.Err(); err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.jsonResponse(w, stats)
+}
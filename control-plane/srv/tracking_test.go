@@ -0,0 +1,182 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTrackingToken(t *testing.T) {
+	// Same vectors as the worker's notifier.TrackingToken test
+	click, booked := "n-1.gy7SL0MO1fAmp5ofs53zJQ", "m-1.OxRAtl16sqx0D2glP92jWQ"
+	if got := signTracking("test-secret", trackClick, "n-1"); got != click {
+		t.Errorf("click token = %s, want %s", got, click)
+	}
+	if got := signTracking("test-secret", trackBooked, "m-1"); got != booked {
+		t.Errorf("booked token = %s, want %s", got, booked)
+	}
+	if id, err := parseTrackingToken("test-secret", trackClick, click); err != nil || id != "n-1" {
+		t.Errorf("トークンを検証できるべき: %q %v", id, err)
+	}
+
+	for _, bad := range []string{"", click[:len(click)-2], "n-2" + click[3:], strings.Replace(click, ".", "", 1)} {
+		if _, err := parseTrackingToken("test-secret", trackClick, bad); err == nil {
+			t.Errorf("改ざんされたトークンは拒否すべき: %q", bad)
+		}
+	}
+	if _, err := parseTrackingToken("test-secret", trackBooked, click); err == nil {
+		t.Error("クリック用のトークンで予約を記録できないべき")
+	}
+	if _, err := parseTrackingToken("", trackClick, click); err == nil {
+		t.Error("シークレットが未設定なら拒否すべき")
+	}
+}
+
+func TestTrackingHandlers(t *testing.T) {
+	server := newTestServer(t)
+	t.Setenv("TRACKING_SECRET", "test-secret")
+	const municipality, ground = "d9e5f0b2-3456-5789-0bcd-ef0123456789", "d0000001-0001-4000-8000-000000000001"
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+		VALUES ('wc-1', 'team-1', ?, '[]', '09:00', '12:00')`, ground)
+	for _, id := range []string{"slot-1", "slot-2", "slot-3"} {
+		mustExec(t, server.DB, `INSERT INTO slots (id, municipality_id, ground_id, slot_date, time_from, time_to)
+			VALUES (?, ?, ?, '2099-01-03', '09:00', '11:00')`, id, municipality, ground)
+	}
+	// A LINE message with two slots, and a later email with one of them
+	for _, m := range [][3]string{{"msg-line", "line", "-2 hours"}, {"msg-email", "email", "-1 hours"}} {
+		mustExec(t, server.DB, `INSERT INTO notification_messages (id, team_id, channel, status, sent_at) VALUES (?, 'team-1', ?, 'sent', datetime('now', ?))`, m[0], m[1], m[2])
+	}
+	for _, n := range [][4]string{{"n-1", "slot-1", "msg-line", "line"}, {"n-2", "slot-2", "msg-line", "line"}, {"n-3", "slot-1", "msg-email", "email"}} {
+		mustExec(t, server.DB, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, status, message_id, sent_at)
+			SELECT ?, 'team-1', 'wc-1', ?, channel, 'sent', id, sent_at FROM notification_messages WHERE id = ? AND channel = ?`, n[0], n[1], n[2], n[3])
+	}
+	redirect := func(method, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/r/"+token, nil)
+		req.SetPathValue("token", token)
+		w := httptest.NewRecorder()
+		server.HandleTrackedRedirect(w, req)
+		return w
+	}
+	postBooked := func(token, slotID string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {token}, "slot_id": {slotID}}
+		req := httptest.NewRequest(http.MethodPost, "/booked", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.HandleBooked(w, req)
+		return w
+	}
+
+	t.Run("クリックを記録して予約サイトへ転送すべき", func(t *testing.T) {
+		w := redirect(http.MethodGet, signTracking("test-secret", trackClick, "n-1"))
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "https://yoyaku.e-kanagawa.lg.jp" {
+			t.Fatalf("自治体の予約サイトへ転送すべき: %d %s", w.Code, w.Header().Get("Location"))
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM notification_clicks WHERE notification_id = 'n-1' AND message_id = 'msg-line' AND slot_id = 'slot-1' AND channel = 'line'`); n != 1 {
+			t.Errorf("通知・枠・チャネルのクリックを記録すべき: %d", n)
+		}
+
+		redirect(http.MethodHead, signTracking("test-secret", trackClick, "n-1"))
+		if w := redirect(http.MethodGet, signTracking("other-secret", trackClick, "n-2")); w.Code != http.StatusNotFound {
+			t.Errorf("署名が不正なら404を返すべき: %d", w.Code)
+		}
+		if w := redirect(http.MethodGet, signTracking("test-secret", trackClick, "n-deleted")); w.Header().Get("Location") != "/user" {
+			t.Errorf("削除された通知はマイページへ転送すべき: %s", w.Header().Get("Location"))
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM notification_clicks`); n != 1 {
+			t.Errorf("HEAD と不正なリンクは記録すべきではない: %d", n)
+		}
+	})
+
+	t.Run("通知のリンクから予約した枠を記録すべき", func(t *testing.T) {
+		token := signTracking("test-secret", trackBooked, "msg-line")
+		w := httptest.NewRecorder()
+		server.HandleBookedPage(w, httptest.NewRequest(http.MethodGet, "/booked?token="+url.QueryEscape(token), nil))
+		if w.Code != http.StatusOK || strings.Count(w.Body.String(), `name="slot_id"`) != 2 {
+			t.Fatalf("メッセージの枠を一覧すべき: %d %s", w.Code, w.Body.String())
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM slot_bookings`); n != 0 {
+			t.Fatal("GETでは記録すべきではない")
+		}
+
+		if w := postBooked(token, "slot-3"); w.Code != http.StatusBadRequest {
+			t.Errorf("通知にない枠は400を返すべき: %d", w.Code)
+		}
+		if w := postBooked(signTracking("test-secret", trackClick, "msg-line"), "slot-2"); w.Code != http.StatusBadRequest {
+			t.Errorf("クリック用のトークンは400を返すべき: %d", w.Code)
+		}
+		if w := postBooked(token, "slot-2"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "予約を記録しました") {
+			t.Fatalf("記録は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM slot_bookings WHERE slot_id = 'slot-2' AND notification_id = 'n-2' AND channel = 'line' AND source = 'link'`); n != 1 {
+			t.Errorf("予約を通知に紐付けて記録すべき: %d", n)
+		}
+	})
+
+	t.Run("マイページからの予約はクリックされた通知に紐付けるべき", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"slot_id":"slot-1"}`))
+		req.SetPathValue("id", "team-1")
+		w := httptest.NewRecorder()
+		server.HandleCreateBooking(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("記録は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if n := queryInt(t, server.DB, `SELECT COUNT(*) FROM slot_bookings WHERE slot_id = 'slot-1' AND notification_id = 'n-1' AND source = 'dashboard'`); n != 1 {
+			t.Error("後から届いたメールより、クリックされた LINE の通知を優先すべき")
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/api/notifications?team_id=team-1", nil)
+		w = httptest.NewRecorder()
+		server.HandleListNotifications(w, req)
+		var list []struct {
+			ID     string `json:"id"`
+			Booked bool   `json:"booked"`
+			Clicks int    `json:"clicks"`
+		}
+		json.NewDecoder(w.Body).Decode(&list)
+		for _, n := range list {
+			if !n.Booked || (n.ID == "n-1") != (n.Clicks == 1) {
+				t.Errorf("通知履歴に予約とクリックを表示すべき: %+v", n)
+			}
+		}
+	})
+
+	t.Run("チャネルごとのクリック率と予約率を集計すべき", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.HandleNotificationStats(w, httptest.NewRequest(http.MethodGet, "/api/notification-stats?days=7", nil))
+		var stats []ChannelStats
+		json.NewDecoder(w.Body).Decode(&stats)
+		want := map[string]ChannelStats{
+			"line":  {Channel: "line", Sent: 1, Clicked: 1, Booked: 1, Clicks: 1, ClickRate: 1, BookingRate: 1},
+			"email": {Channel: "email", Sent: 1},
+		}
+		if len(stats) != len(want) {
+			t.Fatalf("送信したチャネルごとに集計すべき: %+v", stats)
+		}
+		for _, got := range stats {
+			if got != want[got.Channel] {
+				t.Errorf("got %+v, want %+v", got, want[got.Channel])
+			}
+		}
+	})
+
+	t.Run("予約の記録を取り消せるべき", func(t *testing.T) {
+		del := func() int {
+			req := httptest.NewRequest(http.MethodDelete, "/", nil)
+			req.SetPathValue("id", "team-1")
+			req.SetPathValue("slot", "slot-1")
+			w := httptest.NewRecorder()
+			server.HandleDeleteBooking(w, req)
+			return w.Code
+		}
+		if code := del(); code != http.StatusOK {
+			t.Errorf("取り消しは成功すべき: %d", code)
+		}
+		if code := del(); code != http.StatusNotFound {
+			t.Errorf("記録がなければ404を返すべき: %d", code)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package srv
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"akigura.dev/shared/unsubscribe"
+)
+
+// Unsubscribe links
+//
+// Notification emails carry signed links to /unsubscribe (see
+// worker/notifier/unsubscribe.go and akigura.dev/shared/unsubscribe), scoped
+// to the conditions behind the email or to all of the team's conditions. Opening a link shows a confirmation
+// page; confirming it, or a one-click POST from the mail client (RFC 8058),
+// pauses the conditions. No login is needed: the signature is the credential.
+//
+// Emails to a team member are signed for the member instead (member scopes):
+// confirming them removes the member's opt-in to the conditions and leaves
+// them running for the team and the other members.
+
+// unsubscribeRequest is a verified unsubscribe token. MemberID is set for
+// member scopes; TeamID is then filled in from the member.
+type unsubscribeRequest struct {
+	unsubscribe.Request
+	TeamID   string
+	MemberID string
+}
+
+// parseUnsubscribeToken verifies a token signed with secret.
+func parseUnsubscribeToken(secret, token string) (unsubscribeRequest, error) {
+	r, err := unsubscribe.Parse(secret, token)
+	if err != nil {
+		return unsubscribeRequest{}, err
+	}
+	req := unsubscribeRequest{Request: r}
+	if r.ForMember() {
+		req.MemberID = r.OwnerID
+	} else {
+		req.TeamID = r.OwnerID
+	}
+	return req, nil
+}
+
+// unsubscribeCondition is a watch condition shown on the unsubscribe page.
+type unsubscribeCondition struct {
+	GroundName string
+	TimeFrom   string
+	TimeTo     string
+	Enabled    bool
+}
+
+type unsubscribePage struct {
+	Token      string
+	All        bool
+	TeamName   string
+	MemberName string // set when a member opts out
+	Conditions []unsubscribeCondition
+	Done       bool
+	Error      string
+}
+
+// listUnsubscribeConditions lists the conditions a request covers. For a
+// member, Enabled reports whether the member is opted in; a member's
+// all-conditions link covers the conditions they are opted in to.
+func (s *Server) listUnsubscribeConditions(ctx context.Context, req unsubscribeRequest) ([]unsubscribeCondition, error) {
+	query := `
+		SELECT COALESCE(g.name, wc.facility_id), wc.time_from, wc.time_to, wc.enabled
+		FROM watch_conditions wc
+		LEFT JOIN grounds g ON g.id = wc.facility_id
+		WHERE wc.team_id = ?`
+	args := []any{req.TeamID}
+	if req.ForMember() {
+		query = `
+		SELECT COALESCE(g.name, wc.facility_id), wc.time_from, wc.time_to, wcm.member_id IS NOT NULL
+		FROM watch_conditions wc
+		LEFT JOIN grounds g ON g.id = wc.facility_id
+		LEFT JOIN watch_condition_members wcm ON wcm.watch_condition_id = wc.id AND wcm.member_id = ?
+		WHERE wc.team_id = ?`
+		args = []any{req.MemberID, req.TeamID}
+		if !req.Listed() {
+			query += ` AND wcm.member_id IS NOT NULL`
+		}
+	}
+	if req.Listed() {
+		query += ` AND wc.id IN (?` + strings.Repeat(", ?", len(req.ConditionIDs)-1) + `)`
+		for _, id := range req.ConditionIDs {
+			args = append(args, id)
+		}
+	}
+	rows, err := s.DB.QueryContext(ctx, query+` ORDER BY wc.created_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var conditions []unsubscribeCondition
+	for rows.Next() {
+		var c unsubscribeCondition
+		if err := rows.Scan(&c.GroundName, &c.TimeFrom, &c.TimeTo, &c.Enabled); err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, rows.Err()
+}
+
+// pauseConditions disables the conditions a request covers, or for a member
+// removes their opt-in to them.
+func (s *Server) pauseConditions(ctx context.Context, req unsubscribeRequest) (int64, error) {
+	query := `UPDATE watch_conditions SET enabled = 0, updated_at = CURRENT_TIMESTAMP WHERE team_id = ? AND enabled = 1`
+	args := []any{req.TeamID}
+	column := "id"
+	if req.ForMember() {
+		query = `DELETE FROM watch_condition_members WHERE member_id = ?`
+		args = []any{req.MemberID}
+		column = "watch_condition_id"
+	}
+	if req.Listed() {
+		query += ` AND ` + column + ` IN (?` + strings.Repeat(", ?", len(req.ConditionIDs)-1) + `)`
+		for _, id := range req.ConditionIDs {
+			args = append(args, id)
+		}
+	}
+	res, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Server) renderUnsubscribePage(w http.ResponseWriter, r *http.Request, status int, page unsubscribePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := s.renderTemplate(w, "unsubscribe.html", page); err != nil {
+		slog.Warn("render template", "url", r.URL.Path, "error", err)
+	}
+}
+
+// loadUnsubscribePage verifies the request's token and loads the page data.
+func (s *Server) loadUnsubscribePage(r *http.Request, token string) (unsubscribeRequest, unsubscribePage, int) {
+	page := unsubscribePage{Token: token}
+	req, err := parseUnsubscribeToken(os.Getenv("UNSUBSCRIBE_SECRET"), token)
+	if err != nil {
+		page.Error = "リンクが無効です。メールに記載されたリンクをそのまま開いてください。"
+		return req, page, http.StatusBadRequest
+	}
+	page.All = !req.Listed()
+	if req.ForMember() {
+		if err := s.DB.QueryRowContext(r.Context(), `
+			SELECT t.id, t.name, m.name FROM team_members m JOIN teams t ON t.id = m.team_id WHERE m.id = ?
+		`, req.MemberID).Scan(&req.TeamID, &page.TeamName, &page.MemberName); err != nil {
+			page.Error = "メンバーが見つかりません。"
+			return req, page, http.StatusNotFound
+		}
+	} else if err := s.DB.QueryRowContext(r.Context(), `SELECT name FROM teams WHERE id = ?`, req.TeamID).Scan(&page.TeamName); err != nil {
+		page.Error = "チームが見つかりません。"
+		return req, page, http.StatusNotFound
+	}
+	if page.Conditions, err = s.listUnsubscribeConditions(r.Context(), req); err != nil {
+		slog.Error("load unsubscribe conditions", "team_id", req.TeamID, "error", err)
+		page.Error = "読み込みに失敗しました。時間をおいて再度お試しください。"
+		return req, page, http.StatusInternalServerError
+	}
+	return req, page, http.StatusOK
+}
+
+// HandleUnsubscribePage shows what an unsubscribe link will pause and asks
+// for confirmation. It changes nothing, so link scanners that prefetch the
+// URL do not unsubscribe the team.
+func (s *Server) HandleUnsubscribePage(w http.ResponseWriter, r *http.Request) {
+	_, page, status := s.loadUnsubscribePage(r, r.URL.Query().Get("token"))
+	s.renderUnsubscribePage(w, r, status, page)
+}
+
+// HandleUnsubscribe pauses the conditions of an unsubscribe link. It accepts
+// the confirmation form and RFC 8058 one-click requests, which POST
+// "List-Unsubscribe=One-Click" to the link itself.
+func (s *Server) HandleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = r.PostFormValue("token")
+	}
+	req, page, status := s.loadUnsubscribePage(r, token)
+	if status != http.StatusOK {
+		s.renderUnsubscribePage(w, r, status, page)
+		return
+	}
+	paused, err := s.pauseConditions(r.Context(), req)
+	if err != nil {
+		slog.Error("pause conditions", "team_id", req.TeamID, "error", err)
+		page.Error = "停止に失敗しました。時間をおいて再度お試しください。"
+		s.renderUnsubscribePage(w, r, http.StatusInternalServerError, page)
+		return
+	}
+	slog.Info("unsubscribed", "team_id", req.TeamID, "member_id", req.MemberID, "scope", req.Scope, "paused", paused,
+		"one_click", r.PostFormValue("List-Unsubscribe") == "One-Click")
+	page.Done = true
+	for i := range page.Conditions {
+		page.Conditions[i].Enabled = false
+	}
+	s.renderUnsubscribePage(w, r, http.StatusOK, page)
+}
+
+// HandleUpdateConditionEnabled pauses or resumes a watch condition, e.g. one
+// paused from an unsubscribe link.
+func (s *Server) HandleUpdateConditionEnabled(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "id required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		TeamID  string `json:"team_id"`
+		Enabled bool   `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.TeamID == "" {
+		s.jsonError(w, "team_id required", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.DB.ExecContext(r.Context(), `
+		UPDATE watch_conditions SET enabled = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND team_id = ?
+	`, req.Enabled, id, req.TeamID)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "condition not found", http.StatusNotFound)
+		return
+	}
+	s.jsonResponse(w, map[string]bool{"success": true})
+}
@@ -0,0 +1,121 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"akigura.dev/shared/unsubscribe"
+)
+
+func TestUnsubscribeHandlers(t *testing.T) {
+	server := newTestServer(t)
+	t.Setenv("UNSUBSCRIBE_SECRET", "test-secret")
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-2', 'チーム2', 'team2@example.com', 'pro')`)
+	for _, c := range [][3]string{{"wc-1", "team-1", "ground-1"}, {"wc-2", "team-1", "ground-2"}, {"wc-3", "team-1", "ground-3"}, {"wc-other", "team-2", "ground-1"}} {
+		mustExec(t, server.DB, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+			VALUES (?, ?, ?, '[]', '09:00', '12:00')`, c[0], c[1], c[2])
+	}
+	enabled := func(id string) bool {
+		var v bool
+		server.DB.QueryRow(`SELECT enabled FROM watch_conditions WHERE id = ?`, id).Scan(&v)
+		return v
+	}
+
+	conditionsToken := unsubscribe.Sign("test-secret", unsubscribe.ScopeConditions, "team-1", []string{"wc-1", "wc-other"})
+
+	t.Run("確認ページは条件を停止すべきではない", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.HandleUnsubscribePage(w, httptest.NewRequest(http.MethodGet, "/unsubscribe?token="+conditionsToken, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "通知を停止する") {
+			t.Fatalf("確認ページを返すべき: %d %s", w.Code, w.Body.String())
+		}
+		if !enabled("wc-1") {
+			t.Error("GETでは停止すべきではない")
+		}
+	})
+
+	t.Run("ワンクリックPOSTで対象の条件だけを停止すべき", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/unsubscribe?token="+conditionsToken, strings.NewReader("List-Unsubscribe=One-Click"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.HandleUnsubscribe(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "通知を停止しました") {
+			t.Fatalf("停止は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if enabled("wc-1") || !enabled("wc-2") {
+			t.Error("リンクの条件だけが停止されるべき")
+		}
+		if !enabled("wc-other") {
+			t.Error("他チームの条件は停止すべきではない")
+		}
+	})
+
+	t.Run("確認フォームからすべての条件を停止すべき", func(t *testing.T) {
+		form := url.Values{"token": {unsubscribe.Sign("test-secret", unsubscribe.ScopeAll, "team-1", nil)}}
+		req := httptest.NewRequest(http.MethodPost, "/unsubscribe", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.HandleUnsubscribe(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("停止は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if enabled("wc-2") || enabled("wc-3") || !enabled("wc-other") {
+			t.Error("チームのすべての条件だけが停止されるべき")
+		}
+	})
+
+	t.Run("無効なトークンは400を返すべき", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.HandleUnsubscribe(w, httptest.NewRequest(http.MethodPost, "/unsubscribe?token="+conditionsToken+"x", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("400を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("停止した条件を再開できるべき", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"team_id":"team-1","enabled":true}`))
+		req.SetPathValue("id", "wc-1")
+		w := httptest.NewRecorder()
+		server.HandleUpdateConditionEnabled(w, req)
+		if w.Code != http.StatusOK || !enabled("wc-1") {
+			t.Errorf("再開は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+
+		req = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"team_id":"team-1","enabled":true}`))
+		req.SetPathValue("id", "wc-other")
+		w = httptest.NewRecorder()
+		server.HandleUpdateConditionEnabled(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("他チームの条件は404を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("メンバーのリンクはメンバーのオプトインだけを解除すべき", func(t *testing.T) {
+		mustExec(t, server.DB, `INSERT INTO team_members (id, team_id, name, email) VALUES ('member-1', 'team-1', '佐藤', 'sato@example.com')`)
+		mustExec(t, server.DB, `INSERT INTO watch_condition_members (watch_condition_id, member_id) VALUES ('wc-1', 'member-1'), ('wc-2', 'member-1')`)
+		optedIn := func(id string) bool {
+			var v bool
+			server.DB.QueryRow(`SELECT COUNT(*) > 0 FROM watch_condition_members WHERE watch_condition_id = ? AND member_id = 'member-1'`, id).Scan(&v)
+			return v
+		}
+
+		form := url.Values{"token": {unsubscribe.Sign("test-secret", unsubscribe.ScopeMemberConditions, "member-1", []string{"wc-1"})}}
+		req := httptest.NewRequest(http.MethodPost, "/unsubscribe", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.HandleUnsubscribe(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "佐藤 様") {
+			t.Fatalf("停止は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if optedIn("wc-1") || !optedIn("wc-2") {
+			t.Error("リンクの条件のオプトインだけが解除されるべき")
+		}
+		if !enabled("wc-1") {
+			t.Error("チームの条件は停止すべきではない")
+		}
+	})
+}
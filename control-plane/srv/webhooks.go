@@ -0,0 +1,231 @@
+package srv
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"akigura.dev/shared/netguard"
+	"github.com/google/uuid"
+)
+
+// Outgoing webhooks
+//
+// A team can register its own HTTPS endpoint as the "webhook" destination.
+// The worker (notifier.WebhookNotifier) POSTs signed, versioned match events
+// to it. The signing scheme is mirrored here for the test ping sent on save:
+//
+//	X-AkiGura-Signature: t=<unix seconds>,v1=<hex HMAC-SHA256("<t>.<body>")>
+//
+// Rotating the secret keeps the previous one signing deliveries (as a second
+// v1) for WebhookSecretGracePeriod.
+
+// lookupIPAddr resolves webhook hosts; tests replace it.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// WebhookDelivery is one outgoing webhook request from the delivery log.
+type WebhookDelivery struct {
+	ID             string  `json:"id"`
+	EventID        string  `json:"event_id"`
+	EventType      string  `json:"event_type"`
+	NotificationID *string `json:"notification_id"`
+	StatusCode     int     `json:"status_code"`
+	Success        bool    `json:"success"`
+	Error          *string `json:"error"`
+	DurationMs     int     `json:"duration_ms"`
+	AttemptedAt    string  `json:"attempted_at"`
+}
+
+// validateEndpointURL checks a team-provided webhook endpoint. It must be
+// HTTPS and resolve only to public addresses so the test ping and deliveries
+// cannot reach internal services. The name can be re-pointed after this
+// check, so the ping is also sent with a client that checks the address when
+// it connects (see httpClient), as the worker's deliveries are.
+func validateEndpointURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return fmt.Errorf("url must be an https URL")
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return fmt.Errorf("url must not point to a private address")
+	}
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, addr)
+	} else {
+		ips, err := lookupIPAddr(ctx, host)
+		if err != nil || len(ips) == 0 {
+			return fmt.Errorf("cannot resolve %s", host)
+		}
+		for _, ip := range ips {
+			if addr, ok := netip.AddrFromSlice(ip.IP); ok {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	for _, addr := range addrs {
+		if !netguard.IsPublic(addr) {
+			return fmt.Errorf("url must not point to a private address")
+		}
+	}
+	return nil
+}
+
+// generateWebhookSecret returns a new signing secret.
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// signWebhook returns the X-AkiGura-Signature header value for body sent at t.
+func signWebhook(body []byte, t time.Time, secret string) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWebhookPing sends a signed "ping" event to a webhook endpoint and logs it.
+// Only public addresses are connected to, redirects are not followed, and
+// only the status code of a failure is kept.
+func (s *Server) sendWebhookPing(ctx context.Context, teamID, endpoint, secret string) error {
+	now := time.Now()
+	eventID := uuid.New().String()
+	body, err := json.Marshal(map[string]any{
+		"version":    "1",
+		"id":         eventID,
+		"type":       "ping",
+		"created_at": now.UTC().Format(time.RFC3339),
+		"team_id":    teamID,
+		"slots":      []any{},
+	})
+	if err != nil {
+		return err
+	}
+
+	client := *s.httpClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	start := time.Now()
+	status, err := postJSON(ctx, &client, endpoint, body, map[string]string{
+		"User-Agent":           "AkiGura-Webhook/1",
+		"X-AkiGura-Event-ID":   eventID,
+		"X-AkiGura-Event-Type": "ping",
+		"X-AkiGura-Signature":  signWebhook(body, now, secret),
+	})
+
+	// The response body of a rejected ping is left out of the log, which
+	// the team can read
+	if err != nil && status != 0 {
+		err = fmt.Errorf("webhook error: status %d", status)
+	}
+	var errMsg *string
+	if err != nil {
+		msg := err.Error()
+		errMsg = &msg
+	}
+	if _, logErr := s.DB.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (id, team_id, event_id, event_type, url, status_code, success, error, duration_ms, attempted_at)
+		VALUES (?, ?, ?, 'ping', ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, uuid.New().String(), teamID, eventID, endpoint, status, err == nil, errMsg, time.Since(start).Milliseconds()); logErr != nil {
+		return logErr
+	}
+	return err
+}
+
+// HandleRotateWebhookSecret issues a new signing secret for the team's webhook.
+// The previous secret stays valid for WebhookSecretGracePeriod.
+func (s *Server) HandleRotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		s.jsonError(w, "failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	res, err := s.DB.ExecContext(r.Context(), `
+		UPDATE notification_destinations
+		SET previous_secret = secret, previous_secret_expires_at = datetime('now', ?),
+		    secret = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE team_id = ? AND channel = ?
+	`, fmt.Sprintf("+%d seconds", int(WebhookSecretGracePeriod.Seconds())), secret, id, ChannelWebhook)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.jsonError(w, "webhook not registered", http.StatusNotFound)
+		return
+	}
+	var expiresAt string
+	s.DB.QueryRowContext(r.Context(), `
+		SELECT previous_secret_expires_at FROM notification_destinations WHERE team_id = ? AND channel = ?
+	`, id, ChannelWebhook).Scan(&expiresAt)
+	s.jsonResponse(w, map[string]string{"secret": secret, "previous_secret_expires_at": expiresAt})
+}
+
+// HandleListWebhookDeliveries returns the team's recent webhook deliveries.
+func (s *Server) HandleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		s.jsonError(w, "team id required", http.StatusBadRequest)
+		return
+	}
+	rows, err := s.DB.QueryContext(r.Context(), `
+		SELECT id, event_id, event_type, notification_id, status_code, success, error, duration_ms, attempted_at
+		FROM webhook_deliveries
+		WHERE team_id = ?
+		ORDER BY attempted_at DESC
+		LIMIT ?
+	`, id, DefaultAPILimit)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var success int
+		if err := rows.Scan(&d.ID, &d.EventID, &d.EventType, &d.NotificationID, &d.StatusCode, &success,
+			&d.Error, &d.DurationMs, &d.AttemptedAt); err != nil {
+			continue
+		}
+		d.Success = success == 1
+		deliveries = append(deliveries, d)
+	}
+	s.jsonResponse(w, deliveries)
+}
+
+// currentWebhookSecret returns the team's existing webhook secret, or "".
+func (s *Server) currentWebhookSecret(ctx context.Context, teamID string) (string, error) {
+	var secret string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT secret FROM notification_destinations WHERE team_id = ? AND channel = ?
+	`, teamID, ChannelWebhook).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return secret, err
+}
@@ -0,0 +1,199 @@
+package srv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"akigura.dev/shared/netguard"
+)
+
+func TestValidateEndpointURL(t *testing.T) {
+	orig := lookupIPAddr
+	defer func() { lookupIPAddr = orig }()
+	lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "hooks.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		case "internal.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.5")}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://hooks.example.com/akigura", true},
+		{"http://hooks.example.com/akigura", false},
+		{"https://internal.example.com/akigura", false},
+		{"https://localhost/akigura", false},
+		{"https://127.0.0.1/akigura", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://[::1]/akigura", false},
+		{"https://unknown.example.com/akigura", false},
+	}
+	for _, tt := range tests {
+		if err := validateEndpointURL(context.Background(), tt.url); (err == nil) != tt.ok {
+			t.Errorf("validateEndpointURL(%s) = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestSendWebhookPingPrivateAddress(t *testing.T) {
+	server := newTestServer(t)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("プライベートアドレスには接続しないべき")
+	}))
+	defer endpoint.Close()
+
+	// The URL passed validation, but the name now resolves to a private address
+	err := server.sendWebhookPing(context.Background(), "team-1", strings.Replace(endpoint.URL, "127.0.0.1", "localhost", 1), "whsec_test")
+	if !errors.Is(err, netguard.ErrPrivateAddress) {
+		t.Errorf("接続時にプライベートアドレスを拒否すべき: %v", err)
+	}
+	var logged int
+	if err := server.DB.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries WHERE team_id = 'team-1' AND success = 0`).Scan(&logged); err != nil || logged != 1 {
+		t.Errorf("失敗した送信を記録すべき: %d %v", logged, err)
+	}
+}
+
+func TestWebhookHandlers(t *testing.T) {
+	server := newTestServer(t)
+	mustExec(t, server.DB, `INSERT INTO teams (id, name, email, plan) VALUES ('team-1', 'チーム1', 'team1@example.com', 'pro')`)
+
+	orig := lookupIPAddr
+	defer func() { lookupIPAddr = orig }()
+	lookupIPAddr = func(context.Context, string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+	}
+
+	var lastBody []byte
+	var lastHeader http.Header
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastBody, _ = io.ReadAll(r.Body)
+		lastHeader = r.Header.Clone()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer endpoint.Close()
+	target, _ := url.Parse(endpoint.URL)
+	server.HTTPClient = &http.Client{Transport: rewriteTransport{target: target}}
+
+	call := func(handler http.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.SetPathValue("id", "team-1")
+		req.SetPathValue("channel", ChannelWebhook)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	var secret string
+	t.Run("初回保存で署名シークレットを発行し署名付きpingを送るべき", func(t *testing.T) {
+		w := call(server.HandleSaveDestination, http.MethodPut, `{"url":"https://hooks.example.com/akigura"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("保存は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Secret string `json:"secret"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if !strings.HasPrefix(resp.Secret, "whsec_") {
+			t.Fatalf("シークレットが返されるべき: %q", resp.Secret)
+		}
+		secret = resp.Secret
+
+		if lastHeader.Get("X-AkiGura-Event-Type") != "ping" {
+			t.Errorf("pingイベントを送るべき: %v", lastHeader)
+		}
+		sig := lastHeader.Get("X-AkiGura-Signature")
+		ts, _, _ := strings.Cut(strings.TrimPrefix(sig, "t="), ",")
+		sec, _ := strconv.ParseInt(ts, 10, 64)
+		if want := signWebhook(lastBody, time.Unix(sec, 0), secret); sig != want {
+			t.Errorf("署名が一致すべき: got %s want %s", sig, want)
+		}
+
+		var channels string
+		server.DB.QueryRow(`SELECT notification_channels FROM teams WHERE id = 'team-1'`).Scan(&channels)
+		if !strings.Contains(channels, ChannelWebhook) {
+			t.Errorf("webhookチャネルが有効になるべき: %s", channels)
+		}
+	})
+
+	t.Run("再保存ではシークレットを維持し返さないべき", func(t *testing.T) {
+		w := call(server.HandleSaveDestination, http.MethodPut, `{"url":"https://hooks.example.com/v2"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("保存は成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("既存シークレットは再表示すべきではない: %s", w.Body.String())
+		}
+		if got, _ := server.currentWebhookSecret(context.Background(), "team-1"); got != secret {
+			t.Errorf("シークレットは維持されるべき")
+		}
+	})
+
+	t.Run("プライベートアドレスへの登録は拒否すべき", func(t *testing.T) {
+		w := call(server.HandleSaveDestination, http.MethodPut, `{"url":"https://192.168.1.10/hook"}`)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("400を返すべき: %d", w.Code)
+		}
+	})
+
+	t.Run("ローテーションで旧シークレットを猶予期間つきで残すべき", func(t *testing.T) {
+		w := call(server.HandleRotateWebhookSecret, http.MethodPost, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("ローテーションは成功すべき: %d %s", w.Code, w.Body.String())
+		}
+		var resp map[string]string
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["secret"] == "" || resp["secret"] == secret || resp["previous_secret_expires_at"] == "" {
+			t.Errorf("新しいシークレットと期限を返すべき: %v", resp)
+		}
+		var previous string
+		server.DB.QueryRow(`SELECT previous_secret FROM notification_destinations WHERE team_id = 'team-1' AND channel = 'webhook'`).Scan(&previous)
+		if previous != secret {
+			t.Errorf("旧シークレットを保持すべき")
+		}
+
+		list := call(server.HandleListDestinations, http.MethodGet, "")
+		if !strings.Contains(list.Body.String(), "previous_secret_expires_at") {
+			t.Errorf("一覧にローテーション期限を含むべき: %s", list.Body.String())
+		}
+	})
+
+	t.Run("配信ログを新しい順に返すべき", func(t *testing.T) {
+		w := call(server.HandleListWebhookDeliveries, http.MethodGet, "")
+		var deliveries []WebhookDelivery
+		json.NewDecoder(w.Body).Decode(&deliveries)
+		if len(deliveries) != 2 {
+			t.Fatalf("2件のpingが記録されるべき: %d", len(deliveries))
+		}
+		for _, d := range deliveries {
+			if !d.Success || d.StatusCode != http.StatusNoContent || d.EventType != "ping" {
+				t.Errorf("成功したpingとして記録されるべき: %+v", d)
+			}
+		}
+	})
+
+	t.Run("未登録チームのローテーションは404を返すべき", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.SetPathValue("id", "team-unknown")
+		w := httptest.NewRecorder()
+		server.HandleRotateWebhookSecret(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("404を返すべき: %d", w.Code)
+		}
+	})
+}
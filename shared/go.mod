@@ -0,0 +1,3 @@
+module akigura.dev/shared
+
+go 1.24.0
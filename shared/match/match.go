@@ -0,0 +1,257 @@
+// Package match holds the slot matching rules shared by the worker, which
+// notifies matching slots, and the control plane, which explains and
+// backtests them. Both evaluate conditions through this package so that an
+// explanation or a backtest never disagrees with what the worker does.
+package match
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Watch condition bundle types
+const (
+	BundleTypeCourts = "courts" // N simultaneous courts at one ground
+	BundleTypeDays   = "days"   // N consecutive days at one ground
+)
+
+// jst is the timezone used to determine "today" for booking windows.
+var jst = time.FixedZone("JST", 9*60*60)
+
+// Slot is the part of a scraped slot that conditions are matched against.
+type Slot struct {
+	Date      string // YYYY-MM-DD or an ISO timestamp
+	TimeFrom  string // HH:MM or HHMM
+	TimeTo    string
+	CourtName string
+}
+
+// Condition is the part of a watch condition that slots are matched against.
+type Condition struct {
+	DaysOfWeek []int // 0=Sun, 1=Mon, ..., 6=Sat; empty matches any day
+	TimeFrom   string
+	TimeTo     string
+	DateFrom   string // YYYY-MM-DD, empty for no bound
+	DateTo     string
+
+	// Relative booking window measured in days from today (JST).
+	// Zero means no limit.
+	MinLeadDays int
+	MaxLeadDays int
+	// ExcludedDates lists dates (YYYY-MM-DD) that should never be notified.
+	ExcludedDates []string
+
+	// BundleType is empty for single-slot conditions, or BundleTypeCourts /
+	// BundleTypeDays when BundleSize slots must be available together.
+	BundleType string
+	BundleSize int
+}
+
+// Check is the result of a single matching check.
+type Check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// Matches reports whether the slot satisfies every criterion of the
+// condition as of now.
+func Matches(slot Slot, cond Condition, now time.Time) bool {
+	matched := true
+	evaluate(slot, cond, now, func(_ string, passed bool, _ func() string) bool {
+		matched = passed
+		return passed
+	})
+	return matched
+}
+
+// Explain returns the result of every check Matches makes, in order. The
+// checks after the first failure are still evaluated, so that a trace shows
+// every reason a slot does not match.
+func Explain(slot Slot, cond Condition, now time.Time) []Check {
+	var checks []Check
+	evaluate(slot, cond, now, func(name string, passed bool, detail func() string) bool {
+		checks = append(checks, Check{Name: name, Passed: passed, Detail: detail()})
+		return true
+	})
+	return checks
+}
+
+// evaluate runs the checks in order, passing each result to visit until it
+// returns false. Details are formatted only when visit asks for them.
+//
+// The checks are: (1) the slot is not in the past, (2) day of week, (3) time
+// range overlap, (4) date range boundaries, (5) the relative booking window,
+// and (6) excluded dates. "Past" uses the UTC date, as the worker's open slot
+// query (slot_date >= date('now')) does; the booking window uses the JST date.
+func evaluate(slot Slot, cond Condition, now time.Time, visit func(name string, passed bool, detail func() string) bool) {
+	date := Day(slot.Date)
+	slotDate, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		visit("slot_date", false, func() string { return fmt.Sprintf("slot date %q cannot be parsed", slot.Date) })
+		return
+	}
+
+	today := midnight(now.UTC())
+	if !visit("not_past", !slotDate.Before(today), func() string {
+		return fmt.Sprintf("slot date %s, today %s (UTC)", date, today.Format(time.DateOnly))
+	}) {
+		return
+	}
+
+	dow := int(slotDate.Weekday())
+	if !visit("weekday", len(cond.DaysOfWeek) == 0 || slices.Contains(cond.DaysOfWeek, dow), func() string {
+		if len(cond.DaysOfWeek) == 0 {
+			return "any weekday"
+		}
+		return fmt.Sprintf("slot weekday %d, condition weekdays %v", dow, cond.DaysOfWeek)
+	}) {
+		return
+	}
+
+	// Slot must overlap with condition time range
+	slotFrom, slotTo := minutes(slot.TimeFrom), minutes(slot.TimeTo)
+	condFrom, condTo := minutes(cond.TimeFrom), minutes(cond.TimeTo)
+	if !visit("time_overlap", slotTo > condFrom && slotFrom < condTo, func() string {
+		return fmt.Sprintf("slot %s-%s, condition %s-%s", slot.TimeFrom, slot.TimeTo, cond.TimeFrom, cond.TimeTo)
+	}) {
+		return
+	}
+
+	dateFrom, dateTo := Day(cond.DateFrom), Day(cond.DateTo)
+	inRange := (dateFrom == "" || date >= dateFrom) && (dateTo == "" || date <= dateTo)
+	if !visit("date_range", inRange, func() string {
+		return fmt.Sprintf("condition range %q to %q", dateFrom, dateTo)
+	}) {
+		return
+	}
+
+	leadDays := int(slotDate.Sub(midnight(now.In(jst))).Hours() / 24)
+	inWindow := (cond.MinLeadDays == 0 || leadDays >= cond.MinLeadDays) &&
+		(cond.MaxLeadDays == 0 || leadDays <= cond.MaxLeadDays)
+	if !visit("booking_window", inWindow, func() string {
+		return fmt.Sprintf("slot is %d days out (JST), window %d to %d days (0 = no limit)",
+			leadDays, cond.MinLeadDays, cond.MaxLeadDays)
+	}) {
+		return
+	}
+
+	visit("excluded_dates", !slices.Contains(cond.ExcludedDates, date), func() string {
+		return fmt.Sprintf("excluded dates %v", cond.ExcludedDates)
+	})
+}
+
+// FindBundles groups the slots that match a bundle condition into complete
+// bundles. For BundleTypeCourts it returns BundleSize distinct courts sharing
+// the same date and time; for BundleTypeDays it returns the earliest slot of
+// BundleSize consecutive days. Bundles never share a slot. slotOf returns the
+// matched fields of a caller's slot, so bundles keep the caller's type.
+func FindBundles[S any](slots []S, slotOf func(S) Slot, cond Condition, now time.Time) [][]S {
+	if cond.BundleSize < 2 {
+		return nil
+	}
+
+	var matched []S
+	for _, s := range slots {
+		if Matches(slotOf(s), cond, now) {
+			matched = append(matched, s)
+		}
+	}
+	slices.SortFunc(matched, func(a, b S) int {
+		sa, sb := slotOf(a), slotOf(b)
+		return cmp.Or(
+			cmp.Compare(sa.Date, sb.Date),
+			cmp.Compare(sa.TimeFrom, sb.TimeFrom),
+			cmp.Compare(sa.TimeTo, sb.TimeTo),
+			cmp.Compare(sa.CourtName, sb.CourtName),
+		)
+	})
+
+	var bundles [][]S
+	switch cond.BundleType {
+	case BundleTypeCourts:
+		// Consecutive runs of the same date and time, one slot per court
+		for i := 0; i < len(matched); {
+			j := i
+			var group []S
+			for ; j < len(matched) && sameTime(slotOf(matched[i]), slotOf(matched[j])); j++ {
+				if len(group) == 0 || slotOf(group[len(group)-1]).CourtName != slotOf(matched[j]).CourtName {
+					group = append(group, matched[j])
+				}
+			}
+			if len(group) >= cond.BundleSize {
+				bundles = append(bundles, group[:cond.BundleSize])
+			}
+			i = j
+		}
+	case BundleTypeDays:
+		// Earliest slot of each day, then runs of consecutive days
+		var days []S
+		for _, s := range matched {
+			if len(days) == 0 || Day(slotOf(days[len(days)-1]).Date) != Day(slotOf(s).Date) {
+				days = append(days, s)
+			}
+		}
+		for i := 0; i+cond.BundleSize <= len(days); {
+			if consecutiveDays(days[i:i+cond.BundleSize], slotOf) {
+				bundles = append(bundles, days[i:i+cond.BundleSize])
+				i += cond.BundleSize
+				continue
+			}
+			i++
+		}
+	}
+	return bundles
+}
+
+func sameTime(a, b Slot) bool {
+	return Day(a.Date) == Day(b.Date) && a.TimeFrom == b.TimeFrom && a.TimeTo == b.TimeTo
+}
+
+// consecutiveDays reports whether the slots fall on consecutive calendar days.
+func consecutiveDays[S any](slots []S, slotOf func(S) Slot) bool {
+	var prev time.Time
+	for i, s := range slots {
+		d, err := time.Parse(time.DateOnly, Day(slotOf(s).Date))
+		if err != nil {
+			return false
+		}
+		if i > 0 && !d.Equal(prev.AddDate(0, 0, 1)) {
+			return false
+		}
+		prev = d
+	}
+	return true
+}
+
+// Day trims a date or timestamp string to YYYY-MM-DD.
+func Day(s string) string {
+	if len(s) > 10 {
+		return s[:10]
+	}
+	return s
+}
+
+// midnight returns t's calendar date as midnight UTC, comparable with parsed slot dates.
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// minutes converts time strings (HH:MM or HHMM) to minutes since midnight.
+// This enables simple numeric comparison of time ranges.
+func minutes(t string) int {
+	var h, m int
+	switch {
+	case len(t) == 5 && t[2] == ':':
+		h, _ = strconv.Atoi(t[0:2])
+		m, _ = strconv.Atoi(t[3:5])
+	case len(t) == 4:
+		h, _ = strconv.Atoi(t[0:2])
+		m, _ = strconv.Atoi(t[2:4])
+	}
+	return h*60 + m
+}
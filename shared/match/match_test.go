@@ -0,0 +1,109 @@
+package match
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMatchesCases runs testdata/match_cases.json, which documents the
+// matching rules. Past slots are not included: the worker never loads them.
+func TestMatchesCases(t *testing.T) {
+	data, err := os.ReadFile("testdata/match_cases.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mc struct {
+		Today string `json:"today"`
+		Cases []struct {
+			Name string `json:"name"`
+			Slot struct {
+				Date     string `json:"date"`
+				TimeFrom string `json:"time_from"`
+				TimeTo   string `json:"time_to"`
+			} `json:"slot"`
+			Condition struct {
+				DaysOfWeek    []int    `json:"days_of_week"`
+				TimeFrom      string   `json:"time_from"`
+				TimeTo        string   `json:"time_to"`
+				DateFrom      string   `json:"date_from"`
+				DateTo        string   `json:"date_to"`
+				MinLeadDays   int      `json:"min_lead_days"`
+				MaxLeadDays   int      `json:"max_lead_days"`
+				ExcludedDates []string `json:"excluded_dates"`
+			} `json:"condition"`
+			Match bool `json:"match"`
+		} `json:"cases"`
+	}
+	if err := json.Unmarshal(data, &mc); err != nil {
+		t.Fatal(err)
+	}
+	// Noon JST on "today", the same date in UTC
+	now, err := time.ParseInLocation("2006-01-02 15:04", mc.Today+" 12:00", jst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range mc.Cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			c := tc.Condition
+			cond := Condition{
+				DaysOfWeek: c.DaysOfWeek, TimeFrom: c.TimeFrom, TimeTo: c.TimeTo, DateFrom: c.DateFrom, DateTo: c.DateTo,
+				MinLeadDays: c.MinLeadDays, MaxLeadDays: c.MaxLeadDays, ExcludedDates: c.ExcludedDates,
+			}
+			slot := Slot{Date: tc.Slot.Date, TimeFrom: tc.Slot.TimeFrom, TimeTo: tc.Slot.TimeTo}
+			if got := Matches(slot, cond, now); got != tc.Match {
+				t.Errorf("Matches = %v, want %v: %+v", got, tc.Match, Explain(slot, cond, now))
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	// 2025-06-01 00:30 JST is still 2025-05-31 in UTC
+	now := time.Date(2025, 5, 31, 15, 30, 0, 0, time.UTC)
+	cond := Condition{DaysOfWeek: []int{0}, TimeFrom: "00:00", TimeTo: "24:00", MinLeadDays: 1}
+
+	t.Run("過去の枠は UTC の日付で判定すべき", func(t *testing.T) {
+		slot := Slot{Date: "2025-05-31", TimeFrom: "09:00", TimeTo: "11:00"}
+		if c := Explain(slot, cond, now)[0]; c.Name != "not_past" || !c.Passed {
+			t.Errorf("UTC の当日の枠は過去ではないべき: %+v", c)
+		}
+		slot.Date = "2025-05-30"
+		if c := Explain(slot, cond, now)[0]; c.Passed {
+			t.Errorf("UTC の前日の枠は過去になるべき: %+v", c)
+		}
+	})
+
+	t.Run("失敗した後のチェックも返すべき", func(t *testing.T) {
+		// Sunday 2025-06-01 is today in JST, inside the one-day lead time
+		checks := Explain(Slot{Date: "2025-06-01", TimeFrom: "09:00", TimeTo: "11:00"}, cond, now)
+		var names []string
+		for _, c := range checks {
+			names = append(names, c.Name)
+		}
+		want := []string{"not_past", "weekday", "time_overlap", "date_range", "booking_window", "excluded_dates"}
+		if len(names) != len(want) {
+			t.Fatalf("すべてのチェックを返すべき: got %v, want %v", names, want)
+		}
+		for i, c := range checks {
+			if c.Name != want[i] {
+				t.Errorf("checks[%d] = %s, want %s", i, c.Name, want[i])
+			}
+			if c.Passed != (c.Name != "booking_window") {
+				t.Errorf("booking_window のみ失敗すべき: %+v", c)
+			}
+		}
+	})
+
+	t.Run("日付を解析できない枠は一致しないべき", func(t *testing.T) {
+		slot := Slot{Date: "unknown", TimeFrom: "09:00", TimeTo: "11:00"}
+		if checks := Explain(slot, cond, now); len(checks) != 1 || checks[0].Name != "slot_date" || checks[0].Passed {
+			t.Errorf("slot_date チェックのみ失敗すべき: %+v", checks)
+		}
+		if Matches(slot, cond, now) {
+			t.Error("Matches は false を返すべき")
+		}
+	})
+}
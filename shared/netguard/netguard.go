@@ -0,0 +1,60 @@
+// Package netguard keeps requests to URLs chosen by teams (outgoing
+// webhooks, push endpoints, chat webhooks) on the public internet.
+//
+// A URL is checked when it is saved, but DNS can change afterwards (DNS
+// rebinding), so clients from NewClient check the address again on every
+// connection, after the name has been resolved.
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned when a team-supplied URL connects to an
+// address that is not on the public internet.
+var ErrPrivateAddress = errors.New("destination is not a public address")
+
+// NewClient returns a client that only connects to public addresses.
+// Redirects are not followed, and proxies are not used since they would
+// connect on the client's behalf.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: Control}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// Control is a net.Dialer Control function that refuses connections to
+// addresses IsPublic rejects.
+func Control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !IsPublic(addr) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, addr.Unmap())
+	}
+	return nil
+}
+
+// IsPublic reports whether addr is on the public internet: not private,
+// loopback, link-local or another non-global address.
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !addr.IsLoopback() && !addr.IsLinkLocalUnicast()
+}
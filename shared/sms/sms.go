@@ -0,0 +1,208 @@
+// Package sms sends text messages through a Twilio-compatible HTTP API. The
+// worker sends notifications with it and the control plane sends phone
+// verification codes; both are configured by the same environment variables
+// (see NewProviderFromEnv).
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// defaultTimeout bounds a single API request when Twilio.Client is nil.
+const defaultTimeout = 15 * time.Second
+
+// ErrUnreachable is returned for numbers that cannot receive the message,
+// such as landlines or numbers that replied STOP. Sending again will not help.
+var ErrUnreachable = errors.New("recipient cannot receive sms")
+
+// Provider sends one text message to a phone number in E.164 format.
+type Provider interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// NewProviderFromEnv returns the provider configured by SMS_PROVIDER:
+// "twilio" (the default when TWILIO_ACCOUNT_SID is set) or "fake", which
+// only logs messages. It returns nil when SMS is not configured.
+func NewProviderFromEnv() Provider {
+	provider := os.Getenv("SMS_PROVIDER")
+	if provider == "" && os.Getenv("TWILIO_ACCOUNT_SID") != "" {
+		provider = "twilio"
+	}
+	switch provider {
+	case "twilio":
+		return &Twilio{
+			AccountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+			AuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+			From:       os.Getenv("TWILIO_FROM"),
+			APIBaseURL: os.Getenv("TWILIO_API_URL"),
+		}
+	case "fake":
+		return &Fake{}
+	case "":
+		return nil
+	default:
+		slog.Warn("sms disabled: unknown SMS_PROVIDER", "provider", provider)
+		return nil
+	}
+}
+
+// Twilio sends messages with the Twilio Messages API, or any service
+// implementing it at APIBaseURL.
+type Twilio struct {
+	AccountSID string
+	AuthToken  string
+	// From is the sending number, or a messaging service SID (MG...).
+	From       string
+	APIBaseURL string // defaults to https://api.twilio.com
+	Client     *http.Client
+}
+
+// twilioRecipientErrors are Twilio error codes reported as ErrUnreachable.
+var twilioRecipientErrors = []int{
+	21211, // invalid To number
+	21408, // region not enabled
+	21610, // recipient unsubscribed
+	21612, // unreachable via this From number
+	21614, // not a mobile number
+}
+
+func (t *Twilio) Send(ctx context.Context, to, body string) error {
+	if t.AccountSID == "" || t.AuthToken == "" || t.From == "" {
+		return fmt.Errorf("TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM must be set")
+	}
+	form := url.Values{"To": {to}, "Body": {body}}
+	if strings.HasPrefix(t.From, "MG") {
+		form.Set("MessagingServiceSid", t.From)
+	} else {
+		form.Set("From", t.From)
+	}
+	baseURL := t.APIBaseURL
+	if baseURL == "" {
+		baseURL = "https://api.twilio.com"
+	}
+	endpoint := strings.TrimRight(baseURL, "/") + "/2010-04-01/Accounts/" + url.PathEscape(t.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := t.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	var apiErr struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(raw, &apiErr)
+	err = fmt.Errorf("sms error: %d %d %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+	if slices.Contains(twilioRecipientErrors, apiErr.Code) {
+		return fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	return err
+}
+
+// Message is a message recorded by Fake.
+type Message struct {
+	To   string
+	Body string
+}
+
+// Fake records messages instead of sending them, for tests and local
+// development.
+type Fake struct {
+	// Err, when set, is returned for every message.
+	Err error
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (f *Fake) Send(ctx context.Context, to, body string) error {
+	if f.Err != nil {
+		return f.Err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, Message{To: to, Body: body})
+	slog.Info("fake sms", "to", to, "segments", Segments(body), "body", body)
+	return nil
+}
+
+// Messages returns the messages sent so far.
+func (f *Fake) Messages() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.messages)
+}
+
+// Segment sizes. A message that does not fit in one segment is split, and
+// each part carries a header taking a few characters.
+const (
+	gsmSegmentSize       = 160
+	gsmConcatSize        = 153
+	ucs2SegmentSize      = 70
+	UCS2ConcatSize       = 67 // UTF-16 units per part of a split UCS-2 message
+	gsmBasicCharacters   = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsmExtendedCharacter = "^{}\\[~]|€\f"
+)
+
+// Segments returns the number of segments text is billed as. Text using
+// only the GSM 7-bit alphabet fits 160 characters in one segment; anything
+// else, such as Japanese, is sent as UCS-2 with 70 characters.
+func Segments(text string) int {
+	gsm := 0
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune(gsmBasicCharacters, r):
+			gsm++
+		case strings.ContainsRune(gsmExtendedCharacter, r):
+			gsm += 2 // escape and character
+		default:
+			return segmentsFor(Units(text), ucs2SegmentSize, UCS2ConcatSize)
+		}
+	}
+	return segmentsFor(gsm, gsmSegmentSize, gsmConcatSize)
+}
+
+func segmentsFor(size, single, concat int) int {
+	if size <= single {
+		return 1
+	}
+	return (size + concat - 1) / concat
+}
+
+// Units returns the length of text in UTF-16 code units, as UCS-2 messages
+// are measured. Emoji count twice.
+func Units(text string) int {
+	n := 0
+	for _, r := range text {
+		n += max(utf16.RuneLen(r), 1)
+	}
+	return n
+}
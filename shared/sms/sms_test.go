@@ -0,0 +1,72 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"GSM 160文字は1セグメント", strings.Repeat("a", 160), 1},
+		{"GSM 161文字は2セグメント", strings.Repeat("a", 161), 2},
+		{"拡張文字は2文字分", strings.Repeat("{", 81), 2},
+		{"日本語70文字は1セグメント", strings.Repeat("あ", 70), 1},
+		{"日本語71文字は2セグメント", strings.Repeat("あ", 71), 2},
+		{"日本語201文字は3セグメント", strings.Repeat("あ", 201), 3},
+		{"日本語202文字は4セグメント", strings.Repeat("あ", 202), 4},
+		{"絵文字は2文字分", strings.Repeat("🏈", 36), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Segments(tt.text); got != tt.want {
+				t.Errorf("Segments = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTwilio(t *testing.T) {
+	var gotPath, gotUser, gotForm string
+	status, body := http.StatusCreated, `{"sid":"SM1"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotUser, _, _ = r.BasicAuth()
+		r.ParseForm()
+		gotForm = r.Form.Encode()
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	p := &Twilio{AccountSID: "AC123", AuthToken: "token", From: "+15005550006", APIBaseURL: srv.URL}
+
+	t.Run("Messages API に送信すべき", func(t *testing.T) {
+		if err := p.Send(context.Background(), "+819012345678", "本文"); err != nil {
+			t.Fatal(err)
+		}
+		if gotPath != "/2010-04-01/Accounts/AC123/Messages.json" || gotUser != "AC123" {
+			t.Errorf("アカウントの Messages API に認証して送るべき: %s %s", gotPath, gotUser)
+		}
+		if !strings.Contains(gotForm, "To=%2B819012345678") || !strings.Contains(gotForm, "From=%2B15005550006") {
+			t.Errorf("宛先と送信元を送るべき: %s", gotForm)
+		}
+	})
+
+	t.Run("受信できない番号は ErrUnreachable を返すべき", func(t *testing.T) {
+		status, body = http.StatusBadRequest, `{"code":21610,"message":"Attempt to send to unsubscribed recipient"}`
+		if err := p.Send(context.Background(), "+819012345678", "本文"); !errors.Is(err, ErrUnreachable) {
+			t.Errorf("ErrUnreachable を返すべき: %v", err)
+		}
+		status, body = http.StatusInternalServerError, `{"code":20500,"message":"Internal Server Error"}`
+		if err := p.Send(context.Background(), "+819012345678", "本文"); err == nil || errors.Is(err, ErrUnreachable) {
+			t.Errorf("一時的なエラーは ErrUnreachable にしないべき: %v", err)
+		}
+	})
+}
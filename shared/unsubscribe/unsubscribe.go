@@ -0,0 +1,94 @@
+// Package unsubscribe signs and verifies the tokens of unsubscribe links.
+// The worker signs them into notification emails and the control plane's
+// public /unsubscribe endpoint verifies them, both with UNSUBSCRIBE_SECRET.
+//
+// A token is scoped either to the conditions that matched an email's slots
+// or to all of the team's conditions. Emails to a team member carry member
+// scopes instead, signed for the member ID: they only opt the member out of
+// the conditions, so one member cannot pause the team's notifications for
+// everyone.
+package unsubscribe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Unsubscribe scopes
+const (
+	ScopeConditions       = "c"  // the listed watch conditions
+	ScopeAll              = "t"  // every condition of the team
+	ScopeMemberConditions = "mc" // the member's opt-in to the listed conditions
+	ScopeMember           = "m"  // every opt-in of the member
+)
+
+// ErrInvalidToken is returned for tokens that are malformed or not signed
+// with the secret.
+var ErrInvalidToken = errors.New("invalid unsubscribe link")
+
+// Request is the content of a verified token.
+type Request struct {
+	Scope string
+	// OwnerID is the team, or the member for member scopes.
+	OwnerID      string
+	ConditionIDs []string
+}
+
+// ForMember reports whether the request opts a member out rather than
+// pausing the team's conditions.
+func (r Request) ForMember() bool {
+	return r.Scope == ScopeMemberConditions || r.Scope == ScopeMember
+}
+
+// Listed reports whether the request covers only the listed conditions.
+func (r Request) Listed() bool {
+	return r.Scope == ScopeConditions || r.Scope == ScopeMemberConditions
+}
+
+// Sign returns the token for a scope. The token is the base64url payload
+// "scope:ownerID:id,id..." and its base64url HMAC-SHA256, joined by a dot.
+func Sign(secret, scope, ownerID string, conditionIDs []string) string {
+	payload := scope + ":" + ownerID + ":" + strings.Join(conditionIDs, ",")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Parse verifies a token signed with secret.
+func Parse(secret, token string) (Request, error) {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return Request{}, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return Request{}, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return Request{}, ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return Request{}, ErrInvalidToken
+	}
+
+	parts := strings.SplitN(string(payload), ":", 3)
+	if len(parts) != 3 || parts[1] == "" {
+		return Request{}, ErrInvalidToken
+	}
+	req := Request{Scope: parts[0], OwnerID: parts[1]}
+	switch req.Scope {
+	case ScopeConditions, ScopeMemberConditions:
+		req.ConditionIDs = strings.Split(parts[2], ",")
+	case ScopeAll, ScopeMember:
+	default:
+		return Request{}, ErrInvalidToken
+	}
+	return req, nil
+}
@@ -0,0 +1,38 @@
+package unsubscribe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestToken(t *testing.T) {
+	// Tokens in emails already sent must stay valid, so the format is fixed
+	token := "Yzp0ZWFtLTE6d2MtMSx3Yy0y.Kwn16P9Pw9CAC9jvCdTXQNW2ilOHTiwHsXYlkVpd-Lw"
+	if got := Sign("test-secret", ScopeConditions, "team-1", []string{"wc-1", "wc-2"}); got != token {
+		t.Errorf("Sign = %s, want %s", got, token)
+	}
+	req, err := Parse("test-secret", token)
+	if err != nil || req.OwnerID != "team-1" || strings.Join(req.ConditionIDs, ",") != "wc-1,wc-2" || req.ForMember() || !req.Listed() {
+		t.Errorf("トークンを検証できるべき: %+v %v", req, err)
+	}
+
+	for _, bad := range []string{"", token[:len(token)-2], "x" + token, strings.Replace(token, ".", "", 1)} {
+		if _, err := Parse("test-secret", bad); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("改ざんされたトークンは拒否すべき: %q", bad)
+		}
+	}
+	if _, err := Parse("other-secret", token); err == nil {
+		t.Error("別のシークレットで署名されたトークンは拒否すべき")
+	}
+	if _, err := Parse("test-secret", Sign("test-secret", "x", "team-1", nil)); err == nil {
+		t.Error("不明なスコープは拒否すべき")
+	}
+
+	t.Run("メンバーのスコープはメンバー宛てになるべき", func(t *testing.T) {
+		req, err := Parse("test-secret", Sign("test-secret", ScopeMember, "member-1", nil))
+		if err != nil || req.OwnerID != "member-1" || !req.ForMember() || req.Listed() {
+			t.Errorf("メンバーの全条件のトークンを検証できるべき: %+v %v", req, err)
+		}
+	})
+}
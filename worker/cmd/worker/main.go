@@ -33,11 +33,22 @@ var (
 	flagNative         = flag.Bool("native", false, "use native Go scrapers instead of Python")
 	flagScraperType    = flag.String("scraper-type", "", "specific scraper to run (kanagawa, hiratsuka, yokohama)")
 	flagMigrationsDir  = flag.String("migrations-dir", "../control-plane/db/migrations", "path to SQL migration files (empty to skip)")
+	flagGenVAPIDKeys   = flag.Bool("gen-vapid-keys", false, "print a new VAPID key pair for Web Push and exit")
 )
 
 func main() {
 	flag.Parse()
 
+	if *flagGenVAPIDKeys {
+		keys, err := notifier.GenerateVAPIDKeys()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("VAPID_PUBLIC_KEY=%s\nVAPID_PRIVATE_KEY=%s\n", keys.PublicKey, keys.PrivateKey())
+		return
+	}
+
 	if err := run(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -72,9 +83,19 @@ func run() error {
 	sender := notifier.NewSender(db)
 
 	if *flagNotifyOnly {
-		// Only send pending notifications
+		// Only send pending notifications, channel tests and claim notices
 		sent, failed, err := sender.ProcessPending(ctx)
 		fmt.Printf("Notifications: sent=%d, failed=%d\n", sent, failed)
+		if err != nil {
+			return err
+		}
+		tests, err := sender.ProcessChannelTests(ctx)
+		fmt.Printf("Channel tests: %d\n", tests)
+		if err != nil {
+			return err
+		}
+		notices, err := sender.ProcessClaimNotices(ctx)
+		fmt.Printf("Claim notices: %d\n", notices)
 		return err
 	}
 
@@ -238,12 +259,13 @@ func runNativeScrapers(ctx context.Context, db *sql.DB, specificType string) err
 			workerSlots := make([]worker.Slot, len(result.Slots))
 			for i, slot := range result.Slots {
 				workerSlots[i] = worker.Slot{
-					Date:         &slot.Date,
-					TimeFrom:     &slot.TimeFrom,
-					TimeTo:       &slot.TimeTo,
-					CourtName:    &slot.CourtName,
-					RawText:      slot.RawText,
-					FacilityType: name,
+					Date:           &slot.Date,
+					TimeFrom:       &slot.TimeFrom,
+					TimeTo:         &slot.TimeTo,
+					CourtName:      &slot.CourtName,
+					RawText:        slot.RawText,
+					FacilityType:   name,
+					ReservationURL: slot.ReservationURL,
 				}
 			}
 
@@ -1,6 +1,10 @@
 package worker
 
-import "time"
+import (
+	"time"
+
+	"akigura.dev/shared/match"
+)
 
 const (
 	// HTTP Timeouts
@@ -29,6 +33,15 @@ const (
 	NotificationStatusPending = "pending"
 	NotificationStatusSent    = "sent"
 	NotificationStatusFailed  = "failed"
+	NotificationStatusSkipped = "skipped" // over the plan's daily cap
+
+	// Notification channels
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+
+	// Watch condition bundle types
+	BundleTypeCourts = match.BundleTypeCourts
+	BundleTypeDays   = match.BundleTypeDays
 
 	// Facility types
 	FacilityTypeBaseball  = "baseball"
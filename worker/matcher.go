@@ -6,9 +6,10 @@ import (
 	"encoding/json"
 	"log/slog"
 	"slices"
-	"strconv"
+	"strings"
 	"time"
 
+	"akigura.dev/shared/match"
 	"github.com/google/uuid"
 )
 
@@ -24,6 +25,62 @@ type WatchCondition struct {
 	TimeTo     string
 	DateFrom   *string
 	DateTo     *string
+
+	// Relative booking window measured in days from today (JST).
+	// Zero means no limit.
+	MinLeadDays int
+	MaxLeadDays int
+	// ExcludedDates lists dates (YYYY-MM-DD) that should never be notified.
+	ExcludedDates []string
+
+	// BundleType is empty for single-slot conditions, or BundleTypeCourts /
+	// BundleTypeDays when BundleSize slots must be available together.
+	BundleType string
+	BundleSize int
+
+	// Channels are the notification channels for this condition: its own
+	// override, or the team's channels when it has none.
+	Channels []string
+	// Members are the team members opted in to this condition. Each is
+	// notified separately on their own channels.
+	Members []ConditionMember
+}
+
+// ConditionMember is a team member opted in to a watch condition.
+type ConditionMember struct {
+	ID       string
+	Channels []string
+}
+
+// Recipient is who a notification is for: the team's own destination on a
+// channel, or a team member (MemberID set) on one of theirs.
+type Recipient struct {
+	MemberID string
+	Channel  string
+}
+
+// memberArg is the notifications.member_id value for the recipient.
+func (r Recipient) memberArg() any {
+	if r.MemberID == "" {
+		return nil
+	}
+	return r.MemberID
+}
+
+// Recipients returns every recipient of the condition's notifications: the
+// team on each of the condition's channels, then each opted-in member on
+// each of theirs.
+func (c WatchCondition) Recipients() []Recipient {
+	var recipients []Recipient
+	for _, ch := range c.Channels {
+		recipients = append(recipients, Recipient{Channel: ch})
+	}
+	for _, member := range c.Members {
+		for _, ch := range member.Channels {
+			recipients = append(recipients, Recipient{MemberID: member.ID, Channel: ch})
+		}
+	}
+	return recipients
 }
 
 // MatchedSlot represents a slot that matches a condition
@@ -34,11 +91,17 @@ type MatchedSlot struct {
 	TimeFrom  string
 	TimeTo    string
 	CourtName string
+	// New is set when the slot was first scraped after the matcher's since
+	// time. Older slots that are still open can complete a bundle.
+	New bool
 }
 
 // Matcher handles matching slots to watch conditions
 type Matcher struct {
 	DB *sql.DB
+	// Now returns the current time. It defaults to time.Now and can be
+	// overridden in tests to evaluate lead-time constraints deterministically.
+	Now func() time.Time
 }
 
 // NewMatcher creates a new Matcher
@@ -52,7 +115,10 @@ func NewMatcher(db *sql.DB) *Matcher {
 func (m *Matcher) GetActiveConditions(ctx context.Context, groundID string) ([]WatchCondition, error) {
 	rows, err := m.DB.QueryContext(ctx, `
 		SELECT wc.id, wc.team_id, t.email, t.name, wc.facility_id, 
-		       wc.days_of_week, wc.time_from, wc.time_to, wc.date_from, wc.date_to
+		       wc.days_of_week, wc.time_from, wc.time_to, wc.date_from, wc.date_to,
+		       wc.min_lead_days, wc.max_lead_days, wc.excluded_dates,
+		       wc.bundle_type, wc.bundle_size,
+		       COALESCE(wc.channels, t.notification_channels)
 		FROM watch_conditions wc
 		JOIN teams t ON wc.team_id = t.id
 		WHERE wc.facility_id = ? AND wc.enabled = 1 AND t.status = 'active'
@@ -65,9 +131,11 @@ func (m *Matcher) GetActiveConditions(ctx context.Context, groundID string) ([]W
 	var conditions []WatchCondition
 	for rows.Next() {
 		var c WatchCondition
-		var daysJSON string
+		var daysJSON, excludedJSON, channelsJSON string
 		if err := rows.Scan(&c.ID, &c.TeamID, &c.TeamEmail, &c.TeamName, &c.GroundID,
-			&daysJSON, &c.TimeFrom, &c.TimeTo, &c.DateFrom, &c.DateTo); err != nil {
+			&daysJSON, &c.TimeFrom, &c.TimeTo, &c.DateFrom, &c.DateTo,
+			&c.MinLeadDays, &c.MaxLeadDays, &excludedJSON,
+			&c.BundleType, &c.BundleSize, &channelsJSON); err != nil {
 			slog.Warn("failed to scan watch condition row", "error", err)
 			continue
 		}
@@ -75,20 +143,75 @@ func (m *Matcher) GetActiveConditions(ctx context.Context, groundID string) ([]W
 		if err := json.Unmarshal([]byte(daysJSON), &c.DaysOfWeek); err != nil {
 			slog.Debug("failed to parse days_of_week, defaulting to empty", "condition_id", c.ID, "error", err)
 		}
+		if err := json.Unmarshal([]byte(excludedJSON), &c.ExcludedDates); err != nil {
+			slog.Debug("failed to parse excluded_dates, defaulting to empty", "condition_id", c.ID, "error", err)
+		}
+		if err := json.Unmarshal([]byte(channelsJSON), &c.Channels); err != nil || len(c.Channels) == 0 {
+			c.Channels = []string{ChannelEmail}
+		}
 		conditions = append(conditions, c)
 	}
+	rows.Close()
+
+	members, err := m.getConditionMembers(ctx, groundID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range conditions {
+		conditions[i].Members = members[conditions[i].ID]
+	}
 	return conditions, nil
 }
 
-// GetNewSlots retrieves slots that were scraped after the given time.
-// It filters out past dates and only returns future or current slots.
-// The since parameter is typically set to the last scraper run time to avoid duplicate processing.
-func (m *Matcher) GetNewSlots(ctx context.Context, groundID string, since time.Time) ([]MatchedSlot, error) {
+// getConditionMembers returns the members opted in to each watch condition
+// for the ground, keyed by condition ID.
+func (m *Matcher) getConditionMembers(ctx context.Context, groundID string) (map[string][]ConditionMember, error) {
 	rows, err := m.DB.QueryContext(ctx, `
-		SELECT id, ground_id, slot_date, time_from, time_to, COALESCE(court_name, '')
-		FROM slots
-		WHERE ground_id = ? AND scraped_at > ? AND slot_date >= date('now')
-	`, groundID, since)
+		SELECT wcm.watch_condition_id, tm.id, tm.channels
+		FROM watch_condition_members wcm
+		JOIN watch_conditions wc ON wc.id = wcm.watch_condition_id
+		JOIN team_members tm ON tm.id = wcm.member_id AND tm.team_id = wc.team_id
+		WHERE wc.facility_id = ? AND wc.enabled = 1
+		ORDER BY wcm.watch_condition_id, tm.created_at
+	`, groundID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make(map[string][]ConditionMember)
+	for rows.Next() {
+		var conditionID, channelsJSON string
+		var member ConditionMember
+		if err := rows.Scan(&conditionID, &member.ID, &channelsJSON); err != nil {
+			slog.Warn("failed to scan condition member row", "error", err)
+			continue
+		}
+		if err := json.Unmarshal([]byte(channelsJSON), &member.Channels); err != nil {
+			slog.Debug("failed to parse member channels", "member_id", member.ID, "error", err)
+			continue
+		}
+		members[conditionID] = append(members[conditionID], member)
+	}
+	return members, rows.Err()
+}
+
+// GetOpenSlots retrieves the ground's slots that are still open: current or
+// future dates, seen by the municipality's latest completed scrape (the same
+// rule the sender uses before delivering). Slots first scraped after since
+// are marked New; since is typically the last scraper run time, so that
+// single slots are not processed twice.
+func (m *Matcher) GetOpenSlots(ctx context.Context, groundID string, since time.Time) ([]MatchedSlot, error) {
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT id, ground_id, slot_date, time_from, time_to, COALESCE(court_name, ''), scraped_at > ?
+		FROM slots sl
+		WHERE ground_id = ? AND slot_date >= date('now')
+		  AND NOT EXISTS (
+		      SELECT 1 FROM scrape_jobs j
+		      WHERE j.municipality_id = sl.municipality_id AND j.status = 'completed'
+		        AND j.started_at > COALESCE(sl.last_seen_at, sl.scraped_at)
+		  )
+	`, since, groundID)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +220,7 @@ func (m *Matcher) GetNewSlots(ctx context.Context, groundID string, since time.T
 	var slots []MatchedSlot
 	for rows.Next() {
 		var s MatchedSlot
-		if err := rows.Scan(&s.SlotID, &s.GroundID, &s.Date, &s.TimeFrom, &s.TimeTo, &s.CourtName); err != nil {
+		if err := rows.Scan(&s.SlotID, &s.GroundID, &s.Date, &s.TimeFrom, &s.TimeTo, &s.CourtName, &s.New); err != nil {
 			slog.Warn("failed to scan slot row", "error", err)
 			continue
 		}
@@ -106,96 +229,125 @@ func (m *Matcher) GetNewSlots(ctx context.Context, groundID string, since time.T
 	return slots, nil
 }
 
-// MatchSlot determines if a slot satisfies a watch condition's criteria.
-// It checks: (1) day of week, (2) time range overlap, and (3) date range boundaries.
-// Returns true only if all specified criteria in the condition are met.
+// MatchSlot determines if a slot satisfies a watch condition's criteria,
+// using the rules shared with the control plane (akigura.dev/shared/match).
 func (m *Matcher) MatchSlot(slot MatchedSlot, cond WatchCondition) bool {
-	// Parse slot date - handle both YYYY-MM-DD and ISO formats
-	dateStr := slot.Date
-	if len(dateStr) > 10 {
-		dateStr = dateStr[:10] // Trim time portion if present
-	}
-	slotDate, err := time.Parse("2006-01-02", dateStr)
-	if err != nil {
-		return false
-	}
-
-	// Check day of week
-	slotDOW := int(slotDate.Weekday())
-	if len(cond.DaysOfWeek) > 0 && !slices.Contains(cond.DaysOfWeek, slotDOW) {
-		return false
-	}
-
-	// Check time range
-	slotFromMins := parseTimeToMinutes(slot.TimeFrom)
-	slotToMins := parseTimeToMinutes(slot.TimeTo)
-	condFromMins := parseTimeToMinutes(cond.TimeFrom)
-	condToMins := parseTimeToMinutes(cond.TimeTo)
-
-	// Slot must overlap with condition time range
-	if slotToMins <= condFromMins || slotFromMins >= condToMins {
-		return false
-	}
+	return match.Matches(slot.matchSlot(), cond.matchCondition(), m.now())
+}
 
-	// Check date range
-	if cond.DateFrom != nil {
-		dateFrom, _ := time.Parse("2006-01-02", *cond.DateFrom)
-		if slotDate.Before(dateFrom) {
-			return false
-		}
-	}
-	if cond.DateTo != nil {
-		dateTo, _ := time.Parse("2006-01-02", *cond.DateTo)
-		if slotDate.After(dateTo) {
-			return false
-		}
+// now returns the current time from Now, or time.Now when it is unset.
+func (m *Matcher) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
 	}
+	return time.Now()
+}
 
-	return true
+func (s MatchedSlot) matchSlot() match.Slot {
+	return match.Slot{Date: s.Date, TimeFrom: s.TimeFrom, TimeTo: s.TimeTo, CourtName: s.CourtName}
 }
 
-// parseTimeToMinutes converts time strings (HH:MM or HHMM) to minutes since midnight.
-// This enables simple numeric comparison of time ranges.
-func parseTimeToMinutes(t string) int {
-	if len(t) < 4 {
-		return 0
+func (c WatchCondition) matchCondition() match.Condition {
+	mc := match.Condition{
+		DaysOfWeek:    c.DaysOfWeek,
+		TimeFrom:      c.TimeFrom,
+		TimeTo:        c.TimeTo,
+		MinLeadDays:   c.MinLeadDays,
+		MaxLeadDays:   c.MaxLeadDays,
+		ExcludedDates: c.ExcludedDates,
+		BundleType:    c.BundleType,
+		BundleSize:    c.BundleSize,
 	}
-	// Handle HH:MM or HHMM format
-	var h, m int
-	if len(t) == 5 && t[2] == ':' {
-		h, _ = strconv.Atoi(t[0:2])
-		m, _ = strconv.Atoi(t[3:5])
-	} else if len(t) == 4 {
-		h, _ = strconv.Atoi(t[0:2])
-		m, _ = strconv.Atoi(t[2:4])
+	if c.DateFrom != nil {
+		mc.DateFrom = *c.DateFrom
 	}
-	return h*60 + m
+	if c.DateTo != nil {
+		mc.DateTo = *c.DateTo
+	}
+	return mc
 }
 
 // CreateNotification creates a pending notification for a team about a matched slot.
-// It prevents duplicate notifications by checking if the same team/slot/condition combination already exists.
-// The channel parameter specifies the notification method (e.g., "email", "line").
-func (m *Matcher) CreateNotification(ctx context.Context, teamID, conditionID, slotID, channel string) error {
+// It prevents duplicate notifications by checking if the same team/slot/condition/recipient combination already exists.
+// The recipient specifies the notification method (e.g., "email", "line") and, for members, who receives it.
+// Slots the team has already booked are not notified again.
+func (m *Matcher) CreateNotification(ctx context.Context, teamID, conditionID, slotID string, to Recipient) error {
 	// Check if already notified
 	var exists int
 	err := m.DB.QueryRowContext(ctx, `
 		SELECT 1 FROM notifications 
-		WHERE team_id = ? AND slot_id = ? AND watch_condition_id = ?
-	`, teamID, slotID, conditionID).Scan(&exists)
+		WHERE team_id = ? AND slot_id = ? AND watch_condition_id = ? AND channel = ? AND member_id IS ?
+		UNION ALL
+		SELECT 1 FROM slot_bookings WHERE team_id = ? AND slot_id = ?
+	`, teamID, slotID, conditionID, to.Channel, to.memberArg(), teamID, slotID).Scan(&exists)
 	if err == nil {
-		return nil // Already notified
+		return nil // Already notified or booked
 	}
 
 	id := uuid.New().String()
 	_, err = m.DB.ExecContext(ctx, `
-		INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, status, created_at)
-		VALUES (?, ?, ?, ?, ?, 'pending', CURRENT_TIMESTAMP)
-	`, id, teamID, conditionID, slotID, channel)
+		INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, member_id, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, 'pending', CURRENT_TIMESTAMP)
+	`, id, teamID, conditionID, slotID, to.Channel, to.memberArg())
 	return err
 }
 
+// FindBundles groups slots that individually match a bundle condition into
+// complete bundles (see match.FindBundles). Bundles never share a slot.
+func (m *Matcher) FindBundles(slots []MatchedSlot, cond WatchCondition) [][]MatchedSlot {
+	return match.FindBundles(slots, MatchedSlot.matchSlot, cond.matchCondition(), m.now())
+}
+
+// CreateBundleNotification creates one pending notification per slot in the
+// bundle, linked by a bundle ID so the sender delivers them as one package.
+// The bundle ID is derived from the condition and slot IDs, so the same bundle
+// is only notified once per recipient. A bundle with a slot the team has
+// already booked is not notified.
+func (m *Matcher) CreateBundleNotification(ctx context.Context, cond WatchCondition, bundle []MatchedSlot, to Recipient) (bool, error) {
+	slotIDs := make([]string, len(bundle))
+	args := []any{cond.TeamID}
+	for i, s := range bundle {
+		slotIDs[i] = s.SlotID
+		args = append(args, s.SlotID)
+	}
+	bundleID := uuid.NewSHA1(uuid.NameSpaceOID, []byte(cond.ID+":"+strings.Join(slotIDs, ","))).String()
+
+	var exists int
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT 1 FROM notifications WHERE bundle_id = ? AND channel = ? AND member_id IS ?
+		UNION ALL
+		SELECT 1 FROM slot_bookings WHERE team_id = ? AND slot_id IN (?`+strings.Repeat(", ?", len(slotIDs)-1)+`)
+		LIMIT 1
+	`, append([]any{bundleID, to.Channel, to.memberArg()}, args...)...).Scan(&exists)
+	if err == nil {
+		return false, nil // Already notified or booked
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	for _, slotID := range slotIDs {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, member_id, status, bundle_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, 'pending', ?, CURRENT_TIMESTAMP)
+		`, uuid.New().String(), cond.TeamID, cond.ID, slotID, to.Channel, to.memberArg(), bundleID); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
 // ProcessMatches finds all slots that match watch conditions for a specific ground.
 // It retrieves active conditions, compares them against new slots, and creates notifications for matches.
+// Bundle conditions only match when the full bundle is available and count as one match.
+// Bundles are built from every open slot, so a slot completing a set with
+// slots found in earlier scrapes is announced; a bundle needs at least one
+// new slot, so one that was already complete is not announced again.
+// One notification is created per channel of the condition, and per channel
+// of each member opted in to it.
 // Returns the total number of matches found (notifications created).
 func (m *Matcher) ProcessMatches(ctx context.Context, groundID string, since time.Time) (int, error) {
 	conditions, err := m.GetActiveConditions(ctx, groundID)
@@ -203,22 +355,61 @@ func (m *Matcher) ProcessMatches(ctx context.Context, groundID string, since tim
 		return 0, err
 	}
 
-	slots, err := m.GetNewSlots(ctx, groundID, since)
+	slots, err := m.GetOpenSlots(ctx, groundID, since)
 	if err != nil {
 		return 0, err
 	}
 
 	matches := 0
+	var singles []WatchCondition
+	for _, cond := range conditions {
+		if cond.BundleType == "" {
+			singles = append(singles, cond)
+			continue
+		}
+		for _, bundle := range m.FindBundles(slots, cond) {
+			if !slices.ContainsFunc(bundle, func(s MatchedSlot) bool { return s.New }) {
+				continue
+			}
+			for _, to := range cond.Recipients() {
+				created, err := m.CreateBundleNotification(ctx, cond, bundle, to)
+				if err != nil {
+					slog.Warn("failed to create bundle notification", "error", err)
+					continue
+				}
+				if !created {
+					continue
+				}
+				matches++
+				slog.Info("bundle match found",
+					"team", cond.TeamName,
+					"member", to.MemberID,
+					"channel", to.Channel,
+					"bundle_type", cond.BundleType,
+					"slots", len(bundle),
+					"first_date", bundle[0].Date)
+			}
+		}
+	}
+
 	for _, slot := range slots {
-		for _, cond := range conditions {
-			if m.MatchSlot(slot, cond) {
-				if err := m.CreateNotification(ctx, cond.TeamID, cond.ID, slot.SlotID, "email"); err != nil {
+		if !slot.New {
+			continue
+		}
+		for _, cond := range singles {
+			if !m.MatchSlot(slot, cond) {
+				continue
+			}
+			for _, to := range cond.Recipients() {
+				if err := m.CreateNotification(ctx, cond.TeamID, cond.ID, slot.SlotID, to); err != nil {
 					slog.Warn("failed to create notification", "error", err)
 					continue
 				}
 				matches++
 				slog.Info("match found",
 					"team", cond.TeamName,
+					"member", to.MemberID,
+					"channel", to.Channel,
 					"slot_date", slot.Date,
 					"time", slot.TimeFrom+"-"+slot.TimeTo,
 					"court", slot.CourtName)
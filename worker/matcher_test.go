@@ -0,0 +1,285 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"akigura.dev/worker/dbmigrate"
+	_ "modernc.org/sqlite"
+)
+
+func TestMatchSlotBookingWindow(t *testing.T) {
+	// 2025-06-01 00:30 JST is still 2025-05-31 in UTC
+	now := time.Date(2025, 5, 31, 15, 30, 0, 0, time.UTC)
+	m := &Matcher{Now: func() time.Time { return now }}
+
+	slot := func(date string) MatchedSlot {
+		return MatchedSlot{SlotID: date, Date: date, TimeFrom: "09:00", TimeTo: "11:00"}
+	}
+	base := WatchCondition{TimeFrom: "00:00", TimeTo: "24:00"}
+
+	tests := []struct {
+		name string
+		cond func(c WatchCondition) WatchCondition
+		date string
+		want bool
+	}{
+		{"制約がなければ一致すべき", func(c WatchCondition) WatchCondition { return c }, "2025-06-01", true},
+		{"最低リード日数より近い枠は除外されるべき", func(c WatchCondition) WatchCondition { c.MinLeadDays = 3; return c }, "2025-06-03", false},
+		{"最低リード日数ちょうどの枠は一致すべき", func(c WatchCondition) WatchCondition { c.MinLeadDays = 3; return c }, "2025-06-04", true},
+		{"受付期間内の枠は一致すべき", func(c WatchCondition) WatchCondition { c.MaxLeadDays = 14; return c }, "2025-06-15", true},
+		{"受付期間を超える枠は除外されるべき", func(c WatchCondition) WatchCondition { c.MaxLeadDays = 14; return c }, "2025-06-16", false},
+		{"除外日の枠は除外されるべき", func(c WatchCondition) WatchCondition {
+			c.ExcludedDates = []string{"2025-06-07"}
+			return c
+		}, "2025-06-07", false},
+		{"ISO 形式の日付でも除外日を判定すべき", func(c WatchCondition) WatchCondition {
+			c.ExcludedDates = []string{"2025-06-07"}
+			return c
+		}, "2025-06-07T00:00:00Z", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.MatchSlot(slot(tt.date), tt.cond(base)); got != tt.want {
+				t.Errorf("MatchSlot(%s) = %v, want %v", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindBundles(t *testing.T) {
+	now := time.Date(2025, 5, 31, 0, 0, 0, 0, time.UTC)
+	m := &Matcher{Now: func() time.Time { return now }}
+
+	slot := func(date, from, court string) MatchedSlot {
+		return MatchedSlot{SlotID: date + from + court, Date: date, TimeFrom: from, TimeTo: "12:00", CourtName: court}
+	}
+
+	t.Run("同時刻に必要な面数が揃った場合のみセットにすべき", func(t *testing.T) {
+		cond := WatchCondition{TimeFrom: "00:00", TimeTo: "24:00", BundleType: BundleTypeCourts, BundleSize: 2}
+		slots := []MatchedSlot{
+			slot("2025-06-07", "09:00", "B面"),
+			slot("2025-06-07", "09:00", "A面"),
+			slot("2025-06-07", "10:00", "A面"),
+			slot("2025-06-08", "09:00", "A面"),
+		}
+		bundles := m.FindBundles(slots, cond)
+		if len(bundles) != 1 {
+			t.Fatalf("1 セットが見つかるべき: %v", bundles)
+		}
+		if bundles[0][0].CourtName != "A面" || bundles[0][1].CourtName != "B面" {
+			t.Errorf("同時刻の 2 面がセットになるべき: %v", bundles[0])
+		}
+	})
+
+	t.Run("連続した日が揃った場合のみセットにすべき", func(t *testing.T) {
+		cond := WatchCondition{DaysOfWeek: []int{6, 0}, TimeFrom: "00:00", TimeTo: "24:00", BundleType: BundleTypeDays, BundleSize: 2}
+		slots := []MatchedSlot{
+			slot("2025-06-07", "13:00", "A面"), // Sat
+			slot("2025-06-07", "09:00", "A面"),
+			slot("2025-06-08", "09:00", "A面"), // Sun
+			slot("2025-06-14", "09:00", "A面"), // Sat without Sun
+		}
+		bundles := m.FindBundles(slots, cond)
+		if len(bundles) != 1 {
+			t.Fatalf("1 セットが見つかるべき: %v", bundles)
+		}
+		if bundles[0][0].Date != "2025-06-07" || bundles[0][0].TimeFrom != "09:00" || bundles[0][1].Date != "2025-06-08" {
+			t.Errorf("土日の最も早い枠がセットになるべき: %v", bundles[0])
+		}
+	})
+
+	t.Run("条件に一致しない枠はセットに含めないべき", func(t *testing.T) {
+		cond := WatchCondition{TimeFrom: "00:00", TimeTo: "24:00", BundleType: BundleTypeCourts, BundleSize: 2,
+			ExcludedDates: []string{"2025-06-07"}}
+		slots := []MatchedSlot{slot("2025-06-07", "09:00", "A面"), slot("2025-06-07", "09:00", "B面")}
+		if bundles := m.FindBundles(slots, cond); len(bundles) != 0 {
+			t.Errorf("除外日の枠はセットにならないべき: %v", bundles)
+		}
+	})
+}
+
+func TestProcessMatchesChannels(t *testing.T) {
+	const migrationsDir = "../control-plane/db/migrations"
+	const groundID = "d0000001-0001-4000-8000-000000000001" // seeded by 014-grounds-seed-data.sql
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		t.Skip("migrations directory not found (expected in monorepo layout)")
+	}
+	ctx := context.Background()
+
+	newDB := func(t *testing.T) *sql.DB {
+		t.Helper()
+		db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite3"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if err := dbmigrate.RunMigrations(db, migrationsDir); err != nil {
+			t.Fatalf("RunMigrations failed: %v", err)
+		}
+		stmts := []string{
+			`INSERT INTO teams (id, name, email, plan, notification_channels) VALUES ('team-1', 'team-1', 't@example.com', 'pro', '["email","line"]')`,
+			`INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to) VALUES ('wc-1', 'team-1', '` + groundID + `', '[]', '00:00', '24:00')`,
+			`INSERT INTO slots (id, ground_id, slot_date, time_from, time_to, court_name, scraped_at) VALUES ('slot-1', '` + groundID + `', '2099-01-03', '09:00', '11:00', 'A', CURRENT_TIMESTAMP)`,
+		}
+		for _, q := range stmts {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatalf("exec %q: %v", q, err)
+			}
+		}
+		return db
+	}
+
+	channelsOf := func(t *testing.T, db *sql.DB) []string {
+		t.Helper()
+		rows, err := db.Query(`SELECT channel FROM notifications WHERE slot_id = 'slot-1' ORDER BY channel`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var channels []string
+		for rows.Next() {
+			var ch string
+			if err := rows.Scan(&ch); err != nil {
+				t.Fatal(err)
+			}
+			channels = append(channels, ch)
+		}
+		return channels
+	}
+
+	since := time.Now().Add(-time.Hour)
+
+	t.Run("チームの通知先ごとに通知を作成すべき", func(t *testing.T) {
+		db := newDB(t)
+		m := NewMatcher(db)
+		if n, err := m.ProcessMatches(ctx, groundID, since); err != nil || n != 2 {
+			t.Fatalf("ProcessMatches should create two notifications: n=%d err=%v", n, err)
+		}
+		if got := channelsOf(t, db); len(got) != 2 || got[0] != "email" || got[1] != "line" {
+			t.Fatalf("unexpected channels: %v", got)
+		}
+
+		// Re-running does not duplicate per channel
+		if _, err := m.ProcessMatches(ctx, groundID, since); err != nil {
+			t.Fatalf("ProcessMatches failed: %v", err)
+		}
+		if got := channelsOf(t, db); len(got) != 2 {
+			t.Errorf("rerun should not duplicate notifications: %v", got)
+		}
+	})
+
+	t.Run("チームが予約済みの枠は通知を作成しないべき", func(t *testing.T) {
+		db := newDB(t)
+		if _, err := db.Exec(`INSERT INTO slot_bookings (team_id, slot_id, source) VALUES ('team-1', 'slot-1', 'dashboard')`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewMatcher(db).ProcessMatches(ctx, groundID, since); err != nil {
+			t.Fatalf("ProcessMatches failed: %v", err)
+		}
+		if got := channelsOf(t, db); len(got) != 0 {
+			t.Errorf("booked slot should not be notified: %v", got)
+		}
+	})
+
+	t.Run("条件の通知先がチームの設定より優先されるべき", func(t *testing.T) {
+		db := newDB(t)
+		if _, err := db.Exec(`UPDATE watch_conditions SET channels = '["slack"]' WHERE id = 'wc-1'`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewMatcher(db).ProcessMatches(ctx, groundID, since); err != nil {
+			t.Fatalf("ProcessMatches failed: %v", err)
+		}
+		if got := channelsOf(t, db); len(got) != 1 || got[0] != "slack" {
+			t.Fatalf("condition override should be used: %v", got)
+		}
+	})
+
+	t.Run("オプトインしたメンバーにはメンバーの通知方法で個別に通知を作成すべき", func(t *testing.T) {
+		db := newDB(t)
+		stmts := []string{
+			`UPDATE watch_conditions SET channels = '["email"]' WHERE id = 'wc-1'`,
+			`INSERT INTO team_members (id, team_id, name, email, channels) VALUES
+				('member-1', 'team-1', 'A', 'a@example.com', '["email","push"]'),
+				('member-2', 'team-1', 'B', 'b@example.com', '["email"]')`,
+			`INSERT INTO watch_condition_members (watch_condition_id, member_id) VALUES ('wc-1', 'member-1')`,
+		}
+		for _, q := range stmts {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m := NewMatcher(db)
+		for range 2 {
+			if _, err := m.ProcessMatches(ctx, groundID, since); err != nil {
+				t.Fatalf("ProcessMatches failed: %v", err)
+			}
+		}
+
+		rows, err := db.Query(`SELECT COALESCE(member_id, ''), channel FROM notifications WHERE slot_id = 'slot-1' ORDER BY member_id, channel`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var got []string
+		for rows.Next() {
+			var member, channel string
+			if err := rows.Scan(&member, &channel); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, member+":"+channel)
+		}
+		if want := []string{":email", "member-1:email", "member-1:push"}; !slices.Equal(got, want) {
+			t.Errorf("チームとオプトインしたメンバーに1件ずつ作成すべき: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("後のスクレイプで揃ったセットも通知すべき", func(t *testing.T) {
+		db := newDB(t)
+		// slot-1 (court A) was found by an earlier scrape and is still open
+		stmts := []string{
+			`UPDATE watch_conditions SET bundle_type = 'courts', bundle_size = 2 WHERE id = 'wc-1'`,
+			`UPDATE slots SET scraped_at = datetime('now', '-2 hours'), last_seen_at = CURRENT_TIMESTAMP WHERE id = 'slot-1'`,
+		}
+		for _, q := range stmts {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m := NewMatcher(db)
+		if n, err := m.ProcessMatches(ctx, groundID, since); err != nil || n != 0 {
+			t.Fatalf("one court is not a bundle: n=%d err=%v", n, err)
+		}
+
+		// Court B opens in a later scrape
+		if _, err := db.Exec(`INSERT INTO slots (id, ground_id, slot_date, time_from, time_to, court_name, scraped_at)
+			VALUES ('slot-2', '` + groundID + `', '2099-01-03', '09:00', '11:00', 'B', CURRENT_TIMESTAMP)`); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := m.ProcessMatches(ctx, groundID, since); err != nil || n != 2 {
+			t.Fatalf("以前の枠と新しい枠でセットを作るべき: n=%d err=%v", n, err)
+		}
+		var rows, bundles int
+		if err := db.QueryRow(`SELECT COUNT(*), COUNT(DISTINCT bundle_id) FROM notifications WHERE slot_id IN ('slot-1', 'slot-2')`).Scan(&rows, &bundles); err != nil {
+			t.Fatal(err)
+		}
+		if rows != 4 || bundles != 1 {
+			t.Errorf("通知先ごとに2枠のセットを作成すべき: rows=%d bundles=%d", rows, bundles)
+		}
+
+		// Once neither slot is new, the bundle is not announced again, even
+		// if its earlier notifications are gone
+		if _, err := db.Exec(`DELETE FROM notifications`); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := m.ProcessMatches(ctx, groundID, time.Now().Add(time.Hour)); err != nil || n != 0 {
+			t.Errorf("新しい枠のないセットは通知すべきではない: n=%d err=%v", n, err)
+		}
+	})
+}
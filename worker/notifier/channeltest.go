@@ -0,0 +1,189 @@
+package notifier
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Channel tests and health
+//
+// A team can request a test notification on one of its channels from the
+// dashboard (channel_tests, see control-plane/srv/channel_tests.go). The
+// sender picks up pending tests every ChannelTestInterval, sends a sample
+// notification through the Manager exactly like a real one and records the
+// provider's response on the test.
+//
+// Every delivery, test or real, is also recorded in channel_health: a success
+// verifies the channel and resets its failure count, a failure increments it.
+// The dashboard flags channels that are unverified or keep failing.
+
+// ChannelTestInterval is how often pending channel tests are picked up. It
+// is short because the team is waiting for the result.
+const ChannelTestInterval = 5 * time.Second
+
+// channelTestTimeout bounds a test send so a slow provider cannot hold up the
+// sender.
+const channelTestTimeout = 30 * time.Second
+
+// channelTest is a pending test with what is needed to send it.
+type channelTest struct {
+	ID          string
+	TeamID      string
+	TeamName    string
+	TeamEmail   string
+	Channel     string
+	Destination string
+	Secrets     []string
+}
+
+// ProcessChannelTests sends pending test notifications and records their
+// outcome. It returns the number of tests processed.
+func (s *Sender) ProcessChannelTests(ctx context.Context) (int, error) {
+	now := s.now()
+	// Tests left 'sending' by a sender that stopped are not retried: the team
+	// can simply ask again
+	if _, err := s.DB.ExecContext(ctx, `
+		UPDATE channel_tests SET status = 'failed', response = ?, completed_at = CURRENT_TIMESTAMP
+		WHERE status = 'sending' AND created_at <= ?
+	`, errOutcomeUnknown.Error(), now.Add(-DeliveryLease).UTC().Format(time.DateTime)); err != nil {
+		return 0, err
+	}
+
+	tests, err := s.loadChannelTests(ctx)
+	if err != nil {
+		return 0, err
+	}
+	processed := 0
+	for _, t := range tests {
+		res, err := s.DB.ExecContext(ctx, `
+			UPDATE channel_tests SET status = 'sending' WHERE id = ? AND status = 'pending'
+		`, t.ID)
+		if err != nil {
+			return processed, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue // Claimed by another sender
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, channelTestTimeout)
+		sendErr := s.Manager.Send(sendCtx, sampleNotification(t, now))
+		cancel()
+		if err := s.finishChannelTest(ctx, t, sendErr); err != nil {
+			return processed, err
+		}
+		processed++
+		slog.Info("channel test sent", "team", t.TeamID, "channel", t.Channel, "success", sendErr == nil, "error", sendErr)
+	}
+	return processed, nil
+}
+
+// loadChannelTests loads pending tests with the team's destination on the
+// channel, resolved as for real notifications (see pendingSelect).
+func (s *Sender) loadChannelTests(ctx context.Context) ([]channelTest, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT ct.id, ct.team_id, ct.channel, t.name, t.email,
+		       CASE ct.channel
+		           WHEN 'line' THEN CASE WHEN t.line_followed = 1 THEN COALESCE(t.line_user_id, '') ELSE '' END
+		           ELSE COALESCE(d.target, '')
+		       END as destination,
+		       COALESCE(d.secret, '') as secret,
+		       CASE WHEN d.previous_secret_expires_at > datetime('now') THEN d.previous_secret ELSE '' END as previous_secret
+		FROM channel_tests ct
+		JOIN teams t ON ct.team_id = t.id
+		LEFT JOIN notification_destinations d ON d.team_id = ct.team_id AND d.channel = ct.channel
+		WHERE ct.status = 'pending'
+		ORDER BY ct.created_at
+		LIMIT 50
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tests []channelTest
+	for rows.Next() {
+		var t channelTest
+		var secret, previousSecret string
+		if err := rows.Scan(&t.ID, &t.TeamID, &t.Channel, &t.TeamName, &t.TeamEmail, &t.Destination, &secret, &previousSecret); err != nil {
+			slog.Warn("scan channel test", "error", err)
+			continue
+		}
+		for _, sec := range []string{secret, previousSecret} {
+			if sec != "" {
+				t.Secrets = append(t.Secrets, sec)
+			}
+		}
+		tests = append(tests, t)
+	}
+	return tests, rows.Err()
+}
+
+// sampleNotification is the notification sent for a test: one made-up slot a
+// week from now, linking to the dashboard.
+func sampleNotification(t channelTest, now time.Time) *Notification {
+	return &Notification{
+		ID:             t.ID,
+		TeamID:         t.TeamID,
+		TeamName:       t.TeamName,
+		TeamEmail:      t.TeamEmail,
+		Channel:        t.Channel,
+		Destination:    t.Destination,
+		Secrets:        t.Secrets,
+		IdempotencyKey: t.ID,
+		Test:           true,
+		Slots: []SlotInfo{{
+			SlotID:         "test-" + t.ID,
+			SlotDate:       now.In(jst).AddDate(0, 0, 7).Format(time.DateOnly),
+			SlotTime:       "09:00-11:00",
+			CourtName:      "A面",
+			FacilityName:   "サンプル球場",
+			ReservationURL: dashboardURL(),
+		}},
+	}
+}
+
+// finishChannelTest records the outcome of a test and the channel's health
+// in one transaction.
+func (s *Sender) finishChannelTest(ctx context.Context, t channelTest, sendErr error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	status, response := "sent", "delivered"
+	if sendErr != nil {
+		status, response = "failed", sendErr.Error()
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE channel_tests SET status = ?, response = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, status, response, t.ID); err != nil {
+		return err
+	}
+	if err := recordChannelHealth(ctx, tx, t.TeamID, t.Channel, sendErr); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordChannelHealth records a delivery to the team on the channel. A success
+// verifies the channel and resets its failure count; a failure increments it.
+func recordChannelHealth(ctx context.Context, db execer, teamID, channel string, sendErr error) error {
+	if sendErr == nil {
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO channel_health (team_id, channel, verified_at, consecutive_failures, last_success_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP, 0, CURRENT_TIMESTAMP)
+			ON CONFLICT (team_id, channel) DO UPDATE SET
+				verified_at = COALESCE(verified_at, CURRENT_TIMESTAMP), consecutive_failures = 0, last_success_at = CURRENT_TIMESTAMP
+		`, teamID, channel)
+		return err
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO channel_health (team_id, channel, consecutive_failures, last_failure_at, last_error)
+		VALUES (?, ?, 1, CURRENT_TIMESTAMP, ?)
+		ON CONFLICT (team_id, channel) DO UPDATE SET
+			consecutive_failures = consecutive_failures + 1, last_failure_at = CURRENT_TIMESTAMP, last_error = excluded.last_error
+	`, teamID, channel, sendErr.Error())
+	return err
+}
@@ -0,0 +1,95 @@
+package notifier
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestProcessChannelTests(t *testing.T) {
+	ctx := context.Background()
+
+	health := func(t *testing.T, db *sql.DB) (verified bool, failures int, lastError string) {
+		t.Helper()
+		db.QueryRow(`SELECT verified_at IS NOT NULL, consecutive_failures, last_error FROM channel_health WHERE team_id = 'team' AND channel = 'email'`).
+			Scan(&verified, &failures, &lastError)
+		return
+	}
+
+	t.Run("テスト通知を送信しチャネルを確認済みにすべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "free")
+		mustExec(t, db, `INSERT INTO channel_tests (id, team_id, channel) VALUES ('test-1', 'team', 'email')`)
+
+		sender, rec := newTestSender(db)
+		if n, err := sender.ProcessChannelTests(ctx); err != nil || n != 1 {
+			t.Fatalf("1件処理すべき: n=%d err=%v", n, err)
+		}
+		if len(rec.sent) != 1 || !rec.sent[0].Test || rec.sent[0].TeamEmail != "team@example.com" || len(rec.sent[0].Slots) != 1 {
+			t.Fatalf("サンプル通知を送るべき: %+v", rec.sent)
+		}
+		var status, response string
+		db.QueryRow(`SELECT status, response FROM channel_tests WHERE id = 'test-1'`).Scan(&status, &response)
+		if status != "sent" || response != "delivered" {
+			t.Errorf("送信結果を記録すべき: %s %s", status, response)
+		}
+		if verified, failures, _ := health(t, db); !verified || failures != 0 {
+			t.Errorf("チャネルは確認済みになるべき: verified=%v failures=%d", verified, failures)
+		}
+		if n, _ := sender.ProcessChannelTests(ctx); n != 0 {
+			t.Error("処理済みのテストは再送すべきではない")
+		}
+	})
+
+	t.Run("失敗したらプロバイダの応答を記録し失敗回数を数えるべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "free")
+		mustExec(t, db, `INSERT INTO channel_tests (id, team_id, channel) VALUES ('test-1', 'team', 'email'), ('test-2', 'team', 'email')`)
+
+		sender, rec := newTestSender(db)
+		rec.err = errors.New("sendgrid error: 401 invalid api key")
+		if _, err := sender.ProcessChannelTests(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var status, response string
+		db.QueryRow(`SELECT status, response FROM channel_tests WHERE id = 'test-1'`).Scan(&status, &response)
+		if status != "failed" || response != "sendgrid error: 401 invalid api key" {
+			t.Errorf("失敗と応答を記録すべき: %s %s", status, response)
+		}
+		if verified, failures, lastError := health(t, db); verified || failures != 2 || lastError == "" {
+			t.Errorf("連続失敗を数えるべき: verified=%v failures=%d error=%q", verified, failures, lastError)
+		}
+	})
+
+	t.Run("実際の配信結果もチャネルの状態に反映すべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		mustExec(t, db, `INSERT INTO channel_health (team_id, channel, consecutive_failures, last_error) VALUES ('team', 'email', 3, 'timeout')`)
+		seedPending(t, db, "team", "slot-1", "2099-01-03", 60)
+
+		sender, _ := newTestSender(db)
+		if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 1 {
+			t.Fatalf("送信すべき: sent=%d err=%v", sent, err)
+		}
+		if verified, failures, _ := health(t, db); !verified || failures != 0 {
+			t.Errorf("成功で失敗回数をリセットすべき: verified=%v failures=%d", verified, failures)
+		}
+	})
+
+	t.Run("送信途中で停止したテストは失敗にすべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "free")
+		mustExec(t, db, `INSERT INTO channel_tests (id, team_id, channel, status, created_at) VALUES ('test-1', 'team', 'email', 'sending', datetime('now', '-1 hour'))`)
+
+		sender, rec := newTestSender(db)
+		if _, err := sender.ProcessChannelTests(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var status string
+		db.QueryRow(`SELECT status FROM channel_tests WHERE id = 'test-1'`).Scan(&status)
+		if status != "failed" || len(rec.sent) != 0 {
+			t.Errorf("再送せず失敗にすべき: %s sent=%d", status, len(rec.sent))
+		}
+	})
+}
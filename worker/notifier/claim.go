@@ -0,0 +1,358 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Claim notices
+//
+// A member who is booking a slot claims it from the booking page or the
+// dashboard (slot_claims, see control-plane/srv/coordination.go). The sender
+// picks up new claims every ChannelTestInterval and tells the rest of the
+// team: every recipient the slot was sent to other than the claimer, on the
+// same channel, with a link to the same booking page. SMS is left out so
+// that coordination does not use up the team's quota, and so are outgoing
+// webhooks, which announce slots to programs.
+//
+// Notices go through the outbox. Picking up a claim marks it notified and
+// queues one 'pending' message per recipient (notification_messages with
+// claim_id set) in one transaction. Each message is then sent with its ID as
+// the idempotency key; a failed notice is retried with backoff until the
+// claim expires or ends, and a notice left 'sending' by a stopped sender is
+// resent on idempotent channels or marked unknown, as for slot notifications.
+
+// claimNoticeTimeout bounds the send of one notice.
+const claimNoticeTimeout = 30 * time.Second
+
+// slotClaim is a claim to announce.
+type slotClaim struct {
+	ID        int64
+	TeamID    string
+	SlotID    string
+	MemberID  string // empty for the team's representative
+	ClaimedBy string
+	ExpiresAt time.Time
+}
+
+// claimNotice is a queued notice of a claim to one recipient.
+type claimNotice struct {
+	MessageID      string
+	NotificationID string // the slot notification the recipient received
+	Channel        string
+	Attempts       int
+	Claim          slotClaim
+}
+
+// ProcessClaimNotices queues notices of new claims to the other members of
+// the team and sends the notices that are due. It returns the number of
+// notices sent.
+func (s *Sender) ProcessClaimNotices(ctx context.Context) (int, error) {
+	now := s.now()
+	if err := s.recoverClaimNotices(ctx, now); err != nil {
+		return 0, fmt.Errorf("recover interrupted claim notices: %w", err)
+	}
+	claims, err := s.loadClaims(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range claims {
+		if err := s.queueClaimNotices(ctx, c); err != nil {
+			return 0, err
+		}
+	}
+
+	// Notices of claims that were released, taken over, booked or expired
+	// are no longer useful
+	if _, err := s.DB.ExecContext(ctx, `
+		UPDATE notification_messages SET status = 'failed', last_error = 'claim ended'
+		WHERE claim_id IS NOT NULL AND status = 'pending'
+		  AND claim_id NOT IN (SELECT id FROM slot_claims WHERE expires_at > ?)
+	`, now.UTC().Format(time.DateTime)); err != nil {
+		return 0, err
+	}
+
+	notices, err := s.loadClaimNotices(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, cn := range notices {
+		if s.sendClaimNotice(ctx, cn, now) {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// queueClaimNotices marks a claim notified and queues its notices. A claim
+// already marked by another sender, or replaced by a new claim, is skipped.
+func (s *Sender) queueClaimNotices(ctx context.Context, c slotClaim) error {
+	rows, err := s.loadRows(ctx, `
+		WHERE n.id IN (
+			SELECT (SELECT n2.id FROM notifications n2
+			        WHERE n2.team_id = x.team_id AND n2.slot_id = x.slot_id AND n2.channel = x.channel
+			          AND n2.member_id IS x.member_id AND n2.status = 'sent' AND n2.message_id IS NOT NULL
+			        ORDER BY n2.sent_at DESC LIMIT 1)
+			FROM notifications x
+			WHERE x.team_id = ? AND x.slot_id = ? AND x.status = 'sent'
+			  AND x.channel NOT IN ('sms', 'webhook') AND COALESCE(x.member_id, '') != ?
+			GROUP BY x.channel, x.member_id
+		)
+		ORDER BY n.member_id, n.channel
+	`, c.TeamID, c.SlotID, c.MemberID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE slot_claims SET notified_at = CURRENT_TIMESTAMP WHERE id = ? AND notified_at IS NULL
+	`, c.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil // Announced by another sender, or claimed again
+	}
+	queued := 0
+	for _, r := range rows {
+		if !r.ConditionEnabled {
+			continue // The recipient opted out of the slot's condition
+		}
+		var memberID *string
+		if r.MemberID != "" {
+			memberID = &r.MemberID
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO notification_messages (id, team_id, member_id, channel, status, claim_id, notification_id, created_at)
+			VALUES (?, ?, ?, ?, 'pending', ?, ?, CURRENT_TIMESTAMP)
+		`, uuid.New().String(), r.TeamID, memberID, r.Channel, c.ID, r.NotificationID); err != nil {
+			return err
+		}
+		queued++
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.Info("claim announced", "team", c.TeamID, "slot", c.SlotID, "member", c.MemberID, "recipients", queued)
+	return nil
+}
+
+// sendClaimNotice sends a queued notice and records the outcome. It reports
+// whether the send succeeded.
+func (s *Sender) sendClaimNotice(ctx context.Context, cn claimNotice, now time.Time) bool {
+	// Take the lease so that only one sender sends the notice
+	res, err := s.DB.ExecContext(ctx, `
+		UPDATE notification_messages SET status = 'sending', lease_expires_at = ?
+		WHERE id = ? AND status = 'pending'
+	`, now.Add(DeliveryLease).UTC().Format(time.DateTime), cn.MessageID)
+	if err != nil {
+		slog.Warn("claim notice", "message", cn.MessageID, "error", err)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false // Taken by another sender
+	}
+
+	rows, err := s.loadRows(ctx, `
+		WHERE n.id = ?
+	`, cn.NotificationID)
+	if err == nil && len(rows) == 0 {
+		err = fmt.Errorf("%w: notification %s deleted", ErrRecipientUnavailable, cn.NotificationID)
+	}
+	if err == nil {
+		sendCtx, cancel := context.WithTimeout(ctx, claimNoticeTimeout)
+		err = s.Manager.Send(sendCtx, s.claimNotification(rows[0], cn))
+		cancel()
+	}
+	var teamID string
+	if len(rows) > 0 && rows[0].MemberID == "" {
+		teamID = rows[0].TeamID
+	}
+	if ferr := s.finishClaimNotice(ctx, cn, teamID, err, now); ferr != nil {
+		// The notice stays 'sending' and is recovered when its lease expires
+		slog.Error("record claim notice outcome", "message", cn.MessageID, "sent", err == nil, "error", ferr)
+	}
+	if err != nil {
+		slog.Warn("send claim notice failed", "team", cn.Claim.TeamID, "channel", cn.Channel, "attempts", cn.Attempts+1, "error", err)
+		return false
+	}
+	slog.Info("claim notice sent", "team", cn.Claim.TeamID, "slot", cn.Claim.SlotID, "channel", cn.Channel, "message", cn.MessageID)
+	return true
+}
+
+// finishClaimNotice records the outcome of a notice: sent, pending with the
+// next retry time, or failed once retrying is pointless. A notice to the
+// team's own destination (teamID set) also updates the channel's health.
+func (s *Sender) finishClaimNotice(ctx context.Context, cn claimNotice, teamID string, sendErr error, now time.Time) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if teamID != "" {
+		if err := recordChannelHealth(ctx, tx, teamID, cn.Channel, sendErr); err != nil {
+			return err
+		}
+	}
+	if sendErr == nil {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE notification_messages SET status = 'sent', sent_at = CURRENT_TIMESTAMP, attempts = attempts + 1, last_error = NULL, lease_expires_at = NULL
+			WHERE id = ?
+		`, cn.MessageID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	attempts := cn.Attempts + 1
+	next := now.Add(retryBackoff(attempts))
+	if permanent(sendErr) || attempts >= MaxDeliveryAttempts || !next.Before(cn.Claim.ExpiresAt) {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE notification_messages SET status = 'failed', attempts = ?, last_error = ?, lease_expires_at = NULL
+			WHERE id = ?
+		`, attempts, sendErr.Error(), cn.MessageID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE notification_messages SET status = 'pending', attempts = ?, next_attempt_at = ?, last_error = ?, lease_expires_at = NULL
+		WHERE id = ?
+	`, attempts, next.UTC().Format(time.DateTime), sendErr.Error(), cn.MessageID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recoverClaimNotices resolves notices left 'sending' by a sender that
+// stopped before recording the outcome. On idempotent channels they are
+// queued again and resent with the same key; others are marked unknown.
+func (s *Sender) recoverClaimNotices(ctx context.Context, now time.Time) error {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, channel FROM notification_messages
+		WHERE claim_id IS NOT NULL AND status = 'sending' AND lease_expires_at <= ?
+	`, now.UTC().Format(time.DateTime))
+	if err != nil {
+		return err
+	}
+	type stale struct{ id, channel string }
+	var notices []stale
+	for rows.Next() {
+		var m stale
+		if err := rows.Scan(&m.id, &m.channel); err != nil {
+			rows.Close()
+			return err
+		}
+		notices = append(notices, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range notices {
+		status, lastError := "pending", any(nil)
+		if !s.Manager.Idempotent(m.channel) {
+			status, lastError = "unknown", errOutcomeUnknown.Error()
+		}
+		if _, err := s.DB.ExecContext(ctx, `
+			UPDATE notification_messages SET status = ?, last_error = ?, lease_expires_at = NULL
+			WHERE id = ? AND status = 'sending' AND lease_expires_at <= ?
+		`, status, lastError, m.id, now.UTC().Format(time.DateTime)); err != nil {
+			return err
+		}
+		slog.Warn("interrupted claim notice", "message", m.id, "channel", m.channel, "status", status)
+	}
+	return nil
+}
+
+// loadClaims loads open claims that have not been announced yet.
+func (s *Sender) loadClaims(ctx context.Context, now time.Time) ([]slotClaim, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT c.id, c.team_id, c.slot_id, COALESCE(c.member_id, ''),
+		       CASE WHEN c.member_id IS NULL THEN '代表者' ELSE COALESCE(tm.name, '') || ' さん' END,
+		       CAST(strftime('%s', c.expires_at) AS INTEGER)
+		FROM slot_claims c
+		LEFT JOIN team_members tm ON tm.id = c.member_id
+		WHERE c.notified_at IS NULL AND c.expires_at > ?
+		ORDER BY c.claimed_at
+		LIMIT 50
+	`, now.UTC().Format(time.DateTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claims []slotClaim
+	for rows.Next() {
+		var c slotClaim
+		var expires int64
+		if err := rows.Scan(&c.ID, &c.TeamID, &c.SlotID, &c.MemberID, &c.ClaimedBy, &expires); err != nil {
+			slog.Warn("scan slot claim", "error", err)
+			continue
+		}
+		c.ExpiresAt = time.Unix(expires, 0)
+		claims = append(claims, c)
+	}
+	return claims, rows.Err()
+}
+
+// loadClaimNotices loads the queued notices that are due, with their claims.
+func (s *Sender) loadClaimNotices(ctx context.Context, now time.Time) ([]claimNotice, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT m.id, COALESCE(m.notification_id, ''), m.channel, m.attempts,
+		       c.id, c.team_id, c.slot_id, COALESCE(c.member_id, ''),
+		       CASE WHEN c.member_id IS NULL THEN '代表者' ELSE COALESCE(tm.name, '') || ' さん' END,
+		       CAST(strftime('%s', c.expires_at) AS INTEGER)
+		FROM notification_messages m
+		JOIN slot_claims c ON c.id = m.claim_id
+		LEFT JOIN team_members tm ON tm.id = c.member_id
+		WHERE m.status = 'pending' AND (m.next_attempt_at IS NULL OR m.next_attempt_at <= ?)
+		ORDER BY m.created_at
+		LIMIT 200
+	`, now.UTC().Format(time.DateTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notices []claimNotice
+	for rows.Next() {
+		var cn claimNotice
+		var expires int64
+		if err := rows.Scan(&cn.MessageID, &cn.NotificationID, &cn.Channel, &cn.Attempts,
+			&cn.Claim.ID, &cn.Claim.TeamID, &cn.Claim.SlotID, &cn.Claim.MemberID, &cn.Claim.ClaimedBy, &expires); err != nil {
+			slog.Warn("scan claim notice", "error", err)
+			continue
+		}
+		cn.Claim.ExpiresAt = time.Unix(expires, 0)
+		notices = append(notices, cn)
+	}
+	return notices, rows.Err()
+}
+
+// claimNotification is the notice of a claim to one recipient of the slot.
+// Its links are those of the notification the recipient received: the
+// tracked reservation link and the booking page of that message.
+func (s *Sender) claimNotification(r pendingRow, cn claimNotice) *Notification {
+	n := buildNotification([]pendingRow{r})
+	n.IdempotencyKey = cn.MessageID
+	n.Claim = &ClaimInfo{ClaimedBy: cn.Claim.ClaimedBy, Until: cn.Claim.ExpiresAt.In(jst).Format("15:04")}
+	if s.Tracking != nil {
+		n.Slots[0].ReservationURL = s.Tracking.ClickURL(r.NotificationID)
+		n.BookedURL = s.Tracking.BookedURL(r.MessageID)
+	}
+	return n
+}
@@ -0,0 +1,183 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProcessClaimNotices(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	seedTeam(t, db, "team", "pro")
+	mustExec(t, db, `INSERT INTO team_members (id, team_id, name, email, channels) VALUES
+		('member-1', 'team', '佐藤', 'sato@example.com', '["email"]'),
+		('member-2', 'team', '鈴木', 'suzuki@example.com', '["email"]')`)
+	mustExec(t, db, `INSERT INTO watch_condition_members (watch_condition_id, member_id) VALUES ('wc-team', 'member-1'), ('wc-team', 'member-2')`)
+	seedPending(t, db, "team", "slot-1", "2099-01-03", 60)
+	for _, m := range []string{"member-1", "member-2"} {
+		mustExec(t, db, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, member_id, created_at)
+			VALUES (? || '-slot-1', 'team', 'wc-team', 'slot-1', 'email', ?, datetime('now', '-60 minutes'))`, m, m)
+	}
+
+	sender, rec := newTestSender(db)
+	links := &TrackingLinks{BaseURL: "https://akigura.example", Secret: "test-secret"}
+	sender.Tracking = links
+	if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 3 {
+		t.Fatalf("ProcessPending: sent=%d err=%v", sent, err)
+	}
+	rec.sent = nil
+
+	// 佐藤 is booking the slot
+	mustExec(t, db, `INSERT INTO slot_claims (team_id, slot_id, member_id, expires_at)
+		VALUES ('team', 'slot-1', 'member-1', datetime('now', '+30 minutes'))`)
+
+	t.Run("宣言したメンバー以外に宣言を知らせるべき", func(t *testing.T) {
+		n, err := sender.ProcessClaimNotices(ctx)
+		if err != nil || n != 2 {
+			t.Fatalf("ProcessClaimNotices: n=%d err=%v", n, err)
+		}
+		got := map[string]*Notification{}
+		for _, sent := range rec.sent {
+			got[sent.MemberID] = sent
+		}
+		if _, ok := got["member-1"]; ok || got[""] == nil || got["member-2"] == nil {
+			t.Fatalf("チームと鈴木さんに知らせるべき: %+v", rec.sent)
+		}
+		notice := got["member-2"]
+		if notice.Claim == nil || notice.Claim.ClaimedBy != "佐藤 さん" || len(notice.Slots) != 1 {
+			t.Fatalf("宣言の内容を含むべき: %+v", notice)
+		}
+		var messageID string
+		db.QueryRow(`SELECT message_id FROM notifications WHERE id = 'member-2-slot-1'`).Scan(&messageID)
+		if notice.BookedURL != links.BookedURL(messageID) || notice.Slots[0].ReservationURL != links.ClickURL("member-2-slot-1") {
+			t.Errorf("受け取った通知と同じ相談ページへリンクすべき: %s %s", notice.BookedURL, notice.Slots[0].ReservationURL)
+		}
+
+		tmpl, err := LoadEmailTemplates("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := tmpl.Render(notice, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(c.Subject, "佐藤 さんが空き枠を予約します") || !strings.Contains(c.Text, "重複して予約しない") {
+			t.Errorf("宣言のメールになるべき: %s\n%s", c.Subject, c.Text)
+		}
+	})
+
+	t.Run("宣言は1回だけ知らせるべき", func(t *testing.T) {
+		if n, err := sender.ProcessClaimNotices(ctx); err != nil || n != 0 {
+			t.Errorf("ProcessClaimNotices: n=%d err=%v", n, err)
+		}
+	})
+
+	t.Run("期限切れの宣言は知らせないべき", func(t *testing.T) {
+		mustExec(t, db, `UPDATE slot_claims SET notified_at = NULL, expires_at = datetime('now', '-1 minutes')`)
+		if n, err := sender.ProcessClaimNotices(ctx); err != nil || n != 0 {
+			t.Errorf("ProcessClaimNotices: n=%d err=%v", n, err)
+		}
+	})
+
+	// claimNotices returns the statuses of the notices of the current claim
+	claimNotices := func(t *testing.T) map[string]string {
+		t.Helper()
+		rows, err := db.Query(`SELECT m.id, m.status FROM notification_messages m JOIN slot_claims c ON c.id = m.claim_id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		statuses := map[string]string{}
+		for rows.Next() {
+			var id, status string
+			rows.Scan(&id, &status)
+			statuses[id] = status
+		}
+		return statuses
+	}
+	claimAgain := func(t *testing.T) {
+		t.Helper()
+		mustExec(t, db, `DELETE FROM slot_claims`)
+		mustExec(t, db, `INSERT INTO slot_claims (team_id, slot_id, member_id, expires_at)
+			VALUES ('team', 'slot-1', 'member-1', datetime('now', '+30 minutes'))`)
+	}
+
+	t.Run("送れなかった宣言の通知は同じ冪等キーで再送すべき", func(t *testing.T) {
+		claimAgain(t)
+		rec.sent = nil
+		rec.err = errors.New("connection refused")
+		if n, err := sender.ProcessClaimNotices(ctx); err != nil || n != 0 {
+			t.Fatalf("ProcessClaimNotices: n=%d err=%v", n, err)
+		}
+		notices := claimNotices(t)
+		if len(notices) != 2 {
+			t.Fatalf("宛先ごとに通知を積むべき: %v", notices)
+		}
+		for id, status := range notices {
+			if status != "pending" {
+				t.Errorf("失敗した通知は再送を待つべき: %s %s", id, status)
+			}
+		}
+
+		rec.err = nil
+		if n, err := sender.ProcessClaimNotices(ctx); err != nil || n != 0 {
+			t.Errorf("再送時刻までは送らないべき: n=%d err=%v", n, err)
+		}
+		mustExec(t, db, `UPDATE notification_messages SET next_attempt_at = datetime('now', '-1 minutes') WHERE claim_id IS NOT NULL`)
+		if n, err := sender.ProcessClaimNotices(ctx); err != nil || n != 2 {
+			t.Fatalf("ProcessClaimNotices: n=%d err=%v", n, err)
+		}
+		for _, sent := range rec.sent {
+			if notices[sent.IdempotencyKey] == "" {
+				t.Errorf("積んだ通知のIDを冪等キーにすべき: %s", sent.IdempotencyKey)
+			}
+		}
+		for id, status := range claimNotices(t) {
+			if status != "sent" {
+				t.Errorf("再送した通知は送信済みになるべき: %s %s", id, status)
+			}
+		}
+	})
+
+	t.Run("取り消された宣言の通知は再送しないべき", func(t *testing.T) {
+		claimAgain(t)
+		rec.sent = nil
+		rec.err = errors.New("connection refused")
+		if n, err := sender.ProcessClaimNotices(ctx); err != nil || n != 0 {
+			t.Fatalf("ProcessClaimNotices: n=%d err=%v", n, err)
+		}
+		rec.err = nil
+		mustExec(t, db, `UPDATE notification_messages SET next_attempt_at = datetime('now', '-1 minutes') WHERE claim_id IS NOT NULL`)
+		mustExec(t, db, `DELETE FROM slot_claims`)
+		if n, err := sender.ProcessClaimNotices(ctx); err != nil || n != 0 || len(rec.sent) != 0 {
+			t.Fatalf("ProcessClaimNotices: n=%d err=%v sent=%d", n, err, len(rec.sent))
+		}
+		var pending int
+		db.QueryRow(`SELECT COUNT(*) FROM notification_messages WHERE claim_id IS NOT NULL AND status = 'pending'`).Scan(&pending)
+		if pending != 0 {
+			t.Errorf("終わった宣言の通知は失敗にすべき: %d", pending)
+		}
+	})
+}
+
+func TestProcessPendingBookedSlot(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	seedTeam(t, db, "team", "pro")
+	seedPending(t, db, "team", "slot-1", "2099-01-03", 60)
+	seedPending(t, db, "team", "slot-2", "2099-01-04", 60)
+	mustExec(t, db, `INSERT INTO slot_bookings (team_id, slot_id, source) VALUES ('team', 'slot-2', 'link')`)
+
+	sender, rec := newTestSender(db)
+	if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 1 {
+		t.Fatalf("ProcessPending: sent=%d err=%v", sent, err)
+	}
+	if len(rec.sent) != 1 || len(rec.sent[0].Slots) != 1 || rec.sent[0].Slots[0].SlotID != "slot-1" {
+		t.Errorf("予約済みの枠は送らないべき: %+v", rec.sent)
+	}
+	if got := statusOf(t, db, "team-slot-2"); got != "skipped" {
+		t.Errorf("予約済みの枠の通知はスキップされるべき: %s", got)
+	}
+}
@@ -0,0 +1,47 @@
+package notifier
+
+import "time"
+
+// Digest modes. A team or watch condition in a digest mode receives one
+// summary at its digest time instead of an alert per match.
+const (
+	DigestInstant = "instant"
+	DigestDaily   = "daily"
+	DigestWeekly  = "weekly"
+)
+
+// NextDigestAt returns the first digest time strictly after now for the
+// given mode. at is the time of day in JST (HH:MM) and weekday the day of a
+// weekly digest (0=Sun). An unparsable time falls back to 08:00.
+func NextDigestAt(mode, at string, weekday int, now time.Time) time.Time {
+	tod, err := time.Parse("15:04", at)
+	if err != nil {
+		tod = time.Date(0, 1, 1, 8, 0, 0, 0, time.UTC)
+	}
+	local := now.In(jst)
+	next := time.Date(local.Year(), local.Month(), local.Day(), tod.Hour(), tod.Minute(), 0, 0, jst)
+	if mode == DigestWeekly {
+		next = next.AddDate(0, 0, (weekday-int(next.Weekday())+7)%7)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 7)
+		}
+		return next
+	}
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// IsDigest reports whether the notification is a daily or weekly digest.
+func (n *Notification) IsDigest() bool {
+	return n.Digest == DigestDaily || n.Digest == DigestWeekly
+}
+
+// DigestLabel names the digest for headings, e.g. "本日のまとめ".
+func (n *Notification) DigestLabel() string {
+	if n.Digest == DigestWeekly {
+		return "今週のまとめ"
+	}
+	return "本日のまとめ"
+}
@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextDigestAt(t *testing.T) {
+	// 2026-10-18 is a Sunday
+	now := time.Date(2026, 10, 18, 7, 30, 0, 0, jst)
+	tests := []struct {
+		name, mode, at string
+		weekday        int
+		want           time.Time
+	}{
+		{"日次は当日の配信時刻", DigestDaily, "08:00", 0, time.Date(2026, 10, 18, 8, 0, 0, 0, jst)},
+		{"日次で時刻を過ぎていれば翌日", DigestDaily, "07:00", 0, time.Date(2026, 10, 19, 7, 0, 0, 0, jst)},
+		{"週次は次の指定曜日", DigestWeekly, "08:00", 1, time.Date(2026, 10, 19, 8, 0, 0, 0, jst)},
+		{"週次で当日の時刻前なら当日", DigestWeekly, "08:00", 0, time.Date(2026, 10, 18, 8, 0, 0, 0, jst)},
+		{"週次で当日の時刻を過ぎていれば翌週", DigestWeekly, "07:00", 0, time.Date(2026, 10, 25, 7, 0, 0, 0, jst)},
+		{"不正な時刻は08:00", DigestDaily, "bad", 0, time.Date(2026, 10, 18, 8, 0, 0, 0, jst)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NextDigestAt(tt.mode, tt.at, tt.weekday, now); !got.Equal(tt.want) {
+				t.Errorf("NextDigestAt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestSections(t *testing.T) {
+	n := &Notification{Digest: DigestDaily, Slots: []SlotInfo{
+		{SlotID: "3", FacilityName: "B球場", SlotDate: "2026-10-20", SlotTime: "09:00-11:00"},
+		{SlotID: "2", FacilityName: "A球場", SlotDate: "2026-10-21", SlotTime: "09:00-11:00"},
+		{SlotID: "1", FacilityName: "A球場", SlotDate: "2026-10-20", SlotTime: "13:00-15:00", CourtName: "A面"},
+		{SlotID: "0", FacilityName: "A球場", SlotDate: "2026-10-20", SlotTime: "09:00-11:00", CourtName: "B面"},
+	}}
+	sections := n.Sections()
+	if len(sections) != 3 {
+		t.Fatalf("施設と日付ごとに3セクションになるべき: %+v", sections)
+	}
+	if s := sections[0]; s.FacilityName != "A球場" || s.SlotDate != "2026-10-20" || len(s.Slots) != 2 || s.Slots[0].SlotID != "0" {
+		t.Errorf("最初のセクションはA球場の10/20で時刻順になるべき: %+v", s)
+	}
+	if sections[2].FacilityName != "B球場" {
+		t.Errorf("施設名順に並ぶべき: %+v", sections)
+	}
+
+	var texts []string
+	for _, sec := range sections {
+		texts = append(texts, sectionText(sec))
+	}
+	text := strings.Join(texts, "\n")
+	if !strings.Contains(text, "■ A球場 2026-10-20") || !strings.Contains(text, "・13:00-15:00 A面") {
+		t.Errorf("テキストはセクション見出しと枠の行を含むべき:\n%s", text)
+	}
+	if headline(n) != "本日のまとめ: 空き枠4件" {
+		t.Errorf("unexpected headline: %s", headline(n))
+	}
+}
+
+func TestProcessPendingDigest(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("配信時刻まで保留し残っている枠だけをまとめて送るべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		mustExec(t, db, `UPDATE teams SET digest_mode = 'daily', digest_time = '08:00' WHERE id = 'team'`)
+		seedPending(t, db, "team", "slot-1", "2099-01-03", 1)
+		seedPending(t, db, "team", "slot-2", "2099-01-04", 1)
+
+		sender, rec := newTestSender(db)
+		sender.Now = func() time.Time { return time.Date(2026, 10, 18, 7, 0, 0, 0, jst) }
+		if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 0 {
+			t.Fatalf("配信時刻前は送信すべきではない: sent=%d err=%v", sent, err)
+		}
+		var digest, next string
+		db.QueryRow(`SELECT digest, next_attempt_at FROM notifications WHERE id = 'team-slot-1'`).Scan(&digest, &next)
+		if digest != DigestDaily || !strings.HasPrefix(next, "2026-10-17") || !strings.Contains(next, "23:00:00") {
+			t.Errorf("次の配信時刻まで保留すべき: digest=%s next=%s", digest, next)
+		}
+
+		// The latest scrape no longer found slot-2
+		mustExec(t, db, `INSERT INTO scrape_jobs (id, municipality_id, status, started_at) VALUES ('job-1', ?, 'completed', datetime('now', '+1 minute'))`, testMunicipalityID)
+		mustExec(t, db, `UPDATE slots SET last_seen_at = datetime('now', '+2 minutes') WHERE id = 'slot-1'`)
+
+		sender.Now = func() time.Time { return time.Date(2026, 10, 18, 8, 1, 0, 0, jst) }
+		sent, _, err := sender.ProcessPending(ctx)
+		if err != nil || sent != 1 {
+			t.Fatalf("まとめを1件送信すべき: sent=%d err=%v", sent, err)
+		}
+		if len(rec.sent) != 1 || rec.sent[0].Digest != DigestDaily || len(rec.sent[0].Slots) != 1 || rec.sent[0].Slots[0].SlotID != "slot-1" {
+			t.Fatalf("残っている枠だけのまとめになるべき: %+v", rec.sent)
+		}
+		if got := statusOf(t, db, "team-slot-2"); got != "skipped" {
+			t.Errorf("消えた枠はスキップされるべき, got %s", got)
+		}
+	})
+
+	t.Run("条件ごとのまとめ設定がチームの即時設定より優先されるべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		mustExec(t, db, `UPDATE watch_conditions SET digest_mode = 'weekly' WHERE id = 'wc-team'`)
+		seedPending(t, db, "team", "slot-1", "2099-01-03", 1)
+
+		sender, rec := newTestSender(db)
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.sent) != 0 {
+			t.Errorf("週次まとめの条件は即時送信すべきではない")
+		}
+		var digest string
+		db.QueryRow(`SELECT COALESCE(digest, '') FROM notifications WHERE id = 'team-slot-1'`).Scan(&digest)
+		if digest != DigestWeekly {
+			t.Errorf("週次まとめとして保留すべき: %q", digest)
+		}
+	})
+}
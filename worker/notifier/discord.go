@@ -0,0 +1,135 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const discordEmbedColor = 0x4F46E5
+
+// DiscordNotifier sends notifications to the team's Discord channel webhook,
+// which is carried in Notification.Destination. Each section becomes one
+// embed; sections that do not fit in one message are summarized (see
+// Layout).
+type DiscordNotifier struct {
+	Client *http.Client
+}
+
+// NewDiscordNotifier creates a new Discord notifier
+func NewDiscordNotifier() *DiscordNotifier {
+	return &DiscordNotifier{
+		Client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func (d *DiscordNotifier) Channel() string {
+	return "discord"
+}
+
+// Send posts the notification as one message, so that a retried delivery
+// never repeats part of it.
+func (d *DiscordNotifier) Send(ctx context.Context, n *Notification) error {
+	if n.Destination == "" {
+		return fmt.Errorf("%w: no Discord webhook registered", ErrRecipientUnavailable)
+	}
+
+	if len(n.Slots) == 0 {
+		return nil
+	}
+
+	p := Layout(n, discordLimits, discordEmbedSize, dashboardURL())[0]
+	content := fmt.Sprintf("⚾ **%s** 様　%s。%s", n.TeamName, headline(n), closing(n))
+	if n.BookedURL != "" {
+		content += fmt.Sprintf("\n[予約の宣言・投票・記録](<%s>)でチームと相談できます。", n.BookedURL)
+	}
+	var embeds []map[string]interface{}
+	for _, sec := range p.Sections {
+		embeds = append(embeds, discordEmbed(sec))
+	}
+	if p.Omitted > 0 {
+		embeds = append(embeds, map[string]interface{}{
+			"description": fmt.Sprintf("ほか %d 件の空き枠があります。[すべての空き枠を見る](%s)", p.Omitted, p.MoreURL),
+			"color":       discordEmbedColor,
+		})
+	}
+
+	payload := map[string]interface{}{
+		"username": "AkiGura",
+		"content":  content,
+		"embeds":   embeds,
+		// Never ping @everyone or roles from slot data
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal Discord message: %w", err)
+	}
+	return postWebhook(ctx, d.Client, n.Destination, body, "discord")
+}
+
+// discordEmbed renders a section as an embed with one field per slot. The
+// embed links the section's reservation page, or each field its slot's.
+func discordEmbed(sec Section) map[string]interface{} {
+	var fields []map[string]interface{}
+	for _, slot := range sec.Slots {
+		fields = append(fields, map[string]interface{}{
+			"name":   discordFieldName(sec, slot),
+			"value":  discordFieldValue(sec, slot),
+			"inline": true,
+		})
+	}
+	if line := sec.HiddenLine(); line != "" {
+		fields = append(fields, map[string]interface{}{
+			"name":   "…",
+			"value":  line,
+			"inline": true,
+		})
+	}
+	embed := map[string]interface{}{
+		"title":  sec.Title(),
+		"color":  discordEmbedColor,
+		"fields": fields,
+	}
+	if sec.ReservationURL != "" {
+		embed["url"] = sec.ReservationURL
+	}
+	return embed
+}
+
+func discordFieldName(sec Section, slot SlotInfo) string {
+	if sec.SlotDate == "" {
+		return slot.SlotDate + " " + slot.SlotTime
+	}
+	return slot.SlotTime
+}
+
+func discordFieldValue(sec Section, slot SlotInfo) string {
+	if u := sec.SlotURL(slot); u != "" {
+		return fmt.Sprintf("[%s](%s)", orDash(slot.CourtName), u)
+	}
+	return orDash(slot.CourtName)
+}
+
+// discordEmbedSize counts the characters of an embed that Discord limits to
+// 6000 per message: the title and the field names and values.
+func discordEmbedSize(sec Section) int {
+	size := len([]rune(sec.Title()))
+	for _, slot := range sec.Slots {
+		size += len([]rune(discordFieldName(sec, slot))) + len([]rune(discordFieldValue(sec, slot)))
+	}
+	if line := sec.HiddenLine(); line != "" {
+		size += 1 + len([]rune(line))
+	}
+	return size
+}
+
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
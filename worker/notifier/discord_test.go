@@ -0,0 +1,110 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDiscordNotifier(t *testing.T) {
+	ctx := context.Background()
+
+	var got map[string]any
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	n := &Notification{
+		TeamName:    "team",
+		Channel:     "discord",
+		Destination: srv.URL,
+		Slots: []SlotInfo{
+			{SlotID: "a", SlotDate: "2099-01-03", SlotTime: "09:00-11:00", CourtName: "A面", FacilityName: "球場", BundleID: "b1", BundleLabel: "2面同時"},
+			{SlotID: "b", SlotDate: "2099-01-03", SlotTime: "09:00-11:00", CourtName: "B面", FacilityName: "球場", BundleID: "b1", BundleLabel: "2面同時"},
+			{SlotID: "c", SlotDate: "2099-01-04", SlotTime: "13:00-15:00", CourtName: "A面", FacilityName: "球場", ReservationURL: "https://example.com/reserve"},
+		},
+	}
+
+	t.Run("施設と日付のセクションごとにembedを作るべき", func(t *testing.T) {
+		if err := NewDiscordNotifier().Send(ctx, n); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		embeds, _ := got["embeds"].([]any)
+		if len(embeds) != 2 {
+			t.Fatalf("one embed per group expected: %+v", got)
+		}
+		bundle := embeds[0].(map[string]any)
+		if bundle["title"] != "球場 2099-01-03（2面同時セット）" || len(bundle["fields"].([]any)) != 2 {
+			t.Errorf("bundle embed should list both courts: %+v", bundle)
+		}
+		if embeds[1].(map[string]any)["url"] != "https://example.com/reserve" {
+			t.Errorf("reservation URL should link the embed: %+v", embeds[1])
+		}
+	})
+
+	t.Run("embedが上限を超えるときは1通に収めて残りを件数で示すべき", func(t *testing.T) {
+		var posts []map[string]any
+		split := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var p map[string]any
+			json.NewDecoder(r.Body).Decode(&p)
+			posts = append(posts, p)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer split.Close()
+
+		many := *n
+		many.Destination = split.URL
+		many.Slots = nil
+		for day := range 15 {
+			many.Slots = append(many.Slots, SlotInfo{SlotID: fmt.Sprint(day), SlotDate: fmt.Sprintf("2099-01-%02d", day+1), SlotTime: "09:00-11:00", FacilityName: "球場"})
+		}
+		if err := NewDiscordNotifier().Send(ctx, &many); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if len(posts) != 1 {
+			t.Fatalf("1通だけ送るべき: %d", len(posts))
+		}
+		embeds := posts[0]["embeds"].([]any)
+		if len(embeds) > 10 {
+			t.Errorf("embedは10個までにすべき: %d", len(embeds))
+		}
+		last := embeds[len(embeds)-1].(map[string]any)
+		if desc, _ := last["description"].(string); !strings.Contains(desc, fmt.Sprintf("ほか %d 件", 15-(len(embeds)-1))) {
+			t.Errorf("収まらない日付は件数で示すべき: %+v", last)
+		}
+	})
+
+	t.Run("削除されたwebhookは再送不能なエラーにすべき", func(t *testing.T) {
+		status = http.StatusNotFound
+		defer func() { status = http.StatusNoContent }()
+		if err := NewDiscordNotifier().Send(ctx, n); !errors.Is(err, ErrRecipientUnavailable) {
+			t.Errorf("expected ErrRecipientUnavailable, got %v", err)
+		}
+	})
+
+	t.Run("登録されたwebhook URLへ送信すべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "pro-team", "pro")
+		seedPending(t, db, "pro-team", "slot-1", "2099-01-03", 60)
+		mustExec(t, db, `UPDATE notifications SET channel = 'discord'`)
+		mustExec(t, db, `INSERT INTO notification_destinations (team_id, channel, target) VALUES ('pro-team', 'discord', ?)`, srv.URL)
+
+		got = nil
+		sender, _ := newTestSender(db)
+		sender.Manager.Register(NewDiscordNotifier())
+		if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 1 {
+			t.Fatalf("discord notification should be sent: sent=%d err=%v", sent, err)
+		}
+		if got == nil {
+			t.Fatal("webhook should receive the notification")
+		}
+	})
+}
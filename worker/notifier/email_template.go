@@ -0,0 +1,200 @@
+package notifier
+
+import (
+	"bytes"
+	"cmp"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Email templates
+//
+// Notification emails are rendered from three templates: the subject, a plain
+// text body and an HTML body. Defaults are embedded from templates/; an
+// operator can override any of them by placing a file with the same name in
+// EMAIL_TEMPLATE_DIR. Templates receive an EmailData.
+const (
+	emailSubjectTemplate = "email_subject.txt.tmpl"
+	emailTextTemplate    = "email.txt.tmpl"
+	emailHTMLTemplate    = "email.html.tmpl"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// EmailData is the data passed to the email templates.
+type EmailData struct {
+	TeamName    string
+	MemberName  string // set when the email is for a team member
+	Count       int    // number of slots
+	Digest      bool   // a daily/weekly digest rather than new matches
+	Test        bool   // a sample sent to verify the channel
+	DigestLabel string
+	Sections    []EmailSection
+	// Signed links pausing the conditions in this email, or all of the
+	// team's conditions. Empty when unsubscribe links are not configured.
+	UnsubscribeURL    string
+	UnsubscribeAllURL string
+	// BookedURL is the page for claiming, voting on and recording the
+	// booking of the slots; empty when tracking links are not configured.
+	BookedURL string
+	// ClaimedBy and ClaimUntil are set when the email tells the member that
+	// someone else is booking the slot (see Notification.Claim).
+	ClaimedBy  string
+	ClaimUntil string
+}
+
+// EmailSection is one block of an email: the slots of one ground on one date,
+// or of one bundle (see Section).
+type EmailSection struct {
+	Title          string // ground name, with the date in a digest
+	Label          string // bundle label, e.g. "2面同時"; empty otherwise
+	ReservationURL string // shared by the slots; else each slot has its own
+	Slots          []SlotInfo
+}
+
+// EmailContent is a rendered email.
+type EmailContent struct {
+	Subject string
+	Text    string
+	HTML    string
+	// UnsubscribeURL is the one-click unsubscribe URL for the
+	// List-Unsubscribe header (RFC 8058), if any.
+	UnsubscribeURL string
+}
+
+// EmailTemplates renders notification emails.
+type EmailTemplates struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+var templateFuncs = map[string]any{
+	"inc": func(i int) int { return i + 1 },
+}
+
+// LoadEmailTemplates loads the email templates, preferring files in dir over
+// the embedded defaults. An empty dir uses the defaults only.
+func LoadEmailTemplates(dir string) (*EmailTemplates, error) {
+	read := func(name string) (string, error) {
+		if dir != "" {
+			b, err := os.ReadFile(filepath.Join(dir, name))
+			if err == nil {
+				return string(b), nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+		}
+		b, err := defaultTemplates.ReadFile("templates/" + name)
+		return string(b), err
+	}
+
+	var t EmailTemplates
+	src, err := read(emailSubjectTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if t.subject, err = texttemplate.New(emailSubjectTemplate).Funcs(templateFuncs).Parse(src); err != nil {
+		return nil, err
+	}
+	if src, err = read(emailTextTemplate); err != nil {
+		return nil, err
+	}
+	if t.text, err = texttemplate.New(emailTextTemplate).Funcs(templateFuncs).Parse(src); err != nil {
+		return nil, err
+	}
+	if src, err = read(emailHTMLTemplate); err != nil {
+		return nil, err
+	}
+	if t.html, err = htmltemplate.New(emailHTMLTemplate).Funcs(templateFuncs).Parse(src); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// defaultEmailTemplates loads the templates from EMAIL_TEMPLATE_DIR, falling
+// back to the embedded defaults if they cannot be loaded.
+func defaultEmailTemplates() *EmailTemplates {
+	t, err := LoadEmailTemplates(os.Getenv("EMAIL_TEMPLATE_DIR"))
+	if err != nil {
+		slog.Warn("load email templates; using defaults", "error", err)
+		t, _ = LoadEmailTemplates("")
+	}
+	return t
+}
+
+// Render renders the subject and bodies of a notification email, with
+// unsubscribe links when unsub is not nil.
+func (t *EmailTemplates) Render(n *Notification, unsub *UnsubscribeLinks) (EmailContent, error) {
+	data := emailData(n)
+	data.UnsubscribeURL = unsub.ConditionsURL(n)
+	data.UnsubscribeAllURL = unsub.AllURL(n)
+	c, err := t.RenderData(data)
+	if err != nil {
+		return c, err
+	}
+	c.UnsubscribeURL = cmp.Or(data.UnsubscribeURL, data.UnsubscribeAllURL)
+	return c, nil
+}
+
+// RenderData renders the templates with the given data.
+func (t *EmailTemplates) RenderData(data EmailData) (EmailContent, error) {
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return EmailContent{}, fmt.Errorf("render subject: %w", err)
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return EmailContent{}, fmt.Errorf("render text body: %w", err)
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return EmailContent{}, fmt.Errorf("render HTML body: %w", err)
+	}
+	return EmailContent{
+		// Header values must be a single line
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// emailData builds the template data for a notification.
+func emailData(n *Notification) EmailData {
+	data := EmailData{
+		TeamName:   n.TeamName,
+		MemberName: n.MemberName,
+		Count:      len(n.Slots),
+		Digest:     n.IsDigest(),
+		Test:       n.Test,
+		BookedURL:  n.BookedURL,
+	}
+	if data.Digest {
+		data.DigestLabel = n.DigestLabel()
+	}
+	if n.Claim != nil {
+		data.ClaimedBy, data.ClaimUntil = n.Claim.ClaimedBy, n.Claim.Until
+	}
+	for _, sec := range n.Sections() {
+		title := sec.FacilityName
+		if data.Digest {
+			// Slot lines carry the date in an alert
+			title = sec.Title()
+		}
+		data.Sections = append(data.Sections, EmailSection{
+			Title:          title,
+			Label:          sec.Label,
+			ReservationURL: sec.ReservationURL,
+			Slots:          sec.Slots,
+		})
+	}
+	return data
+}
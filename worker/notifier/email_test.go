@@ -0,0 +1,131 @@
+package notifier
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailTemplatesRender(t *testing.T) {
+	tmpl, err := LoadEmailTemplates("")
+	if err != nil {
+		t.Fatalf("既定テンプレートを読み込めるべき: %v", err)
+	}
+	slots := []SlotInfo{
+		{SlotID: "1", FacilityName: "A球場", SlotDate: "2026-10-20", SlotTime: "09:00-11:00", CourtName: "A面", ReservationURL: "https://example.com/a"},
+		{SlotID: "2", FacilityName: "B球場", SlotDate: "2026-10-21", SlotTime: "13:00-15:00", CourtName: "<B面>"},
+	}
+
+	t.Run("新着通知はテキストとHTMLの両方に枠を含むべき", func(t *testing.T) {
+		c, err := tmpl.Render(&Notification{TeamName: "テストチーム", Slots: slots}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Subject != "【AkiGura】空き枠が見つかりました（2件）" {
+			t.Errorf("unexpected subject: %q", c.Subject)
+		}
+		for _, want := range []string{"テストチーム 様", "【1】A球場", "・2026-10-20 09:00-11:00 A面", "予約: https://example.com/a"} {
+			if !strings.Contains(c.Text, want) {
+				t.Errorf("テキストに %q を含むべき:\n%s", want, c.Text)
+			}
+		}
+		if !strings.Contains(c.HTML, `href="https://example.com/a"`) || !strings.Contains(c.HTML, "&lt;B面&gt;") {
+			t.Errorf("HTMLはリンクを含みエスケープされるべき:\n%s", c.HTML)
+		}
+	})
+
+	t.Run("まとめ通知はまとめの件名になるべき", func(t *testing.T) {
+		c, err := tmpl.Render(&Notification{TeamName: "テストチーム", Digest: DigestWeekly, Slots: slots}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Subject != "【AkiGura】今週のまとめ（空き枠2件）" {
+			t.Errorf("unexpected subject: %q", c.Subject)
+		}
+		if !strings.Contains(c.Text, "【1】A球場 2026-10-20") || !strings.Contains(c.Text, "・09:00-11:00 A面") {
+			t.Errorf("施設と日付ごとのセクションになるべき:\n%s", c.Text)
+		}
+	})
+}
+
+func TestLoadEmailTemplatesOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, emailSubjectTemplate), []byte("{{.TeamName}}へのお知らせ\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := LoadEmailTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := tmpl.Render(&Notification{TeamName: "テストチーム", Slots: []SlotInfo{{SlotID: "1", FacilityName: "A球場"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "テストチームへのお知らせ" {
+		t.Errorf("上書きした件名を使うべき: %q", c.Subject)
+	}
+	if !strings.Contains(c.Text, "A球場") {
+		t.Errorf("上書きしていない本文は既定を使うべき:\n%s", c.Text)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, emailTextTemplate), []byte("{{.Broken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadEmailTemplates(dir); err == nil {
+		t.Error("構文エラーのテンプレートはエラーになるべき")
+	}
+}
+
+func TestBuildMIMEMessage(t *testing.T) {
+	content := EmailContent{Subject: "【AkiGura】空き枠が見つかりました（1件）", Text: "本文です\n", HTML: "<p>本文です</p>"}
+	raw, err := buildMIMEMessage(mail.Address{Name: "アキグラ", Address: "noreply@akigura.jp"}, "team@example.com", content, time.Date(2026, 10, 18, 9, 0, 0, 0, jst))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("RFC 5322 として読めるべき: %v", err)
+	}
+	var dec mime.WordDecoder
+	if subject, _ := dec.DecodeHeader(msg.Header.Get("Subject")); subject != content.Subject {
+		t.Errorf("件名はデコードすると元に戻るべき: %q", subject)
+	}
+	if from, err := msg.Header.AddressList("From"); err != nil || from[0].Name != "アキグラ" {
+		t.Errorf("差出人名はデコードすると元に戻るべき: %v %v", from, err)
+	}
+	if msg.Header.Get("Message-Id") == "" || msg.Header.Get("Date") == "" {
+		t.Error("Message-ID と Date ヘッダを含むべき")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("multipart/alternative になるべき: %s %v", mediaType, err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var types, bodies []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(p) // multipart.Reader decodes quoted-printable
+		types = append(types, p.Header.Get("Content-Type"))
+		bodies = append(bodies, string(b))
+	}
+	if len(types) != 2 || !strings.HasPrefix(types[0], "text/plain") || !strings.HasPrefix(types[1], "text/html") {
+		t.Fatalf("テキスト→HTMLの順の2パートになるべき: %v", types)
+	}
+	if bodies[0] != "本文です\r\n" || bodies[1] != content.HTML {
+		t.Errorf("本文はデコードすると元に戻るべき: %q", bodies)
+	}
+}
@@ -0,0 +1,45 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// webhookTimeout bounds a single webhook delivery.
+const webhookTimeout = 15 * time.Second
+
+// postWebhook posts a JSON payload to a team's incoming-webhook URL. A webhook
+// that no longer exists or no longer accepts posts (deleted, archived channel,
+// revoked) is reported as ErrRecipientUnavailable so it is not retried.
+func postWebhook(ctx context.Context, client *http.Client, webhookURL string, body []byte, service string) error {
+	if client == nil {
+		client = &http.Client{Timeout: webhookTimeout}
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s webhook error: %d %s", service, resp.StatusCode, strings.TrimSpace(string(msg)))
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+		return fmt.Errorf("%w: %w", ErrRecipientUnavailable, err)
+	}
+	return err
+}
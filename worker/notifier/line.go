@@ -6,20 +6,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 )
 
 // LINENotifier sends notifications via LINE Notify API.
 // LINE Notify allows sending messages to LINE groups or individual users.
+// A notification too long for one message is split over several (see Layout).
 type LINENotifier struct {
 	AccessToken string
+	APIURL      string // defaults to https://notify-api.line.me/api/notify
 }
 
 // NewLINENotifier creates a new LINE notifier
 func NewLINENotifier() *LINENotifier {
 	return &LINENotifier{
 		AccessToken: os.Getenv("LINE_NOTIFY_TOKEN"),
+		APIURL:      "https://notify-api.line.me/api/notify",
 	}
 }
 
@@ -27,6 +31,8 @@ func (l *LINENotifier) Channel() string {
 	return "line"
 }
 
+// Send posts the notification as one message, so that a retried delivery
+// never repeats part of it; what does not fit is summarized (see Layout).
 func (l *LINENotifier) Send(ctx context.Context, n *Notification) error {
 	if l.AccessToken == "" {
 		return fmt.Errorf("LINE_NOTIFY_TOKEN not set")
@@ -36,26 +42,9 @@ func (l *LINENotifier) Send(ctx context.Context, n *Notification) error {
 		return nil
 	}
 
-	// Build message with all slots
-	var lines []string
-	lines = append(lines, fmt.Sprintf("🏈 AkiGura 空き枠通知\n"))
-	lines = append(lines, fmt.Sprintf("%s様\n", n.TeamName))
-	lines = append(lines, fmt.Sprintf("空き枠が%d件見つかりました。\n", len(n.Slots)))
-
-	for i, slot := range n.Slots {
-		lines = append(lines, fmt.Sprintf("\n【%d】%s", i+1, slot.FacilityName))
-		lines = append(lines, fmt.Sprintf("日時: %s %s", slot.SlotDate, slot.SlotTime))
-		lines = append(lines, fmt.Sprintf("場所: %s", slot.CourtName))
-		if slot.ReservationURL != "" {
-			lines = append(lines, fmt.Sprintf("予約: %s", slot.ReservationURL))
-		}
-	}
-	lines = append(lines, "\nお早めにご予約ください。")
-
-	message := strings.Join(lines, "\n")
-
-	data := fmt.Sprintf("message=%s", message)
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://notify-api.line.me/api/notify", bytes.NewBufferString(data))
+	p := Layout(n, lineNotifyLimits, textSize, dashboardURL())[0]
+	data := url.Values{"message": {lineNotifyText(n, p)}}
+	req, err := http.NewRequestWithContext(ctx, "POST", l.APIURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return err
 	}
@@ -67,7 +56,7 @@ func (l *LINENotifier) Send(ctx context.Context, n *Notification) error {
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("LINE notify error: %d", resp.StatusCode)
@@ -75,22 +64,51 @@ func (l *LINENotifier) Send(ctx context.Context, n *Notification) error {
 	return nil
 }
 
+// lineNotifyText renders one page as a LINE Notify message. The greeting
+// opens the first page and the closing line ends the last.
+func lineNotifyText(n *Notification, p Page) string {
+	var lines []string
+	lines = append(lines, "⚾ AkiGura "+pageTitle(n, p)+"\n")
+	if p.Number == 1 {
+		lines = append(lines, fmt.Sprintf("%s様\n", n.TeamName))
+		lines = append(lines, headline(n)+"。\n")
+	}
+	for _, sec := range p.Sections {
+		lines = append(lines, sectionText(sec)+"\n")
+	}
+	if line := p.OmittedLine(); line != "" {
+		lines = append(lines, line+"\n")
+	}
+	if p.Number == p.Total {
+		lines = append(lines, closing(n))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
 // LINEMessagingNotifier sends rich messages using the LINE Messaging API.
 // Unlike LINE Notify, this provides Flex Message support for better formatting.
-// Note: Requires users to have linked their LINE account with the bot.
+// Messages are pushed to the team's LINE user ID, which is stored when the team
+// links its LINE account via LINE Login.
 type LINEMessagingNotifier struct {
 	ChannelAccessToken string
+	APIBaseURL         string // defaults to https://api.line.me
 }
 
 // NewLINEMessagingNotifier creates a LINE Messaging API notifier
 func NewLINEMessagingNotifier() *LINEMessagingNotifier {
 	return &LINEMessagingNotifier{
 		ChannelAccessToken: os.Getenv("LINE_CHANNEL_ACCESS_TOKEN"),
+		APIBaseURL:         "https://api.line.me",
 	}
 }
 
 func (l *LINEMessagingNotifier) Channel() string {
-	return "line_messaging"
+	return "line"
+}
+
+// Idempotent is true: the Messaging API accepts a push once per retry key.
+func (l *LINEMessagingNotifier) Idempotent() bool {
+	return true
 }
 
 func (l *LINEMessagingNotifier) Send(ctx context.Context, n *Notification) error {
@@ -102,66 +120,19 @@ func (l *LINEMessagingNotifier) Send(ctx context.Context, n *Notification) error
 		return nil
 	}
 
-	// Note: This requires storing LINE user IDs in the database
-	// For now, we'll use a placeholder
-	lineUserID := "" // Would come from team's LINE registration
+	lineUserID := n.Destination
 	if lineUserID == "" {
-		return fmt.Errorf("LINE user ID not registered for team")
+		return fmt.Errorf("%w: LINE account not linked or not following", ErrRecipientUnavailable)
 	}
 
-	// Build body contents for all slots
-	var bodyContents []map[string]interface{}
-	bodyContents = append(bodyContents, map[string]interface{}{
-		"type":   "text",
-		"text":   fmt.Sprintf("空き枠が%d件見つかりました", len(n.Slots)),
-		"weight": "bold",
-		"size":   "lg",
-	})
-
-	for _, slot := range n.Slots {
-		bodyContents = append(bodyContents, map[string]interface{}{
-			"type": "separator", "margin": "md",
-		})
-		bodyContents = append(bodyContents, map[string]interface{}{
-			"type": "text", "text": slot.FacilityName, "weight": "bold", "margin": "md",
-		})
-		bodyContents = append(bodyContents, map[string]interface{}{
-			"type": "text", "text": fmt.Sprintf("日時: %s %s", slot.SlotDate, slot.SlotTime),
-		})
-		bodyContents = append(bodyContents, map[string]interface{}{
-			"type": "text", "text": fmt.Sprintf("場所: %s", slot.CourtName),
-		})
-		if slot.ReservationURL != "" {
-			bodyContents = append(bodyContents, map[string]interface{}{
-				"type": "text", "text": fmt.Sprintf("予約: %s", slot.ReservationURL), "color": "#4F46E5", "action": map[string]string{"type": "uri", "uri": slot.ReservationURL},
-			})
-		}
+	// All pages go in one push, so the delivery stays all-or-nothing
+	var messages []map[string]interface{}
+	for _, p := range Layout(n, lineMessagingLimits, lineSectionSize, dashboardURL()) {
+		messages = append(messages, lineFlexMessage(n, p))
 	}
-
 	message := map[string]interface{}{
-		"to": lineUserID,
-		"messages": []map[string]interface{}{
-			{
-				"type":    "flex",
-				"altText": fmt.Sprintf("空き枠通知（%d件）", len(n.Slots)),
-				"contents": map[string]interface{}{
-					"type": "bubble",
-					"header": map[string]interface{}{
-						"type":            "box",
-						"layout":          "vertical",
-						"backgroundColor": "#4F46E5",
-						"contents": []map[string]interface{}{
-							{"type": "text", "text": "🏈 AkiGura", "color": "#ffffff", "weight": "bold"},
-						},
-					},
-					"body": map[string]interface{}{
-						"type":     "box",
-						"layout":   "vertical",
-						"contents": bodyContents,
-					},
-				},
-			},
-		},
+		"to":       lineUserID,
+		"messages": messages,
 	}
 
 	body, err := json.Marshal(message)
@@ -169,13 +140,17 @@ func (l *LINEMessagingNotifier) Send(ctx context.Context, n *Notification) error
 		return fmt.Errorf("marshal LINE message: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.line.me/v2/bot/message/push", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", l.APIBaseURL+"/v2/bot/message/push", bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("create LINE request: %w", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+l.ChannelAccessToken)
 	req.Header.Set("Content-Type", "application/json")
+	if n.IdempotencyKey != "" {
+		// A UUID; the API rejects a key it has already accepted with 409
+		req.Header.Set("X-Line-Retry-Key", n.IdempotencyKey)
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -183,8 +158,95 @@ func (l *LINEMessagingNotifier) Send(ctx context.Context, n *Notification) error
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict && n.IdempotencyKey != "" {
+		return nil // Delivered by an earlier request with the same key
+	}
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("LINE messaging error: %d", resp.StatusCode)
 	}
 	return nil
 }
+
+// lineFlexMessage renders one page as a Flex Message bubble.
+func lineFlexMessage(n *Notification, p Page) map[string]interface{} {
+	var bodyContents []map[string]interface{}
+	bodyContents = append(bodyContents, map[string]interface{}{
+		"type":   "text",
+		"text":   headline(n),
+		"weight": "bold",
+		"size":   "lg",
+	})
+	if p.Paged() {
+		bodyContents = append(bodyContents, map[string]interface{}{
+			"type": "text", "text": fmt.Sprintf("%d/%d", p.Number, p.Total), "size": "sm", "color": "#888888",
+		})
+	}
+	for _, sec := range p.Sections {
+		bodyContents = append(bodyContents, lineSectionContents(sec)...)
+	}
+	if p.Omitted > 0 {
+		bodyContents = append(bodyContents, map[string]interface{}{
+			"type": "separator", "margin": "md",
+		}, map[string]interface{}{
+			"type": "text", "text": fmt.Sprintf("ほか%d件の空き枠があります。すべての空き枠を見る", p.Omitted), "wrap": true, "margin": "md",
+			"color": "#4F46E5", "action": map[string]string{"type": "uri", "uri": p.MoreURL},
+		})
+	}
+
+	return map[string]interface{}{
+		"type": "flex",
+		// altText is limited to 400 characters
+		"altText": pageTitle(n, p),
+		"contents": map[string]interface{}{
+			"type": "bubble",
+			"header": map[string]interface{}{
+				"type":            "box",
+				"layout":          "vertical",
+				"backgroundColor": "#4F46E5",
+				"contents": []map[string]interface{}{
+					{"type": "text", "text": "⚾ AkiGura", "color": "#ffffff", "weight": "bold"},
+				},
+			},
+			"body": map[string]interface{}{
+				"type":     "box",
+				"layout":   "vertical",
+				"contents": bodyContents,
+			},
+		},
+	}
+}
+
+// lineSectionContents renders a section as Flex components.
+func lineSectionContents(sec Section) []map[string]interface{} {
+	contents := []map[string]interface{}{
+		{"type": "separator", "margin": "md"},
+		{"type": "text", "text": sec.Title(), "weight": "bold", "wrap": true, "margin": "md"},
+	}
+	for _, slot := range sec.Slots {
+		line := map[string]interface{}{
+			"type": "text", "text": "・" + sec.SlotLine(slot),
+		}
+		if u := sec.SlotURL(slot); u != "" {
+			line["color"], line["action"] = "#4F46E5", map[string]string{"type": "uri", "uri": u}
+		}
+		contents = append(contents, line)
+	}
+	if line := sec.HiddenLine(); line != "" {
+		contents = append(contents, map[string]interface{}{
+			"type": "text", "text": "・" + line, "color": "#888888",
+		})
+	}
+	if sec.ReservationURL != "" {
+		contents = append(contents, map[string]interface{}{
+			"type": "text", "text": fmt.Sprintf("予約: %s", sec.ReservationURL), "color": "#4F46E5", "action": map[string]string{"type": "uri", "uri": sec.ReservationURL},
+		})
+	}
+	return contents
+}
+
+// lineSectionSize measures a section in bytes of Flex JSON, which is what
+// the bubble size limit counts.
+func lineSectionSize(sec Section) int {
+	b, _ := json.Marshal(lineSectionContents(sec))
+	return len(b)
+}
@@ -0,0 +1,151 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLINEMessagingNotifier(t *testing.T) {
+	ctx := context.Background()
+	n := &Notification{
+		TeamName: "team",
+		Channel:  "line",
+		Slots:    []SlotInfo{{SlotID: "slot-1", SlotDate: "2099-01-03", SlotTime: "09:00-11:00", CourtName: "A面", FacilityName: "球場"}},
+	}
+
+	t.Run("連携済みのLINEユーザーにpushすべき", func(t *testing.T) {
+		var got struct {
+			To       string           `json:"to"`
+			Messages []map[string]any `json:"messages"`
+		}
+		var auth string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v2/bot/message/push" {
+				t.Errorf("unexpected path: %s", r.URL.Path)
+			}
+			auth = r.Header.Get("Authorization")
+			json.NewDecoder(r.Body).Decode(&got)
+		}))
+		defer srv.Close()
+
+		l := &LINEMessagingNotifier{ChannelAccessToken: "token", APIBaseURL: srv.URL}
+		withUser := *n
+		withUser.Destination = "U123"
+		if err := l.Send(ctx, &withUser); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if got.To != "U123" || auth != "Bearer token" {
+			t.Errorf("unexpected push: to=%q auth=%q", got.To, auth)
+		}
+		if len(got.Messages) != 1 || got.Messages[0]["type"] != "flex" {
+			t.Errorf("one flex message expected: %+v", got.Messages)
+		}
+	})
+
+	t.Run("冪等キーをリトライキーとして渡し受理済みの409は成功とすべき", func(t *testing.T) {
+		var keys []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keys = append(keys, r.Header.Get("X-Line-Retry-Key"))
+			if len(keys) > 1 {
+				w.WriteHeader(http.StatusConflict)
+			}
+		}))
+		defer srv.Close()
+
+		l := &LINEMessagingNotifier{ChannelAccessToken: "token", APIBaseURL: srv.URL}
+		keyed := *n
+		keyed.Destination = "U123"
+		keyed.IdempotencyKey = "7d9f2c1e-0000-4000-8000-000000000001"
+		for range 2 {
+			if err := l.Send(ctx, &keyed); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+		}
+		if len(keys) != 2 || keys[0] != keyed.IdempotencyKey || keys[1] != keyed.IdempotencyKey {
+			t.Errorf("同じリトライキーを送るべき: %v", keys)
+		}
+	})
+
+	t.Run("長い通知は1回のpushで複数のメッセージに分けるべき", func(t *testing.T) {
+		var requests int
+		var got struct {
+			Messages []map[string]any `json:"messages"`
+		}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			json.NewDecoder(r.Body).Decode(&got)
+		}))
+		defer srv.Close()
+
+		many := *n
+		many.Destination = "U123"
+		many.Slots = nil
+		for day := range 60 {
+			many.Slots = append(many.Slots, SlotInfo{SlotID: fmt.Sprint(day), SlotDate: fmt.Sprintf("2099-%02d-01", day%12+1), SlotTime: "09:00-11:00", FacilityName: fmt.Sprintf("球場%d", day/12)})
+		}
+		l := &LINEMessagingNotifier{ChannelAccessToken: "token", APIBaseURL: srv.URL}
+		if err := l.Send(ctx, &many); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if requests != 1 || len(got.Messages) != 5 {
+			t.Fatalf("5通のメッセージを1回でpushすべき: requests=%d messages=%d", requests, len(got.Messages))
+		}
+		b, _ := json.Marshal(got.Messages[4])
+		if !strings.Contains(string(b), "ほか10件") {
+			t.Errorf("入りきらない枠の件数を示すべき: %s", b)
+		}
+	})
+
+	t.Run("未連携のチームは再送不能なエラーを返すべき", func(t *testing.T) {
+		l := &LINEMessagingNotifier{ChannelAccessToken: "token", APIBaseURL: "http://127.0.0.1:0"}
+		err := l.Send(ctx, n)
+		if !errors.Is(err, ErrRecipientUnavailable) || !permanent(err) {
+			t.Errorf("expected permanent ErrRecipientUnavailable, got %v", err)
+		}
+	})
+}
+
+func TestLINENotifier(t *testing.T) {
+	ctx := context.Background()
+	var messages []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		v, _ := url.ParseQuery(string(body))
+		messages = append(messages, v.Get("message"))
+	}))
+	defer srv.Close()
+
+	t.Run("1000文字を超える通知は1通に収めて残りを件数で示すべき", func(t *testing.T) {
+		n := &Notification{TeamName: "team", Channel: "line"}
+		for day := range 40 {
+			n.Slots = append(n.Slots, SlotInfo{
+				SlotID: fmt.Sprint(day), SlotDate: fmt.Sprintf("2099-01-%02d", day%28+1), SlotTime: "09:00-11:00", CourtName: "A面",
+				FacilityName: "市営グラウンド", ReservationURL: "https://reserve.example/?a=1&b=2",
+			})
+		}
+		l := &LINENotifier{AccessToken: "token", APIURL: srv.URL}
+		if err := l.Send(ctx, n); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if len(messages) != 1 {
+			t.Fatalf("1通だけ送るべき: %d", len(messages))
+		}
+		if len([]rune(messages[0])) > 1000 {
+			t.Errorf("1000文字を超えている: %d", len([]rune(messages[0])))
+		}
+		if !strings.Contains(messages[0], "ほか") || !strings.Contains(messages[0], "件の空き枠") {
+			t.Errorf("収まらない枠は件数で示すべき: %s", messages[0])
+		}
+		if !strings.Contains(messages[0], "a=1&b=2") {
+			t.Errorf("URLはエンコードして送るべき: %s", messages[0])
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// encodeHeader MIME-encodes a header value (RFC 2047) when it is not plain
+// ASCII, e.g. a Japanese subject.
+func encodeHeader(v string) string {
+	return mime.BEncoding.Encode("UTF-8", v)
+}
+
+// listUnsubscribeHeaders returns the RFC 8058 one-click unsubscribe headers:
+// mail clients POST "List-Unsubscribe=One-Click" to the URL.
+func listUnsubscribeHeaders(unsubscribeURL string) map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// buildMIMEMessage builds a multipart/alternative email with a plain text and
+// an HTML part. Non-ASCII headers are MIME-encoded and both parts are sent as
+// quoted-printable UTF-8.
+func buildMIMEMessage(from mail.Address, to string, c EmailContent, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", c.Text},
+		{"text/html; charset=UTF-8", c.HTML},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(strings.ReplaceAll(part.content, "\n", "\r\n"))); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	domain := "akigura.jp"
+	if _, d, ok := strings.Cut(from.Address, "@"); ok {
+		domain = d
+	}
+
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", from.String()}, // mail.Address encodes a non-ASCII name
+		{"To", to},
+		{"Subject", encodeHeader(c.Subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	if c.UnsubscribeURL != "" {
+		h := listUnsubscribeHeaders(c.UnsubscribeURL)
+		headers = append(headers,
+			[2]string{"List-Unsubscribe", h["List-Unsubscribe"]},
+			[2]string{"List-Unsubscribe-Post", h["List-Unsubscribe-Post"]})
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
@@ -2,28 +2,107 @@ package notifier
 
 import (
 	"context"
+	"errors"
 	"fmt"
 )
 
 // SlotInfo represents a single slot in a notification
+// The JSON form is part of the outgoing webhook payload (see WebhookPayload).
 type SlotInfo struct {
-	SlotID         string
-	SlotDate       string
-	SlotTime       string
-	CourtName      string
-	FacilityName   string // ground name
-	ReservationURL string // URL to the reservation system
+	SlotID         string `json:"slot_id"`
+	SlotDate       string `json:"slot_date"`
+	SlotTime       string `json:"slot_time"`
+	CourtName      string `json:"court_name"`
+	FacilityName   string `json:"facility_name"`             // ground name
+	ReservationURL string `json:"reservation_url,omitempty"` // URL to the reservation system
+	BundleID       string `json:"bundle_id,omitempty"`       // set when the slot is part of a bundle
+	BundleLabel    string `json:"bundle_label,omitempty"`    // e.g. "2面同時", "2日連続"
+	// WatchConditionID is the condition that matched the slot, used for
+	// unsubscribe links.
+	WatchConditionID string `json:"-"`
+	// NotificationID is the notification row of the slot, used for click
+	// tracking.
+	NotificationID string `json:"-"`
 }
 
 // Notification represents a notification to be sent
 // Contains multiple slots for batch notification
 type Notification struct {
-	ID        string
-	TeamID    string
-	TeamName  string
+	ID       string
+	TeamID   string
+	TeamName string
+	// TeamEmail is the recipient address: the team's, or the member's for a
+	// member notification.
 	TeamEmail string
-	Channel   string // email, line, slack
-	Slots     []SlotInfo
+	// MemberID and MemberName are set when the notification is for a team
+	// member rather than the team's own destination.
+	MemberID   string
+	MemberName string
+	Channel    string // email, line, slack, discord
+	// Destination is the team's recipient on the channel: the linked LINE
+	// user ID, or the registered Slack/Discord webhook URL. Empty when the
+	// team has none (email uses TeamEmail).
+	Destination string
+	// Secrets sign outgoing webhook payloads: the current secret first, then
+	// the previous one while a rotation is in its grace period.
+	Secrets []string
+	// Digest is DigestDaily or DigestWeekly when the slots are a scheduled
+	// summary rather than new matches; empty for instant alerts.
+	Digest string
+	// IdempotencyKey identifies the message in the outbox. It is the same
+	// when an interrupted delivery is retried, so providers that support
+	// idempotency keys deliver it only once.
+	IdempotencyKey string
+	// BookedURL is the page where the team coordinates its slots (claims
+	// and votes) and records the ones it booked; empty when tracking links
+	// are not configured.
+	BookedURL string
+	// Claim is set when the notification tells the team that another member
+	// is booking its slot, rather than announcing the slot.
+	Claim *ClaimInfo
+	// Test marks a sample notification sent to verify the channel.
+	Test  bool
+	Slots []SlotInfo
+}
+
+// ClaimInfo describes a member's claim on a slot.
+type ClaimInfo struct {
+	ClaimedBy string // e.g. "佐藤 さん", or "代表者"
+	Until     string // expiry in JST, e.g. "15:04"
+}
+
+// bundleLabel describes a bundle condition for display.
+func bundleLabel(bundleType string, size int) string {
+	switch bundleType {
+	case "courts":
+		return fmt.Sprintf("%d面同時", size)
+	case "days":
+		return fmt.Sprintf("%d日連続", size)
+	default:
+		return "セット"
+	}
+}
+
+// headline summarizes the notification in one line.
+func headline(n *Notification) string {
+	if n.Test {
+		return "テスト通知です。空き枠が見つかるとこのように届きます"
+	}
+	if n.Claim != nil {
+		return fmt.Sprintf("%sがこの枠を予約します（%sまで）", n.Claim.ClaimedBy, n.Claim.Until)
+	}
+	if n.IsDigest() {
+		return fmt.Sprintf("%s: 空き枠%d件", n.DigestLabel(), len(n.Slots))
+	}
+	return fmt.Sprintf("空き枠が%d件見つかりました", len(n.Slots))
+}
+
+// closing is the line that ends a chat message.
+func closing(n *Notification) string {
+	if n.Claim != nil {
+		return "重複して予約しないようご注意ください。"
+	}
+	return "お早めにご予約ください。"
 }
 
 // Notifier interface for sending notifications
@@ -56,11 +135,20 @@ func (m *Manager) Register(n Notifier) {
 	m.notifiers[n.Channel()] = n
 }
 
+// ErrNoNotifier is returned by Manager.Send when no notifier is registered
+// for the notification's channel. Retrying cannot succeed.
+var ErrNoNotifier = errors.New("no notifier registered for channel")
+
+// ErrRecipientUnavailable is returned by a notifier when the team has no
+// reachable destination on its channel, e.g. no linked LINE account.
+// Retrying cannot succeed.
+var ErrRecipientUnavailable = errors.New("recipient not available")
+
 // Send sends a notification using the appropriate channel
 func (m *Manager) Send(ctx context.Context, n *Notification) error {
 	notifier, ok := m.notifiers[n.Channel]
 	if !ok {
-		return fmt.Errorf("no notifier registered for channel: %s", n.Channel)
+		return fmt.Errorf("%w: %s", ErrNoNotifier, n.Channel)
 	}
 	return notifier.Send(ctx, n)
 }
@@ -0,0 +1,284 @@
+package notifier
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notification outbox
+//
+// Each message to a team (or team member) on a channel is recorded in notification_messages
+// before it is sent. Claiming a batch creates the message in the 'sending'
+// state with a lease and moves its notifications from 'pending' to 'sending'
+// in one transaction, so no other sender can pick them up. After the send, the
+// attempts, the notification statuses and the message status are written in
+// one transaction as well.
+//
+// A sender that stops between the two leaves the message 'sending'. Once its
+// lease has expired the message is recovered: channels whose provider
+// deduplicates by idempotency key get the same batch again with the same key;
+// for the others the outcome is unknown, so the notifications are marked
+// failed with that reason rather than risking a duplicate. An admin can resend
+// them from the deliveries view.
+
+// DeliveryLease is how long a message may stay 'sending' before it is
+// considered interrupted. It must exceed the slowest provider timeout.
+const DeliveryLease = 5 * time.Minute
+
+// errOutcomeUnknown is recorded for interrupted messages that were not retried.
+var errOutcomeUnknown = errors.New("delivery outcome unknown: sender stopped while sending")
+
+// IdempotentNotifier is implemented by notifiers whose provider deduplicates
+// requests carrying the same Notification.IdempotencyKey, so an interrupted
+// delivery can be retried without a duplicate reaching the team.
+type IdempotentNotifier interface {
+	Notifier
+	Idempotent() bool
+}
+
+// Idempotent reports whether the channel's notifier deduplicates by
+// idempotency key.
+func (m *Manager) Idempotent(channel string) bool {
+	n, ok := m.notifiers[channel].(IdempotentNotifier)
+	return ok && n.Idempotent()
+}
+
+// execer is a *sql.DB or *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// claim creates the outbox message for rows and moves them to 'sending'. It
+// returns the message ID, the idempotency key, or "" when another sender
+// claimed some of the rows first.
+func (s *Sender) claim(ctx context.Context, n *Notification, rows []pendingRow, now time.Time) (string, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	key := uuid.New().String()
+	var digest *string
+	if n.Digest != "" {
+		digest = &n.Digest
+	}
+	var memberID *string
+	if n.MemberID != "" {
+		memberID = &n.MemberID
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO notification_messages (id, team_id, member_id, channel, digest, status, lease_expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, 'sending', ?, CURRENT_TIMESTAMP)
+	`, key, n.TeamID, memberID, n.Channel, digest, now.Add(DeliveryLease).UTC().Format(time.DateTime)); err != nil {
+		return "", err
+	}
+
+	ids := make([]any, 0, len(rows)+1)
+	ids = append(ids, key)
+	for _, r := range rows {
+		ids = append(ids, r.NotificationID)
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE notifications SET status = 'sending', message_id = ?
+		WHERE status = 'pending' AND id IN (?`+strings.Repeat(", ?", len(rows)-1)+`)
+	`, ids...)
+	if err != nil {
+		return "", err
+	}
+	if claimed, err := res.RowsAffected(); err != nil || claimed != int64(len(rows)) {
+		return "", err
+	}
+	return key, tx.Commit()
+}
+
+// deliver sends a claimed message and records the outcome. It reports
+// whether the send succeeded.
+func (s *Sender) deliver(ctx context.Context, n *Notification, rows []pendingRow, now time.Time) bool {
+	s.Tracking.Apply(n)
+	err := s.Manager.Send(ctx, n)
+	retrying, ferr := s.finish(ctx, n.IdempotencyKey, rows, err, now)
+	if ferr != nil {
+		// The message stays 'sending' and is recovered when its lease expires
+		slog.Error("record notification outcome", "message", n.IdempotencyKey, "sent", err == nil, "error", ferr)
+	}
+	if err != nil {
+		slog.Warn("send notification failed", "team", n.TeamName, "slots", len(n.Slots), "retrying", retrying, "error", err)
+		return false
+	}
+	slog.Info("notification sent", "channel", n.Channel, "team", n.TeamName, "slots", len(n.Slots), "digest", n.Digest, "message", n.IdempotencyKey)
+	return true
+}
+
+// finish records the outcome of a message in one transaction: an attempt per
+// notification, the channel's health, the notification statuses (sent, retry
+// or failed) and the message status. It returns the number of notifications scheduled for retry.
+// Messages to members do not affect the health of the team's channel: a
+// member's bad address is reported on the member instead.
+func (s *Sender) finish(ctx context.Context, key string, rows []pendingRow, sendErr error, now time.Time) (retrying int, err error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, r := range rows {
+		if err := recordAttempt(ctx, tx, r, key, sendErr); err != nil {
+			return 0, err
+		}
+	}
+	if len(rows) > 0 && rows[0].MemberID == "" {
+		if err := recordChannelHealth(ctx, tx, rows[0].TeamID, rows[0].Channel, sendErr); err != nil {
+			return 0, err
+		}
+	}
+
+	if sendErr == nil {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE notifications SET status = 'sent', sent_at = CURRENT_TIMESTAMP WHERE message_id = ? AND status = 'sending'
+		`, key); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE notification_messages SET status = 'sent', sent_at = CURRENT_TIMESTAMP, lease_expires_at = NULL WHERE id = ?
+		`, key); err != nil {
+			return 0, err
+		}
+		return 0, tx.Commit()
+	}
+
+	for _, r := range rows {
+		if permanent(sendErr) {
+			if err := setStatus(ctx, tx, r.NotificationID, "failed"); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		scheduled, err := scheduleRetry(ctx, tx, r, now)
+		if err != nil {
+			return 0, err
+		}
+		if scheduled {
+			retrying++
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE notification_messages SET status = 'failed', last_error = ?, lease_expires_at = NULL WHERE id = ?
+	`, sendErr.Error(), key); err != nil {
+		return 0, err
+	}
+	return retrying, tx.Commit()
+}
+
+// recoverStale resolves messages left 'sending' by a sender that stopped
+// before recording the outcome. Idempotent channels get the same batch again
+// with the same key; other messages are marked unknown and their
+// notifications failed. It returns the notifications sent and failed. Claim
+// notices are recovered by recoverClaimNotices.
+func (s *Sender) recoverStale(ctx context.Context, now time.Time) (sent, failed int, err error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, channel FROM notification_messages
+		WHERE status = 'sending' AND lease_expires_at <= ? AND claim_id IS NULL
+		ORDER BY created_at
+	`, now.UTC().Format(time.DateTime))
+	if err != nil {
+		return 0, 0, err
+	}
+	type stale struct{ id, channel string }
+	var messages []stale
+	for rows.Next() {
+		var m stale
+		if err := rows.Scan(&m.id, &m.channel); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, m := range messages {
+		// Take over the lease so that only one sender recovers the message
+		res, err := s.DB.ExecContext(ctx, `
+			UPDATE notification_messages SET lease_expires_at = ?
+			WHERE id = ? AND status = 'sending' AND lease_expires_at <= ?
+		`, now.Add(DeliveryLease).UTC().Format(time.DateTime), m.id, now.UTC().Format(time.DateTime))
+		if err != nil {
+			return sent, failed, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
+		batch, err := s.loadRows(ctx, `
+			WHERE n.message_id = ? AND n.status = 'sending'
+			ORDER BY sl.slot_date, sl.time_from
+		`, m.id)
+		if err != nil {
+			return sent, failed, err
+		}
+		if len(batch) == 0 {
+			// Nothing left to send, e.g. the notifications were deleted
+			if _, err := s.DB.ExecContext(ctx, `
+				UPDATE notification_messages SET status = 'failed', last_error = 'no notifications', lease_expires_at = NULL WHERE id = ?
+			`, m.id); err != nil {
+				return sent, failed, err
+			}
+			continue
+		}
+
+		if s.Manager.Idempotent(m.channel) {
+			n := buildNotification(batch)
+			n.IdempotencyKey = m.id
+			slog.Info("resending interrupted notification", "message", m.id, "channel", m.channel, "slots", len(batch))
+			if s.deliver(ctx, n, batch, now) {
+				sent += len(batch)
+			} else {
+				failed += len(batch)
+			}
+			continue
+		}
+
+		if err := s.markUnknown(ctx, m.id, batch); err != nil {
+			return sent, failed, err
+		}
+		slog.Warn("interrupted notification not resent", "message", m.id, "channel", m.channel, "slots", len(batch))
+		failed += len(batch)
+	}
+	return sent, failed, nil
+}
+
+// markUnknown records an interrupted, non-idempotent message as failed with
+// an unknown outcome, so that it is visible and can be resent manually.
+func (s *Sender) markUnknown(ctx context.Context, key string, rows []pendingRow) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range rows {
+		if err := recordAttempt(ctx, tx, r, key, errOutcomeUnknown); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE notifications SET status = 'failed' WHERE message_id = ? AND status = 'sending'
+	`, key); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE notification_messages SET status = 'unknown', last_error = ?, lease_expires_at = NULL WHERE id = ?
+	`, errOutcomeUnknown.Error(), key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
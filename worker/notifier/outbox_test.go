@@ -0,0 +1,130 @@
+package notifier
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+// idempotentNotifier is a recordingNotifier whose provider deduplicates by key.
+type idempotentNotifier struct{ recordingNotifier }
+
+func (n *idempotentNotifier) Idempotent() bool { return true }
+
+// seedInterrupted simulates a sender that stopped after claiming the
+// notification: the message is 'sending' with an expired lease.
+func seedInterrupted(t *testing.T, db *sql.DB, messageID, notificationID string) {
+	t.Helper()
+	mustExec(t, db, `INSERT INTO notification_messages (id, team_id, channel, status, lease_expires_at)
+		SELECT ?, team_id, channel, 'sending', datetime('now', '-1 minute') FROM notifications WHERE id = ?`, messageID, notificationID)
+	mustExec(t, db, `UPDATE notifications SET status = 'sending', message_id = ? WHERE id = ?`, messageID, notificationID)
+}
+
+func TestProcessPendingOutbox(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("送信メッセージを記録し冪等キーを渡すべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		seedPending(t, db, "team", "slot-1", "2099-01-03", 1)
+		seedPending(t, db, "team", "slot-2", "2099-01-04", 1)
+
+		sender, rec := newTestSender(db)
+		if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 2 {
+			t.Fatalf("2件送信すべき: sent=%d err=%v", sent, err)
+		}
+		key := rec.sent[0].IdempotencyKey
+		if key == "" {
+			t.Fatal("冪等キーを渡すべき")
+		}
+		var status string
+		var messageIDs, attempts int
+		db.QueryRow(`SELECT status FROM notification_messages WHERE id = ?`, key).Scan(&status)
+		db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE message_id = ? AND status = 'sent'`, key).Scan(&messageIDs)
+		db.QueryRow(`SELECT COUNT(*) FROM notification_attempts WHERE message_id = ? AND success = 1`, key).Scan(&attempts)
+		if status != "sent" || messageIDs != 2 || attempts != 2 {
+			t.Errorf("メッセージ・通知・試行が紐づいて記録されるべき: status=%s notifications=%d attempts=%d", status, messageIDs, attempts)
+		}
+	})
+
+	t.Run("送信失敗はメッセージを失敗にし通知を再送待ちに戻すべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		seedPending(t, db, "team", "slot-1", "2099-01-03", 1)
+
+		sender, rec := newTestSender(db)
+		rec.err = errors.New("temporary")
+		if _, failed, err := sender.ProcessPending(ctx); err != nil || failed != 1 {
+			t.Fatalf("1件失敗すべき: failed=%d err=%v", failed, err)
+		}
+		var status, lastError string
+		db.QueryRow(`SELECT status, last_error FROM notification_messages`).Scan(&status, &lastError)
+		if status != "failed" || lastError != "temporary" {
+			t.Errorf("メッセージは失敗として記録されるべき: %s %s", status, lastError)
+		}
+		if got := statusOf(t, db, "team-slot-1"); got != "pending" {
+			t.Errorf("通知は再送待ちに戻るべき, got %s", got)
+		}
+	})
+
+	t.Run("送信中の通知は他の送信処理で再送すべきではない", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		seedPending(t, db, "team", "slot-1", "2099-01-03", 1)
+		mustExec(t, db, `INSERT INTO notification_messages (id, team_id, channel, status, lease_expires_at)
+			VALUES ('msg-1', 'team', 'email', 'sending', datetime('now', '+5 minutes'))`)
+		mustExec(t, db, `UPDATE notifications SET status = 'sending', message_id = 'msg-1'`)
+
+		sender, rec := newTestSender(db)
+		if sent, failed, err := sender.ProcessPending(ctx); err != nil || sent+failed != 0 || len(rec.sent) != 0 {
+			t.Fatalf("リース中のメッセージには触れるべきではない: sent=%d failed=%d err=%v", sent, failed, err)
+		}
+	})
+
+	t.Run("中断した送信は冪等でないチャネルでは再送せず結果不明の失敗にすべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		seedPending(t, db, "team", "slot-1", "2099-01-03", 1)
+		seedInterrupted(t, db, "msg-1", "team-slot-1")
+
+		sender, rec := newTestSender(db)
+		if _, failed, err := sender.ProcessPending(ctx); err != nil || failed != 1 || len(rec.sent) != 0 {
+			t.Fatalf("再送せず失敗にすべき: failed=%d sent=%d err=%v", failed, len(rec.sent), err)
+		}
+		var status, lastError string
+		db.QueryRow(`SELECT status FROM notification_messages WHERE id = 'msg-1'`).Scan(&status)
+		db.QueryRow(`SELECT last_error FROM notifications WHERE id = 'team-slot-1'`).Scan(&lastError)
+		if status != "unknown" || statusOf(t, db, "team-slot-1") != "failed" || lastError != errOutcomeUnknown.Error() {
+			t.Errorf("結果不明として記録されるべき: message=%s last_error=%s", status, lastError)
+		}
+	})
+
+	t.Run("中断した送信は冪等なチャネルでは同じキーで再送すべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		seedPending(t, db, "team", "slot-1", "2099-01-03", 1)
+		seedPending(t, db, "team", "slot-2", "2099-01-04", 1)
+		mustExec(t, db, `UPDATE notifications SET channel = 'webhook'`)
+		seedInterrupted(t, db, "msg-1", "team-slot-1")
+
+		rec := &idempotentNotifier{recordingNotifier{channel: "webhook"}}
+		mgr := NewManager()
+		mgr.Register(rec)
+		sender := &Sender{DB: db, Manager: mgr}
+		if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 2 {
+			t.Fatalf("中断分と新規分を送信すべき: sent=%d err=%v", sent, err)
+		}
+		if len(rec.sent) != 2 || rec.sent[0].IdempotencyKey != "msg-1" || len(rec.sent[0].Slots) != 1 || rec.sent[0].Slots[0].SlotID != "slot-1" {
+			t.Fatalf("中断したメッセージを同じキー・同じ内容で再送すべき: %+v", rec.sent)
+		}
+		if rec.sent[1].IdempotencyKey == "msg-1" {
+			t.Error("新しいメッセージには新しいキーを使うべき")
+		}
+		var status string
+		db.QueryRow(`SELECT status FROM notification_messages WHERE id = 'msg-1'`).Scan(&status)
+		if status != "sent" || statusOf(t, db, "team-slot-1") != "sent" {
+			t.Errorf("再送後は送信済みになるべき: %s", status)
+		}
+	})
+}
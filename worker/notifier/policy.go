@@ -0,0 +1,82 @@
+package notifier
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PlanPolicy describes how notifications are delivered for a subscription plan.
+// Values come from the plan_limits table.
+type PlanPolicy struct {
+	Plan     string
+	Priority int           // higher is processed first
+	Delay    time.Duration // wait before a match is delivered
+	DailyCap int           // max slots notified to a team per JST day, 0 = unlimited
+	// MonthlySMSQuota is the number of SMS the team may be sent per JST
+	// month, across the team and its members; 0 means no SMS.
+	MonthlySMSQuota int
+}
+
+// Ready reports whether a notification of the given age may be delivered.
+func (p PlanPolicy) Ready(age time.Duration) bool {
+	return age >= p.Delay
+}
+
+// Remaining returns how many more slots may be notified today.
+// It returns -1 when the plan has no daily cap.
+func (p PlanPolicy) Remaining(sentToday int) int {
+	if p.DailyCap <= 0 {
+		return -1
+	}
+	return max(p.DailyCap-sentToday, 0)
+}
+
+// SMSRemaining returns how many more SMS may be sent this month.
+func (p PlanPolicy) SMSRemaining(sentThisMonth int) int {
+	return max(p.MonthlySMSQuota-sentThisMonth, 0)
+}
+
+// Policy holds the delivery policy for every plan.
+type Policy struct {
+	plans map[string]PlanPolicy
+}
+
+// defaultPlan is used for teams whose plan has no plan_limits row.
+const defaultPlan = "free"
+
+// LoadPolicy reads the per-plan notification policy from plan_limits.
+func LoadPolicy(ctx context.Context, db *sql.DB) (*Policy, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT plan, notification_priority, notification_delay_minutes, daily_notification_cap, monthly_sms_quota
+		FROM plan_limits
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	p := &Policy{plans: make(map[string]PlanPolicy)}
+	for rows.Next() {
+		var pp PlanPolicy
+		var delayMinutes int
+		if err := rows.Scan(&pp.Plan, &pp.Priority, &delayMinutes, &pp.DailyCap, &pp.MonthlySMSQuota); err != nil {
+			return nil, err
+		}
+		pp.Delay = time.Duration(delayMinutes) * time.Minute
+		p.plans[pp.Plan] = pp
+	}
+	return p, rows.Err()
+}
+
+// For returns the policy for a plan, falling back to the free plan
+// (and then to an unrestricted policy) when the plan is unknown.
+func (p *Policy) For(plan string) PlanPolicy {
+	if pp, ok := p.plans[plan]; ok {
+		return pp
+	}
+	if pp, ok := p.plans[defaultPlan]; ok {
+		return pp
+	}
+	return PlanPolicy{Plan: plan}
+}
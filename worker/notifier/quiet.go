@@ -0,0 +1,85 @@
+package notifier
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// QuietHours is a team's daily window (JST) in which instant notifications
+// are held. End may be earlier than Start for a window that crosses
+// midnight. The zero value has no quiet hours.
+type QuietHours struct {
+	Start string // HH:MM
+	End   string // HH:MM
+}
+
+// Until returns when the quiet window containing now ends, or the zero time
+// if now is outside quiet hours.
+func (q QuietHours) Until(now time.Time) time.Time {
+	start, err1 := time.Parse("15:04", q.Start)
+	end, err2 := time.Parse("15:04", q.End)
+	if err1 != nil || err2 != nil || q.Start == q.End {
+		return time.Time{}
+	}
+	local := now.In(jst)
+	startAt := time.Date(local.Year(), local.Month(), local.Day(), start.Hour(), start.Minute(), 0, 0, jst)
+	endAt := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, jst)
+	if !endAt.After(startAt) {
+		// Crosses midnight: the window started yesterday or ends tomorrow
+		if local.Before(endAt) {
+			return endAt
+		}
+		endAt = endAt.AddDate(0, 0, 1)
+	}
+	if !local.Before(startAt) && local.Before(endAt) {
+		return endAt
+	}
+	return time.Time{}
+}
+
+// urgent reports whether the slot is for the current day (JST), which may
+// bypass quiet hours.
+func urgent(slotDate string, now time.Time) bool {
+	if len(slotDate) > 10 {
+		slotDate = slotDate[:10]
+	}
+	return slotDate == now.In(jst).Format(time.DateOnly)
+}
+
+// hold defers a pending notification to at without counting an attempt, so
+// that held notifications are delivered together when the hold ends.
+func (s *Sender) hold(ctx context.Context, r pendingRow, at time.Time) {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE notifications SET next_attempt_at = ? WHERE id = ?
+	`, at.UTC().Format(time.DateTime), r.NotificationID)
+	if err != nil {
+		slog.Warn("hold notification", "error", err)
+	}
+}
+
+// lastSentAt returns when each team, or member, last received a message on
+// each channel within the past day, keyed by recipientKey.
+func (s *Sender) lastSentAt(ctx context.Context, now time.Time) (map[string]time.Time, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT team_id, channel, COALESCE(member_id, ''), CAST(strftime('%s', MAX(sent_at)) AS INTEGER)
+		FROM notifications
+		WHERE status = 'sent' AND sent_at >= ?
+		GROUP BY team_id, channel, member_id
+	`, now.Add(-24*time.Hour).UTC().Format(time.DateTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	last := make(map[string]time.Time)
+	for rows.Next() {
+		var teamID, channel, memberID string
+		var sentAt int64
+		if err := rows.Scan(&teamID, &channel, &memberID, &sentAt); err != nil {
+			return nil, err
+		}
+		last[recipientKey(teamID, channel, memberID)] = time.Unix(sentAt, 0)
+	}
+	return last, rows.Err()
+}
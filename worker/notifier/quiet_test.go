@@ -0,0 +1,108 @@
+package notifier
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQuietHoursUntil(t *testing.T) {
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 10, day, hour, minute, 0, 0, jst) }
+	tests := []struct {
+		name  string
+		quiet QuietHours
+		now   time.Time
+		want  time.Time
+	}{
+		{"日付をまたぐ時間帯の前半", QuietHours{"22:00", "07:00"}, at(18, 23, 0), at(19, 7, 0)},
+		{"日付をまたぐ時間帯の後半", QuietHours{"22:00", "07:00"}, at(18, 2, 0), at(18, 7, 0)},
+		{"時間帯の外", QuietHours{"22:00", "07:00"}, at(18, 12, 0), time.Time{}},
+		{"終了時刻ちょうどは時間帯の外", QuietHours{"22:00", "07:00"}, at(18, 7, 0), time.Time{}},
+		{"日中の時間帯", QuietHours{"12:00", "13:00"}, at(18, 12, 30), at(18, 13, 0)},
+		{"未設定", QuietHours{}, at(18, 2, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.quiet.Until(tt.now); !got.Equal(tt.want) {
+				t.Errorf("Until = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessPendingQuietHours(t *testing.T) {
+	ctx := context.Background()
+	night := time.Date(2026, 10, 18, 2, 0, 0, 0, jst)
+
+	t.Run("静かな時間帯の通知は保留し終了時にまとめて送るべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		mustExec(t, db, `UPDATE teams SET quiet_start = '22:00', quiet_end = '07:00' WHERE id = 'team'`)
+		seedPending(t, db, "team", "slot-1", "2099-01-03", 1)
+		seedPending(t, db, "team", "slot-2", "2099-01-04", 1)
+
+		sender, rec := newTestSender(db)
+		sender.Now = func() time.Time { return night }
+		if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 0 {
+			t.Fatalf("静かな時間帯は送信すべきではない: sent=%d err=%v", sent, err)
+		}
+		var next time.Time
+		db.QueryRow(`SELECT next_attempt_at FROM notifications WHERE id = 'team-slot-2'`).Scan(&next)
+		if want := time.Date(2026, 10, 18, 7, 0, 0, 0, jst); !next.Equal(want) {
+			t.Errorf("07:00 JST まで保留すべき: %v", next)
+		}
+
+		sender.Now = func() time.Time { return time.Date(2026, 10, 18, 7, 1, 0, 0, jst) }
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.sent) != 1 || len(rec.sent[0].Slots) != 2 {
+			t.Fatalf("保留した通知は1通にまとめて送るべき: %+v", rec.sent)
+		}
+	})
+
+	t.Run("オプトインしたチームは当日の枠を静かな時間帯でも送るべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		mustExec(t, db, `UPDATE teams SET quiet_start = '22:00', quiet_end = '07:00', quiet_urgent_bypass = 1 WHERE id = 'team'`)
+		seedPending(t, db, "team", "slot-today", "2026-10-18", 1)
+		seedPending(t, db, "team", "slot-later", "2099-01-03", 1)
+
+		sender, rec := newTestSender(db)
+		sender.Now = func() time.Time { return night }
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.sent) != 1 || len(rec.sent[0].Slots) != 1 || rec.sent[0].Slots[0].SlotID != "slot-today" {
+			t.Fatalf("当日の枠だけを送るべき: %+v", rec.sent)
+		}
+		if got := statusOf(t, db, "team-slot-later"); got != "pending" {
+			t.Errorf("後日の枠は保留されるべき, got %s", got)
+		}
+	})
+
+	t.Run("最小間隔内の通知は間隔が空くまで保留すべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "team", "pro")
+		mustExec(t, db, `UPDATE teams SET min_interval_minutes = 30 WHERE id = 'team'`)
+		noon := time.Date(2026, 10, 18, 12, 0, 0, 0, jst)
+		seedPending(t, db, "team", "slot-1", "2099-01-03", 1)
+		mustExec(t, db, `UPDATE notifications SET status = 'sent', sent_at = ? WHERE id = 'team-slot-1'`,
+			noon.Add(-10*time.Minute).UTC().Format(time.DateTime))
+		seedPending(t, db, "team", "slot-2", "2099-01-04", 1)
+
+		sender, rec := newTestSender(db)
+		sender.Now = func() time.Time { return noon }
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.sent) != 0 {
+			t.Fatalf("最小間隔内は送信すべきではない")
+		}
+		var next time.Time
+		db.QueryRow(`SELECT next_attempt_at FROM notifications WHERE id = 'team-slot-2'`).Scan(&next)
+		if want := noon.Add(20 * time.Minute); !next.Equal(want) {
+			t.Errorf("前回送信の30分後まで保留すべき: got %v want %v", next, want)
+		}
+	})
+}
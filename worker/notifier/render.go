@@ -0,0 +1,283 @@
+package notifier
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Message layout
+//
+// Every channel presents a notification the same way: slots are grouped into
+// sections of one ground on one date (a bundle stays together as its own
+// section), ordered by ground, date and time. Channels differ only in how
+// much fits in one message, described by MessageLimits; email has no
+// practical limit and lists every section. Layout applies the limits: a long
+// section lists its first slots and "ほかN枠", sections that do not fit are
+// carried over to further messages, and what exceeds the channel's message
+// count is summarized as "ほかN件" with a link to the dashboard.
+//
+// Channels that post each message with a separate request (Slack, Discord,
+// LINE Notify) allow one message: a delivery that failed after its first
+// posts would post them again when retried, so such a notification is
+// summarized rather than split.
+//
+// A section links the reservation page its slots share. With tracking
+// (see tracking.go) every slot has a link of its own, so each slot is
+// linked instead and clicks are counted for the right notification.
+
+// Section is the slots of one ground on one date, or of one bundle.
+type Section struct {
+	FacilityName string
+	// SlotDate is the date of every slot in the section; empty for a bundle
+	// spanning several days.
+	SlotDate string
+	BundleID string
+	Label    string // bundle label, e.g. "2面同時"; empty otherwise
+	// ReservationURL is the link shared by every slot of the section; empty
+	// when they link to different pages (see SlotURL).
+	ReservationURL string
+	Slots          []SlotInfo
+	// Hidden is the number of slots left out by the channel's section limit.
+	Hidden int
+}
+
+// Title is the section heading, e.g. "A球場 2026-10-20" or "A球場（2日連続セット）".
+func (s Section) Title() string {
+	title := s.FacilityName
+	if s.SlotDate != "" {
+		title += " " + s.SlotDate
+	}
+	if s.Label != "" {
+		title += "（" + s.Label + "セット）"
+	}
+	return title
+}
+
+// SlotLine is the line for one slot in the section: its time and court, with
+// the date when the section spans several days.
+func (s Section) SlotLine(slot SlotInfo) string {
+	if s.SlotDate == "" {
+		return fmt.Sprintf("%s %s %s", slot.SlotDate, slot.SlotTime, orDash(slot.CourtName))
+	}
+	return fmt.Sprintf("%s %s", slot.SlotTime, orDash(slot.CourtName))
+}
+
+// SlotURL is the slot's own link when the section has no shared one, or "".
+func (s Section) SlotURL(slot SlotInfo) string {
+	if s.ReservationURL != "" {
+		return ""
+	}
+	return slot.ReservationURL
+}
+
+// HiddenLine summarizes the slots left out of the section, or "" if none.
+func (s Section) HiddenLine() string {
+	if s.Hidden == 0 {
+		return ""
+	}
+	return fmt.Sprintf("ほか%d枠", s.Hidden)
+}
+
+// Sections groups the notification's slots by ground and date, with each
+// bundle as a section of its own, ordered by ground name, date and time.
+func (n *Notification) Sections() []Section {
+	slots := slices.Clone(n.Slots)
+	slices.SortStableFunc(slots, func(a, b SlotInfo) int {
+		return cmp.Or(
+			cmp.Compare(a.FacilityName, b.FacilityName),
+			cmp.Compare(a.SlotDate, b.SlotDate),
+			cmp.Compare(a.SlotTime, b.SlotTime),
+			cmp.Compare(a.CourtName, b.CourtName),
+		)
+	})
+
+	var sections []Section
+	index := make(map[string]int) // section key -> position in sections
+	for _, slot := range slots {
+		key := "d:" + slot.FacilityName + "\x00" + slot.SlotDate
+		if slot.BundleID != "" {
+			key = "b:" + slot.BundleID
+		}
+		if i, ok := index[key]; ok {
+			sec := &sections[i]
+			sec.Slots = append(sec.Slots, slot)
+			if sec.SlotDate != slot.SlotDate {
+				sec.SlotDate = ""
+			}
+			if sec.ReservationURL != slot.ReservationURL {
+				sec.ReservationURL = ""
+			}
+			continue
+		}
+		index[key] = len(sections)
+		sections = append(sections, Section{
+			FacilityName:   slot.FacilityName,
+			SlotDate:       slot.SlotDate,
+			BundleID:       slot.BundleID,
+			Label:          slot.BundleLabel,
+			ReservationURL: slot.ReservationURL,
+			Slots:          []SlotInfo{slot},
+		})
+	}
+	return sections
+}
+
+// MessageLimits describes how much of a notification fits in one message on
+// a channel. Zero means no limit.
+type MessageLimits struct {
+	MaxSections     int // sections per message
+	MaxSectionSlots int // slots listed per section; the rest become "ほかN枠"
+	MaxSectionSize  int // size of one section, as measured by the channel
+	MaxSize         int // size of the sections in one message
+	MaxMessages     int // messages per notification
+}
+
+// Channel message limits. Sizes leave room for each message's heading and
+// footer.
+var (
+	// LINE Notify: 1000 characters per message
+	lineNotifyLimits = MessageLimits{MaxSectionSlots: 10, MaxSize: 800, MaxMessages: 1}
+	// LINE Messaging API: up to 5 messages per push, a Flex bubble of at most
+	// 30 KB of JSON
+	lineMessagingLimits = MessageLimits{MaxSections: 10, MaxSectionSlots: 15, MaxSize: 20000, MaxMessages: 5}
+	// Slack: 50 blocks per message, 3000 characters per section block
+	slackLimits = MessageLimits{MaxSections: 45, MaxSectionSlots: 40, MaxSectionSize: 2900, MaxSize: 35000, MaxMessages: 1}
+	// Discord: 10 embeds (one kept for the omitted summary) with 25 fields
+	// each and 6000 characters in total
+	discordLimits = MessageLimits{MaxSections: 9, MaxSectionSlots: 24, MaxSize: 5500, MaxMessages: 1}
+	// Web Push: one encrypted payload of about 4 KB
+	webPushLimits = MessageLimits{MaxSectionSlots: 5, MaxSize: 3000, MaxMessages: 1}
+)
+
+// Page is one message of a notification.
+type Page struct {
+	Number   int // 1-based
+	Total    int
+	Sections []Section
+	// Omitted is the number of slots left out because the notification
+	// needed more messages than the channel allows; MoreURL lists them.
+	Omitted int
+	MoreURL string
+}
+
+// Paged reports whether the notification is split over several messages.
+func (p Page) Paged() bool {
+	return p.Total > 1
+}
+
+// OmittedLine summarizes the slots left out of the notification, or "" if none.
+func (p Page) OmittedLine() string {
+	if p.Omitted == 0 {
+		return ""
+	}
+	return fmt.Sprintf("ほか%d件の空き枠があります。すべての空き枠: %s", p.Omitted, p.MoreURL)
+}
+
+// Layout splits a notification into messages within limits. size measures a
+// section as the channel renders it; it may be nil when limits has no
+// MaxSectionSize or MaxSize. moreURL is linked from the summary of omitted
+// slots.
+func Layout(n *Notification, limits MessageLimits, size func(Section) int, moreURL string) []Page {
+	sections := n.Sections()
+	for i := range sections {
+		sec := &sections[i]
+		if limits.MaxSectionSlots > 0 && len(sec.Slots) > limits.MaxSectionSlots {
+			sec.Hidden = len(sec.Slots) - limits.MaxSectionSlots
+			sec.Slots = sec.Slots[:limits.MaxSectionSlots]
+		}
+		// A section too large on its own lists fewer slots
+		for len(sec.Slots) > 1 && tooLarge(*sec, limits, size) {
+			sec.Slots = sec.Slots[:len(sec.Slots)-1]
+			sec.Hidden++
+		}
+	}
+
+	var pages []Page
+	var current Page
+	used := 0
+	for _, sec := range sections {
+		s := 0
+		if limits.MaxSize > 0 {
+			s = size(sec)
+		}
+		full := (limits.MaxSections > 0 && len(current.Sections) == limits.MaxSections) ||
+			(limits.MaxSize > 0 && used+s > limits.MaxSize)
+		if full && len(current.Sections) > 0 {
+			pages = append(pages, current)
+			current, used = Page{}, 0
+		}
+		current.Sections = append(current.Sections, sec)
+		used += s
+	}
+	if len(current.Sections) > 0 {
+		pages = append(pages, current)
+	}
+
+	if limits.MaxMessages > 0 && len(pages) > limits.MaxMessages {
+		omitted := 0
+		for _, p := range pages[limits.MaxMessages:] {
+			for _, sec := range p.Sections {
+				omitted += len(sec.Slots) + sec.Hidden
+			}
+		}
+		pages = pages[:limits.MaxMessages]
+		last := &pages[len(pages)-1]
+		last.Omitted, last.MoreURL = omitted, moreURL
+	}
+	for i := range pages {
+		pages[i].Number, pages[i].Total = i+1, len(pages)
+	}
+	return pages
+}
+
+// tooLarge reports whether a section exceeds the section size limit or would
+// not fit in a message by itself.
+func tooLarge(sec Section, limits MessageLimits, size func(Section) int) bool {
+	if limits.MaxSectionSize == 0 && limits.MaxSize == 0 {
+		return false
+	}
+	s := size(sec)
+	return (limits.MaxSectionSize > 0 && s > limits.MaxSectionSize) || (limits.MaxSize > 0 && s > limits.MaxSize)
+}
+
+// pageTitle is the heading of a chat message, numbered when the notification
+// is split, e.g. "空き枠通知（12件）1/2".
+func pageTitle(n *Notification, p Page) string {
+	title := messageTitle(n)
+	if p.Paged() {
+		title += fmt.Sprintf(" %d/%d", p.Number, p.Total)
+	}
+	return title
+}
+
+// sectionText renders a section as plain text: a heading, one line per slot
+// and the reservation link, or a link under each slot.
+func sectionText(sec Section) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "■ %s", sec.Title())
+	for _, slot := range sec.Slots {
+		fmt.Fprintf(&b, "\n・%s", sec.SlotLine(slot))
+		if u := sec.SlotURL(slot); u != "" {
+			fmt.Fprintf(&b, "\n　予約: %s", u)
+		}
+	}
+	if line := sec.HiddenLine(); line != "" {
+		fmt.Fprintf(&b, "\n・%s", line)
+	}
+	if sec.ReservationURL != "" {
+		fmt.Fprintf(&b, "\n予約: %s", sec.ReservationURL)
+	}
+	return b.String()
+}
+
+// textSize measures plain text in characters, as chat services count them.
+func textSize(sec Section) int {
+	return len([]rune(sectionText(sec))) + 2 // blank line between sections
+}
+
+// dashboardURL is the team dashboard, linked when slots are omitted.
+func dashboardURL() string {
+	return strings.TrimRight(getEnv("BASE_URL", "http://localhost:8000"), "/") + "/user"
+}
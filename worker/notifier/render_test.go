@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	slot := func(id, facility, date, time string) SlotInfo {
+		return SlotInfo{SlotID: id, FacilityName: facility, SlotDate: date, SlotTime: time, CourtName: "A面"}
+	}
+
+	t.Run("施設と日付ごとにまとめセットは独立したセクションにすべき", func(t *testing.T) {
+		n := &Notification{Slots: []SlotInfo{
+			slot("1", "B球場", "2099-01-03", "09:00-11:00"),
+			{SlotID: "2", FacilityName: "A球場", SlotDate: "2099-01-04", SlotTime: "09:00-11:00", BundleID: "b1", BundleLabel: "2日連続"},
+			{SlotID: "3", FacilityName: "A球場", SlotDate: "2099-01-03", SlotTime: "09:00-11:00", BundleID: "b1", BundleLabel: "2日連続"},
+			slot("4", "A球場", "2099-01-03", "13:00-15:00"),
+		}}
+		sections := n.Sections()
+		if len(sections) != 3 {
+			t.Fatalf("3セクションになるべき: %+v", sections)
+		}
+		if got := sections[0].Title(); got != "A球場（2日連続セット）" {
+			t.Errorf("複数日のセットは日付なしの見出しにすべき: %s", got)
+		}
+		if got := sections[0].SlotLine(sections[0].Slots[0]); got != "2099-01-03 09:00-11:00 -" {
+			t.Errorf("複数日のセットは行に日付を含むべき: %s", got)
+		}
+		if sections[1].Title() != "A球場 2099-01-03" || sections[2].FacilityName != "B球場" {
+			t.Errorf("施設名と日付の順に並ぶべき: %+v", sections)
+		}
+	})
+
+	t.Run("枠ごとにリンクが異なるときは各枠にリンクすべき", func(t *testing.T) {
+		shared := slot("1", "A球場", "2099-01-03", "09:00-11:00")
+		shared.ReservationURL = "https://reserve.example/"
+		other := slot("2", "A球場", "2099-01-03", "13:00-15:00")
+		other.ReservationURL = "https://reserve.example/"
+		if sec := (&Notification{Slots: []SlotInfo{shared, other}}).Sections()[0]; sec.ReservationURL != "https://reserve.example/" || sec.SlotURL(shared) != "" {
+			t.Errorf("共通のリンクはセクションにまとめるべき: %+v", sec)
+		}
+
+		tracked := []SlotInfo{shared, other}
+		tracked[0].ReservationURL = "https://akigura.example/r/aaa"
+		tracked[1].ReservationURL = "https://akigura.example/r/bbb"
+		sec := (&Notification{Slots: tracked}).Sections()[0]
+		if sec.ReservationURL != "" {
+			t.Errorf("最初の枠のリンクをセクションに使うべきではない: %s", sec.ReservationURL)
+		}
+		if sec.SlotURL(sec.Slots[1]) != "https://akigura.example/r/bbb" {
+			t.Errorf("各枠のリンクを返すべき: %s", sec.SlotURL(sec.Slots[1]))
+		}
+		text := sectionText(sec)
+		if !strings.Contains(text, "https://akigura.example/r/aaa") || !strings.Contains(text, "https://akigura.example/r/bbb") {
+			t.Errorf("本文に各枠のリンクを含むべき: %s", text)
+		}
+		if text := slackSectionText(sec); !strings.Contains(text, "<https://akigura.example/r/bbb|") {
+			t.Errorf("Slackでは各枠の行をリンクにすべき: %s", text)
+		}
+	})
+
+	t.Run("セクションの枠数を超えた分はほかN枠にすべき", func(t *testing.T) {
+		n := &Notification{}
+		for i := range 12 {
+			n.Slots = append(n.Slots, slot(fmt.Sprint(i), "A球場", "2099-01-03", fmt.Sprintf("%02d:00", i+6)))
+		}
+		pages := Layout(n, MessageLimits{MaxSectionSlots: 10}, nil, "")
+		sec := pages[0].Sections[0]
+		if len(sec.Slots) != 10 || sec.Hidden != 2 || !strings.Contains(sectionText(sec), "・ほか2枠") {
+			t.Errorf("10枠とほか2枠にすべき: %+v", sec)
+		}
+	})
+
+	t.Run("上限を超えるセクションは次のメッセージに送り超過分は件数とリンクにすべき", func(t *testing.T) {
+		n := &Notification{}
+		for day := range 7 {
+			n.Slots = append(n.Slots, slot(fmt.Sprint(day), "A球場", fmt.Sprintf("2099-01-%02d", day+1), "09:00-11:00"))
+		}
+		n.Slots = append(n.Slots, slot("x", "A球場", "2099-01-07", "13:00-15:00"))
+		pages := Layout(n, MessageLimits{MaxSections: 2, MaxMessages: 3}, nil, "https://akigura.example/user")
+		if len(pages) != 3 {
+			t.Fatalf("3通に収めるべき: %d", len(pages))
+		}
+		for i, p := range pages {
+			if p.Number != i+1 || p.Total != 3 || len(p.Sections) != 2 {
+				t.Errorf("unexpected page %d: %+v", i+1, p)
+			}
+		}
+		if last := pages[2]; last.Omitted != 2 || last.OmittedLine() != "ほか2件の空き枠があります。すべての空き枠: https://akigura.example/user" {
+			t.Errorf("残りの2件を最後のメッセージで示すべき: %+v", last)
+		}
+		if pages[0].Omitted != 0 || pages[0].OmittedLine() != "" {
+			t.Errorf("最後以外のメッセージには件数を出すべきではない: %+v", pages[0])
+		}
+	})
+
+	t.Run("サイズの上限でメッセージを分けるべき", func(t *testing.T) {
+		n := &Notification{}
+		for day := range 5 {
+			n.Slots = append(n.Slots, slot(fmt.Sprint(day), "A球場", fmt.Sprintf("2099-01-%02d", day+1), "09:00-11:00"))
+		}
+		size := textSize(n.Sections()[0])
+		pages := Layout(n, MessageLimits{MaxSize: size * 2}, textSize, "")
+		if len(pages) != 3 || len(pages[0].Sections) != 2 || len(pages[2].Sections) != 1 {
+			t.Errorf("2セクションずつに分けるべき: %+v", pages)
+		}
+	})
+
+	t.Run("1セクションで上限を超えるときは枠を減らすべき", func(t *testing.T) {
+		n := &Notification{}
+		for i := range 30 {
+			n.Slots = append(n.Slots, slot(fmt.Sprint(i), "A球場", "2099-01-03", fmt.Sprintf("%02d:%02d", 6+i/4, i%4*15)))
+		}
+		pages := Layout(n, MessageLimits{MaxSectionSize: 100}, textSize, "")
+		sec := pages[0].Sections[0]
+		if textSize(sec) > 100 || sec.Hidden == 0 || len(sec.Slots)+sec.Hidden != 30 {
+			t.Errorf("上限内の枠数とほかN枠にすべき: size=%d %+v", textSize(sec), sec)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Retry settings for failed deliveries
+const (
+	MaxDeliveryAttempts = 8
+	RetryBaseDelay      = time.Minute
+	RetryMaxDelay       = time.Hour
+)
+
+// permanent reports whether a delivery error cannot be fixed by retrying.
+func permanent(err error) bool {
+	return errors.Is(err, ErrNoNotifier) || errors.Is(err, ErrRecipientUnavailable)
+}
+
+// jst is the timezone of slot dates and times.
+var jst = time.FixedZone("JST", 9*60*60)
+
+// retryBackoff returns the wait before the next try after the given number
+// of failed attempts: 1m, 2m, 4m, ... capped at RetryMaxDelay.
+func retryBackoff(attempts int) time.Duration {
+	d := RetryBaseDelay
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= RetryMaxDelay {
+			return RetryMaxDelay
+		}
+	}
+	return d
+}
+
+// slotDeadline returns the time after which a notification about the slot is
+// no longer useful: the slot start time, or the end of the slot date when the
+// start time is unknown.
+func slotDeadline(slotDate, timeFrom string) time.Time {
+	if len(slotDate) > 10 {
+		slotDate = slotDate[:10]
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", slotDate+" "+timeFrom, jst); err == nil {
+		return t
+	}
+	d, err := time.ParseInLocation("2006-01-02", slotDate, jst)
+	if err != nil {
+		return time.Time{}
+	}
+	return d.AddDate(0, 0, 1)
+}
+
+// recordAttempt stores one delivery try as part of message messageID and
+// updates the notification's attempt counter. Attempt numbers keep increasing
+// across manual resends, which reset the counter but not the history.
+func recordAttempt(ctx context.Context, db execer, r pendingRow, messageID string, sendErr error) error {
+	var errMsg *string
+	if sendErr != nil {
+		msg := sendErr.Error()
+		errMsg = &msg
+	}
+	success := 0
+	if sendErr == nil {
+		success = 1
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO notification_attempts (id, notification_id, attempt, channel, success, error, attempted_at, message_id)
+		SELECT ?, ?, COALESCE(MAX(attempt), 0) + 1, ?, ?, ?, CURRENT_TIMESTAMP, ?
+		FROM notification_attempts WHERE notification_id = ?
+	`, uuid.New().String(), r.NotificationID, r.Channel, success, errMsg, messageID, r.NotificationID)
+	if err != nil {
+		return fmt.Errorf("record notification attempt: %w", err)
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE notifications SET attempts = attempts + 1, last_error = ? WHERE id = ?
+	`, errMsg, r.NotificationID)
+	if err != nil {
+		return fmt.Errorf("update notification attempts: %w", err)
+	}
+	return nil
+}
+
+// scheduleRetry returns a failed notification to pending with a backoff, or
+// marks it failed when the attempts are exhausted or the next try would be
+// after the slot deadline. It reports whether a retry was scheduled.
+func scheduleRetry(ctx context.Context, db execer, r pendingRow, now time.Time) (bool, error) {
+	attempts := r.Attempts + 1
+	next := now.Add(retryBackoff(attempts))
+	if attempts >= MaxDeliveryAttempts || next.After(slotDeadline(r.SlotDate, r.TimeFrom)) {
+		return false, setStatus(ctx, db, r.NotificationID, "failed")
+	}
+	_, err := db.ExecContext(ctx, `
+		UPDATE notifications SET status = 'pending', next_attempt_at = ? WHERE id = ?
+	`, next.UTC().Format(time.DateTime), r.NotificationID)
+	if err != nil {
+		return false, fmt.Errorf("schedule notification retry: %w", err)
+	}
+	return true, nil
+}
+
+// expire marks a notification failed without sending because its slot has
+// already started.
+func (s *Sender) expire(ctx context.Context, r pendingRow) {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE notifications SET status = 'failed', last_error = 'slot deadline passed' WHERE id = ?
+	`, r.NotificationID)
+	if err != nil {
+		slog.Warn("expire notification", "error", err)
+	}
+}
+
+func (s *Sender) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
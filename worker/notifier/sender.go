@@ -3,31 +3,53 @@ package notifier
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log/slog"
 	"time"
+
+	"akigura.dev/worker"
 )
 
 // Sender processes pending notifications and sends them
 type Sender struct {
 	DB      *sql.DB
 	Manager *Manager
+	// Tracking routes reservation links through the click redirect; nil
+	// links straight to the reservation page.
+	Tracking *TrackingLinks
+	// Now returns the current time. It defaults to time.Now and can be
+	// overridden in tests.
+	Now func() time.Time
 }
 
 // NewSender creates a new notification sender
 func NewSender(db *sql.DB) *Sender {
 	mgr := NewManager()
 	mgr.Register(NewEmailNotifier())
-	// Optionally register LINE and Slack if configured
-	if ln := NewLINENotifier(); ln.AccessToken != "" {
-		mgr.Register(ln)
+	// Slack and Discord post to each team's registered webhook URL
+	mgr.Register(NewSlackNotifier())
+	mgr.Register(NewDiscordNotifier())
+	mgr.Register(NewWebhookNotifier(db))
+	if sms := NewSMSNotifier(db); sms.Provider != nil {
+		mgr.Register(sms)
 	}
-	if sl := NewSlackNotifier(); sl.WebhookURL != "" {
-		mgr.Register(sl)
+	// Web Push needs a VAPID key pair shared with the control plane
+	if wp := NewWebPushNotifier(db); wp.Keys != nil {
+		mgr.Register(wp)
+	}
+	// Optionally register LINE if configured. The Messaging API pushes to
+	// each team's linked LINE account and takes precedence over LINE Notify,
+	// which can only post to a single preconfigured chat.
+	if lm := NewLINEMessagingNotifier(); lm.ChannelAccessToken != "" {
+		mgr.Register(lm)
+	} else if ln := NewLINENotifier(); ln.AccessToken != "" {
+		mgr.Register(ln)
 	}
 
 	return &Sender{
-		DB:      db,
-		Manager: mgr,
+		DB:       db,
+		Manager:  mgr,
+		Tracking: NewTrackingLinks(),
 	}
 }
 
@@ -36,7 +58,14 @@ type pendingRow struct {
 	NotificationID string
 	TeamID         string
 	TeamName       string
-	TeamEmail      string
+	TeamEmail      string // the member's address for member notifications
+	TeamPlan       string
+	MemberID       string
+	MemberName     string
+	Destination    string
+	Secrets        []string
+	AgeMinutes     int
+	Attempts       int
 	Channel        string
 	SlotID         string
 	SlotDate       string
@@ -44,121 +73,468 @@ type pendingRow struct {
 	TimeTo         string
 	CourtName      string
 	FacilityName   string
+	// ReservationURL is the slot's own reservation page, or the
+	// municipality's site when it has none
 	ReservationURL string
+	BundleID       string
+	BundleType     string
+	BundleSize     int
+	// DigestMode is the condition's (or team's) current delivery mode and
+	// Digest the mode the notification was held for, empty until then.
+	DigestMode    string
+	DigestTime    string
+	DigestWeekday int
+	Digest        string
+	// Available is false once a later scrape no longer found the slot.
+	Available bool
+	// Team throttling settings
+	Quiet        QuietHours
+	UrgentBypass bool
+	MinInterval  int // minutes
+	// WatchConditionID matched the slot; ConditionEnabled is false once the
+	// condition was paused, e.g. from an unsubscribe link, or the member the
+	// notification is for opted out of it.
+	WatchConditionID string
+	ConditionEnabled bool
+	// MessageID is the outbox message the notification was sent in, once
+	// claimed. Booked is set once the team recorded booking the slot.
+	MessageID string
+	Booked    bool
 }
 
-// ProcessPending sends all pending notifications, grouped by team
+// ProcessPending sends all pending notifications, grouped by team.
+// Delivery follows the plan policy: higher-priority plans are processed first,
+// each plan's delay must elapse before a match is sent, and slots beyond the
+// plan's daily cap are skipped. Failed deliveries are retried with exponential
+// backoff until MaxDeliveryAttempts or the slot start time, whichever is first.
+//
+// Matches for a condition in a digest mode are held until the team's next
+// digest time and then sent together as one digest per team and channel.
+// Slots that are no longer available by then are dropped.
+//
+// Notifications for team members are grouped per member, so each member
+// opted in to a condition gets one message of their own on each of their
+// channels. The team's timing settings, delays and caps apply to each
+// member separately.
+//
+// Slots the team has recorded as booked are not notified any more, e.g. to
+// members whose notification was held.
+//
+// SMS also counts against the plan's monthly SMS quota, shared by the team
+// and its members. Once it is used up, SMS notifications are skipped; the
+// team's other channels are unaffected.
+//
+// Instant notifications arriving during the team's quiet hours, or within its
+// minimum interval since the last message on the channel, are held and sent
+// together when the hold ends. Same-day slots bypass quiet hours when the
+// team opted in.
 func (s *Sender) ProcessPending(ctx context.Context) (sent, failed int, err error) {
-	rows, err := s.DB.QueryContext(ctx, `
-		SELECT n.id, n.team_id, n.channel, n.slot_id,
-		       t.name as team_name, t.email as team_email,
+	policy, err := LoadPolicy(ctx, s.DB)
+	if err != nil {
+		return 0, 0, fmt.Errorf("load notification policy: %w", err)
+	}
+
+	now := s.now()
+	sent, failed, err = s.recoverStale(ctx, now)
+	if err != nil {
+		return 0, 0, fmt.Errorf("recover interrupted deliveries: %w", err)
+	}
+	pendingRows, err := s.loadRows(ctx, `
+		WHERE n.status = 'pending' AND (n.next_attempt_at IS NULL OR n.next_attempt_at <= ?)
+		ORDER BY COALESCE(pl.notification_priority, 0) DESC, n.team_id, n.member_id, n.channel, sl.slot_date, sl.time_from
+		LIMIT 500
+	`, now.UTC().Format(time.DateTime))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sentToday, err := s.slotsSentToday(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("count notifications sent today: %w", err)
+	}
+	lastSent, err := s.lastSentAt(ctx, now)
+	if err != nil {
+		return 0, 0, fmt.Errorf("load last sent times: %w", err)
+	}
+	smsThisMonth, err := s.countSMSThisMonth(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("count sms sent this month: %w", err)
+	}
+
+	// Group by recipient (and digest), keeping the priority order of the query
+	var keys []string
+	grouped := make(map[string][]pendingRow) // key: teamID:channel[:memberID][:digest]
+	for _, r := range pendingRows {
+		if now.After(slotDeadline(r.SlotDate, r.TimeFrom)) {
+			s.expire(ctx, r) // Too late to be useful
+			continue
+		}
+		if !r.ConditionEnabled {
+			s.drop(ctx, r, "watch condition paused")
+			continue
+		}
+		if r.Booked {
+			s.drop(ctx, r, "slot booked by the team")
+			continue
+		}
+		if r.Digest == "" && r.Attempts == 0 && (r.DigestMode == DigestDaily || r.DigestMode == DigestWeekly) {
+			s.holdForDigest(ctx, r, NextDigestAt(r.DigestMode, r.DigestTime, r.DigestWeekday, now))
+			continue
+		}
+		if r.Digest != "" && !r.Available {
+			s.drop(ctx, r, "slot no longer available")
+			continue
+		}
+		if until := r.Quiet.Until(now); r.Digest == "" && !until.IsZero() &&
+			!(r.UrgentBypass && urgent(r.SlotDate, now)) {
+			s.hold(ctx, r, until) // Coalesced with the rest of the quiet window
+			continue
+		}
+		if !policy.For(r.TeamPlan).Ready(time.Duration(r.AgeMinutes) * time.Minute) {
+			continue // Still within the plan's delivery delay
+		}
+		key := recipientKey(r.TeamID, r.Channel, r.MemberID)
+		if r.Digest != "" {
+			key += ":" + r.Digest
+		}
+		if _, ok := grouped[key]; !ok {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], r)
+	}
+
+	// Send one notification per recipient with all slots
+	for _, key := range keys {
+		rows := grouped[key]
+		first := rows[0]
+		recipient := recipientKey(first.TeamID, first.Channel, first.MemberID)
+
+		if last, ok := lastSent[recipient]; ok && first.Digest == "" && first.MinInterval > 0 {
+			if next := last.Add(time.Duration(first.MinInterval) * time.Minute); next.After(now) {
+				for _, r := range rows {
+					s.hold(ctx, r, next)
+				}
+				continue
+			}
+		}
+
+		teamSent := sentToday[first.TeamID]
+		if teamSent == nil {
+			teamSent = make(map[string]bool)
+			sentToday[first.TeamID] = teamSent
+		}
+		rows, over := applyDailyCap(rows, policy.For(first.TeamPlan), teamSent)
+		for _, r := range over {
+			s.updateStatus(ctx, r.NotificationID, worker.NotificationStatusSkipped)
+		}
+		if len(over) > 0 {
+			slog.Info("daily notification cap reached", "team", first.TeamName, "plan", first.TeamPlan, "skipped", len(over))
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		if first.Channel == worker.ChannelSMS && policy.For(first.TeamPlan).SMSRemaining(smsThisMonth[first.TeamID]) == 0 {
+			for _, r := range rows {
+				s.drop(ctx, r, "monthly SMS quota reached")
+			}
+			slog.Info("monthly sms quota reached", "team", first.TeamName, "plan", first.TeamPlan, "skipped", len(rows))
+			continue
+		}
+
+		n := buildNotification(rows)
+		key, err := s.claim(ctx, n, rows, now)
+		if err != nil {
+			slog.Warn("claim notifications", "team", n.TeamName, "channel", n.Channel, "error", err)
+			continue
+		}
+		if key == "" {
+			continue // Claimed by another sender
+		}
+		n.IdempotencyKey = key
+
+		// Send combined notification
+		if s.deliver(ctx, n, rows, now) {
+			sent += len(rows)
+			for _, r := range rows {
+				teamSent[r.SlotID] = true
+			}
+			lastSent[recipient] = now
+			if n.Channel == worker.ChannelSMS {
+				smsThisMonth[n.TeamID]++
+			}
+		} else {
+			failed += len(rows)
+		}
+	}
+
+	return sent, failed, nil
+}
+
+// recipientKey identifies who a message goes to: the team on a channel, or
+// one of its members on a channel.
+func recipientKey(teamID, channel, memberID string) string {
+	key := teamID + ":" + channel
+	if memberID != "" {
+		key += ":" + memberID
+	}
+	return key
+}
+
+// buildNotification combines rows for one recipient into a notification.
+func buildNotification(rows []pendingRow) *Notification {
+	first := rows[0]
+	n := &Notification{
+		ID:          first.NotificationID, // Use first notification ID for logging
+		TeamID:      first.TeamID,
+		TeamName:    first.TeamName,
+		TeamEmail:   first.TeamEmail,
+		MemberID:    first.MemberID,
+		MemberName:  first.MemberName,
+		Channel:     first.Channel,
+		Destination: first.Destination,
+		Secrets:     first.Secrets,
+		Digest:      first.Digest,
+		Slots:       make([]SlotInfo, 0, len(rows)),
+	}
+
+	// Collect all slots for this team
+	for _, r := range rows {
+		slotTime := r.TimeFrom
+		if r.TimeTo != "" {
+			slotTime = r.TimeFrom + "-" + r.TimeTo
+		}
+		slot := SlotInfo{
+			SlotID:         r.SlotID,
+			SlotDate:       r.SlotDate,
+			SlotTime:       slotTime,
+			CourtName:      r.CourtName,
+			FacilityName:   r.FacilityName,
+			ReservationURL: r.ReservationURL,
+
+			WatchConditionID: r.WatchConditionID,
+			NotificationID:   r.NotificationID,
+		}
+		if r.BundleID != "" {
+			slot.BundleID = r.BundleID
+			slot.BundleLabel = bundleLabel(r.BundleType, r.BundleSize)
+		}
+		n.Slots = append(n.Slots, slot)
+	}
+	return n
+}
+
+// pendingSelect selects notifications with everything needed to send them;
+// loadRows appends the WHERE clause.
+const pendingSelect = `
+		SELECT n.id, n.team_id, n.channel, n.slot_id, n.watch_condition_id,
+		       COALESCE(wc.enabled, 1) AND (n.member_id IS NULL OR EXISTS (
+		           SELECT 1 FROM watch_condition_members wcm
+		           WHERE wcm.watch_condition_id = n.watch_condition_id AND wcm.member_id = n.member_id
+		       )) as condition_enabled,
+		       t.name as team_name, CASE WHEN n.member_id IS NULL THEN t.email ELSE COALESCE(tm.email, '') END as team_email,
+		       t.plan as team_plan, COALESCE(n.member_id, '') as member_id, COALESCE(tm.name, '') as member_name,
+		       CASE
+		           WHEN n.member_id IS NOT NULL THEN CASE WHEN n.channel = 'sms' THEN COALESCE(tm.phone, '') ELSE '' END
+		           WHEN n.channel = 'line' THEN CASE WHEN t.line_followed = 1 THEN COALESCE(t.line_user_id, '') ELSE '' END
+		           ELSE COALESCE(d.target, '')
+		       END as destination,
+		       COALESCE(d.secret, '') as secret,
+		       CASE WHEN d.previous_secret_expires_at > datetime('now') THEN d.previous_secret ELSE '' END as previous_secret,
+		       CAST((julianday('now') - julianday(n.created_at)) * 1440 AS INTEGER) as age_minutes,
+		       n.attempts,
 		       sl.slot_date, sl.time_from, sl.time_to, COALESCE(sl.court_name, '') as court_name,
 		       COALESCE(g.name, '') as facility_name,
-		       COALESCE(m.url, '') as reservation_url
+		       COALESCE(NULLIF(sl.reservation_url, ''), m.url, '') as reservation_url,
+		       COALESCE(n.bundle_id, '') as bundle_id,
+		       COALESCE(wc.bundle_type, '') as bundle_type, COALESCE(wc.bundle_size, 1) as bundle_size,
+		       COALESCE(wc.digest_mode, t.digest_mode) as digest_mode, t.digest_time, t.digest_weekday,
+		       COALESCE(n.digest, '') as digest,
+		       NOT EXISTS (
+		           SELECT 1 FROM scrape_jobs j
+		           WHERE j.municipality_id = sl.municipality_id AND j.status = 'completed'
+		             AND j.started_at > COALESCE(sl.last_seen_at, sl.scraped_at)
+		       ) as available,
+		       COALESCE(t.quiet_start, '') as quiet_start, COALESCE(t.quiet_end, '') as quiet_end,
+		       t.quiet_urgent_bypass, t.min_interval_minutes,
+		       COALESCE(n.message_id, '') as message_id,
+		       EXISTS (SELECT 1 FROM slot_bookings b WHERE b.team_id = n.team_id AND b.slot_id = n.slot_id) as booked
 		FROM notifications n
 		JOIN teams t ON n.team_id = t.id
 		JOIN slots sl ON n.slot_id = sl.id
+		LEFT JOIN team_members tm ON n.member_id = tm.id
+		LEFT JOIN notification_destinations d ON d.team_id = n.team_id AND d.channel = n.channel AND n.member_id IS NULL
+		LEFT JOIN watch_conditions wc ON n.watch_condition_id = wc.id
 		LEFT JOIN grounds g ON sl.ground_id = g.id
 		LEFT JOIN municipalities m ON sl.municipality_id = m.id
-		WHERE n.status = 'pending'
-		ORDER BY n.team_id, n.channel, sl.slot_date, sl.time_from
-		LIMIT 500
-	`)
+		LEFT JOIN plan_limits pl ON pl.plan = t.plan`
+
+// loadRows loads notifications selected by where, which may use the aliases
+// of pendingSelect.
+func (s *Sender) loadRows(ctx context.Context, where string, args ...any) ([]pendingRow, error) {
+	rows, err := s.DB.QueryContext(ctx, pendingSelect+where, args...)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 	defer rows.Close()
 
 	var pendingRows []pendingRow
 	for rows.Next() {
 		var r pendingRow
-		if err := rows.Scan(&r.NotificationID, &r.TeamID, &r.Channel, &r.SlotID,
-			&r.TeamName, &r.TeamEmail,
+		var secret, previousSecret string
+		if err := rows.Scan(&r.NotificationID, &r.TeamID, &r.Channel, &r.SlotID, &r.WatchConditionID, &r.ConditionEnabled,
+			&r.TeamName, &r.TeamEmail, &r.TeamPlan, &r.MemberID, &r.MemberName, &r.Destination, &secret, &previousSecret, &r.AgeMinutes, &r.Attempts,
 			&r.SlotDate, &r.TimeFrom, &r.TimeTo, &r.CourtName,
-			&r.FacilityName, &r.ReservationURL); err != nil {
+			&r.FacilityName, &r.ReservationURL,
+			&r.BundleID, &r.BundleType, &r.BundleSize,
+			&r.DigestMode, &r.DigestTime, &r.DigestWeekday, &r.Digest, &r.Available,
+			&r.Quiet.Start, &r.Quiet.End, &r.UrgentBypass, &r.MinInterval,
+			&r.MessageID, &r.Booked); err != nil {
 			slog.Warn("scan notification", "error", err)
 			continue
 		}
+		for _, sec := range []string{secret, previousSecret} {
+			if sec != "" {
+				r.Secrets = append(r.Secrets, sec)
+			}
+		}
 		pendingRows = append(pendingRows, r)
 	}
+	return pendingRows, rows.Err()
+}
 
-	// Group by team + channel
-	grouped := make(map[string][]pendingRow) // key: teamID:channel
-	for _, r := range pendingRows {
-		key := r.TeamID + ":" + r.Channel
-		grouped[key] = append(grouped[key], r)
+// applyDailyCap splits rows into those within the plan's daily allowance
+// and those over it. The cap counts distinct slots per team: sent holds the
+// slots already notified to the team today on any channel or member, and
+// rows for those slots are always within, so adding a channel or a member
+// does not multiply the allowance. Rows are already ordered by slot date, so
+// the earliest new slots are kept. A bundle is never split: if any of its
+// slots is over the cap, the whole bundle is.
+func applyDailyCap(rows []pendingRow, pp PlanPolicy, sent map[string]bool) (within, over []pendingRow) {
+	remaining := pp.Remaining(len(sent))
+	if remaining < 0 {
+		return rows, nil
 	}
 
-	// Send one notification per team+channel with all slots
-	for _, rows := range grouped {
-		if len(rows) == 0 {
-			continue
+	fits := make([]bool, len(rows))
+	added := make(map[string]bool)
+	overBundles := make(map[string]bool)
+	for i, r := range rows {
+		switch {
+		case sent[r.SlotID] || added[r.SlotID]:
+			fits[i] = true
+		case len(added) < remaining:
+			added[r.SlotID] = true
+			fits[i] = true
+		case r.BundleID != "":
+			overBundles[r.BundleID] = true
 		}
-
-		first := rows[0]
-		n := &Notification{
-			ID:        first.NotificationID, // Use first notification ID for logging
-			TeamID:    first.TeamID,
-			TeamName:  first.TeamName,
-			TeamEmail: first.TeamEmail,
-			Channel:   first.Channel,
-			Slots:     make([]SlotInfo, 0, len(rows)),
-		}
-
-		// Collect all slots and notification IDs for this team
-		var notificationIDs []string
-		for _, r := range rows {
-			slotTime := r.TimeFrom
-			if r.TimeTo != "" {
-				slotTime = r.TimeFrom + "-" + r.TimeTo
-			}
-			n.Slots = append(n.Slots, SlotInfo{
-				SlotID:         r.SlotID,
-				SlotDate:       r.SlotDate,
-				SlotTime:       slotTime,
-				CourtName:      r.CourtName,
-				FacilityName:   r.FacilityName,
-				ReservationURL: r.ReservationURL,
-			})
-			notificationIDs = append(notificationIDs, r.NotificationID)
+	}
+	for i, r := range rows {
+		if fits[i] && !overBundles[r.BundleID] {
+			within = append(within, r)
+		} else {
+			over = append(over, r)
 		}
+	}
+	return within, over
+}
 
-		// Send combined notification
-		err := s.Manager.Send(ctx, n)
-		if err != nil {
-			slog.Warn("send notification failed", "team", n.TeamName, "slots", len(n.Slots), "error", err)
-			for _, id := range notificationIDs {
-				s.updateStatus(ctx, id, "failed")
-			}
-			failed += len(notificationIDs)
-		} else {
-			slog.Info("notification sent", "channel", n.Channel, "team", n.TeamName, "slots", len(n.Slots))
-			for _, id := range notificationIDs {
-				s.updateStatus(ctx, id, "sent")
-			}
-			sent += len(notificationIDs)
+// slotsSentToday returns the slots notified to each team since midnight
+// JST, keyed by team and slot ID, for the per-team daily cap.
+func (s *Sender) slotsSentToday(ctx context.Context) (map[string]map[string]bool, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT DISTINCT team_id, slot_id
+		FROM notifications
+		WHERE status = ? AND date(sent_at, '+9 hours') = date('now', '+9 hours')
+	`, worker.NotificationStatusSent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sent := make(map[string]map[string]bool)
+	for rows.Next() {
+		var teamID, slotID string
+		if err := rows.Scan(&teamID, &slotID); err != nil {
+			return nil, err
 		}
+		if sent[teamID] == nil {
+			sent[teamID] = make(map[string]bool)
+		}
+		sent[teamID][slotID] = true
+	}
+	return sent, rows.Err()
+}
+
+// countSMSThisMonth returns the number of SMS sent per team since the start
+// of the JST month, including verification codes and tests.
+func (s *Sender) countSMSThisMonth(ctx context.Context) (map[string]int, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT team_id, COUNT(*)
+		FROM sms_usage
+		WHERE strftime('%Y-%m', created_at, '+9 hours') = strftime('%Y-%m', 'now', '+9 hours')
+		GROUP BY team_id
+	`)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	return sent, failed, nil
+	counts := make(map[string]int)
+	for rows.Next() {
+		var teamID string
+		var n int
+		if err := rows.Scan(&teamID, &n); err != nil {
+			return nil, err
+		}
+		counts[teamID] = n
+	}
+	return counts, rows.Err()
 }
 
 func (s *Sender) updateStatus(ctx context.Context, id, status string) {
-	_, err := s.DB.ExecContext(ctx, `
+	if err := setStatus(ctx, s.DB, id, status); err != nil {
+		slog.Warn("update notification status", "error", err)
+	}
+}
+
+func setStatus(ctx context.Context, db execer, id, status string) error {
+	_, err := db.ExecContext(ctx, `
 		UPDATE notifications 
 		SET status = ?, sent_at = CASE WHEN ? = 'sent' THEN CURRENT_TIMESTAMP ELSE sent_at END
 		WHERE id = ?
 	`, status, status, id)
+	return err
+}
+
+// holdForDigest defers a notification to the next digest time.
+func (s *Sender) holdForDigest(ctx context.Context, r pendingRow, at time.Time) {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE notifications SET digest = ?, next_attempt_at = ? WHERE id = ?
+	`, r.DigestMode, at.UTC().Format(time.DateTime), r.NotificationID)
 	if err != nil {
-		slog.Warn("update notification status", "error", err)
+		slog.Warn("hold notification for digest", "error", err)
+	}
+}
+
+// drop skips a notification without sending, recording why.
+func (s *Sender) drop(ctx context.Context, r pendingRow, reason string) {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE notifications SET status = ?, last_error = ? WHERE id = ?
+	`, worker.NotificationStatusSkipped, reason, r.NotificationID)
+	if err != nil {
+		slog.Warn("drop notification", "error", err)
 	}
 }
 
-// StartSender starts a periodic notification sender
+// StartSender starts a periodic notification sender. Channel tests and
+// claim notices are picked up every ChannelTestInterval in between.
 func (s *Sender) StartSender(ctx context.Context, interval time.Duration) {
 	slog.Info("starting notification sender", "interval", interval)
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	tests := time.NewTicker(ChannelTestInterval)
+	defer tests.Stop()
 
 	for {
 		select {
@@ -172,6 +548,13 @@ func (s *Sender) StartSender(ctx context.Context, interval time.Duration) {
 			} else if sent > 0 || failed > 0 {
 				slog.Info("notifications processed", "sent", sent, "failed", failed)
 			}
+		case <-tests.C:
+			if _, err := s.ProcessChannelTests(ctx); err != nil {
+				slog.Error("process channel tests", "error", err)
+			}
+			if _, err := s.ProcessClaimNotices(ctx); err != nil {
+				slog.Error("process claim notices", "error", err)
+			}
 		}
 	}
 }
@@ -0,0 +1,515 @@
+package notifier
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"akigura.dev/worker"
+	"akigura.dev/worker/dbmigrate"
+	_ "modernc.org/sqlite"
+)
+
+const migrationsDir = "../../control-plane/db/migrations"
+
+// Seeded by 014-grounds-seed-data.sql
+const (
+	testMunicipalityID = "d9e5f0b2-3456-5789-0bcd-ef0123456789"
+	testGroundID       = "d0000001-0001-4000-8000-000000000001"
+)
+
+// recordingNotifier records notifications instead of delivering them.
+type recordingNotifier struct {
+	channel string
+	sent    []*Notification
+	err     error
+}
+
+func (r *recordingNotifier) Channel() string { return r.channel }
+
+func (r *recordingNotifier) Send(ctx context.Context, n *Notification) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
+		t.Skip("migrations directory not found (expected in monorepo layout)")
+	}
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := dbmigrate.RunMigrations(db, migrationsDir); err != nil {
+		t.Fatalf("RunMigrations failed: %v", err)
+	}
+	return db
+}
+
+func mustExec(t *testing.T, db *sql.DB, query string, args ...any) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("exec %q: %v", query, err)
+	}
+}
+
+// seedTeam creates a team with one watch condition on the test ground.
+func seedTeam(t *testing.T, db *sql.DB, teamID, plan string) {
+	t.Helper()
+	mustExec(t, db, `INSERT INTO teams (id, name, email, plan) VALUES (?, ?, ?, ?)`,
+		teamID, teamID, teamID+"@example.com", plan)
+	mustExec(t, db, `INSERT INTO watch_conditions (id, team_id, facility_id, days_of_week, time_from, time_to)
+		VALUES (?, ?, ?, '[]', '00:00', '24:00')`, "wc-"+teamID, teamID, testGroundID)
+}
+
+// seedPending creates a slot and a pending notification created minutesAgo minutes ago.
+func seedPending(t *testing.T, db *sql.DB, teamID, slotID, date string, minutesAgo int) {
+	t.Helper()
+	mustExec(t, db, `INSERT OR IGNORE INTO slots (id, municipality_id, ground_id, slot_date, time_from, time_to, court_name)
+		VALUES (?, ?, ?, ?, '09:00', '11:00', ?)`, slotID, testMunicipalityID, testGroundID, date, slotID)
+	mustExec(t, db, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, status, created_at)
+		VALUES (?, ?, ?, ?, 'email', 'pending', datetime('now', ?))`,
+		teamID+"-"+slotID, teamID, "wc-"+teamID, slotID, fmt.Sprintf("-%d minutes", minutesAgo))
+}
+
+func statusOf(t *testing.T, db *sql.DB, id string) string {
+	t.Helper()
+	var status string
+	if err := db.QueryRow(`SELECT status FROM notifications WHERE id = ?`, id).Scan(&status); err != nil {
+		t.Fatalf("query status of %s: %v", id, err)
+	}
+	return status
+}
+
+func newTestSender(db *sql.DB) (*Sender, *recordingNotifier) {
+	rec := &recordingNotifier{channel: "email"}
+	mgr := NewManager()
+	mgr.Register(rec)
+	return &Sender{DB: db, Manager: mgr}, rec
+}
+
+func TestProcessPendingPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("無料プランは遅延時間が経過するまで送信しないべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "free-team", "free")
+		seedTeam(t, db, "pro-team", "pro")
+		seedPending(t, db, "free-team", "slot-1", "2099-01-03", 1)
+		seedPending(t, db, "pro-team", "slot-1", "2099-01-03", 1)
+
+		sender, rec := newTestSender(db)
+		sent, failed, err := sender.ProcessPending(ctx)
+		if err != nil {
+			t.Fatalf("ProcessPending failed: %v", err)
+		}
+		if sent != 1 || failed != 0 {
+			t.Fatalf("pro team only should be sent: sent=%d failed=%d", sent, failed)
+		}
+		if len(rec.sent) != 1 || rec.sent[0].TeamID != "pro-team" {
+			t.Fatalf("unexpected recipients: %+v", rec.sent)
+		}
+		if got := statusOf(t, db, "free-team-slot-1"); got != "pending" {
+			t.Errorf("free team notification should stay pending, got %s", got)
+		}
+	})
+
+	t.Run("遅延時間を過ぎた無料プランの通知は送信されるべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "free-team", "free")
+		seedPending(t, db, "free-team", "slot-1", "2099-01-03", 60)
+
+		sender, rec := newTestSender(db)
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatalf("ProcessPending failed: %v", err)
+		}
+		if len(rec.sent) != 1 {
+			t.Fatalf("free team should be notified after the delay, got %d", len(rec.sent))
+		}
+	})
+
+	t.Run("優先度の高いプランから送信されるべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "a-personal", "personal")
+		seedTeam(t, db, "z-org", "org")
+		seedPending(t, db, "a-personal", "slot-1", "2099-01-03", 60)
+		seedPending(t, db, "z-org", "slot-1", "2099-01-03", 60)
+
+		sender, rec := newTestSender(db)
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatalf("ProcessPending failed: %v", err)
+		}
+		if len(rec.sent) != 2 || rec.sent[0].TeamID != "z-org" {
+			t.Fatalf("org team should be sent first: %+v", rec.sent)
+		}
+	})
+
+	t.Run("1日の上限を超えた枠はスキップされるべき", func(t *testing.T) {
+		db := newTestDB(t)
+		mustExec(t, db, `UPDATE plan_limits SET daily_notification_cap = 2 WHERE plan = 'pro'`)
+		seedTeam(t, db, "pro-team", "pro")
+		seedPending(t, db, "pro-team", "slot-1", "2099-01-01", 1)
+		seedPending(t, db, "pro-team", "slot-2", "2099-01-02", 1)
+		seedPending(t, db, "pro-team", "slot-3", "2099-01-03", 1)
+
+		sender, rec := newTestSender(db)
+		sent, _, err := sender.ProcessPending(ctx)
+		if err != nil {
+			t.Fatalf("ProcessPending failed: %v", err)
+		}
+		if sent != 2 || len(rec.sent) != 1 || len(rec.sent[0].Slots) != 2 {
+			t.Fatalf("only two slots should be sent: sent=%d notifications=%+v", sent, rec.sent)
+		}
+		if got := statusOf(t, db, "pro-team-slot-3"); got != "skipped" {
+			t.Errorf("latest slot should be skipped, got %s", got)
+		}
+
+		// The cap also applies to later runs on the same day
+		seedPending(t, db, "pro-team", "slot-4", "2099-01-04", 1)
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatalf("ProcessPending failed: %v", err)
+		}
+		if got := statusOf(t, db, "pro-team-slot-4"); got != "skipped" {
+			t.Errorf("slot after cap should be skipped, got %s", got)
+		}
+	})
+}
+
+func TestProcessPendingBundles(t *testing.T) {
+	ctx := context.Background()
+
+	seedBundle := func(t *testing.T, db *sql.DB, teamID, bundleID string, slotIDs ...string) {
+		t.Helper()
+		mustExec(t, db, `UPDATE watch_conditions SET bundle_type = 'courts', bundle_size = ? WHERE id = ?`, len(slotIDs), "wc-"+teamID)
+		for _, slotID := range slotIDs {
+			seedPending(t, db, teamID, slotID, "2099-01-03", 60)
+			mustExec(t, db, `UPDATE notifications SET bundle_id = ? WHERE id = ?`, bundleID, teamID+"-"+slotID)
+		}
+	}
+
+	t.Run("上限を跨ぐセットは分割せずにスキップされるべき", func(t *testing.T) {
+		db := newTestDB(t)
+		mustExec(t, db, `UPDATE plan_limits SET daily_notification_cap = 1 WHERE plan = 'pro'`)
+		seedTeam(t, db, "pro-team", "pro")
+		seedBundle(t, db, "pro-team", "bundle-1", "slot-a", "slot-b")
+
+		sender, rec := newTestSender(db)
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatalf("ProcessPending failed: %v", err)
+		}
+		if len(rec.sent) != 0 {
+			t.Fatalf("partial bundle should not be sent: %+v", rec.sent)
+		}
+		for _, id := range []string{"pro-team-slot-a", "pro-team-slot-b"} {
+			if got := statusOf(t, db, id); got != "skipped" {
+				t.Errorf("%s should be skipped, got %s", id, got)
+			}
+		}
+	})
+}
+
+func TestProcessPendingChannels(t *testing.T) {
+	ctx := context.Background()
+
+	seedChannel := func(t *testing.T, db *sql.DB, teamID, slotID, channel string) {
+		t.Helper()
+		seedPending(t, db, teamID, slotID, "2099-01-03", 60)
+		mustExec(t, db, `UPDATE notifications SET id = ?, channel = ? WHERE id = ?`,
+			teamID+"-"+slotID+"-"+channel, channel, teamID+"-"+slotID)
+	}
+
+	t.Run("通知先ごとに別々に送信され同じ枠は上限で1枠と数えるべき", func(t *testing.T) {
+		db := newTestDB(t)
+		mustExec(t, db, `UPDATE plan_limits SET daily_notification_cap = 1 WHERE plan = 'pro'`)
+		seedTeam(t, db, "pro-team", "pro")
+		seedChannel(t, db, "pro-team", "slot-1", "email")
+		seedChannel(t, db, "pro-team", "slot-1", "slack")
+
+		sender, email := newTestSender(db)
+		slack := &recordingNotifier{channel: "slack"}
+		sender.Manager.Register(slack)
+
+		if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 2 {
+			t.Fatalf("both channels should be sent: sent=%d err=%v", sent, err)
+		}
+		if len(email.sent) != 1 || len(slack.sent) != 1 {
+			t.Fatalf("one notification per channel expected: email=%d slack=%d", len(email.sent), len(slack.sent))
+		}
+	})
+
+	t.Run("上限は通知先を増やしてもチームの枠数で数えるべき", func(t *testing.T) {
+		db := newTestDB(t)
+		mustExec(t, db, `UPDATE plan_limits SET daily_notification_cap = 1 WHERE plan = 'pro'`)
+		seedTeam(t, db, "pro-team", "pro")
+		seedChannel(t, db, "pro-team", "slot-1", "email")
+		seedChannel(t, db, "pro-team", "slot-2", "slack")
+
+		sender, email := newTestSender(db)
+		slack := &recordingNotifier{channel: "slack"}
+		sender.Manager.Register(slack)
+
+		if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 1 {
+			t.Fatalf("only one slot should be sent: sent=%d err=%v", sent, err)
+		}
+		if got := statusOf(t, db, "pro-team-slot-2-slack"); got != worker.NotificationStatusSkipped {
+			t.Errorf("second slot on another channel should be skipped, got %s", got)
+		}
+
+		// A slot already sent today does not use the allowance again
+		seedChannel(t, db, "pro-team", "slot-1", "discord")
+		discord := &recordingNotifier{channel: "discord"}
+		sender.Manager.Register(discord)
+		if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 1 || len(discord.sent) != 1 {
+			t.Fatalf("a slot already sent should be sent on a new channel: sent=%d err=%v", sent, err)
+		}
+		if len(email.sent) != 1 || len(slack.sent) != 0 {
+			t.Errorf("unexpected deliveries: email=%d slack=%d", len(email.sent), len(slack.sent))
+		}
+	})
+
+	t.Run("未登録の通知先は再送せずに失敗にすべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "pro-team", "pro")
+		seedChannel(t, db, "pro-team", "slot-1", "line")
+
+		sender, _ := newTestSender(db)
+		if _, failed, err := sender.ProcessPending(ctx); err != nil || failed != 1 {
+			t.Fatalf("ProcessPending should report one failure: failed=%d err=%v", failed, err)
+		}
+		if got := statusOf(t, db, "pro-team-slot-1-line"); got != "failed" {
+			t.Errorf("notification without notifier should fail, got %s", got)
+		}
+	})
+}
+
+// addressNotifier records notifications and fails those to one address.
+type addressNotifier struct {
+	recordingNotifier
+	fail string
+}
+
+func (a *addressNotifier) Send(ctx context.Context, n *Notification) error {
+	if n.TeamEmail == a.fail {
+		return fmt.Errorf("%w: mailbox unavailable", ErrRecipientUnavailable)
+	}
+	return a.recordingNotifier.Send(ctx, n)
+}
+
+func TestProcessPendingMembers(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	seedTeam(t, db, "team", "pro")
+	mustExec(t, db, `INSERT INTO team_members (id, team_id, name, email) VALUES
+		('member-1', 'team', '佐藤', 'sato@example.com'),
+		('member-2', 'team', '鈴木', 'bad@example.com'),
+		('member-3', 'team', '高橋', 'takahashi@example.com')`)
+	mustExec(t, db, `INSERT INTO watch_condition_members (watch_condition_id, member_id) VALUES ('wc-team', 'member-1'), ('wc-team', 'member-2')`)
+	for _, slot := range []string{"slot-1", "slot-2"} {
+		seedPending(t, db, "team", slot, "2099-01-03", 60)
+		mustExec(t, db, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, member_id, created_at)
+			SELECT 'member-1-' || ?, 'team', 'wc-team', ?, 'email', 'member-1', datetime('now', '-60 minutes')`, slot, slot)
+	}
+	mustExec(t, db, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, member_id, created_at)
+		VALUES ('member-2-slot-1', 'team', 'wc-team', 'slot-1', 'email', 'member-2', datetime('now', '-60 minutes')),
+		       ('member-3-slot-1', 'team', 'wc-team', 'slot-1', 'email', 'member-3', datetime('now', '-60 minutes'))`)
+
+	rec := &addressNotifier{recordingNotifier: recordingNotifier{channel: "email"}, fail: "bad@example.com"}
+	mgr := NewManager()
+	mgr.Register(rec)
+	sender := &Sender{DB: db, Manager: mgr}
+	if sent, failed, err := sender.ProcessPending(ctx); err != nil || sent != 4 || failed != 1 {
+		t.Fatalf("ProcessPending: sent=%d failed=%d err=%v", sent, failed, err)
+	}
+
+	t.Run("受信者ごとに1通にまとめて送信すべき", func(t *testing.T) {
+		if len(rec.sent) != 2 {
+			t.Fatalf("チームとメンバーに1通ずつ送るべき: %d", len(rec.sent))
+		}
+		team, member := rec.sent[0], rec.sent[1]
+		if team.MemberID != "" || team.TeamEmail != "team@example.com" || len(team.Slots) != 2 {
+			t.Errorf("チームの通知先に送るべき: %+v", team)
+		}
+		if member.MemberID != "member-1" || member.MemberName != "佐藤" || member.TeamEmail != "sato@example.com" || len(member.Slots) != 2 {
+			t.Errorf("メンバーの連絡先に送るべき: %+v", member)
+		}
+	})
+
+	t.Run("配信状況をメンバーごとに記録すべき", func(t *testing.T) {
+		if got := statusOf(t, db, "member-1-slot-1"); got != "sent" {
+			t.Errorf("届いたメンバーの通知は sent になるべき: %s", got)
+		}
+		if got := statusOf(t, db, "member-2-slot-1"); got != "failed" {
+			t.Errorf("届かなかったメンバーの通知は failed になるべき: %s", got)
+		}
+		var messageStatus string
+		db.QueryRow(`SELECT status FROM notification_messages WHERE member_id = 'member-2'`).Scan(&messageStatus)
+		if messageStatus != "failed" {
+			t.Errorf("送信メッセージにメンバーを記録すべき: %q", messageStatus)
+		}
+		if got := statusOf(t, db, "member-3-slot-1"); got != "skipped" {
+			t.Errorf("オプトインを解除したメンバーの通知はスキップされるべき: %s", got)
+		}
+	})
+
+	t.Run("メンバーへの配信失敗はチームのチャネルの状態に影響しないべき", func(t *testing.T) {
+		var failures int
+		db.QueryRow(`SELECT consecutive_failures FROM channel_health WHERE team_id = 'team' AND channel = 'email'`).Scan(&failures)
+		if failures != 0 {
+			t.Errorf("チームのチャネルは失敗にならないべき: %d", failures)
+		}
+	})
+}
+
+func TestProcessPendingRetry(t *testing.T) {
+	ctx := context.Background()
+	slotStart := time.Date(2099, 1, 3, 9, 0, 0, 0, jst) // seedPending slots start at 09:00
+
+	attemptsOf := func(t *testing.T, db *sql.DB, id string) (attempts int, nextAttempt sql.NullString) {
+		t.Helper()
+		if err := db.QueryRow(`SELECT attempts, next_attempt_at FROM notifications WHERE id = ?`, id).Scan(&attempts, &nextAttempt); err != nil {
+			t.Fatalf("query attempts of %s: %v", id, err)
+		}
+		return attempts, nextAttempt
+	}
+
+	t.Run("送信に失敗した通知はバックオフ後に再送されるべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "pro-team", "pro")
+		seedPending(t, db, "pro-team", "slot-1", "2099-01-03", 1)
+
+		now := slotStart.Add(-24 * time.Hour)
+		sender, rec := newTestSender(db)
+		sender.Now = func() time.Time { return now }
+		rec.err = errors.New("smtp: connection refused")
+
+		if _, failed, err := sender.ProcessPending(ctx); err != nil || failed != 1 {
+			t.Fatalf("ProcessPending should report one failure: failed=%d err=%v", failed, err)
+		}
+		if got := statusOf(t, db, "pro-team-slot-1"); got != "pending" {
+			t.Fatalf("failed notification should stay pending for retry, got %s", got)
+		}
+		if attempts, next := attemptsOf(t, db, "pro-team-slot-1"); attempts != 1 || !next.Valid {
+			t.Fatalf("retry should be scheduled: attempts=%d next=%v", attempts, next)
+		}
+
+		// Not retried before the backoff elapses
+		rec.err = nil
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatalf("ProcessPending failed: %v", err)
+		}
+		if len(rec.sent) != 0 {
+			t.Fatalf("notification should not be retried before backoff: %+v", rec.sent)
+		}
+
+		now = now.Add(RetryBaseDelay + time.Second)
+		if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 1 {
+			t.Fatalf("notification should be retried after backoff: sent=%d err=%v", sent, err)
+		}
+		var count, failures int
+		if err := db.QueryRow(`SELECT COUNT(*), SUM(success = 0) FROM notification_attempts WHERE notification_id = ?`,
+			"pro-team-slot-1").Scan(&count, &failures); err != nil {
+			t.Fatalf("query attempts: %v", err)
+		}
+		if count != 2 || failures != 1 {
+			t.Errorf("both attempts should be recorded: count=%d failures=%d", count, failures)
+		}
+	})
+
+	t.Run("枠の開始までに再送できない場合は失敗にすべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "pro-team", "pro")
+		seedPending(t, db, "pro-team", "slot-1", "2099-01-03", 1)
+
+		sender, rec := newTestSender(db)
+		sender.Now = func() time.Time { return slotStart.Add(-30 * time.Second) }
+		rec.err = errors.New("slack webhook error: 503")
+
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatalf("ProcessPending failed: %v", err)
+		}
+		if got := statusOf(t, db, "pro-team-slot-1"); got != "failed" {
+			t.Errorf("notification past its deadline should fail, got %s", got)
+		}
+	})
+
+	t.Run("開始済みの枠は送信せずに失敗にすべき", func(t *testing.T) {
+		db := newTestDB(t)
+		seedTeam(t, db, "pro-team", "pro")
+		seedPending(t, db, "pro-team", "slot-1", "2099-01-03", 1)
+
+		sender, rec := newTestSender(db)
+		sender.Now = func() time.Time { return slotStart.Add(time.Minute) }
+
+		if _, _, err := sender.ProcessPending(ctx); err != nil {
+			t.Fatalf("ProcessPending failed: %v", err)
+		}
+		if len(rec.sent) != 0 {
+			t.Fatalf("expired notification should not be sent: %+v", rec.sent)
+		}
+		if got := statusOf(t, db, "pro-team-slot-1"); got != "failed" {
+			t.Errorf("expired notification should fail, got %s", got)
+		}
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{10, RetryMaxDelay},
+	}
+	for _, tt := range tests {
+		if got := retryBackoff(tt.attempts); got != tt.want {
+			t.Errorf("retryBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestProcessPendingReservationURL(t *testing.T) {
+	ctx := context.Background()
+	const deepLink = "https://yoyaku.e-kanagawa.lg.jp/Kanagawa/SmartPhone/Wsp_JikanSentaku.aspx?SJCode=01&UseDate=20990103"
+
+	db := newTestDB(t)
+	seedTeam(t, db, "pro-team", "pro")
+	for _, slot := range []string{"slot-link", "slot-none"} {
+		seedPending(t, db, "pro-team", slot, "2099-01-03", 60)
+	}
+	mustExec(t, db, `UPDATE slots SET reservation_url = ? WHERE id = 'slot-link'`, deepLink)
+
+	sender, rec := newTestSender(db)
+	if _, _, err := sender.ProcessPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, n := range rec.sent {
+		for _, slot := range n.Slots {
+			got[slot.SlotID] = slot.ReservationURL
+		}
+	}
+
+	t.Run("枠の予約ページへのリンクを使うべき", func(t *testing.T) {
+		if got["slot-link"] != deepLink {
+			t.Errorf("ReservationURL = %q, want %q", got["slot-link"], deepLink)
+		}
+	})
+
+	t.Run("リンクのない枠は自治体の予約サイトを使うべき", func(t *testing.T) {
+		if got["slot-none"] != "https://yoyaku.e-kanagawa.lg.jp" {
+			t.Errorf("ReservationURL = %q", got["slot-none"])
+		}
+	})
+}
@@ -1,23 +1,23 @@
 package notifier
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
+	"strings"
 )
 
-// SlackNotifier sends notifications via Slack webhook
+// SlackNotifier sends notifications to the team's Slack incoming webhook,
+// which is carried in Notification.Destination.
 type SlackNotifier struct {
-	WebhookURL string
+	Client *http.Client
 }
 
 // NewSlackNotifier creates a new Slack notifier
 func NewSlackNotifier() *SlackNotifier {
 	return &SlackNotifier{
-		WebhookURL: os.Getenv("SLACK_WEBHOOK_URL"),
+		Client: &http.Client{Timeout: webhookTimeout},
 	}
 }
 
@@ -25,47 +25,58 @@ func (s *SlackNotifier) Channel() string {
 	return "slack"
 }
 
+// Send posts the notification as one message, so that a retried delivery
+// never repeats part of it; what does not fit is summarized (see Layout).
 func (s *SlackNotifier) Send(ctx context.Context, n *Notification) error {
-	if s.WebhookURL == "" {
-		return fmt.Errorf("SLACK_WEBHOOK_URL not set")
+	if n.Destination == "" {
+		return fmt.Errorf("%w: no Slack webhook registered", ErrRecipientUnavailable)
 	}
 
 	if len(n.Slots) == 0 {
 		return nil
 	}
 
-	// Build blocks with all slots
+	p := Layout(n, slackLimits, slackSectionSize, dashboardURL())[0]
+	payload := map[string]interface{}{
+		"text":   pageTitle(n, p), // notification fallback
+		"blocks": slackBlocks(n, p),
+	}
+	body, _ := json.Marshal(payload)
+	return postWebhook(ctx, s.Client, n.Destination, body, "slack")
+}
+
+// slackBlocks renders one page as Block Kit blocks: a header, one section
+// per Section and a closing context. The greeting opens the first page.
+func slackBlocks(n *Notification, p Page) []map[string]interface{} {
 	blocks := []map[string]interface{}{
 		{
 			"type": "header",
 			"text": map[string]string{
 				"type":  "plain_text",
-				"text":  fmt.Sprintf("🏈 AkiGura 空き枠通知（%d件）", len(n.Slots)),
+				"text":  "⚾ AkiGura " + pageTitle(n, p),
 				"emoji": "true",
 			},
 		},
-		{
+	}
+	if p.Number == 1 {
+		blocks = append(blocks, map[string]interface{}{
 			"type": "section",
 			"text": map[string]string{
 				"type": "mrkdwn",
-				"text": fmt.Sprintf("*%s* 様\nご登録いただいた条件にマッチする空き枠が見つかりました。", n.TeamName),
+				"text": fmt.Sprintf("*%s* 様\n%s。", n.TeamName, headline(n)),
 			},
-		},
-		{
-			"type": "divider",
-		},
+		})
 	}
+	blocks = append(blocks, map[string]interface{}{
+		"type": "divider",
+	})
 
-	for _, slot := range n.Slots {
+	for _, sec := range p.Sections {
 		sectionBlock := map[string]interface{}{
 			"type": "section",
-			"fields": []map[string]string{
-				{"type": "mrkdwn", "text": fmt.Sprintf("*施設:*\n%s", slot.FacilityName)},
-				{"type": "mrkdwn", "text": fmt.Sprintf("*日時:*\n%s %s", slot.SlotDate, slot.SlotTime)},
-				{"type": "mrkdwn", "text": fmt.Sprintf("*場所:*\n%s", slot.CourtName)},
-			},
+			"text": map[string]string{"type": "mrkdwn", "text": slackSectionText(sec)},
 		}
-		if slot.ReservationURL != "" {
+		if sec.ReservationURL != "" {
 			sectionBlock["accessory"] = map[string]interface{}{
 				"type": "button",
 				"text": map[string]string{
@@ -73,39 +84,69 @@ func (s *SlackNotifier) Send(ctx context.Context, n *Notification) error {
 					"text":  "予約する",
 					"emoji": "true",
 				},
-				"url": slot.ReservationURL,
+				"url": sec.ReservationURL,
 			}
 		}
 		blocks = append(blocks, sectionBlock)
 	}
 
-	blocks = append(blocks, map[string]interface{}{
-		"type": "context",
-		"elements": []map[string]string{
-			{"type": "mrkdwn", "text": "お早めにご予約ください。"},
-		},
-	})
-
-	payload := map[string]interface{}{
-		"blocks": blocks,
+	if p.Omitted > 0 {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{
+				"type": "mrkdwn",
+				"text": fmt.Sprintf("ほか%d件の空き枠があります。<%s|すべての空き枠を見る>", p.Omitted, p.MoreURL),
+			},
+		})
+	}
+	if p.Number == p.Total {
+		text := closing(n)
+		if n.BookedURL != "" {
+			text += fmt.Sprintf(" <%s|予約の宣言・投票・記録>でチームと相談できます。", n.BookedURL)
+		}
+		blocks = append(blocks, map[string]interface{}{
+			"type": "context",
+			"elements": []map[string]string{
+				{"type": "mrkdwn", "text": text},
+			},
+		})
 	}
+	return blocks
+}
 
-	body, _ := json.Marshal(payload)
-	req, err := http.NewRequestWithContext(ctx, "POST", s.WebhookURL, bytes.NewReader(body))
-	if err != nil {
-		return err
+// slackSectionText renders a section as mrkdwn. Slots with links of their
+// own are linked.
+func slackSectionText(sec Section) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "*%s*", sec.Title())
+	for _, slot := range sec.Slots {
+		if u := sec.SlotURL(slot); u != "" {
+			fmt.Fprintf(&text, "\n• <%s|%s>", u, sec.SlotLine(slot))
+			continue
+		}
+		fmt.Fprintf(&text, "\n• %s", sec.SlotLine(slot))
+	}
+	if line := sec.HiddenLine(); line != "" {
+		fmt.Fprintf(&text, "\n• %s", line)
 	}
+	return text.String()
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// slackSectionSize measures a section block's text in characters.
+func slackSectionSize(sec Section) int {
+	return len([]rune(slackSectionText(sec)))
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+// messageTitle is the heading of a chat message.
+func messageTitle(n *Notification) string {
+	if n.Test {
+		return "テスト通知"
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("slack webhook error: %d", resp.StatusCode)
+	if n.Claim != nil {
+		return "予約の宣言"
+	}
+	if n.IsDigest() {
+		return fmt.Sprintf("%s（%d件）", n.DigestLabel(), len(n.Slots))
 	}
-	return nil
+	return fmt.Sprintf("空き枠通知（%d件）", len(n.Slots))
 }
@@ -0,0 +1,171 @@
+package notifier
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"akigura.dev/shared/sms"
+	"akigura.dev/worker"
+)
+
+// SMS
+//
+// SMS reaches people who use neither chat apps nor email. Numbers are
+// verified with a one-time code from the dashboard (see
+// control-plane/srv/sms.go): the team's number is its sms destination, a
+// member's is stored on the member. Messages are sent through an
+// sms.Provider, a Twilio-compatible HTTP API in production or a fake that
+// only records them; the control plane sends its codes the same way.
+//
+// SMS is billed per segment, so a notification is one message of at most
+// smsMaxSegments, listing as many slots as fit and linking to the dashboard
+// for the rest. Every SMS sent is recorded in sms_usage and counted against
+// the plan's monthly quota (PlanPolicy.MonthlySMSQuota).
+
+// smsMaxSegments is the most segments one notification may use. Japanese
+// text is sent as UCS-2, so this is about 200 characters.
+const smsMaxSegments = 3
+
+// SMSNotifier sends notifications as SMS to the team's or member's verified
+// phone number.
+type SMSNotifier struct {
+	Provider sms.Provider
+	DB       *sql.DB // records usage against the plan's quota
+}
+
+// NewSMSNotifier creates an SMS notifier with the provider from the
+// environment. Provider is nil when SMS is not configured.
+func NewSMSNotifier(db *sql.DB) *SMSNotifier {
+	return &SMSNotifier{Provider: sms.NewProviderFromEnv(), DB: db}
+}
+
+func (s *SMSNotifier) Channel() string {
+	return worker.ChannelSMS
+}
+
+func (s *SMSNotifier) Send(ctx context.Context, n *Notification) error {
+	if s.Provider == nil {
+		return fmt.Errorf("SMS provider not configured")
+	}
+	if len(n.Slots) == 0 {
+		return nil
+	}
+	if n.Destination == "" {
+		return fmt.Errorf("%w: no verified phone number", ErrRecipientUnavailable)
+	}
+
+	text := smsText(n, dashboardURL())
+	if err := s.Provider.Send(ctx, n.Destination, text); err != nil {
+		if errors.Is(err, sms.ErrUnreachable) {
+			return fmt.Errorf("%w: %w", ErrRecipientUnavailable, err)
+		}
+		return err
+	}
+	kind := "notification"
+	if n.Test {
+		kind = "test"
+	}
+	var memberID *string
+	if n.MemberID != "" {
+		memberID = &n.MemberID
+	}
+	// The message is out; failing to record it only undercounts the quota
+	if _, err := s.DB.ExecContext(ctx, `
+		INSERT INTO sms_usage (team_id, member_id, kind, segments, created_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, n.TeamID, memberID, kind, sms.Segments(text)); err != nil {
+		slog.Warn("record sms usage", "team", n.TeamName, "error", err)
+	}
+	return nil
+}
+
+// smsText renders the notification as one message of at most
+// smsMaxSegments: a title, the sections that fit with their slots in short
+// form, and a link. Sections that do not fit are summarized as "ほかN件"
+// with a link to the dashboard; a single section links to its reservation
+// page instead.
+func smsText(n *Notification, moreURL string) string {
+	link := moreURL
+	if sections := n.Sections(); len(sections) == 1 && sections[0].ReservationURL != "" {
+		link = sections[0].ReservationURL
+	}
+	// Leave room for the title and the longest possible footer
+	budget := smsMaxSegments*sms.UCS2ConcatSize - sms.Units(smsTitle(n)) - sms.Units(fmt.Sprintf("\nほか%d件 %s", len(n.Slots), link))
+	limits := MessageLimits{MaxSectionSlots: 3, MaxSize: max(budget, 1), MaxMessages: 1}
+	pages := Layout(n, limits, smsSectionSize, moreURL)
+	if len(pages) == 0 {
+		return smsTitle(n)
+	}
+	p := pages[0]
+
+	text := smsRender(n, p, link)
+	// Sizes are measured per section; drop sections until the whole fits
+	for sms.Segments(text) > smsMaxSegments && len(p.Sections) > 1 {
+		last := p.Sections[len(p.Sections)-1]
+		p.Sections = p.Sections[:len(p.Sections)-1]
+		p.Omitted += len(last.Slots) + last.Hidden
+		text = smsRender(n, p, link)
+	}
+	return text
+}
+
+// smsRender renders one page with link at the end.
+func smsRender(n *Notification, p Page, link string) string {
+	lines := []string{smsTitle(n)}
+	for _, sec := range p.Sections {
+		lines = append(lines, smsSectionText(sec))
+	}
+	if p.Omitted > 0 {
+		lines = append(lines, fmt.Sprintf("ほか%d件 %s", p.Omitted, link))
+	} else if link != "" {
+		lines = append(lines, link)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// smsTitle is the first line of an SMS, e.g. "AkiGura 空き枠通知（3件）".
+func smsTitle(n *Notification) string {
+	return "AkiGura " + messageTitle(n)
+}
+
+// smsSectionText renders a section in short form: the ground and date
+// ("A球場 10/20") and one line per slot without the year.
+func smsSectionText(sec Section) string {
+	var b strings.Builder
+	b.WriteString(sec.FacilityName)
+	if sec.SlotDate != "" {
+		b.WriteString(" " + smsDate(sec.SlotDate))
+	}
+	if sec.Label != "" {
+		b.WriteString("（" + sec.Label + "）")
+	}
+	for _, slot := range sec.Slots {
+		line := slot.SlotTime
+		if sec.SlotDate == "" {
+			line = smsDate(slot.SlotDate) + " " + line
+		}
+		if slot.CourtName != "" {
+			line += " " + slot.CourtName
+		}
+		b.WriteString("\n" + line)
+	}
+	if line := sec.HiddenLine(); line != "" {
+		b.WriteString("\n" + line)
+	}
+	return b.String()
+}
+
+func smsSectionSize(sec Section) int {
+	return sms.Units(smsSectionText(sec)) + 1 // newline
+}
+
+// smsDate shortens a slot date to month/day, e.g. "2026-10-20" to "10/20".
+func smsDate(date string) string {
+	if len(date) < 10 {
+		return date
+	}
+	return strings.TrimPrefix(date[5:7], "0") + "/" + strings.TrimPrefix(date[8:10], "0")
+}
@@ -0,0 +1,116 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"akigura.dev/shared/sms"
+)
+
+func TestSMSText(t *testing.T) {
+	t.Run("空き枠が多くても上限のセグメント数に収めるべき", func(t *testing.T) {
+		n := &Notification{TeamName: "team"}
+		for g := 0; g < 8; g++ {
+			for i := 0; i < 5; i++ {
+				n.Slots = append(n.Slots, SlotInfo{
+					SlotID: fmt.Sprintf("s-%d-%d", g, i), SlotDate: "2026-10-20", SlotTime: fmt.Sprintf("%02d:00-%02d:00", 9+i, 10+i),
+					CourtName: "A面", FacilityName: fmt.Sprintf("中央公園野球場%d", g), ReservationURL: "https://example.com/reserve",
+				})
+			}
+		}
+		text := smsText(n, "https://akigura.example/user")
+		if got := sms.Segments(text); got > smsMaxSegments {
+			t.Errorf("%dセグメント以内にすべき: %d\n%s", smsMaxSegments, got, text)
+		}
+		if !strings.Contains(text, "ほか") || !strings.HasSuffix(text, "https://akigura.example/user") {
+			t.Errorf("送れない分はダッシュボードへのリンクでまとめるべき:\n%s", text)
+		}
+		if !strings.Contains(text, "中央公園野球場0 10/20\n09:00-10:00 A面") {
+			t.Errorf("日付と枠を短く表示すべき:\n%s", text)
+		}
+	})
+
+	t.Run("1施設の通知は予約ページにリンクすべき", func(t *testing.T) {
+		n := &Notification{TeamName: "team", Slots: []SlotInfo{
+			{SlotID: "s-1", SlotDate: "2026-01-05", SlotTime: "09:00-11:00", FacilityName: "A球場", ReservationURL: "https://example.com/reserve"},
+		}}
+		want := "AkiGura 空き枠通知（1件）\nA球場 1/5\n09:00-11:00\nhttps://example.com/reserve"
+		if got := smsText(n, "https://akigura.example/user"); got != want {
+			t.Errorf("smsText =\n%s\nwant\n%s", got, want)
+		}
+	})
+}
+
+func TestSMSNotifierUnreachable(t *testing.T) {
+	n := &Notification{TeamName: "team", Destination: "+819012345678", Slots: []SlotInfo{
+		{SlotID: "s-1", SlotDate: "2026-01-05", SlotTime: "09:00-11:00", FacilityName: "A球場"},
+	}}
+	t.Run("受信できない番号は再試行しないべき", func(t *testing.T) {
+		s := &SMSNotifier{Provider: &sms.Fake{Err: fmt.Errorf("%w: landline", sms.ErrUnreachable)}}
+		if err := s.Send(context.Background(), n); !errors.Is(err, ErrRecipientUnavailable) {
+			t.Errorf("ErrRecipientUnavailable を返すべき: %v", err)
+		}
+	})
+	t.Run("一時的なエラーは再試行できるべき", func(t *testing.T) {
+		s := &SMSNotifier{Provider: &sms.Fake{Err: errors.New("sms error: 500")}}
+		if err := s.Send(context.Background(), n); err == nil || errors.Is(err, ErrRecipientUnavailable) {
+			t.Errorf("ErrRecipientUnavailable にしないべき: %v", err)
+		}
+	})
+}
+
+func TestProcessPendingSMS(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	seedTeam(t, db, "pro-team", "pro")
+	seedTeam(t, db, "used-team", "personal")
+	seedTeam(t, db, "free-team", "free")
+	for _, team := range []string{"pro-team", "used-team", "free-team"} {
+		mustExec(t, db, `INSERT INTO notification_destinations (team_id, channel, target) VALUES (?, 'sms', '+819000000000')`, team)
+		mustExec(t, db, `INSERT INTO slots (id, municipality_id, ground_id, slot_date, time_from, time_to)
+			VALUES (?, ?, ?, '2099-01-03', '09:00', '11:00')`, "slot-"+team, testMunicipalityID, testGroundID)
+		mustExec(t, db, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, created_at)
+			VALUES (?, ?, ?, ?, 'sms', datetime('now', '-60 minutes'))`, team+"-sms", team, "wc-"+team, "slot-"+team)
+	}
+	mustExec(t, db, `INSERT INTO team_members (id, team_id, name, phone, channels) VALUES ('member-1', 'pro-team', '佐藤', '+819011112222', '["sms"]')`)
+	mustExec(t, db, `INSERT INTO watch_condition_members (watch_condition_id, member_id) VALUES ('wc-pro-team', 'member-1')`)
+	mustExec(t, db, `INSERT INTO notifications (id, team_id, watch_condition_id, slot_id, channel, member_id, created_at)
+		VALUES ('member-1-sms', 'pro-team', 'wc-pro-team', 'slot-pro-team', 'sms', 'member-1', datetime('now', '-60 minutes'))`)
+	// The personal plan's quota is already used up this month
+	for i := 0; i < 30; i++ {
+		mustExec(t, db, `INSERT INTO sms_usage (team_id, kind) VALUES ('used-team', 'notification')`)
+	}
+
+	provider := &sms.Fake{}
+	mgr := NewManager()
+	mgr.Register(&SMSNotifier{Provider: provider, DB: db})
+	sender := &Sender{DB: db, Manager: mgr}
+	if sent, failed, err := sender.ProcessPending(ctx); err != nil || sent != 2 || failed != 0 {
+		t.Fatalf("ProcessPending: sent=%d failed=%d err=%v", sent, failed, err)
+	}
+
+	t.Run("チームとメンバーの電話番号に送信すべき", func(t *testing.T) {
+		msgs := provider.Messages()
+		if len(msgs) != 2 || msgs[0].To != "+819000000000" || msgs[1].To != "+819011112222" {
+			t.Fatalf("チームとメンバーに1通ずつ送るべき: %+v", msgs)
+		}
+		var count, segments int
+		db.QueryRow(`SELECT COUNT(*), SUM(segments) FROM sms_usage WHERE team_id = 'pro-team' AND kind = 'notification'`).Scan(&count, &segments)
+		if count != 2 || segments != 4 {
+			t.Errorf("送信した SMS を記録すべき: %d通 %dセグメント", count, segments)
+		}
+	})
+
+	t.Run("月間の上限に達したら SMS を送らないべき", func(t *testing.T) {
+		for _, id := range []string{"used-team-sms", "free-team-sms"} {
+			var status, lastError string
+			db.QueryRow(`SELECT status, last_error FROM notifications WHERE id = ?`, id).Scan(&status, &lastError)
+			if status != "skipped" || lastError != "monthly SMS quota reached" {
+				t.Errorf("%s はスキップされるべき: %s %q", id, status, lastError)
+			}
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package notifier
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"strings"
+)
+
+// Click tracking
+//
+// Reservation links in notifications point at the control plane's /r/
+// redirect instead of the municipal site. The redirect records the click for
+// the notification (and so its slot and channel) and then sends the browser
+// on to the reservation page, which it looks up itself: the link names a
+// notification, never a destination. Each message also links to /booked,
+// where the team records which of its slots they booked.
+//
+// Both links carry a token signed with TRACKING_SECRET, shared with the
+// control plane (see control-plane/srv/tracking.go). Without a secret links
+// go straight to the reservation page, as before.
+
+// Tracking token purposes, so a click link cannot be replayed as a booking.
+const (
+	TrackClick  = "click"
+	TrackBooked = "booked"
+)
+
+// trackingSigSize is the number of HMAC bytes kept in a token. Links end up
+// in SMS, where every character counts; 128 bits is plenty for a link.
+const trackingSigSize = 16
+
+// TrackingLinks builds signed click-tracking and booking URLs.
+type TrackingLinks struct {
+	BaseURL string // public URL of the control plane
+	Secret  string
+}
+
+// NewTrackingLinks configures tracking links from BASE_URL and
+// TRACKING_SECRET. It returns nil when no secret is set.
+func NewTrackingLinks() *TrackingLinks {
+	secret := os.Getenv("TRACKING_SECRET")
+	if secret == "" {
+		return nil
+	}
+	return &TrackingLinks{
+		BaseURL: strings.TrimRight(getEnv("BASE_URL", "http://localhost:8000"), "/"),
+		Secret:  secret,
+	}
+}
+
+// TrackingToken signs an ID for a purpose: the ID and the base64url of the
+// truncated HMAC-SHA256 of "purpose:id", joined by a dot. The ID is a
+// notification for clicks and a message for bookings.
+func TrackingToken(secret, purpose, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose + ":" + id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:trackingSigSize])
+}
+
+// ClickURL returns the tracked link for a notification's reservation page.
+func (t *TrackingLinks) ClickURL(notificationID string) string {
+	return t.BaseURL + "/r/" + TrackingToken(t.Secret, TrackClick, notificationID)
+}
+
+// BookedURL returns the page for recording bookings from a message.
+func (t *TrackingLinks) BookedURL(messageID string) string {
+	return t.BaseURL + "/booked?token=" + TrackingToken(t.Secret, TrackBooked, messageID)
+}
+
+// Apply routes the notification's reservation links through the click
+// redirect and sets its booking link. Test notifications have no rows to
+// record against, and outgoing webhooks keep the reservation URL: they are
+// read by programs, not clicked.
+func (t *TrackingLinks) Apply(n *Notification) {
+	if t == nil || n.Test || n.Channel == "webhook" || n.IdempotencyKey == "" {
+		return
+	}
+	for i, slot := range n.Slots {
+		if slot.ReservationURL != "" && slot.NotificationID != "" {
+			n.Slots[i].ReservationURL = t.ClickURL(slot.NotificationID)
+		}
+	}
+	n.BookedURL = t.BookedURL(n.IdempotencyKey)
+}
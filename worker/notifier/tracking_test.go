@@ -0,0 +1,78 @@
+package notifier
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTrackingToken(t *testing.T) {
+	// The control plane verifies the same vectors (srv/tracking_test.go)
+	if got, want := TrackingToken("test-secret", TrackClick, "n-1"), "n-1.gy7SL0MO1fAmp5ofs53zJQ"; got != want {
+		t.Errorf("click token = %s, want %s", got, want)
+	}
+	if got, want := TrackingToken("test-secret", TrackBooked, "m-1"), "m-1.OxRAtl16sqx0D2glP92jWQ"; got != want {
+		t.Errorf("booked token = %s, want %s", got, want)
+	}
+}
+
+func TestProcessPendingTracking(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	seedTeam(t, db, "team-1", "pro")
+	seedPending(t, db, "team-1", "slot-1", "2099-01-03", 60)
+	seedPending(t, db, "team-1", "slot-2", "2099-01-04", 60)
+
+	sender, rec := newTestSender(db)
+	links := &TrackingLinks{BaseURL: "https://akigura.example", Secret: "test-secret"}
+	sender.Tracking = links
+	if sent, _, err := sender.ProcessPending(ctx); err != nil || sent != 2 {
+		t.Fatalf("ProcessPending: sent=%d err=%v", sent, err)
+	}
+	if len(rec.sent) != 1 {
+		t.Fatalf("1通にまとめて送るべき: %d", len(rec.sent))
+	}
+	n := rec.sent[0]
+
+	t.Run("予約リンクは通知ごとのリダイレクトを経由すべき", func(t *testing.T) {
+		for _, slot := range n.Slots {
+			if want := links.ClickURL("team-1-" + slot.SlotID); slot.ReservationURL != want {
+				t.Errorf("%s: ReservationURL = %s, want %s", slot.SlotID, slot.ReservationURL, want)
+			}
+		}
+	})
+
+	t.Run("メッセージの予約記録リンクをメールに載せるべき", func(t *testing.T) {
+		var messageID string
+		db.QueryRow(`SELECT message_id FROM notifications WHERE id = 'team-1-slot-1'`).Scan(&messageID)
+		if want := links.BookedURL(messageID); n.BookedURL != want {
+			t.Fatalf("BookedURL = %s, want %s", n.BookedURL, want)
+		}
+		tmpl, err := LoadEmailTemplates("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := tmpl.Render(n, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, body := range []string{c.Text, c.HTML} {
+			if !strings.Contains(body, n.BookedURL) || !strings.Contains(body, n.Slots[0].ReservationURL) {
+				t.Errorf("本文に予約記録とクリック計測のリンクを含むべき:\n%s", body)
+			}
+		}
+	})
+
+	t.Run("テスト通知と Webhook はリンクを書き換えないべき", func(t *testing.T) {
+		for _, n := range []*Notification{
+			{Channel: "email", Test: true, IdempotencyKey: "m-1"},
+			{Channel: "webhook", IdempotencyKey: "m-1"},
+		} {
+			n.Slots = []SlotInfo{{NotificationID: "n-1", ReservationURL: "https://example.com/reserve"}}
+			links.Apply(n)
+			if n.Slots[0].ReservationURL != "https://example.com/reserve" || n.BookedURL != "" {
+				t.Errorf("%s: %+v", n.Channel, n)
+			}
+		}
+	})
+}
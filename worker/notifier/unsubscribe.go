@@ -0,0 +1,80 @@
+package notifier
+
+import (
+	"os"
+	"slices"
+	"strings"
+
+	"akigura.dev/shared/unsubscribe"
+)
+
+// Unsubscribe links
+//
+// Every notification email links to the control plane's public /unsubscribe
+// endpoint, which pauses watch conditions without a login. The link carries a
+// token signed with UNSUBSCRIBE_SECRET (akigura.dev/shared/unsubscribe),
+// scoped either to the conditions that matched the email's slots or to all of
+// the team's conditions. Emails to a team member carry member scopes instead.
+
+// UnsubscribeLinks builds signed unsubscribe URLs.
+type UnsubscribeLinks struct {
+	BaseURL string // public URL of the control plane
+	Secret  string
+}
+
+// NewUnsubscribeLinks configures unsubscribe links from BASE_URL and
+// UNSUBSCRIBE_SECRET. It returns nil when no secret is set, in which case
+// emails are sent without unsubscribe links.
+func NewUnsubscribeLinks() *UnsubscribeLinks {
+	secret := os.Getenv("UNSUBSCRIBE_SECRET")
+	if secret == "" {
+		return nil
+	}
+	return &UnsubscribeLinks{
+		BaseURL: strings.TrimRight(getEnv("BASE_URL", "http://localhost:8000"), "/"),
+		Secret:  secret,
+	}
+}
+
+func (u *UnsubscribeLinks) url(scope, ownerID string, conditionIDs []string) string {
+	return u.BaseURL + "/unsubscribe?token=" + unsubscribe.Sign(u.Secret, scope, ownerID, conditionIDs)
+}
+
+// ConditionsURL returns the link pausing the conditions behind the
+// notification's slots, or "" when they are unknown. For a member it opts
+// the member out of them instead.
+func (u *UnsubscribeLinks) ConditionsURL(n *Notification) string {
+	ids := n.ConditionIDs()
+	if u == nil || len(ids) == 0 {
+		return ""
+	}
+	if n.MemberID != "" {
+		return u.url(unsubscribe.ScopeMemberConditions, n.MemberID, ids)
+	}
+	return u.url(unsubscribe.ScopeConditions, n.TeamID, ids)
+}
+
+// AllURL returns the link pausing all of the team's conditions, or opting
+// the member out of all of them.
+func (u *UnsubscribeLinks) AllURL(n *Notification) string {
+	if u == nil {
+		return ""
+	}
+	if n.MemberID != "" {
+		return u.url(unsubscribe.ScopeMember, n.MemberID, nil)
+	}
+	return u.url(unsubscribe.ScopeAll, n.TeamID, nil)
+}
+
+// ConditionIDs returns the distinct watch conditions of the notification's
+// slots, sorted.
+func (n *Notification) ConditionIDs() []string {
+	var ids []string
+	for _, slot := range n.Slots {
+		if slot.WatchConditionID != "" && !slices.Contains(ids, slot.WatchConditionID) {
+			ids = append(ids, slot.WatchConditionID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,87 @@
+package notifier
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"akigura.dev/shared/unsubscribe"
+)
+
+func TestEmailUnsubscribeLinks(t *testing.T) {
+	tmpl, err := LoadEmailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsub := &UnsubscribeLinks{BaseURL: "https://akigura.example", Secret: "test-secret"}
+	n := &Notification{TeamID: "team-1", TeamName: "テストチーム", Slots: []SlotInfo{
+		{SlotID: "1", FacilityName: "A球場", WatchConditionID: "wc-2"},
+		{SlotID: "2", FacilityName: "B球場", WatchConditionID: "wc-1"},
+		{SlotID: "3", FacilityName: "B球場", WatchConditionID: "wc-1"},
+	}}
+
+	c, err := tmpl.Render(n, unsub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditionsURL := "https://akigura.example/unsubscribe?token=" + unsubscribe.Sign("test-secret", unsubscribe.ScopeConditions, "team-1", []string{"wc-1", "wc-2"})
+	allURL := "https://akigura.example/unsubscribe?token=" + unsubscribe.Sign("test-secret", unsubscribe.ScopeAll, "team-1", nil)
+	if c.UnsubscribeURL != conditionsURL {
+		t.Errorf("List-Unsubscribe はメール内の条件を対象にすべき: %s", c.UnsubscribeURL)
+	}
+	for _, body := range []string{c.Text, c.HTML} {
+		if !strings.Contains(body, conditionsURL) || !strings.Contains(body, allURL) {
+			t.Errorf("本文に条件とすべての停止リンクを含むべき:\n%s", body)
+		}
+	}
+
+	raw, err := buildMIMEMessage(mail.Address{Address: "noreply@akigura.jp"}, "team@example.com", c, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("List-Unsubscribe"); got != "<"+conditionsURL+">" {
+		t.Errorf("unexpected List-Unsubscribe: %s", got)
+	}
+	if got := msg.Header.Get("List-Unsubscribe-Post"); got != "List-Unsubscribe=One-Click" {
+		t.Errorf("unexpected List-Unsubscribe-Post: %s", got)
+	}
+
+	c, _ = tmpl.Render(n, nil)
+	if c.UnsubscribeURL != "" || strings.Contains(c.Text, "/unsubscribe") {
+		t.Errorf("未設定ならリンクを含めるべきではない: %+v", c)
+	}
+}
+
+func TestMemberUnsubscribeLinks(t *testing.T) {
+	unsub := &UnsubscribeLinks{BaseURL: "https://akigura.example", Secret: "test-secret"}
+	n := &Notification{TeamID: "team-1", MemberID: "member-1", Slots: []SlotInfo{{SlotID: "1", WatchConditionID: "wc-1"}}}
+
+	// A member only opts out of the conditions; the team's are not paused
+	if got, want := unsub.ConditionsURL(n), unsub.url(unsubscribe.ScopeMemberConditions, "member-1", []string{"wc-1"}); got != want {
+		t.Errorf("ConditionsURL = %s, want %s", got, want)
+	}
+	if got, want := unsub.AllURL(n), unsub.url(unsubscribe.ScopeMember, "member-1", nil); got != want {
+		t.Errorf("AllURL = %s, want %s", got, want)
+	}
+}
+
+func TestProcessPendingPausedCondition(t *testing.T) {
+	db := newTestDB(t)
+	seedTeam(t, db, "team", "pro")
+	seedPending(t, db, "team", "slot-1", "2099-01-03", 1)
+	mustExec(t, db, `UPDATE watch_conditions SET enabled = 0 WHERE id = 'wc-team'`)
+
+	sender, rec := newTestSender(db)
+	if sent, _, err := sender.ProcessPending(context.Background()); err != nil || sent != 0 || len(rec.sent) != 0 {
+		t.Fatalf("停止した条件の通知は送信すべきではない: sent=%d err=%v", sent, err)
+	}
+	if got := statusOf(t, db, "team-slot-1"); got != "skipped" {
+		t.Errorf("停止した条件の通知はスキップされるべき, got %s", got)
+	}
+}
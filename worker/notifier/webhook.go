@@ -0,0 +1,252 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"akigura.dev/shared/netguard"
+	"github.com/google/uuid"
+)
+
+// Outgoing webhooks
+//
+// Teams that run their own bots receive raw match events. Each request body
+// is a WebhookPayload and carries these headers:
+//
+//	X-AkiGura-Event-ID:   stable across retries of the same event; receivers
+//	                      should ignore IDs they have already processed
+//	X-AkiGura-Event-Type: slots.matched, or slots.digest for a daily/weekly
+//	                      digest of the slots still available, or slots.test
+//	                      for a sample sent from the dashboard
+//	X-AkiGura-Signature:  t=<unix seconds>,v1=<hex HMAC-SHA256>[,v1=...]
+//	Idempotency-Key:      the outbox message ID, repeated when a delivery
+//	                      interrupted by a restart is sent again
+//
+// The signature is HMAC-SHA256 over "<t>.<body>" keyed with the team's secret.
+// During a secret rotation one v1 is sent per valid secret. Receivers should
+// reject timestamps older than WebhookTolerance to prevent replays.
+
+// Webhook payload and signature settings
+const (
+	WebhookPayloadVersion = "1"
+	WebhookEventMatched   = "slots.matched"
+	WebhookEventDigest    = "slots.digest"
+	WebhookEventTest      = "slots.test"
+	WebhookTolerance      = 5 * time.Minute
+
+	webhookSignatureHeader = "X-AkiGura-Signature"
+	webhookEventIDHeader   = "X-AkiGura-Event-ID"
+	webhookEventTypeHeader = "X-AkiGura-Event-Type"
+	idempotencyKeyHeader   = "Idempotency-Key"
+)
+
+// WebhookPayload is the versioned JSON body of an outgoing webhook.
+type WebhookPayload struct {
+	Version   string     `json:"version"`
+	ID        string     `json:"id"`
+	Type      string     `json:"type"`
+	CreatedAt string     `json:"created_at"` // RFC 3339
+	TeamID    string     `json:"team_id"`
+	Slots     []SlotInfo `json:"slots"`
+}
+
+// WebhookNotifier POSTs signed match events to the team's webhook URL,
+// carried in Notification.Destination, and records every delivery in
+// webhook_deliveries. Teams read the delivery log, so it keeps the status
+// code of a rejected delivery but never the response body.
+type WebhookNotifier struct {
+	DB     *sql.DB
+	Client *http.Client
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewWebhookNotifier creates a new webhook notifier
+func NewWebhookNotifier(db *sql.DB) *WebhookNotifier {
+	return &WebhookNotifier{
+		DB:     db,
+		Client: netguard.NewClient(webhookTimeout),
+	}
+}
+
+func (wh *WebhookNotifier) Channel() string {
+	return "webhook"
+}
+
+// Idempotent is true: receivers deduplicate by event ID and idempotency key.
+func (wh *WebhookNotifier) Idempotent() bool {
+	return true
+}
+
+func (wh *WebhookNotifier) Send(ctx context.Context, n *Notification) error {
+	if n.Destination == "" || len(n.Secrets) == 0 {
+		return fmt.Errorf("%w: no webhook registered", ErrRecipientUnavailable)
+	}
+
+	if len(n.Slots) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if wh.Now != nil {
+		now = wh.Now()
+	}
+	eventType := WebhookEventMatched
+	if n.IsDigest() {
+		eventType = WebhookEventDigest
+	}
+	if n.Test {
+		eventType = WebhookEventTest
+	}
+	payload := WebhookPayload{
+		Version:   WebhookPayloadVersion,
+		ID:        webhookEventID(eventType, n),
+		Type:      eventType,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		TeamID:    n.TeamID,
+		Slots:     n.Slots,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.Destination, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AkiGura-Webhook/"+WebhookPayloadVersion)
+	req.Header.Set(webhookEventIDHeader, payload.ID)
+	req.Header.Set(webhookEventTypeHeader, payload.Type)
+	req.Header.Set(webhookSignatureHeader, SignWebhook(body, now, n.Secrets...))
+	if n.IdempotencyKey != "" {
+		req.Header.Set(idempotencyKeyHeader, n.IdempotencyKey)
+	}
+
+	client := wh.Client
+	if client == nil {
+		client = netguard.NewClient(webhookTimeout)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	status := 0
+	switch {
+	case errors.Is(err, netguard.ErrPrivateAddress):
+		err = fmt.Errorf("%w: %w", ErrRecipientUnavailable, netguard.ErrPrivateAddress)
+	case err == nil:
+		status = resp.StatusCode
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		if status < 200 || status >= 300 {
+			err = fmt.Errorf("webhook error: status %d", status)
+			if status == http.StatusGone {
+				err = fmt.Errorf("%w: %w", ErrRecipientUnavailable, err)
+			}
+		}
+	}
+	wh.logDelivery(ctx, n, payload, status, time.Since(start), err)
+	return err
+}
+
+// logDelivery records one webhook request in the delivery log.
+func (wh *WebhookNotifier) logDelivery(ctx context.Context, n *Notification, p WebhookPayload, status int, d time.Duration, sendErr error) {
+	if wh.DB == nil {
+		return
+	}
+	var errMsg *string
+	if sendErr != nil {
+		msg := sendErr.Error()
+		errMsg = &msg
+	}
+	_, err := wh.DB.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (id, team_id, event_id, event_type, notification_id, url, status_code, success, error, duration_ms, attempted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, uuid.New().String(), n.TeamID, p.ID, p.Type, n.ID, n.Destination, status, sendErr == nil, errMsg, d.Milliseconds())
+	if err != nil {
+		slog.Warn("record webhook delivery", "error", err)
+	}
+}
+
+// webhookEventID derives the event ID from the event type, team and slots
+// so a retried or re-queued delivery of the same event keeps its ID, while a
+// digest or test carrying the same slots as a match is a distinct event.
+// Each channel test is its own event, identified by the test's ID.
+func webhookEventID(eventType string, n *Notification) string {
+	ids := make([]string, len(n.Slots))
+	for i, s := range n.Slots {
+		ids[i] = s.SlotID
+	}
+	slices.Sort(ids)
+	name := "webhook:" + eventType + ":" + n.TeamID + ":" + strings.Join(ids, ",")
+	if n.Test {
+		name += ":" + n.IdempotencyKey
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)).String()
+}
+
+// SignWebhook returns the X-AkiGura-Signature header value for body sent at t,
+// with one v1 signature per secret.
+func SignWebhook(body []byte, t time.Time, secrets ...string) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	parts := []string{"t=" + ts}
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		parts = append(parts, "v1="+webhookMAC(secret, ts, body))
+	}
+	return strings.Join(parts, ",")
+}
+
+// VerifyWebhook checks a signature header as a receiver would: the timestamp
+// must be within WebhookTolerance of now and one v1 must match secret.
+func VerifyWebhook(body []byte, header, secret string, now time.Time) error {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid timestamp")
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > WebhookTolerance || age < -WebhookTolerance {
+		return fmt.Errorf("timestamp outside tolerance")
+	}
+	want := webhookMAC(secret, ts, body)
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(sig), []byte(want)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no matching signature")
+}
+
+func webhookMAC(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
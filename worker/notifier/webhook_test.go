@@ -0,0 +1,173 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"version":"1"}`)
+	now := time.Unix(1_900_000_000, 0)
+
+	t.Run("現在と旧シークレットのどちらでも検証できるべき", func(t *testing.T) {
+		header := SignWebhook(body, now, "new-secret", "old-secret")
+		for _, secret := range []string{"new-secret", "old-secret"} {
+			if err := VerifyWebhook(body, header, secret, now); err != nil {
+				t.Errorf("%s should verify: %v", secret, err)
+			}
+		}
+		if err := VerifyWebhook(body, header, "other", now); err == nil {
+			t.Error("unknown secret should not verify")
+		}
+	})
+
+	t.Run("改ざんや古いタイムスタンプは拒否すべき", func(t *testing.T) {
+		header := SignWebhook(body, now, "secret")
+		if err := VerifyWebhook([]byte(`{"version":"2"}`), header, "secret", now); err == nil {
+			t.Error("tampered body should not verify")
+		}
+		if err := VerifyWebhook(body, header, "secret", now.Add(WebhookTolerance+time.Second)); err == nil {
+			t.Error("replayed request should be rejected")
+		}
+	})
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	var requests []request
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, request{r.Header.Clone(), body})
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/internal", http.StatusFound)
+			return
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, "internal details")
+	}))
+	defer srv.Close()
+
+	db := newTestDB(t)
+	seedTeam(t, db, "pro-team", "pro")
+	wh := NewWebhookNotifier(db)
+	wh.Now = func() time.Time { return now }
+	// The test server listens on loopback, which the notifier's client
+	// refuses; keep the client's redirect policy with a plain transport.
+	wh.Client.Transport = srv.Client().Transport
+	n := &Notification{
+		ID:             "n-1",
+		TeamID:         "pro-team",
+		Channel:        "webhook",
+		Destination:    srv.URL,
+		Secrets:        []string{"whsec_test"},
+		IdempotencyKey: "msg-1",
+		Slots:          []SlotInfo{{SlotID: "slot-1", SlotDate: "2099-01-03", SlotTime: "09:00-11:00", CourtName: "A面", FacilityName: "球場"}},
+	}
+
+	t.Run("署名付きのバージョン付きペイロードを送信すべき", func(t *testing.T) {
+		if err := wh.Send(ctx, n); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		req := requests[0]
+		if err := VerifyWebhook(req.body, req.header.Get("X-AkiGura-Signature"), "whsec_test", now); err != nil {
+			t.Fatalf("signature should verify: %v", err)
+		}
+		var p WebhookPayload
+		if err := json.Unmarshal(req.body, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Version != WebhookPayloadVersion || p.Type != WebhookEventMatched || len(p.Slots) != 1 || p.Slots[0].SlotID != "slot-1" {
+			t.Errorf("unexpected payload: %+v", p)
+		}
+		if req.header.Get("X-AkiGura-Event-ID") != p.ID {
+			t.Errorf("event ID header should match payload")
+		}
+		if req.header.Get("Idempotency-Key") != "msg-1" {
+			t.Errorf("Idempotency-Key header should be the message ID, got %q", req.header.Get("Idempotency-Key"))
+		}
+	})
+
+	t.Run("再送でもイベントIDは変わらず配信ログに記録すべき", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		defer func() { status = http.StatusOK }()
+		if err := wh.Send(ctx, n); err == nil {
+			t.Fatal("5xx should fail")
+		}
+		if requests[1].header.Get("X-AkiGura-Event-ID") != requests[0].header.Get("X-AkiGura-Event-ID") {
+			t.Error("event ID should be stable across retries")
+		}
+
+		var total, failures int
+		if err := db.QueryRow(`SELECT COUNT(*), SUM(success = 0) FROM webhook_deliveries WHERE team_id = 'pro-team'`).Scan(&total, &failures); err != nil {
+			t.Fatal(err)
+		}
+		if total != 2 || failures != 1 {
+			t.Errorf("deliveries should be logged: total=%d failures=%d", total, failures)
+		}
+	})
+
+	t.Run("同じ枠でもまとめ通知やテストは別のイベントIDにすべき", func(t *testing.T) {
+		ids := map[string]bool{}
+		for _, variant := range []func(*Notification){
+			func(*Notification) {},
+			func(v *Notification) { v.Digest = "daily" },
+			func(v *Notification) { v.Test, v.IdempotencyKey = true, "test-1" },
+			func(v *Notification) { v.Test, v.IdempotencyKey = true, "test-2" },
+		} {
+			v := *n
+			variant(&v)
+			if err := wh.Send(ctx, &v); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			ids[requests[len(requests)-1].header.Get("X-AkiGura-Event-ID")] = true
+		}
+		if len(ids) != 4 {
+			t.Errorf("イベントごとに異なるIDにすべき: %v", ids)
+		}
+	})
+
+	t.Run("リダイレクトを追わず、応答本文を記録すべきではない", func(t *testing.T) {
+		before := len(requests)
+		redirected := *n
+		redirected.Destination = srv.URL + "/redirect"
+		if err := wh.Send(ctx, &redirected); err == nil {
+			t.Fatal("redirect should fail")
+		}
+		if len(requests) != before+1 {
+			t.Errorf("redirect should not be followed: %d requests", len(requests)-before)
+		}
+
+		var errMsg string
+		if err := db.QueryRow(`SELECT COALESCE(error, '') FROM webhook_deliveries WHERE team_id = 'pro-team' AND success = 0 ORDER BY rowid DESC LIMIT 1`).Scan(&errMsg); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(errMsg, "internal details") || !strings.Contains(errMsg, "302") {
+			t.Errorf("only the status code should be logged: %q", errMsg)
+		}
+	})
+
+	t.Run("公開されていないアドレスには接続すべきではない", func(t *testing.T) {
+		before := len(requests)
+		err := NewWebhookNotifier(db).Send(ctx, n)
+		if !errors.Is(err, ErrRecipientUnavailable) {
+			t.Errorf("loopback destination should be unavailable: %v", err)
+		}
+		if len(requests) != before {
+			t.Error("no request should reach a loopback address")
+		}
+	})
+}
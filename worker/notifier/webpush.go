@@ -0,0 +1,463 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"akigura.dev/shared/netguard"
+)
+
+// Web Push
+//
+// Members subscribe their browsers from the /user dashboard; each device's
+// push subscription (endpoint and keys) is stored in push_subscriptions. A
+// notification is encrypted for every subscription of the team, or of the
+// team member it is for (RFC 8291, aes128gcm), and posted to its push service
+// with a VAPID token (RFC 8292).
+// Subscriptions the push service reports as gone (404/410) are deleted.
+
+// Web Push settings
+const (
+	// WebPushTTL is how long a push service keeps a message for an offline
+	// device. Slots are booked quickly, so older alerts are not worth showing.
+	WebPushTTL = 6 * time.Hour
+
+	// webPushRecordSize is the aes128gcm record size; the whole payload is
+	// sent as a single record.
+	webPushRecordSize = 4096
+	// webPushMaxPayload is the largest plaintext push services accept: 4096
+	// bytes of body minus the 86-byte header and the 16-byte tag, and the
+	// padding delimiter.
+	webPushMaxPayload = 4096 - 86 - 16 - 1
+	// vapidExpiry is the lifetime of a VAPID token; RFC 8292 allows up to 24h.
+	vapidExpiry = 12 * time.Hour
+)
+
+// VAPIDKeys is the application server's P-256 key pair identifying it to
+// push services. PublicKey is the base64url uncompressed point browsers pass
+// as applicationServerKey when subscribing.
+type VAPIDKeys struct {
+	PublicKey string
+	private   *ecdsa.PrivateKey
+}
+
+// GenerateVAPIDKeys creates a new key pair.
+func GenerateVAPIDKeys() (*VAPIDKeys, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVAPIDPrivateKey(base64.RawURLEncoding.EncodeToString(key.Bytes()))
+}
+
+// ParseVAPIDPrivateKey parses a base64url-encoded raw P-256 private key, the
+// format used by common web-push tooling.
+func ParseVAPIDPrivateKey(s string) (*VAPIDKeys, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode VAPID private key: %w", err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse VAPID private key: %w", err)
+	}
+	pub := key.PublicKey().Bytes() // 0x04 || X || Y
+	return &VAPIDKeys{
+		PublicKey: base64.RawURLEncoding.EncodeToString(pub),
+		private: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(pub[1:33]),
+				Y:     new(big.Int).SetBytes(pub[33:]),
+			},
+			D: new(big.Int).SetBytes(raw),
+		},
+	}, nil
+}
+
+// PrivateKey returns the base64url-encoded raw private key.
+func (k *VAPIDKeys) PrivateKey() string {
+	return base64.RawURLEncoding.EncodeToString(k.private.D.FillBytes(make([]byte, 32)))
+}
+
+// authorization returns the VAPID Authorization header for a push endpoint.
+func (k *VAPIDKeys) authorization(endpoint, subject string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	claims, _ := json.Marshal(map[string]any{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidExpiry).Unix(),
+		"sub": subject,
+	})
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return "vapid t=" + signingInput + "." + base64.RawURLEncoding.EncodeToString(sig) + ", k=" + k.PublicKey, nil
+}
+
+// PushSubscription is a browser's push subscription as stored for a team.
+type PushSubscription struct {
+	ID       string
+	Endpoint string
+	P256dh   string // base64url user agent public key
+	Auth     string // base64url authentication secret
+}
+
+// encryptWebPush encrypts payload for a subscription (RFC 8291).
+func encryptWebPush(payload []byte, sub PushSubscription) ([]byte, error) {
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encryptWebPushWith(payload, sub, asPrivate, salt)
+}
+
+// encryptWebPushWith encrypts payload with the given ephemeral key and salt.
+// The result is an aes128gcm body with a single record.
+func encryptWebPushWith(payload []byte, sub PushSubscription, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	if len(payload) > webPushMaxPayload {
+		return nil, fmt.Errorf("push payload too large: %d bytes", len(payload))
+	}
+	uaRaw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.P256dh, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode p256dh: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaRaw)
+	if err != nil {
+		return nil, fmt.Errorf("parse p256dh: %w", err)
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.Auth, "="))
+	if err != nil || len(authSecret) != 16 {
+		return nil, fmt.Errorf("invalid auth secret")
+	}
+
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	keyInfo := "WebPush: info\x00" + string(uaRaw) + string(asPublic)
+	ikm, err := hkdf.Key(sha256.New, ecdhSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// 0x02 marks the last (and only) record, with no further padding
+	plaintext := append(append([]byte{}, payload...), 0x02)
+
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(webPushRecordSize))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+	body.Write(gcm.Seal(nil, nonce, plaintext, nil))
+	return body.Bytes(), nil
+}
+
+// WebPushMessage is the JSON payload the dashboard's service worker shows as
+// a notification.
+type WebPushMessage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+	// Tag replaces an earlier notification for the same message on the device.
+	Tag string `json:"tag"`
+}
+
+// WebPushNotifier sends notifications to every browser the team has
+// subscribed.
+type WebPushNotifier struct {
+	DB      *sql.DB
+	Keys    *VAPIDKeys
+	Subject string // contact for push services, mailto: or https:
+	BaseURL string // dashboard opened when a notification is clicked
+	Client  *http.Client
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewWebPushNotifier creates a Web Push notifier from VAPID_PRIVATE_KEY and
+// VAPID_SUBJECT. Keys is nil when no valid key is configured.
+func NewWebPushNotifier(db *sql.DB) *WebPushNotifier {
+	wp := &WebPushNotifier{
+		DB:      db,
+		Subject: os.Getenv("VAPID_SUBJECT"),
+		BaseURL: os.Getenv("BASE_URL"),
+		Client:  netguard.NewClient(webhookTimeout),
+	}
+	if wp.Subject == "" {
+		wp.Subject = "mailto:noreply@akigura.dev"
+	}
+	if wp.BaseURL == "" {
+		wp.BaseURL = "http://localhost:8000"
+	}
+	if key := os.Getenv("VAPID_PRIVATE_KEY"); key != "" {
+		keys, err := ParseVAPIDPrivateKey(key)
+		if err != nil {
+			slog.Warn("web push disabled", "error", err)
+		}
+		wp.Keys = keys
+	}
+	return wp
+}
+
+func (wp *WebPushNotifier) Channel() string {
+	return "push"
+}
+
+func (wp *WebPushNotifier) Send(ctx context.Context, n *Notification) error {
+	if wp.Keys == nil {
+		return fmt.Errorf("VAPID_PRIVATE_KEY not set")
+	}
+	if len(n.Slots) == 0 {
+		return nil
+	}
+	subs, err := wp.subscriptions(ctx, n.TeamID, n.MemberID)
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return fmt.Errorf("%w: no push subscriptions", ErrRecipientUnavailable)
+	}
+
+	payload, err := json.Marshal(wp.message(n))
+	if err != nil {
+		return fmt.Errorf("marshal push payload: %w", err)
+	}
+
+	// The notification counts as delivered when it reaches any device.
+	// Devices that failed transiently are not retried so that the others do
+	// not get it twice.
+	var delivered, gone int
+	var errs []error
+	for _, sub := range subs {
+		err := wp.push(ctx, sub, payload, n.IsDigest())
+		switch {
+		case err == nil:
+			delivered++
+		case errors.Is(err, ErrRecipientUnavailable):
+			gone++
+			wp.removeSubscription(ctx, sub, err)
+		default:
+			errs = append(errs, err)
+		}
+	}
+	if delivered > 0 {
+		if len(errs) > 0 {
+			slog.Warn("push not delivered to some devices", "team", n.TeamName, "delivered", delivered, "error", errors.Join(errs...))
+		}
+		return nil
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("%w: all %d push subscriptions expired", ErrRecipientUnavailable, gone)
+	}
+	return errors.Join(errs...)
+}
+
+// fits reports whether the message is within the push service size limit.
+func (m *WebPushMessage) fits() bool {
+	b, _ := json.Marshal(m)
+	return len(b) <= webPushMaxPayload
+}
+
+// message builds the payload, listing as many sections as fit in one
+// message (see Layout); the rest are summarized as "ほかN件".
+func (wp *WebPushNotifier) message(n *Notification) *WebPushMessage {
+	m := &WebPushMessage{
+		Title: "AkiGura " + messageTitle(n),
+		URL:   strings.TrimRight(wp.BaseURL, "/") + "/user",
+		Tag:   "akigura-" + n.ID,
+	}
+	if n.IdempotencyKey != "" {
+		m.Tag = "akigura-" + n.IdempotencyKey
+	}
+	if len(n.Slots) == 1 && n.Slots[0].ReservationURL != "" {
+		m.URL = n.Slots[0].ReservationURL
+	}
+
+	// webPushLimits allows a single message, so there is at most one page
+	if pages := Layout(n, webPushLimits, webPushSectionSize, m.URL); len(pages) > 0 {
+		var parts []string
+		for _, sec := range pages[0].Sections {
+			parts = append(parts, webPushSectionText(sec))
+		}
+		if pages[0].Omitted > 0 {
+			parts = append(parts, fmt.Sprintf("ほか%d件", pages[0].Omitted))
+		}
+		m.Body = strings.Join(parts, "\n")
+	}
+	if m.fits() {
+		return m
+	}
+	m.Body = headline(n)
+	return m
+}
+
+// webPushSectionText renders a section for a notification body.
+func webPushSectionText(sec Section) string {
+	lines := []string{sec.Title()}
+	for _, slot := range sec.Slots {
+		lines = append(lines, "・"+sec.SlotLine(slot))
+	}
+	if line := sec.HiddenLine(); line != "" {
+		lines = append(lines, "・"+line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// webPushSectionSize measures a section in payload bytes.
+func webPushSectionSize(sec Section) int {
+	return len(webPushSectionText(sec)) + 1
+}
+
+// push encrypts and posts payload to one subscription. A subscription the
+// push service no longer knows is reported as ErrRecipientUnavailable.
+func (wp *WebPushNotifier) push(ctx context.Context, sub PushSubscription, payload []byte, digest bool) error {
+	body, err := encryptWebPush(payload, sub)
+	if err != nil {
+		// Keys that cannot be used will never work
+		return fmt.Errorf("%w: %w", ErrRecipientUnavailable, err)
+	}
+	now := time.Now()
+	if wp.Now != nil {
+		now = wp.Now()
+	}
+	auth, err := wp.Keys.authorization(sub.Endpoint, wp.Subject, now)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRecipientUnavailable, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRecipientUnavailable, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(WebPushTTL.Seconds())))
+	req.Header.Set("Authorization", auth)
+	urgency := "high"
+	if digest {
+		urgency = "normal"
+	}
+	req.Header.Set("Urgency", urgency)
+
+	client := wp.Client
+	if client == nil {
+		client = netguard.NewClient(webhookTimeout)
+	}
+	resp, err := client.Do(req)
+	if errors.Is(err, netguard.ErrPrivateAddress) {
+		return fmt.Errorf("%w: %w", ErrRecipientUnavailable, netguard.ErrPrivateAddress)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		wp.touchSubscription(ctx, sub)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("push service error: %d %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return fmt.Errorf("%w: %w", ErrRecipientUnavailable, err)
+	}
+	return err
+}
+
+// subscriptions loads the push subscriptions of the team's own devices, or
+// of the member's when memberID is set.
+func (wp *WebPushNotifier) subscriptions(ctx context.Context, teamID, memberID string) ([]PushSubscription, error) {
+	rows, err := wp.DB.QueryContext(ctx, `
+		SELECT id, endpoint, p256dh, auth FROM push_subscriptions
+		WHERE team_id = ? AND COALESCE(member_id, '') = ?
+		ORDER BY created_at
+	`, teamID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []PushSubscription
+	for rows.Next() {
+		var s PushSubscription
+		if err := rows.Scan(&s.ID, &s.Endpoint, &s.P256dh, &s.Auth); err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+func (wp *WebPushNotifier) touchSubscription(ctx context.Context, sub PushSubscription) {
+	if _, err := wp.DB.ExecContext(ctx, `
+		UPDATE push_subscriptions SET last_success_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, sub.ID); err != nil {
+		slog.Warn("update push subscription", "id", sub.ID, "error", err)
+	}
+}
+
+// removeSubscription deletes a subscription that can no longer be delivered to.
+func (wp *WebPushNotifier) removeSubscription(ctx context.Context, sub PushSubscription, reason error) {
+	if _, err := wp.DB.ExecContext(ctx, `DELETE FROM push_subscriptions WHERE id = ?`, sub.ID); err != nil {
+		slog.Warn("delete push subscription", "id", sub.ID, "error", err)
+		return
+	}
+	slog.Info("push subscription removed", "id", sub.ID, "reason", reason)
+}
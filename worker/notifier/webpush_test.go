@@ -0,0 +1,295 @@
+package notifier
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEncryptWebPush(t *testing.T) {
+	// RFC 8291 Appendix A
+	asPrivate, err := ecdh.P256().NewPrivateKey(b64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := PushSubscription{
+		P256dh: "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+		Auth:   "BTBZMqHH6r4Tts7J_aSIgg",
+	}
+	body, err := encryptWebPushWith([]byte("When I grow up, I want to be a watermelon"), sub, asPrivate, b64(t, "DGv6ra1nlYgDCS1FRnbzlw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if got := base64.RawURLEncoding.EncodeToString(body); got != want {
+		t.Errorf("encrypted body = %s, want %s", got, want)
+	}
+}
+
+// pushDevice is a browser subscription held by the push service stand-in.
+type pushDevice struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+func newPushDevice(t *testing.T) *pushDevice {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &pushDevice{key: key, auth: auth}
+}
+
+func (d *pushDevice) subscription(id, endpoint string) PushSubscription {
+	return PushSubscription{
+		ID:       id,
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(d.key.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(d.auth),
+	}
+}
+
+// decrypt reverses encryptWebPush as the browser does.
+func (d *pushDevice) decrypt(body []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("short body")
+	}
+	salt, rs, idlen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	if rs != webPushRecordSize || len(body) < 21+idlen {
+		return nil, errors.New("bad header")
+	}
+	asRaw := body[21 : 21+idlen]
+	asPublic, err := ecdh.P256().NewPublicKey(asRaw)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := d.key.ECDH(asPublic)
+	if err != nil {
+		return nil, err
+	}
+	ikm, _ := hkdf.Key(sha256.New, secret, d.auth, "WebPush: info\x00"+string(d.key.PublicKey().Bytes())+string(asRaw), 32)
+	prk, _ := hkdf.Extract(sha256.New, ikm, salt)
+	cek, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, body[21+idlen:], nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) == 0 || plain[len(plain)-1] != 0x02 {
+		return nil, errors.New("missing record delimiter")
+	}
+	return plain[:len(plain)-1], nil
+}
+
+// verifyVAPID checks a VAPID Authorization header against the push service origin.
+func verifyVAPID(header, audience, publicKey string) error {
+	token, key, ok := strings.Cut(strings.TrimPrefix(header, "vapid t="), ", k=")
+	if !ok || key != publicKey {
+		return fmt.Errorf("bad authorization header: %q", header)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("bad JWT")
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(key)
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(raw[1:33]), Y: new(big.Int).SetBytes(raw[33:])}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if len(sig) != 64 || !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return errors.New("bad signature")
+	}
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	json.Unmarshal(claimsJSON, &claims)
+	if claims.Aud != audience || claims.Sub == "" || claims.Exp < time.Now().Unix() {
+		return fmt.Errorf("bad claims: %+v", claims)
+	}
+	return nil
+}
+
+func TestWebPushNotifier(t *testing.T) {
+	ctx := context.Background()
+	keys, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseVAPIDPrivateKey(keys.PrivateKey())
+	if err != nil || parsed.PublicKey != keys.PublicKey {
+		t.Fatalf("鍵を往復できるべき: %v", err)
+	}
+
+	// Push service stand-in: /active/* accepts messages, /expired/* is gone
+	devices := map[string]*pushDevice{}
+	var received []WebPushMessage
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if err := verifyVAPID(r.Header.Get("Authorization"), "http://"+r.Host, keys.PublicKey); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/expired/") {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		plain, err := devices[r.URL.Path].decrypt(body)
+		if err != nil || r.Header.Get("Content-Encoding") != "aes128gcm" {
+			http.Error(w, "decrypt: "+fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+		var m WebPushMessage
+		json.Unmarshal(plain, &m)
+		received = append(received, m)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	db := newTestDB(t)
+	seedTeam(t, db, "team", "pro")
+	subscribe := func(id, path string) {
+		t.Helper()
+		d := newPushDevice(t)
+		devices[path] = d
+		s := d.subscription(id, srv.URL+path)
+		mustExec(t, db, `INSERT INTO push_subscriptions (id, team_id, endpoint, p256dh, auth) VALUES (?, 'team', ?, ?, ?)`,
+			s.ID, s.Endpoint, s.P256dh, s.Auth)
+	}
+	countSubs := func() int {
+		var n int
+		db.QueryRow(`SELECT COUNT(*) FROM push_subscriptions WHERE team_id = 'team'`).Scan(&n)
+		return n
+	}
+
+	wp := &WebPushNotifier{DB: db, Keys: keys, Subject: "mailto:test@example.com", BaseURL: "https://akigura.example", Client: srv.Client()}
+	n := &Notification{
+		ID:      "n-1",
+		TeamID:  "team",
+		Channel: "push",
+		Slots:   []SlotInfo{{SlotID: "slot-1", SlotDate: "2099-01-03", SlotTime: "09:00-11:00", FacilityName: "球場", ReservationURL: "https://reserve.example/1"}},
+	}
+
+	t.Run("購読がなければ再送不能なエラーを返すべき", func(t *testing.T) {
+		if err := wp.Send(ctx, n); !errors.Is(err, ErrRecipientUnavailable) {
+			t.Errorf("ErrRecipientUnavailable を返すべき: %v", err)
+		}
+	})
+
+	subscribe("sub-1", "/active/1")
+	subscribe("sub-2", "/active/2")
+	subscribe("sub-3", "/expired/3")
+
+	t.Run("すべての端末に暗号化して送信し失効した購読を削除すべき", func(t *testing.T) {
+		if err := wp.Send(ctx, n); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if len(received) != 2 {
+			t.Fatalf("有効な2端末に届くべき: %d", len(received))
+		}
+		if m := received[0]; m.Title == "" || !strings.Contains(m.Body, "球場 2099-01-03\n・09:00-11:00") || m.URL != "https://reserve.example/1" {
+			t.Errorf("unexpected message: %+v", m)
+		}
+		if r := requests[0]; r.Header.Get("TTL") == "" || r.Header.Get("Urgency") != "high" {
+			t.Errorf("TTL と Urgency を指定すべき: %v", r.Header)
+		}
+		if countSubs() != 2 {
+			t.Error("410 を返した購読は削除されるべき")
+		}
+		var touched int
+		db.QueryRow(`SELECT COUNT(*) FROM push_subscriptions WHERE last_success_at IS NOT NULL`).Scan(&touched)
+		if touched != 2 {
+			t.Errorf("配信に成功した購読を記録すべき: %d", touched)
+		}
+	})
+
+	t.Run("大量の枠はペイロード上限に収めるべき", func(t *testing.T) {
+		received = nil
+		many := *n
+		many.Slots = nil
+		for i := range 200 {
+			many.Slots = append(many.Slots, SlotInfo{SlotID: fmt.Sprint(i), SlotDate: "2099-01-03", SlotTime: "09:00-11:00", FacilityName: "とても長い名前の市営グラウンド"})
+		}
+		if err := wp.Send(ctx, &many); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if len(received) != 2 || !strings.Contains(received[0].Body, "ほか") || received[0].URL != "https://akigura.example/user" {
+			t.Errorf("残りの件数を示しダッシュボードを開くべき: %+v", received)
+		}
+		if b, _ := json.Marshal(received[0]); len(b) > webPushMaxPayload {
+			t.Errorf("payload too large: %d", len(b))
+		}
+	})
+
+	t.Run("すべての購読が失効したら再送不能なエラーを返すべき", func(t *testing.T) {
+		mustExec(t, db, `DELETE FROM push_subscriptions`)
+		subscribe("sub-4", "/expired/4")
+		if err := wp.Send(ctx, n); !errors.Is(err, ErrRecipientUnavailable) {
+			t.Errorf("ErrRecipientUnavailable を返すべき: %v", err)
+		}
+		if countSubs() != 0 {
+			t.Error("失効した購読は削除されるべき")
+		}
+	})
+
+	t.Run("一時的なエラーは再送可能なエラーを返すべき", func(t *testing.T) {
+		subscribe("sub-5", "/active/5")
+		devices["/active/5"] = newPushDevice(t) // keys no longer match: 400
+		err := wp.Send(ctx, n)
+		if err == nil || errors.Is(err, ErrRecipientUnavailable) {
+			t.Errorf("再送可能なエラーを返すべき: %v", err)
+		}
+		if countSubs() != 1 {
+			t.Error("一時的なエラーでは購読を削除すべきではない")
+		}
+	})
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	keys, _ := GenerateVAPIDKeys()
+	auth, err := keys.authorization("https://fcm.googleapis.com/fcm/send/abc", "mailto:a@example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyVAPID(auth, "https://fcm.googleapis.com", keys.PublicKey); err != nil {
+		t.Errorf("VAPID トークンはエンドポイントのオリジン宛に署名されるべき: %v", err)
+	}
+	if !strings.HasPrefix(auth, "vapid t=") {
+		t.Errorf("unexpected scheme: %s", auth)
+	}
+}
@@ -199,6 +199,8 @@ func (s *HiratsukaScraper) parseAvailability(body string, date time.Time) []Slot
 						if timeSlot != "" && currentFacility != "" {
 							parts := strings.Split(timeSlot, "-")
 							if len(parts) == 2 {
+								// No ReservationURL: every page after the menu
+								// needs the scraper's g_sessionid
 								slots = append(slots, Slot{
 									Date:      dateStr,
 									TimeFrom:  parts[0],
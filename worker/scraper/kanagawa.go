@@ -166,7 +166,23 @@ func (s *KanagawaScraper) scrapeDate(ctx context.Context, ufps string, fac kanag
 	}
 
 	// Parse available time slots
-	return s.parseTimeSlots(body, fac.Name, targetDate)
+	slots, err := s.parseTimeSlots(body, fac.Name, targetDate)
+	if err != nil {
+		return nil, err
+	}
+	link := s.reservationURL(fac, dateStr)
+	for i := range slots {
+		slots[i].ReservationURL = link
+	}
+	return slots, nil
+}
+
+// reservationURL is the time selection page of a facility on a date
+// (YYYYMMDD). __ufps belongs to the scraper's session and is left out: the
+// site starts a new one for the visitor.
+func (s *KanagawaScraper) reservationURL(fac kanagawaFacility, dateStr string) string {
+	return fmt.Sprintf("%s/Kanagawa/SmartPhone/Wsp_JikanSentaku.aspx?SJCode=%s&UseDate=%s",
+		s.baseURL, url.QueryEscape(fac.Code), dateStr)
 }
 
 func (s *KanagawaScraper) parseTimeSlots(body string, facilityName string, date time.Time) ([]Slot, error) {
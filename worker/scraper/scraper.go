@@ -13,6 +13,12 @@ type Slot struct {
 	TimeTo    string // HH:MM format
 	CourtName string // e.g., "大神グラウンド野球場Ａ面"
 	RawText   string // Original text from the website
+
+	// ReservationURL is the most specific page for booking the slot, e.g.
+	// the facility's time selection for the date. Empty when the site only
+	// reaches it through a session, in which case notifications link to the
+	// municipality's site.
+	ReservationURL string
 }
 
 // Result represents the result of a scrape operation.
@@ -235,6 +235,8 @@ func (s *YokohamaScraper) parseTableRow(tr *html.Node) *Slot {
 		return nil
 	}
 
+	// No ReservationURL: the search is a POST guarded by the session's
+	// __RequestVerificationToken, so a visitor has to start from the home page
 	return &Slot{
 		Date:      parsedDate,
 		TimeFrom:  timeMatch[1],
@@ -32,6 +32,9 @@ type Slot struct {
 	CourtName    *string `json:"court_name"`
 	RawText      string  `json:"raw_text"`
 	FacilityType string  `json:"facility_type"`
+	// Most specific reservation page for the slot; empty when the scraper
+	// has none
+	ReservationURL string `json:"reservation_url,omitempty"`
 }
 
 // Worker handles scraping jobs
@@ -150,11 +153,16 @@ func (w *Worker) SaveSlots(ctx context.Context, municipalityID string, slots []S
 
 		// Insert slot with ground_id and municipality_id
 		// facility_id is legacy and set to NULL; we use municipality_id now
+		// A slot that is already known has last_seen_at refreshed, which
+		// tells the sender it is still available, and takes the latest
+		// reservation link
 		_, err = w.DB.ExecContext(ctx, `
-			INSERT INTO slots (id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, scraped_at)
-			VALUES (?, NULL, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
-			ON CONFLICT DO NOTHING
-		`, id, municipalityID, groundID, *slot.Date, timeFrom, timeTo, courtName, slot.RawText)
+			INSERT INTO slots (id, facility_id, municipality_id, ground_id, slot_date, time_from, time_to, court_name, raw_text, reservation_url, scraped_at, last_seen_at)
+			VALUES (?, NULL, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			ON CONFLICT (municipality_id, slot_date, time_from, time_to, court_name) DO UPDATE SET
+				last_seen_at = CURRENT_TIMESTAMP,
+				reservation_url = excluded.reservation_url
+		`, id, municipalityID, groundID, *slot.Date, timeFrom, timeTo, courtName, slot.RawText, slot.ReservationURL)
 		if err != nil {
 			slog.Warn("failed to save slot", "error", err)
 			continue